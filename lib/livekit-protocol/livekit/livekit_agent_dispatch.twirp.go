@@ -21,10 +21,6 @@ import errors "errors"
 import path "path"
 import url "net/url"
 
-import (
-	"github.com/ziti-livekit-example/lib/openziti"
-)
-
 // Version compatibility assertion.
 // If the constant is not defined in the package, that likely means
 // the package needs to be updated to work with this generated code.
@@ -1503,7 +1499,7 @@ func doProtobufRequest(ctx context.Context, client HTTPClient, hooks *twirp.Clie
 	}
 
 	req = req.WithContext(ctx)
-	resp, err := openziti.ZitiClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return ctx, wrapInternal(err, "failed to do request")
 	}
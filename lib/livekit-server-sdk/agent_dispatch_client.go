@@ -16,10 +16,10 @@ package lksdk
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/livekit/protocol/livekit"
 	"github.com/twitchtv/twirp"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 type AgentDispatchClient struct {
@@ -28,11 +28,16 @@ type AgentDispatchClient struct {
 }
 
 func NewAgentDispatchServiceClient(url string, apiKey string, secretKey string, opts ...twirp.ClientOption) *AgentDispatchClient {
+	return NewAgentDispatchServiceClientWithHTTPClient(url, apiKey, secretKey, openziti.DefaultClient, opts...)
+}
+
+// NewAgentDispatchServiceClientWithHTTPClient is like NewAgentDispatchServiceClient with requests sent through client.
+func NewAgentDispatchServiceClientWithHTTPClient(url string, apiKey string, secretKey string, client livekit.HTTPClient, opts ...twirp.ClientOption) *AgentDispatchClient {
 	url = ToHttpURL(url)
-	client := livekit.NewAgentDispatchServiceProtobufClient(url, &http.Client{}, opts...)
+	svc := livekit.NewAgentDispatchServiceProtobufClient(url, client, opts...)
 
 	return &AgentDispatchClient{
-		agentDispatchService: client,
+		agentDispatchService: svc,
 		authBase: authBase{
 			apiKey:    apiKey,
 			apiSecret: secretKey,
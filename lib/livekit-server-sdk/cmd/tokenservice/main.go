@@ -0,0 +1,142 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Token service hosted on the ziti overlay, issuing LiveKit access tokens to
+// the identities dialing it.
+//
+//	ZITI_IDENTITY       identity file without .json(default token-service),
+//	                    needs to read identities from the management api
+//	ZITI_CTRL_URL       controller the role attributes of callers are read from
+//	ZITI_SERVICE_TOKEN  service to bind(default livekit-token)
+//	LIVEKIT_API_KEY     key tokens are signed with
+//	LIVEKIT_API_SECRET  secret tokens are signed with
+//	TOKEN_POLICY        grants of role attributes(default policy.yaml)
+//	TOKEN_TTL           validity of tokens(default 10m)
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ziti-livekit-example/lib/openziti"
+
+	"github.com/livekit/server-sdk-go/v2/pkg/tokenservice"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	policy, err := tokenservice.LoadPolicy(getenv("TOKEN_POLICY", "policy.yaml"))
+	if err != nil {
+		return err
+	}
+	ttl, err := time.ParseDuration(getenv("TOKEN_TTL", "10m"))
+	if err != nil {
+		return err
+	}
+
+	identity := getenv("ZITI_IDENTITY", "token-service")
+	err = openziti.InitCon(identity)
+	if err != nil {
+		return err
+	}
+	r := openziti.Default()
+	defer r.Close()
+
+	renewer := openziti.NewCertRenewer(openziti.CertRenewerOptions{
+		Name:    filepath.Base(identity),
+		Store:   openziti.FileIdentityStore{Dir: filepath.Dir(identity)},
+		Runtime: r,
+	})
+	err = renewer.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer renewer.Close()
+
+	// Management api session to look up the role attributes of callers
+	ctrlUrl := os.Getenv("ZITI_CTRL_URL")
+	authenticator, err := openziti.NewIdentityAuthenticator(ctrlUrl, identity)
+	if err != nil {
+		return err
+	}
+	session := openziti.NewSessionManager(openziti.SessionManagerOptions{
+		CtrlUrl:       ctrlUrl,
+		Authenticator: authenticator,
+		Client:        authenticator.HTTPClient(),
+	})
+	err = session.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	server, err := tokenservice.NewServer(tokenservice.Options{
+		APIKey:     os.Getenv("LIVEKIT_API_KEY"),
+		APISecret:  os.Getenv("LIVEKIT_API_SECRET"),
+		Policy:     policy,
+		Identities: openziti.NewManagementClient(ctrlUrl, session, authenticator.HTTPClient()),
+		TTL:        ttl,
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	// Bind again when the listener fails, e.g. after the certificate was
+	// renewed and the ziti context swapped
+	service := getenv("ZITI_SERVICE_TOKEN", "livekit-token")
+	for ctx.Err() == nil {
+		l, err := r.CurrentContext().Listen(service)
+		if err != nil {
+			log.Print(err)
+		} else {
+			log.Printf("token service bound to %s", service)
+			err = server.Serve(l)
+			if err != nil {
+				log.Print(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+	return nil
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -16,10 +16,10 @@ package lksdk
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/livekit/protocol/livekit"
 	"github.com/twitchtv/twirp"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 type EgressClient struct {
@@ -28,10 +28,15 @@ type EgressClient struct {
 }
 
 func NewEgressClient(url string, apiKey string, secretKey string, opts ...twirp.ClientOption) *EgressClient {
+	return NewEgressClientWithHTTPClient(url, apiKey, secretKey, openziti.DefaultClient, opts...)
+}
+
+// NewEgressClientWithHTTPClient is like NewEgressClient with requests sent through client.
+func NewEgressClientWithHTTPClient(url string, apiKey string, secretKey string, client livekit.HTTPClient, opts ...twirp.ClientOption) *EgressClient {
 	url = ToHttpURL(url)
-	client := livekit.NewEgressProtobufClient(url, &http.Client{}, opts...)
+	svc := livekit.NewEgressProtobufClient(url, client, opts...)
 	return &EgressClient{
-		egressClient: client,
+		egressClient: svc,
 		authBase: authBase{
 			apiKey:    apiKey,
 			apiSecret: secretKey,
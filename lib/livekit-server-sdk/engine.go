@@ -242,12 +242,16 @@ func (e *RTCEngine) configure(
 		Interceptors:         e.connParams.Interceptors,
 		OnRTTUpdate:          e.setRTT,
 		IsSender:             true,
+		ZitiRuntime:          e.connParams.ZitiRuntime,
+		ZitiOnly:             e.connParams.ZitiOnly,
 	}); err != nil {
 		return err
 	}
 	if e.subscriber, err = NewPCTransport(PCTransportParams{
 		Configuration:        configuration,
 		RetransmitBufferSize: e.connParams.RetransmitBufferSize,
+		ZitiRuntime:          e.connParams.ZitiRuntime,
+		ZitiOnly:             e.connParams.ZitiOnly,
 	}); err != nil {
 		return err
 	}
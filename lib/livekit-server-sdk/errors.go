@@ -14,7 +14,10 @@
 
 package lksdk
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrURLNotProvided           = errors.New("URL was not provided")
@@ -29,4 +32,45 @@ var (
 	ErrCannotConnectSignal      = errors.New("could not establish signal connection")
 	ErrCannotDialSignal         = errors.New("could not dial signal connection")
 	ErrNoPeerConnection         = errors.New("peer connection not established")
+
+	// Kinds of SignalError
+	ErrUnauthorized           = errors.New("unauthorized")
+	ErrRoomNotFound           = errors.New("room not found")
+	ErrServerUnavailable      = errors.New("server unavailable")
+	ErrZitiServiceUnavailable = errors.New("ziti service unavailable")
+	ErrZitiSessionExpired     = errors.New("ziti session expired")
+	ErrZitiNotConfigured      = errors.New("ziti not configured")
 )
+
+// SignalError is returned when the signal connection can't be established.
+// errors.Is matches its Kind and the wrapped cause. Roughly:
+// ErrZitiSessionExpired needs a new session or identity, ErrUnauthorized a
+// new token, ErrZitiServiceUnavailable and ErrServerUnavailable may succeed
+// on retry.
+type SignalError struct {
+	Kind error
+	// Status of /rtc/validate, 0 if it wasn't reached
+	StatusCode int
+	// Body of the /rtc/validate response
+	Message string
+	// Dial or validation error
+	Err error
+}
+
+func (e *SignalError) Error() string {
+	msg := e.Kind.Error()
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *SignalError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
@@ -17,6 +17,7 @@ replace github.com/pion/transport/v2 v2.2.8 => ../pion-transport
 replace github.com/pion/turn/v2 v2.1.6 => ../pion-turn
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/bep/debounce v1.2.1
 	github.com/go-logr/logr v1.4.2
 	github.com/go-logr/stdr v1.2.2
@@ -25,11 +26,13 @@ require (
 	github.com/livekit/mediatransportutil v0.0.0-20240730083616-559fa5ece598
 	github.com/livekit/protocol v1.19.4-0.20240808180722-581b59b65309
 	github.com/magefile/mage v1.15.0
+	github.com/openziti/sdk-golang v0.23.41
 	github.com/pion/dtls/v2 v2.2.12
 	github.com/pion/interceptor v0.1.29
 	github.com/pion/rtcp v1.2.14
 	github.com/pion/rtp v1.8.9
 	github.com/pion/sdp/v3 v3.0.9
+	github.com/pion/transport/v2 v2.2.8
 	github.com/pion/webrtc/v3 v3.2.50
 	github.com/stretchr/testify v1.9.0
 	github.com/twitchtv/twirp v8.1.3+incompatible
@@ -37,6 +40,7 @@ require (
 	go.uber.org/atomic v1.11.0
 	golang.org/x/exp v0.0.0-20240808152545-0cdaa3abc0fa
 	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -94,7 +98,6 @@ require (
 	github.com/openziti/foundation/v2 v2.0.49 // indirect
 	github.com/openziti/identity v1.0.85 // indirect
 	github.com/openziti/metrics v1.2.58 // indirect
-	github.com/openziti/sdk-golang v0.23.41 // indirect
 	github.com/openziti/secretstream v0.1.21 // indirect
 	github.com/openziti/transport/v2 v2.0.146 // indirect
 	github.com/openziti/ziti v1.1.4 // indirect
@@ -108,7 +111,6 @@ require (
 	github.com/pion/sctp v1.8.19 // indirect
 	github.com/pion/srtp/v2 v2.0.20 // indirect
 	github.com/pion/stun v0.6.1 // indirect
-	github.com/pion/transport/v2 v2.2.8 // indirect
 	github.com/pion/turn/v2 v2.1.6 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
@@ -146,6 +148,5 @@ require (
 	google.golang.org/genproto/googleapis/api v0.0.0-20240528184218-531527333157 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240725223205-93522f1f2a9f // indirect
 	gopkg.in/go-jose/go-jose.v2 v2.6.3 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	nhooyr.io/websocket v1.8.17 // indirect
 )
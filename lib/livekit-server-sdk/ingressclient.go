@@ -16,10 +16,10 @@ package lksdk
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/livekit/protocol/livekit"
 	"github.com/twitchtv/twirp"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 type IngressClient struct {
@@ -28,10 +28,15 @@ type IngressClient struct {
 }
 
 func NewIngressClient(url string, apiKey string, secretKey string, opts ...twirp.ClientOption) *IngressClient {
+	return NewIngressClientWithHTTPClient(url, apiKey, secretKey, openziti.DefaultClient, opts...)
+}
+
+// NewIngressClientWithHTTPClient is like NewIngressClient with requests sent through client.
+func NewIngressClientWithHTTPClient(url string, apiKey string, secretKey string, client livekit.HTTPClient, opts ...twirp.ClientOption) *IngressClient {
 	url = ToHttpURL(url)
-	client := livekit.NewIngressProtobufClient(url, &http.Client{}, opts...)
+	svc := livekit.NewIngressProtobufClient(url, client, opts...)
 	return &IngressClient{
-		ingressClient: client,
+		ingressClient: svc,
 		authBase: authBase{
 			apiKey:    apiKey,
 			apiSecret: secretKey,
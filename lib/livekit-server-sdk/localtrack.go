@@ -85,6 +85,9 @@ type LocalTrack struct {
 	onUnbind     func()
 	// notify when sample provider responds with EOF
 	onWriteComplete func()
+	// set while the server paused the layer
+	layerPaused       atomic.Bool
+	onLayerSubscribed func(subscribed bool)
 }
 type LocalSampleTrack = LocalTrack
 
@@ -295,6 +298,15 @@ func (s *LocalTrack) OnBind(f func()) {
 	s.lock.Unlock()
 }
 
+// OnLayerSubscribed sets a callback to be called when the server pauses the
+// track(subscribed false) because no subscriber wants its simulcast layer,
+// or resumes it. Tracks start subscribed.
+func (s *LocalTrack) OnLayerSubscribed(f func(subscribed bool)) {
+	s.lock.Lock()
+	s.onLayerSubscribed = f
+	s.lock.Unlock()
+}
+
 // OnUnbind sets a callback to be called after the track is removed from a peer connection
 func (s *LocalTrack) OnUnbind(f func()) {
 	s.lock.Lock()
@@ -507,6 +519,18 @@ func (s *LocalTrack) setMuted(muted bool) {
 	s.muted.Store(muted)
 }
 
+func (s *LocalTrack) setLayerSubscribed(subscribed bool) {
+	if s.layerPaused.Swap(!subscribed) == !subscribed {
+		return
+	}
+	s.lock.RLock()
+	onLayerSubscribed := s.onLayerSubscribed
+	s.lock.RUnlock()
+	if onLayerSubscribed != nil {
+		onLayerSubscribed(subscribed)
+	}
+}
+
 func (s *LocalTrack) setDisconnected(disconnected bool) {
 	s.disconnected.Store(disconnected)
 }
@@ -0,0 +1,339 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appconfig loads the configuration of apps joining a LiveKit room
+// over ziti. Defaults of the app are overridden by a yaml(or json) file, or a
+// toml file named *.toml, then the environment, then flags.
+package appconfig
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/livekit/protocol/utils"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+type Config struct {
+	LiveKit LiveKit `yaml:"livekit" toml:"livekit"`
+	Ziti    Ziti    `yaml:"ziti" toml:"ziti"`
+	// Room to join
+	Room string `yaml:"room" toml:"room"`
+	// Identity of the participant in the room
+	Identity string `yaml:"identity" toml:"identity"`
+	// Published video, nil for apps that don't publish
+	Video *Video `yaml:"video,omitempty" toml:"video,omitempty"`
+	// HLS stream of the subscribed tracks, nil for apps that don't serve one
+	HLS *HLS `yaml:"hls,omitempty" toml:"hls,omitempty"`
+
+	// Set by --print-config
+	PrintConfig bool `yaml:"-" toml:"-"`
+}
+
+type LiveKit struct {
+	URL string `yaml:"url" toml:"url"`
+	// Key and secret are either the value itself or a reference to it,
+	// file:<path> or env:<name>
+	APIKey    string `yaml:"api_key" toml:"api_key"`
+	APISecret string `yaml:"api_secret" toml:"api_secret"`
+	// Ziti service of a token service(see pkg/tokenservice) issuing the
+	// access tokens, instead of signing them with the API secret
+	TokenService string `yaml:"token_service,omitempty" toml:"token_service,omitempty"`
+}
+
+type Ziti struct {
+	// Identity file without .json
+	Identity string `yaml:"identity" toml:"identity"`
+}
+
+type Video struct {
+	Width     int `yaml:"width" toml:"width"`
+	Height    int `yaml:"height" toml:"height"`
+	FrameRate int `yaml:"framerate" toml:"framerate"`
+	// Bits per second
+	Bitrate int `yaml:"bitrate" toml:"bitrate"`
+	// What is published, e.g. pattern or y4m:clip.y4m,file:music.ogg, see
+	// mediasource.Parse
+	Source string `yaml:"source,omitempty" toml:"source,omitempty"`
+	// Full, half and quarter size layers, with the bitrate of the full one
+	Simulcast bool `yaml:"simulcast" toml:"simulcast"`
+}
+
+type HLS struct {
+	// Ziti service the streams are served on, at
+	// /<participant identity>/index.m3u8
+	Service string `yaml:"service" toml:"service"`
+	// Directory the streams are written to, kept in memory if empty
+	Dir string `yaml:"dir,omitempty" toml:"dir,omitempty"`
+	// Partial segments and blocking playlist reloads(LL-HLS)
+	LowLatency bool `yaml:"low_latency" toml:"low_latency"`
+}
+
+// Enabled reports if streams are served or written
+func (h *HLS) Enabled() bool {
+	return h != nil && (h.Service != "" || h.Dir != "")
+}
+
+// setting is a value that can be set from the environment and a flag
+type setting struct {
+	env, flag, usage string
+	// points into the config
+	str     *string
+	num     *int
+	boolean *bool
+}
+
+func (s setting) set(v string) error {
+	if s.str != nil {
+		*s.str = v
+		return nil
+	}
+	if s.boolean != nil {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s is not a boolean: %q", s.flag, v)
+		}
+		*s.boolean = b
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s is not a number: %q", s.flag, v)
+	}
+	*s.num = n
+	return nil
+}
+
+func (c *Config) settings() []setting {
+	settings := []setting{
+		{env: "LIVEKIT_URL", flag: "url", usage: "LiveKit server url", str: &c.LiveKit.URL},
+		{env: "LIVEKIT_API_KEY", flag: "api-key", usage: "LiveKit API key, file:<path> or env:<name> to read it", str: &c.LiveKit.APIKey},
+		{env: "LIVEKIT_API_SECRET", flag: "api-secret", usage: "LiveKit API secret, file:<path> or env:<name> to read it", str: &c.LiveKit.APISecret},
+		{env: "LIVEKIT_TOKEN_SERVICE", flag: "token-service", usage: "ziti service issuing access tokens", str: &c.LiveKit.TokenService},
+		{env: "LIVEKIT_ROOM", flag: "room", usage: "room to join", str: &c.Room},
+		{env: "LIVEKIT_IDENTITY", flag: "identity", usage: "participant identity", str: &c.Identity},
+		{env: "ZITI_IDENTITY", flag: "ziti-identity", usage: "ziti identity file without .json", str: &c.Ziti.Identity},
+	}
+	if c.Video != nil {
+		settings = append(settings,
+			setting{env: "VIDEO_WIDTH", flag: "width", usage: "video width", num: &c.Video.Width},
+			setting{env: "VIDEO_HEIGHT", flag: "height", usage: "video height", num: &c.Video.Height},
+			setting{env: "VIDEO_FRAMERATE", flag: "framerate", usage: "video frames per second", num: &c.Video.FrameRate},
+			setting{env: "VIDEO_BITRATE", flag: "bitrate", usage: "video bits per second", num: &c.Video.Bitrate},
+			setting{env: "VIDEO_SIMULCAST", flag: "simulcast", usage: "publish raw video as simulcast layers, true or false", boolean: &c.Video.Simulcast},
+			setting{env: "VIDEO_SOURCE", flag: "source", usage: "media published, pattern, images:<glob>, y4m:<file>, file:<file> or stdin:<format>, comma separated", str: &c.Video.Source},
+		)
+	}
+	if c.HLS != nil {
+		settings = append(settings,
+			setting{env: "HLS_SERVICE", flag: "hls-service", usage: "ziti service serving the HLS streams", str: &c.HLS.Service},
+			setting{env: "HLS_DIR", flag: "hls-dir", usage: "directory the HLS streams are written to", str: &c.HLS.Dir},
+			setting{env: "HLS_LOW_LATENCY", flag: "hls-low-latency", usage: "low latency HLS, true or false", boolean: &c.HLS.LowLatency},
+		)
+	}
+	return settings
+}
+
+// Load returns defaults overridden by the config file, the environment and
+// args, in that order. The file is given by --config or CONFIG_FILE, else
+// defaultFile is read if it exists. Secret references are resolved and the
+// result is validated.
+func Load(name string, defaults Config, defaultFile string, args []string) (*Config, error) {
+	c := defaults
+	if defaults.Video != nil {
+		video := *defaults.Video
+		c.Video = &video
+	}
+	if defaults.HLS != nil {
+		hls := *defaults.HLS
+		c.HLS = &hls
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String("config", "", "config file, yaml, json or toml (env CONFIG_FILE)")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the config with secrets redacted and exit")
+	settings := c.settings()
+	flags := map[string]*string{}
+	for _, s := range settings {
+		flags[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// file
+	path, required := *file, true
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path, required = defaultFile, false
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err = decodeFile(path, data, &c); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+			}
+		case required || !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	// the file may have added or removed the video and hls sections
+	settings = c.settings()
+
+	// environment
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, s.env, err)
+			}
+		}
+	}
+
+	// flags
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				err = s.set(*flags[s.flag])
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	// tokens from the token service need no credentials, references to
+	// unset variables are left empty
+	optional := c.LiveKit.TokenService != ""
+	if c.LiveKit.APIKey, err = resolveSecret("livekit.api_key", c.LiveKit.APIKey, optional); err != nil {
+		return nil, err
+	}
+	if c.LiveKit.APISecret, err = resolveSecret("livekit.api_secret", c.LiveKit.APISecret, optional); err != nil {
+		return nil, err
+	}
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// decodeFile decodes a toml file by its extension, anything else as yaml(a
+// superset of json). Sections in the file override fields of c, others are kept.
+func decodeFile(path string, data []byte, c *Config) error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return toml.Unmarshal(data, c)
+	}
+	return yaml.Unmarshal(data, c)
+}
+
+// resolveSecret reads a file:<path> or env:<name> reference, other values
+// are the secret itself. An unset variable is empty if optional.
+func resolveSecret(field, value string, optional bool) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidConfig, field, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok && !optional {
+			return "", fmt.Errorf("%w: %s: environment variable %s is not set", ErrInvalidConfig, field, name)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+// Validate reports every problem of the config at once.
+func (c *Config) Validate() error {
+	var problems []string
+	if c.LiveKit.URL == "" {
+		problems = append(problems, "livekit.url is required")
+	} else if u, err := url.Parse(c.LiveKit.URL); err != nil || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("livekit.url %q is not a url", c.LiveKit.URL))
+	} else if u.Scheme != "ws" && u.Scheme != "wss" && u.Scheme != "http" && u.Scheme != "https" {
+		problems = append(problems, fmt.Sprintf("livekit.url %q must be ws(s):// or http(s)://", c.LiveKit.URL))
+	}
+	if c.LiveKit.TokenService == "" {
+		if c.LiveKit.APIKey == "" {
+			problems = append(problems, "livekit.api_key is required without livekit.token_service")
+		}
+		if c.LiveKit.APISecret == "" {
+			problems = append(problems, "livekit.api_secret is required without livekit.token_service")
+		}
+	}
+	if c.Room == "" {
+		problems = append(problems, "room is required")
+	}
+	if c.Identity == "" {
+		problems = append(problems, "identity is required")
+	}
+	if c.Ziti.Identity == "" {
+		problems = append(problems, "ziti.identity is required")
+	}
+	if c.Video != nil {
+		if c.Video.Width <= 0 || c.Video.Height <= 0 {
+			problems = append(problems, fmt.Sprintf("video size %dx%d must be positive", c.Video.Width, c.Video.Height))
+		}
+		if c.Video.FrameRate <= 0 {
+			problems = append(problems, "video.framerate must be positive")
+		}
+		if c.Video.Bitrate <= 0 {
+			problems = append(problems, "video.bitrate must be positive")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy safe to log, the key is shortened and the secret
+// hidden.
+func (c *Config) Redacted() *Config {
+	r := *c
+	if c.LiveKit.APIKey != "" {
+		r.LiveKit.APIKey = utils.RedactIdentifier(c.LiveKit.APIKey)
+	}
+	r.LiveKit.APISecret = utils.Redact(c.LiveKit.APISecret, "<redacted>")
+	return &r
+}
+
+// Print writes the redacted config as yaml.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testDefaults() Config {
+	return Config{
+		Room:     "testroom",
+		Identity: "publisher",
+		Ziti:     Ziti{Identity: "publisher"},
+		Video:    &Video{Width: 1640, Height: 900, FrameRate: 30, Bitrate: 2000000},
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLoadLayering(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+livekit:
+  url: wss://livekit.ziti.example:7880
+  api_key: file-key
+  api_secret: env:TEST_LIVEKIT_SECRET
+room: fileroom
+video:
+  width: 1280
+  height: 720
+`)
+	t.Setenv("TEST_LIVEKIT_SECRET", "secret-from-env")
+	t.Setenv("LIVEKIT_ROOM", "envroom")
+	t.Setenv("VIDEO_FRAMERATE", "15")
+	t.Setenv("VIDEO_SIMULCAST", "true")
+
+	c, err := Load("publisher", testDefaults(), "", []string{"--config", file, "--room", "flagroom", "--height", "480", "--source", "y4m:clip.y4m"})
+	require.NoError(t, err)
+	require.Equal(t, "wss://livekit.ziti.example:7880", c.LiveKit.URL)
+	require.Equal(t, "file-key", c.LiveKit.APIKey)
+	require.Equal(t, "secret-from-env", c.LiveKit.APISecret)
+	require.Equal(t, "flagroom", c.Room)
+	require.Equal(t, "publisher", c.Identity)
+	require.Equal(t, Video{Width: 1280, Height: 480, FrameRate: 15, Bitrate: 2000000, Source: "y4m:clip.y4m", Simulcast: true}, *c.Video)
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	secret := writeFile(t, "secret", "secret-from-file\n")
+	t.Setenv("LIVEKIT_URL", "https://livekit.ziti.example")
+	t.Setenv("LIVEKIT_API_KEY", "key")
+	t.Setenv("LIVEKIT_API_SECRET", "file:"+secret)
+
+	c, err := Load("publisher", testDefaults(), "missing.yaml", nil)
+	require.NoError(t, err)
+	require.Equal(t, "secret-from-file", c.LiveKit.APISecret)
+
+	// an explicit config file has to exist
+	_, err = Load("publisher", testDefaults(), "", []string{"--config", "missing.yaml"})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadTOML(t *testing.T) {
+	file := writeFile(t, "config.toml", `
+room = "fileroom"
+
+[livekit]
+url = "wss://livekit.ziti.example:7880"
+api_key = "file-key"
+api_secret = "env:TEST_LIVEKIT_SECRET"
+
+[video]
+width = 1280
+height = 720
+simulcast = true
+`)
+	t.Setenv("TEST_LIVEKIT_SECRET", "secret-from-env")
+	t.Setenv("VIDEO_FRAMERATE", "15")
+
+	c, err := Load("publisher", testDefaults(), "", []string{"--config", file})
+	require.NoError(t, err)
+	require.Equal(t, "wss://livekit.ziti.example:7880", c.LiveKit.URL)
+	require.Equal(t, "file-key", c.LiveKit.APIKey)
+	require.Equal(t, "secret-from-env", c.LiveKit.APISecret)
+	require.Equal(t, "fileroom", c.Room)
+	require.Equal(t, Video{Width: 1280, Height: 720, FrameRate: 15, Bitrate: 2000000, Simulcast: true}, *c.Video)
+
+	_, err = Load("publisher", testDefaults(), "", []string{"--config", writeFile(t, "config.toml", "room = fileroom\n")})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv("LIVEKIT_URL", "livekit.ziti.example")
+	_, err := Load("publisher", testDefaults(), "", []string{"--width", "0"})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	require.ErrorContains(t, err, `livekit.url "livekit.ziti.example" is not a url`)
+	require.ErrorContains(t, err, "livekit.api_key is required without livekit.token_service")
+	require.ErrorContains(t, err, "livekit.api_secret is required without livekit.token_service")
+	require.ErrorContains(t, err, "video size 0x900 must be positive")
+
+	_, err = Load("publisher", testDefaults(), "", []string{"--framerate", "fast"})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	t.Setenv("LIVEKIT_API_SECRET", "env:TEST_UNSET_SECRET")
+	_, err = Load("publisher", testDefaults(), "", nil)
+	require.ErrorContains(t, err, "TEST_UNSET_SECRET is not set")
+}
+
+func TestPrintRedacted(t *testing.T) {
+	t.Setenv("LIVEKIT_URL", "wss://livekit.ziti.example:7880")
+	t.Setenv("LIVEKIT_API_KEY", "APIexamplekey")
+	t.Setenv("LIVEKIT_API_SECRET", "example-secret-not-for-use")
+	c, err := Load("subscriber", Config{Room: "testroom", Identity: "subscriber", Ziti: Ziti{Identity: "subscriber"}}, "", []string{"--print-config"})
+	require.NoError(t, err)
+	require.True(t, c.PrintConfig)
+
+	var out bytes.Buffer
+	require.NoError(t, c.Print(&out))
+	require.Contains(t, out.String(), "api_key: '{API...key}'")
+	require.Contains(t, out.String(), "api_secret: <redacted>")
+	require.NotContains(t, out.String(), "example-secret-not-for-use")
+	require.NotContains(t, out.String(), "video")
+	require.Equal(t, "APIexamplekey", c.LiveKit.APIKey)
+}
+
+func TestLoadTokenService(t *testing.T) {
+	t.Setenv("LIVEKIT_URL", "wss://livekit.ziti.example:7880")
+	c, err := Load("publisher", testDefaults(), "", []string{"--token-service", "livekit-token"})
+	require.NoError(t, err)
+	require.Equal(t, "livekit-token", c.LiveKit.TokenService)
+	require.Empty(t, c.LiveKit.APISecret)
+
+	// the shipped config files reference credentials that aren't needed
+	t.Setenv("LIVEKIT_API_KEY", "env:TEST_UNSET_KEY")
+	t.Setenv("LIVEKIT_API_SECRET", "env:TEST_UNSET_SECRET")
+	c, err = Load("publisher", testDefaults(), "", []string{"--token-service", "livekit-token"})
+	require.NoError(t, err)
+	require.Empty(t, c.LiveKit.APIKey)
+	require.Empty(t, c.LiveKit.APISecret)
+}
+
+func TestLoadHLS(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+hls:
+  service: livekit-hls
+`)
+	t.Setenv("LIVEKIT_URL", "wss://livekit.ziti.example:7880")
+	t.Setenv("LIVEKIT_TOKEN_SERVICE", "livekit-token")
+	t.Setenv("HLS_LOW_LATENCY", "true")
+	defaults := Config{Room: "testroom", Identity: "subscriber", Ziti: Ziti{Identity: "subscriber"}, HLS: &HLS{}}
+
+	c, err := Load("subscriber", defaults, "", []string{"--config", file, "--hls-dir", "/var/hls"})
+	require.NoError(t, err)
+	require.Equal(t, HLS{Service: "livekit-hls", Dir: "/var/hls", LowLatency: true}, *c.HLS)
+	require.True(t, c.HLS.Enabled())
+	require.Empty(t, defaults.HLS.Service, "defaults are copied")
+
+	t.Setenv("HLS_LOW_LATENCY", "maybe")
+	_, err = Load("subscriber", defaults, "", nil)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	// apps without hls don't take its settings
+	c, err = Load("publisher", testDefaults(), "", nil)
+	require.NoError(t, err)
+	require.False(t, c.HLS.Enabled())
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hls
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler serves the stream: the playlist and the files of the storage. With
+// LowLatency the playlist can be reloaded blocking(_HLS_msn and _HLS_part)
+// and a request for the hinted part waits until it's written. Mount it with
+// http.StripPrefix to serve it below a path.
+func (s *Segmenter) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *Segmenter) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || name == PlaylistName {
+		s.servePlaylist(w, r)
+		return
+	}
+	s.serveFile(w, r, name)
+}
+
+func (s *Segmenter) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if s.opts.LowLatency && query.Has("_HLS_msn") {
+		msn, err := strconv.Atoi(query.Get("_HLS_msn"))
+		if err != nil {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		part := -1
+		if query.Has("_HLS_part") {
+			if part, err = strconv.Atoi(query.Get("_HLS_part")); err != nil || part < 0 {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		s.mu.Lock()
+		tooFar := msn > s.sequence+2
+		s.mu.Unlock()
+		if tooFar {
+			http.Error(w, "_HLS_msn is too far ahead", http.StatusBadRequest)
+			return
+		}
+		if !s.wait(r.Context(), 3*s.opts.TargetDuration, func() bool { return s.ready(msn, part) }) {
+			http.Error(w, "playlist not ready", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(s.Playlist())
+}
+
+func (s *Segmenter) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := s.opts.Storage.Get(name)
+	if errors.Is(err, ErrNotFound) && s.opts.LowLatency {
+		// the preload hint is requested before the part is written
+		hinted := false
+		s.wait(r.Context(), 3*s.opts.PartDuration, func() bool {
+			hinted = hinted || name == s.nextPart()
+			if !hinted {
+				return true
+			}
+			data, err = s.opts.Storage.Get(name)
+			return !errors.Is(err, ErrNotFound)
+		})
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
+
+// wait blocks until cond, called with the lock held, holds, the request ends
+// or timeout passed
+func (s *Segmenter) wait(ctx context.Context, timeout time.Duration, cond func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
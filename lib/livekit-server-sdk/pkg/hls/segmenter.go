@@ -0,0 +1,382 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hls cuts the tracks of a participant into CMAF segments and
+// writes HLS playlists, with partial segments for low latency HLS, to a
+// Storage. Segmenter.Handler serves them, including blocking playlist
+// reloads.
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	protoLogger "github.com/livekit/protocol/logger"
+	"github.com/pion/webrtc/v3/pkg/media/fmp4writer"
+)
+
+const (
+	PlaylistName = "index.m3u8"
+	InitName     = "init.mp4"
+
+	// parts are listed for the last segments only
+	partSegments = 3
+)
+
+var ErrClosed = errors.New("hls: segmenter closed")
+
+type Options struct {
+	Storage Storage
+	// Segments never get longer than TargetDuration, default 4s. They start
+	// with a key frame if the tracks send one in time, it is requested a part
+	// before a segment reaches TargetDuration.
+	TargetDuration time.Duration
+	// Longest partial segment, default 1s
+	PartDuration time.Duration
+	// Lists partial segments and a preload hint
+	LowLatency bool
+	// Segments kept in the playlist, older ones are deleted, default 6
+	Window int
+}
+
+// Segmenter writes a stream: every fragment of its fmp4 writer is a part,
+// parts are joined into segments starting with a key frame
+type Segmenter struct {
+	opts   Options
+	writer *fmp4writer.FMP4Writer
+	log    protoLogger.Logger
+
+	mu       sync.Mutex
+	segments []*segment
+	sequence int
+	ended    bool
+	// closed and replaced whenever the playlist changes
+	changed chan struct{}
+
+	tracks []*trackReader
+}
+
+type segment struct {
+	sequence int
+	duration time.Duration
+	parts    []part
+	data     [][]byte
+	complete bool
+	// starts with a key frame
+	independent bool
+	// a key frame was requested for the next segment
+	keyFrameRequested bool
+}
+
+type part struct {
+	name        string
+	duration    time.Duration
+	independent bool
+}
+
+func (s *segment) name() string {
+	return fmt.Sprintf("seg%d.m4s", s.sequence)
+}
+
+func (s *segment) partName(i int) string {
+	return fmt.Sprintf("seg%d.%d.m4s", s.sequence, i)
+}
+
+func New(opts Options) (*Segmenter, error) {
+	if opts.Storage == nil {
+		opts.Storage = NewMemoryStorage()
+	}
+	if opts.TargetDuration <= 0 {
+		opts.TargetDuration = 4 * time.Second
+	}
+	if opts.PartDuration <= 0 {
+		opts.PartDuration = time.Second
+	}
+	if opts.PartDuration > opts.TargetDuration {
+		opts.PartDuration = opts.TargetDuration
+	}
+	if opts.Window <= 0 {
+		opts.Window = 6
+	}
+
+	s := &Segmenter{
+		opts:    opts,
+		log:     protoLogger.GetLogger(),
+		changed: make(chan struct{}),
+	}
+	writer, err := fmp4writer.NewWithSegmentWriter(segmentWriter{s})
+	if err != nil {
+		return nil, err
+	}
+	writer.SetFragmentDuration(opts.PartDuration)
+	s.writer = writer
+	return s, nil
+}
+
+// Storage returns the storage the stream is written to
+func (s *Segmenter) Storage() Storage {
+	return s.opts.Storage
+}
+
+// segmentWriter takes the output of the fmp4 writer, it isn't closed with it
+// as Close is driven by the Segmenter
+type segmentWriter struct {
+	s *Segmenter
+}
+
+func (w segmentWriter) WriteInit(init []byte) error {
+	return w.s.opts.Storage.Put(InitName, init)
+}
+
+func (w segmentWriter) WriteFragment(fragment []byte, info fmp4writer.FragmentInfo) error {
+	return w.s.addPart(fragment, info)
+}
+
+// addPart adds a fragment as part of the current segment, a new segment is
+// started once the current one would exceed TargetDuration. The requested key
+// frame starts a fragment, so it usually starts the segment.
+func (s *Segmenter) addPart(fragment []byte, info fmp4writer.FragmentInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return ErrClosed
+	}
+
+	current := s.current()
+	if current != nil && current.duration+info.Duration > s.opts.TargetDuration {
+		if !info.Independent {
+			s.log.Debugw("hls segment starts without a key frame", "sequence", current.sequence+1)
+		}
+		if err := s.finishSegment(current); err != nil {
+			return err
+		}
+		current = nil
+	}
+	if current == nil {
+		s.sequence++
+		current = &segment{sequence: s.sequence, independent: info.Independent}
+		s.segments = append(s.segments, current)
+	}
+
+	p := part{
+		name:        current.partName(len(current.parts)),
+		duration:    info.Duration,
+		independent: info.Independent,
+	}
+	if s.opts.LowLatency {
+		if err := s.opts.Storage.Put(p.name, fragment); err != nil {
+			return err
+		}
+	}
+	current.parts = append(current.parts, p)
+	current.data = append(current.data, fragment)
+	current.duration += info.Duration
+
+	// a part ahead of the segment's end, WebRTC encoders send key frames
+	// rarely without being asked
+	if !current.keyFrameRequested && current.duration+2*s.opts.PartDuration > s.opts.TargetDuration {
+		current.keyFrameRequested = true
+		s.requestKeyFrame()
+	}
+
+	if err := s.trim(); err != nil {
+		return err
+	}
+	return s.writePlaylist()
+}
+
+// current returns the segment parts are added to, nil if there is none
+func (s *Segmenter) current() *segment {
+	if len(s.segments) == 0 || s.segments[len(s.segments)-1].complete {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Segmenter) finishSegment(seg *segment) error {
+	if err := s.opts.Storage.Put(seg.name(), bytes.Join(seg.data, nil)); err != nil {
+		return err
+	}
+	seg.complete = true
+	seg.data = nil
+	return nil
+}
+
+// trim deletes segments out of the window and parts no longer listed
+func (s *Segmenter) trim() error {
+	complete := 0
+	for _, seg := range s.segments {
+		if seg.complete {
+			complete++
+		}
+	}
+	for complete > s.opts.Window {
+		if err := s.deleteParts(s.segments[0]); err != nil {
+			return err
+		}
+		if err := s.opts.Storage.Delete(s.segments[0].name()); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+		complete--
+	}
+
+	for i := 0; i < len(s.segments)-partSegments; i++ {
+		if err := s.deleteParts(s.segments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Segmenter) deleteParts(seg *segment) error {
+	if s.opts.LowLatency {
+		for _, p := range seg.parts {
+			if err := s.opts.Storage.Delete(p.name); err != nil {
+				return err
+			}
+		}
+	}
+	seg.parts = nil
+	return nil
+}
+
+func (s *Segmenter) writePlaylist() error {
+	err := s.opts.Storage.Put(PlaylistName, s.playlist())
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// playlist renders the media playlist
+func (s *Segmenter) playlist() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if s.opts.LowLatency {
+		b.WriteString("#EXT-X-VERSION:9\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:7\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(s.opts.TargetDuration.Seconds())))
+	if s.opts.LowLatency {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%s\n", seconds(3*s.opts.PartDuration))
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", seconds(s.opts.PartDuration))
+	}
+	sequence := s.sequence
+	if len(s.segments) > 0 {
+		sequence = s.segments[0].sequence
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if s.independent() {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", InitName)
+
+	for _, seg := range s.segments {
+		if s.opts.LowLatency {
+			for _, p := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=\"%s\"", seconds(p.duration), p.name)
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.complete {
+			fmt.Fprintf(&b, "#EXTINF:%s,\n%s\n", seconds(seg.duration), seg.name())
+		}
+	}
+
+	switch {
+	case s.ended:
+		b.WriteString("#EXT-X-ENDLIST\n")
+	case s.opts.LowLatency:
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", s.nextPart())
+	}
+	return []byte(b.String())
+}
+
+// independent reports if every listed segment starts with a key frame
+func (s *Segmenter) independent() bool {
+	for _, seg := range s.segments {
+		if !seg.independent {
+			return false
+		}
+	}
+	return true
+}
+
+// nextPart returns the name of the part written next
+func (s *Segmenter) nextPart() string {
+	if current := s.current(); current != nil {
+		return current.partName(len(current.parts))
+	}
+	next := &segment{sequence: s.sequence + 1}
+	return next.partName(0)
+}
+
+// ready reports if segment sequence, or its part if part >= 0, is listed
+func (s *Segmenter) ready(sequence, part int) bool {
+	if s.ended {
+		return true
+	}
+	for _, seg := range s.segments {
+		if seg.sequence == sequence {
+			return seg.complete || (part >= 0 && part < len(seg.parts))
+		}
+		if seg.sequence > sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// Playlist returns the current media playlist
+func (s *Segmenter) Playlist() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playlist()
+}
+
+// Close writes the last segment and ends the playlist
+func (s *Segmenter) Close() error {
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return nil
+	}
+	if current := s.current(); current != nil {
+		if err := s.finishSegment(current); err != nil {
+			return err
+		}
+	}
+	s.ended = true
+	if err := s.trim(); err != nil {
+		return err
+	}
+	return s.writePlaylist()
+}
@@ -0,0 +1,289 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hls
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/fmp4writer"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSPS = []byte{0x67, 0x42, 0xc0, 0x1f, 0xf4, 0x0a, 0x0f, 0xc8}
+	testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// videoStream writes frames of 100ms with a key frame every gop frames,
+// default every second
+type videoStream struct {
+	track *fmp4writer.Track
+	frame int
+	gop   int
+	// the next frame is a key frame
+	keyFrame bool
+}
+
+func newVideoStream(t *testing.T, s *Segmenter) *videoStream {
+	track, err := s.writer.AddTrack(fmp4writer.TrackConfig{MimeType: "video/H264"})
+	require.NoError(t, err)
+	return &videoStream{track: track, gop: 10}
+}
+
+func (v *videoStream) write(t *testing.T, frames int) {
+	for i := 0; i < frames; i++ {
+		ts := uint32(v.frame * 9000)
+		pts := time.Duration(v.frame) * 100 * time.Millisecond
+		payloads := [][]byte{{0x41, 0x9a, byte(v.frame)}}
+		if v.frame%v.gop == 0 || v.keyFrame {
+			v.keyFrame = false
+			stapA := []byte{0x78}
+			for _, nalu := range [][]byte{testSPS, testPPS} {
+				stapA = binary.BigEndian.AppendUint16(stapA, uint16(len(nalu)))
+				stapA = append(stapA, nalu...)
+			}
+			payloads = [][]byte{stapA, {0x65, 0x88, byte(v.frame)}}
+		}
+		for j, payload := range payloads {
+			packet := &rtp.Packet{Header: rtp.Header{Timestamp: ts, Marker: j == len(payloads)-1}, Payload: payload}
+			require.NoError(t, v.track.WriteRTPAt(packet, pts))
+		}
+		v.frame++
+	}
+}
+
+func TestSegmenter(t *testing.T) {
+	storage := NewMemoryStorage()
+	s, err := New(Options{
+		Storage:        storage,
+		TargetDuration: 2 * time.Second,
+		PartDuration:   500 * time.Millisecond,
+		LowLatency:     true,
+		Window:         3,
+	})
+	require.NoError(t, err)
+	video := newVideoStream(t, s)
+
+	video.write(t, 26)
+	require.Equal(t, `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500
+#EXT-X-PART-INF:PART-TARGET=0.500
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MAP:URI="init.mp4"
+#EXT-X-PART:DURATION=0.500,URI="seg1.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="seg1.1.m4s"
+#EXT-X-PART:DURATION=0.500,URI="seg1.2.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="seg1.3.m4s"
+#EXTINF:2.000,
+seg1.m4s
+#EXT-X-PART:DURATION=0.500,URI="seg2.0.m4s",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="seg2.1.m4s"
+`, string(s.Playlist()))
+
+	stored, err := storage.Get(PlaylistName)
+	require.NoError(t, err)
+	require.Equal(t, s.Playlist(), stored)
+	_, err = storage.Get(InitName)
+	require.NoError(t, err)
+
+	// a segment is made of its parts
+	var parts []byte
+	for i := 0; i < 4; i++ {
+		part, err := storage.Get(segmentName(1, i))
+		require.NoError(t, err)
+		parts = append(parts, part...)
+	}
+	segment, err := storage.Get("seg1.m4s")
+	require.NoError(t, err)
+	require.Equal(t, parts, segment)
+
+	// 10s, 5 segments of which the last 3 are kept
+	video.write(t, 74)
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	playlist := string(s.Playlist())
+	require.Contains(t, playlist, "#EXT-X-MEDIA-SEQUENCE:3\n")
+	require.True(t, strings.HasSuffix(playlist, "#EXTINF:2.000,\nseg5.m4s\n#EXT-X-ENDLIST\n"), playlist)
+	require.Equal(t, 3, strings.Count(playlist, "#EXTINF"))
+	require.NotContains(t, playlist, "PRELOAD-HINT")
+
+	for name, kept := range map[string]bool{
+		"seg1.m4s": false, "seg2.m4s": false, "seg3.m4s": true, "seg5.m4s": true,
+		"seg1.0.m4s": false, "seg2.3.m4s": false, "seg3.0.m4s": true, "seg5.3.m4s": true,
+	} {
+		_, err := storage.Get(name)
+		if kept {
+			require.NoError(t, err, name)
+		} else {
+			require.ErrorIs(t, err, ErrNotFound, name)
+		}
+	}
+}
+
+func TestSegmenterLongKeyFrameInterval(t *testing.T) {
+	for _, honorPLI := range []bool{true, false} {
+		s, err := New(Options{TargetDuration: 2 * time.Second, PartDuration: 500 * time.Millisecond, LowLatency: true, Window: 10})
+		require.NoError(t, err)
+		video := newVideoStream(t, s)
+		video.gop = 50
+		plis := 0
+		s.tracks = append(s.tracks, &trackReader{pli: func() {
+			plis++
+			video.keyFrame = honorPLI
+		}})
+
+		video.write(t, 100)
+		require.NoError(t, s.Close())
+		playlist := string(s.Playlist())
+
+		// the header doesn't follow the stream
+		require.Contains(t, playlist, "#EXT-X-TARGETDURATION:2\n")
+		require.Contains(t, playlist, "#EXT-X-PART-INF:PART-TARGET=0.500\n")
+		var total time.Duration
+		for _, line := range strings.Split(playlist, "\n") {
+			if d, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+				duration, err := time.ParseDuration(strings.TrimSuffix(d, ",") + "s")
+				require.NoError(t, err)
+				require.LessOrEqual(t, duration, 2*time.Second, playlist)
+				total += duration
+			}
+		}
+		require.Equal(t, 10*time.Second, total)
+		require.Positive(t, plis)
+
+		// segments start without a key frame unless the publisher sent one
+		require.Equal(t, honorPLI, strings.Contains(playlist, "#EXT-X-INDEPENDENT-SEGMENTS"), playlist)
+	}
+}
+
+func segmentName(sequence, part int) string {
+	return (&segment{sequence: sequence}).partName(part)
+}
+
+func TestSegmenterWithoutLowLatency(t *testing.T) {
+	storage := NewMemoryStorage()
+	s, err := New(Options{Storage: storage})
+	require.NoError(t, err)
+	video := newVideoStream(t, s)
+
+	video.write(t, 60)
+	require.NoError(t, s.Close())
+	require.Equal(t, `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.000,
+seg1.m4s
+#EXTINF:2.000,
+seg2.m4s
+#EXT-X-ENDLIST
+`, string(s.Playlist()))
+
+	_, err = storage.Get("seg1.0.m4s")
+	require.ErrorIs(t, err, ErrNotFound, "parts are only written for low latency")
+}
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	s, err := New(Options{TargetDuration: 2 * time.Second, PartDuration: 500 * time.Millisecond, LowLatency: true})
+	require.NoError(t, err)
+	video := newVideoStream(t, s)
+	server := httptest.NewServer(http.StripPrefix("/alice", s.Handler()))
+	defer server.Close()
+
+	video.write(t, 6)
+
+	// blocking reload of a part not written yet
+	type response struct {
+		status int
+		body   string
+	}
+	playlist := make(chan response, 1)
+	go func() {
+		status, body := get(t, server.URL+"/alice/index.m3u8?_HLS_msn=1&_HLS_part=2")
+		playlist <- response{status, body}
+	}()
+	hint := make(chan response, 1)
+	go func() {
+		status, body := get(t, server.URL+"/alice/seg1.1.m4s")
+		hint <- response{status, body}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, playlist, 0)
+	require.Len(t, hint, 0)
+	video.write(t, 10)
+
+	r := <-hint
+	require.Equal(t, http.StatusOK, r.status)
+	part, err := s.Storage().Get("seg1.1.m4s")
+	require.NoError(t, err)
+	require.Equal(t, string(part), r.body)
+
+	r = <-playlist
+	require.Equal(t, http.StatusOK, r.status)
+	require.Contains(t, r.body, `URI="seg1.2.m4s"`)
+
+	status, body := get(t, server.URL+"/alice/index.m3u8")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, string(s.Playlist()), body)
+
+	status, _ = get(t, server.URL+"/alice/init.mp4")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = get(t, server.URL+"/alice/seg9.m4s")
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = get(t, server.URL+"/alice/index.m3u8?_HLS_msn=9")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	require.NoError(t, s.Close())
+	status, body = get(t, server.URL+"/alice/index.m3u8?_HLS_msn=2&_HLS_part=0")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, body, "#EXT-X-ENDLIST")
+}
+
+func TestDirStorage(t *testing.T) {
+	storage, err := NewDirStorage(t.TempDir() + "/stream")
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Put("seg1.m4s", []byte("data")))
+	data, err := storage.Get("seg1.m4s")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+
+	require.NoError(t, storage.Delete("seg1.m4s"))
+	require.NoError(t, storage.Delete("seg1.m4s"))
+	_, err = storage.Get("seg1.m4s")
+	require.ErrorIs(t, err, ErrNotFound)
+}
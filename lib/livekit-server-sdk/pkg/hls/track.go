@@ -0,0 +1,127 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hls
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/fmp4writer"
+
+	"github.com/livekit/server-sdk-go/v2/pkg/samplebuilder"
+)
+
+var ErrUnsupportedCodec = errors.New("hls: only h264 and opus tracks are supported")
+
+type trackReader struct {
+	track  *webrtc.TrackRemote
+	sb     *samplebuilder.SampleBuilder
+	writer *fmp4writer.Track
+	// asks for a key frame, nil for audio
+	pli func()
+}
+
+// AddTrack adds a subscribed H264 or Opus track, all tracks have to be added
+// before Start. pliWriter, e.g. RemoteParticipant.WritePLI, asks for a key
+// frame when the stream starts, when packets were lost and when a segment
+// would get too long, it may be nil.
+func (s *Segmenter) AddTrack(track *webrtc.TrackRemote, pliWriter func(webrtc.SSRC)) error {
+	codec := track.Codec()
+	var depacketizer rtp.Depacketizer
+	var maxLate uint16
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		depacketizer, maxLate = &codecs.H264Packet{}, samplebuilder.MaxVideoLate
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus):
+		depacketizer, maxLate = &codecs.OpusPacket{}, samplebuilder.MaxAudioLate
+	default:
+		return ErrUnsupportedCodec
+	}
+
+	writer, err := s.writer.AddTrack(fmp4writer.TrackConfig{
+		MimeType:  codec.MimeType,
+		ClockRate: codec.ClockRate,
+		Channels:  int(codec.Channels),
+	})
+	if err != nil {
+		return err
+	}
+
+	var opts []samplebuilder.Option
+	var pli func()
+	if pliWriter != nil && track.Kind() == webrtc.RTPCodecTypeVideo {
+		pli = func() {
+			pliWriter(track.SSRC())
+		}
+		opts = append(opts, samplebuilder.WithPacketDroppedHandler(pli))
+		// the stream starts with a key frame
+		pli()
+	}
+
+	s.tracks = append(s.tracks, &trackReader{
+		track:  track,
+		sb:     samplebuilder.New(maxLate, depacketizer, codec.ClockRate, opts...),
+		writer: writer,
+		pli:    pli,
+	})
+	return nil
+}
+
+// requestKeyFrame asks the video tracks for a key frame
+func (s *Segmenter) requestKeyFrame() {
+	for _, t := range s.tracks {
+		if t.pli != nil {
+			t.pli()
+		}
+	}
+}
+
+// Start reads the tracks until they end, the segmenter is closed afterwards
+func (s *Segmenter) Start() {
+	var wg sync.WaitGroup
+	for _, t := range s.tracks {
+		wg.Add(1)
+		go func(t *trackReader) {
+			defer wg.Done()
+			t.read()
+		}(t)
+	}
+	go func() {
+		wg.Wait()
+		if err := s.Close(); err != nil {
+			s.log.Warnw("could not end hls stream", err)
+		}
+	}()
+}
+
+func (t *trackReader) read() {
+	for {
+		pkt, _, err := t.track.ReadRTP()
+		if err != nil {
+			return
+		}
+		t.sb.Push(pkt)
+
+		for _, p := range t.sb.PopPackets() {
+			if err = t.writer.WriteRTP(p); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"context"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// FrameReader is read by a VideoEncoder for the next raw frame, it matches
+// mediadevices' video.Reader
+type FrameReader interface {
+	Read() (img image.Image, release func(), err error)
+}
+
+// EncodedReader reads encoded frames, it matches mediadevices'
+// codec.ReadCloser. A reader with a ForceKeyFrame() error method is asked
+// for a key frame on PLI.
+type EncodedReader interface {
+	Read() (frame []byte, release func(), err error)
+	Close() error
+}
+
+// VideoEncoder encodes the raw video of test patterns, images and y4m
+type VideoEncoder interface {
+	// MimeType is the codec of the published track, e.g. webrtc.MimeTypeH264
+	MimeType() string
+	// Encode starts encoding frames at a bitrate in bits per second
+	Encode(frames FrameReader, width, height int, frameRate float64, bitrate int) (EncodedReader, error)
+}
+
+type keyFrameForcer interface {
+	ForceKeyFrame() error
+}
+
+// frames is a raw video source
+type frames interface {
+	FrameReader
+	size() (width, height int)
+	frameRate() float64
+}
+
+// encodedProvider provides the encoded frames of a raw video source as
+// samples, their pace comes from the writer of the track
+type encodedProvider struct {
+	mu       sync.Mutex
+	reader   EncodedReader
+	duration time.Duration
+	// no frames are read and encoded while no subscriber wants the track
+	paused atomic.Bool
+}
+
+func newEncodedProvider(f frames, encoder VideoEncoder, bitrate int) (*encodedProvider, error) {
+	width, height := f.size()
+	reader, err := encoder.Encode(f, width, height, f.frameRate(), bitrate)
+	if err != nil {
+		return nil, err
+	}
+	return &encodedProvider{
+		reader:   reader,
+		duration: time.Duration(float64(time.Second) / f.frameRate()),
+	}, nil
+}
+
+func (p *encodedProvider) NextSample(ctx context.Context) (media.Sample, error) {
+	if p.paused.Load() {
+		// nothing is sent, the track's timestamps go on
+		return media.Sample{Duration: p.duration}, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	frame, release, err := p.reader.Read()
+	if err != nil {
+		return media.Sample{}, err
+	}
+	// the encoder reuses its buffer after release
+	sample := media.Sample{Data: append([]byte(nil), frame...), Duration: p.duration}
+	release()
+	return sample, nil
+}
+
+// onRTCP asks the encoder for a key frame when a subscriber lost one
+func (p *encodedProvider) onRTCP(pkt rtcp.Packet) {
+	switch pkt.(type) {
+	case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+	default:
+		return
+	}
+	p.forceKeyFrame()
+}
+
+func (p *encodedProvider) forceKeyFrame() {
+	if forcer, ok := p.reader.(keyFrameForcer); ok {
+		_ = forcer.ForceKeyFrame()
+	}
+}
+
+// onSubscribed pauses the encoder while the server doesn't want the track,
+// it resumes with a key frame
+func (p *encodedProvider) onSubscribed(subscribed bool) {
+	if p.paused.Swap(!subscribed) && subscribed {
+		p.forceKeyFrame()
+	}
+}
+
+func (p *encodedProvider) OnBind() error {
+	return nil
+}
+
+func (p *encodedProvider) OnUnbind() error {
+	return nil
+}
+
+// Close stops the encoder
+func (p *encodedProvider) Close() error {
+	return p.reader.Close()
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// fileProvider publishes an .ivf, .h264 or .ogg file as is and opens it
+// again at its end
+type fileProvider struct {
+	mu       sync.Mutex
+	name     string
+	provider *lksdk.ReaderSampleProvider
+}
+
+func newFilePart(name string) (*providerPart, error) {
+	provider, err := lksdk.NewFileSampleProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &fileProvider{name: name, provider: provider}
+	return newProviderPart(provider.Mime, f, filepath.Base(name)), nil
+}
+
+func (f *fileProvider) NextSample(ctx context.Context) (media.Sample, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sample, err := f.provider.NextSample(ctx)
+	if err != io.EOF {
+		return sample, err
+	}
+
+	_ = f.provider.Close()
+	if f.provider, err = lksdk.NewFileSampleProvider(f.name); err != nil {
+		return sample, err
+	}
+	if err = f.provider.OnBind(); err != nil {
+		return sample, err
+	}
+	return f.provider.NextSample(ctx)
+}
+
+func (f *fileProvider) OnBind() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.provider.OnBind()
+}
+
+func (f *fileProvider) OnUnbind() error {
+	return nil
+}
+
+func (f *fileProvider) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.provider.Close()
+}
+
+func (f *fileProvider) CurrentAudioLevel() uint8 {
+	return f.provider.CurrentAudioLevel()
+}
+
+// stdinPart publishes stdin until it ends, y4m is encoded, the other formats
+// are published as is
+func stdinPart(format string, opts Options) (part, error) {
+	in := stdin{bufio.NewReader(os.Stdin)}
+	var mime string
+	switch format {
+	case "y4m":
+		return y4mVideo(in, opts, "stdin")
+	case "h264":
+		mime = webrtc.MimeTypeH264
+	case "ivf":
+		// the codec's fourcc follows the signature, version and header size
+		header, err := in.Peek(12)
+		if err != nil {
+			return nil, err
+		}
+		switch string(header[8:11]) {
+		case "VP8":
+			mime = webrtc.MimeTypeVP8
+		case "VP9":
+			mime = webrtc.MimeTypeVP9
+		default:
+			return nil, lksdk.ErrCannotDetermineMime
+		}
+	case "ogg":
+		mime = webrtc.MimeTypeOpus
+	default:
+		return nil, fmt.Errorf("%w: stdin:%s", ErrUnknownSource, format)
+	}
+	provider, err := lksdk.NewReaderSampleProvider(in, mime)
+	if err != nil {
+		return nil, err
+	}
+	return newProviderPart(mime, provider, "stdin"), nil
+}
+
+type stdin struct {
+	*bufio.Reader
+}
+
+func (stdin) Close() error {
+	return os.Stdin.Close()
+}
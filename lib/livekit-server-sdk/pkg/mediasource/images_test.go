@@ -0,0 +1,71 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePNG(t *testing.T, name string, width, height int, c color.Color) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, img))
+}
+
+func TestImages(t *testing.T) {
+	dir := t.TempDir()
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	writePNG(t, filepath.Join(dir, "frame-1.png"), 8, 4, red)
+	writePNG(t, filepath.Join(dir, "frame-2.png"), 4, 4, blue)
+	writePNG(t, filepath.Join(dir, "other.png"), 4, 4, blue)
+
+	s, err := newImages(filepath.Join(dir, "frame-*.png"), 10)
+	require.NoError(t, err)
+	w, h := s.size()
+	require.Equal(t, 8, w)
+	require.Equal(t, 4, h)
+	require.Equal(t, 10.0, s.frameRate())
+
+	for i := 0; i < 2; i++ {
+		img, _, err := s.Read()
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 8, 4), img.Bounds())
+		require.Equal(t, color.RGBAModel.Convert(red), color.RGBAModel.Convert(img.At(7, 3)))
+
+		// smaller images are padded
+		img, _, err = s.Read()
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 8, 4), img.Bounds())
+		require.Equal(t, color.RGBAModel.Convert(blue), color.RGBAModel.Convert(img.At(3, 3)))
+		require.Equal(t, color.RGBA{}, color.RGBAModel.Convert(img.At(7, 3)))
+	}
+
+	_, err = newImages(filepath.Join(dir, "*.jpg"), 10)
+	require.ErrorIs(t, err, errNoImages)
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"fmt"
+	"image"
+	"sync"
+)
+
+// bars are the colors of the test pattern's bars as Y, Cb, Cr: white,
+// yellow, cyan, green, magenta, red, blue at 75%
+var bars = [][3]uint8{
+	{180, 128, 128},
+	{162, 44, 142},
+	{131, 156, 44},
+	{112, 72, 58},
+	{84, 184, 198},
+	{65, 100, 212},
+	{35, 212, 114},
+}
+
+// segments of the digits 0-9, bits a-g of a seven segment display
+var digitSegments = [10]uint8{0x3f, 0x06, 0x5b, 0x4f, 0x66, 0x6d, 0x7d, 0x07, 0x7f, 0x6f}
+
+// pattern renders color bars, a box moving one step per frame and the
+// timecode of the frame, so freezes, drops and delays can be seen on the
+// subscriber side
+type pattern struct {
+	mu     sync.Mutex
+	width  int
+	height int
+	rate   int
+	frame  int
+	// the bars, copied to every frame
+	background *image.YCbCr
+}
+
+func newPattern(width, height, rate int) *pattern {
+	p := &pattern{width: width, height: height, rate: rate}
+	p.background = image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	top := height * 2 / 3
+	for i, c := range bars {
+		fillRect(p.background, image.Rect(i*width/len(bars), 0, (i+1)*width/len(bars), top), c)
+	}
+	fillRect(p.background, image.Rect(0, top, width, height), [3]uint8{16, 128, 128})
+	return p
+}
+
+func (p *pattern) size() (int, int) {
+	return p.width, p.height
+}
+
+func (p *pattern) frameRate() float64 {
+	return float64(p.rate)
+}
+
+// Read renders the next frame
+func (p *pattern) Read() (image.Image, func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	img := p.render(p.frame)
+	p.frame++
+	return img, func() {}, nil
+}
+
+func (p *pattern) render(frame int) *image.YCbCr {
+	img := image.NewYCbCr(p.background.Rect, p.background.SubsampleRatio)
+	copy(img.Y, p.background.Y)
+	copy(img.Cb, p.background.Cb)
+	copy(img.Cr, p.background.Cr)
+
+	top := p.height * 2 / 3
+	bottom := p.height - top
+	white := [3]uint8{235, 128, 128}
+
+	// the box crosses the frame in two seconds
+	box := bottom / 4
+	steps := 2 * p.rate
+	x := (frame % steps) * (p.width - box) / steps
+	fillRect(img, image.Rect(x, top+box/2, x+box, top+box/2+box), white)
+
+	// digits fill the lower half of the bottom area
+	digitHeight := bottom / 3
+	digitWidth := digitHeight / 2
+	text := timecode(frame, p.rate)
+	x = (p.width - len(text)*digitWidth*3/2) / 2
+	y := p.height - digitHeight - box/2
+	for _, c := range text {
+		if c >= '0' && c <= '9' {
+			drawDigit(img, image.Rect(x, y, x+digitWidth, y+digitHeight), digitSegments[c-'0'], white)
+		} else {
+			dot := digitWidth / 5
+			fillRect(img, image.Rect(x+digitWidth/2-dot/2, y+digitHeight/4, x+digitWidth/2+dot-dot/2, y+digitHeight/4+dot), white)
+			fillRect(img, image.Rect(x+digitWidth/2-dot/2, y+digitHeight*3/4-dot, x+digitWidth/2+dot-dot/2, y+digitHeight*3/4), white)
+		}
+		x += digitWidth * 3 / 2
+	}
+	return img
+}
+
+// timecode formats a frame number as HH:MM:SS:FF
+func timecode(frame, rate int) string {
+	seconds := frame / rate
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60, frame%rate)
+}
+
+// drawDigit draws the segments of a seven segment digit into r
+func drawDigit(img *image.YCbCr, r image.Rectangle, segments uint8, c [3]uint8) {
+	t := r.Dx() / 5
+	if t < 1 {
+		t = 1
+	}
+	mid := r.Min.Y + r.Dy()/2
+	rects := [7]image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t), // a
+		image.Rect(r.Max.X-t, r.Min.Y, r.Max.X, mid),     // b
+		image.Rect(r.Max.X-t, mid, r.Max.X, r.Max.Y),     // c
+		image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y), // d
+		image.Rect(r.Min.X, mid, r.Min.X+t, r.Max.Y),     // e
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+t, mid),     // f
+		image.Rect(r.Min.X, mid-t/2, r.Max.X, mid-t/2+t), // g
+	}
+	for i, rect := range rects {
+		if segments&(1<<i) != 0 {
+			fillRect(img, rect, c)
+		}
+	}
+}
+
+// fillRect fills r, clipped to the image, with a Y, Cb, Cr color
+func fillRect(img *image.YCbCr, r image.Rectangle, c [3]uint8) {
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Y[img.YOffset(r.Min.X, y) : img.YOffset(r.Max.X-1, y)+1]
+		for i := range row {
+			row[i] = c[0]
+		}
+	}
+	for y := r.Min.Y; y < r.Max.Y; y += 2 {
+		for x := r.Min.X; x < r.Max.X; x += 2 {
+			i := img.COffset(x, y)
+			img.Cb[i], img.Cr[i] = c[1], c[2]
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimecode(t *testing.T) {
+	require.Equal(t, "00:00:00:00", timecode(0, 30))
+	require.Equal(t, "00:00:01:05", timecode(35, 30))
+	require.Equal(t, "01:01:01:29", timecode((3600+60+1)*30+29, 30))
+}
+
+func TestPattern(t *testing.T) {
+	p := newPattern(320, 180, 30)
+	w, h := p.size()
+	require.Equal(t, 320, w)
+	require.Equal(t, 180, h)
+
+	first, release, err := p.Read()
+	require.NoError(t, err)
+	release()
+	second, _, err := p.Read()
+	require.NoError(t, err)
+
+	img := first.(*image.YCbCr)
+	require.Equal(t, image.Rect(0, 0, 320, 180), img.Rect)
+	// bars on top, white to blue
+	require.Equal(t, bars[0][0], img.Y[img.YOffset(10, 10)])
+	require.Equal(t, bars[6][1], img.Cb[img.COffset(310, 10)])
+	// the box moved and the frame digit changed
+	require.NotEqual(t, img.Y, second.(*image.YCbCr).Y)
+	// the background isn't drawn on
+	require.Equal(t, uint8(16), p.background.Y[p.background.YOffset(0, 179)])
+	require.Equal(t, p.background.Y, newPattern(320, 180, 30).background.Y)
+}
+
+func TestDrawDigit(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 10, 20), image.YCbCrSubsampleRatio420)
+	drawDigit(img, img.Rect, digitSegments[1], [3]uint8{235, 128, 128})
+	// 1 is the right segments only
+	require.Equal(t, uint8(235), img.Y[img.YOffset(9, 5)])
+	require.Equal(t, uint8(235), img.Y[img.YOffset(9, 15)])
+	require.Equal(t, uint8(0), img.Y[img.YOffset(0, 5)])
+	require.Equal(t, uint8(0), img.Y[img.YOffset(5, 0)])
+}
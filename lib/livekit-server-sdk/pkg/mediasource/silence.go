@@ -0,0 +1,52 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"context"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// an Opus frame(TOC 0xf8: CELT fullband 20ms, mono) decoding to silence
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+const opusFrameDuration = 20 * time.Millisecond
+
+// silenceProvider provides silent Opus frames, the audio track of test
+// patterns
+type silenceProvider struct{}
+
+func (silenceProvider) NextSample(ctx context.Context) (media.Sample, error) {
+	return media.Sample{Data: opusSilence, Duration: opusFrameDuration}, nil
+}
+
+func (silenceProvider) OnBind() error {
+	return nil
+}
+
+func (silenceProvider) OnUnbind() error {
+	return nil
+}
+
+func (silenceProvider) Close() error {
+	return nil
+}
+
+func (silenceProvider) CurrentAudioLevel() uint8 {
+	// -127dBov
+	return 127
+}
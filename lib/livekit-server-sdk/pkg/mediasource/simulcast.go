@@ -0,0 +1,208 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/livekit/protocol/livekit"
+	protoLogger "github.com/livekit/protocol/logger"
+	"github.com/livekit/protocol/utils/guid"
+	"github.com/pion/webrtc/v3"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// simulcastLayers are the layers of a simulcast track as size divisor and
+// share of the bitrate, high to low
+var simulcastLayers = []struct {
+	quality livekit.VideoQuality
+	divisor int
+	bitrate float64
+}{
+	{livekit.VideoQuality_HIGH, 1, 1},
+	{livekit.VideoQuality_MEDIUM, 2, 0.3},
+	{livekit.VideoQuality_LOW, 4, 0.1},
+}
+
+// simulcastPart encodes a raw video source at full, half and quarter size and
+// publishes the layers as one track. Each layer's encoder pauses while no
+// subscriber wants its quality.
+type simulcastPart struct {
+	mime    string
+	layers  []*layer
+	options lksdk.TrackPublicationOptions
+}
+
+type layer struct {
+	info     *livekit.VideoLayer
+	provider *encodedProvider
+}
+
+func newSimulcastPart(f frames, opts Options, name string) (*simulcastPart, error) {
+	if opts.Encoder == nil {
+		return nil, ErrNoEncoder
+	}
+	fan := &fanout{src: f}
+	width, height := f.size()
+	p := &simulcastPart{
+		mime: opts.Encoder.MimeType(),
+		options: lksdk.TrackPublicationOptions{
+			Name:        name,
+			Source:      livekit.TrackSource_CAMERA,
+			VideoWidth:  width,
+			VideoHeight: height,
+		},
+	}
+	for _, l := range simulcastLayers {
+		frames := &layerFrames{fan: fan, width: width, height: height}
+		if l.divisor > 1 {
+			// encoders want even sizes
+			frames.width, frames.height = width/l.divisor&^1, height/l.divisor&^1
+		}
+		bitrate := int(float64(opts.Bitrate) * l.bitrate)
+		provider, err := newEncodedProvider(frames, opts.Encoder, bitrate)
+		if err != nil {
+			_ = p.close()
+			return nil, err
+		}
+		p.layers = append(p.layers, &layer{
+			info: &livekit.VideoLayer{
+				Quality: l.quality,
+				Width:   uint32(frames.width),
+				Height:  uint32(frames.height),
+				Bitrate: uint32(bitrate),
+			},
+			provider: provider,
+		})
+	}
+	return p, nil
+}
+
+func (p *simulcastPart) track() (Track, error) {
+	id := guid.New("TR_")
+	t := Track{Options: &lksdk.TrackPublicationOptions{}}
+	*t.Options = p.options
+	for _, l := range p.layers {
+		provider, quality := l.provider, l.info.Quality
+		track, err := lksdk.NewLocalTrack(webrtc.RTPCodecCapability{MimeType: p.mime},
+			lksdk.WithSimulcast(id, l.info),
+			lksdk.WithRTCPHandler(provider.onRTCP),
+		)
+		if err != nil {
+			_ = t.Close()
+			return Track{}, err
+		}
+		// the layers of a new track start subscribed
+		provider.onSubscribed(true)
+		track.OnLayerSubscribed(provider.onSubscribed)
+		track.OnBind(func() {
+			if err := track.StartWrite(shared{provider}, nil); err != nil {
+				protoLogger.GetLogger().Warnw("could not start writing", err, "track", p.options.Name, "quality", quality)
+			}
+		})
+		t.Layers = append(t.Layers, track)
+	}
+	return t, nil
+}
+
+func (p *simulcastPart) close() error {
+	var err error
+	for _, l := range p.layers {
+		if e := l.provider.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// fanout reads each frame of a source once for all layers
+type fanout struct {
+	mu      sync.Mutex
+	src     frames
+	frame   image.Image
+	release func()
+	seq     int
+}
+
+// layerFrames are the frames of a fanout scaled to a layer
+type layerFrames struct {
+	fan           *fanout
+	width, height int
+	// the last frame read
+	seq int
+}
+
+func (l *layerFrames) size() (int, int) {
+	return l.width, l.height
+}
+
+func (l *layerFrames) frameRate() float64 {
+	return l.fan.src.frameRate()
+}
+
+// Read reads the frame other layers read, or the next one if this layer
+// already read it
+func (l *layerFrames) Read() (image.Image, func(), error) {
+	f := l.fan
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.frame == nil || l.seq == f.seq {
+		img, release, err := f.src.Read()
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.release != nil {
+			f.release()
+		}
+		f.frame, f.release = img, release
+		f.seq++
+	}
+	l.seq = f.seq
+	return scale(f.frame, l.width, l.height), func() {}, nil
+}
+
+// scale resizes a frame to a 4:2:0 frame by picking the nearest pixels
+func scale(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return img
+	}
+	dst := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	src, ycbcr := img.(*image.YCbCr)
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := b.Min.X + x*b.Dx()/width
+			if ycbcr {
+				dst.Y[dst.YOffset(x, y)] = src.Y[src.YOffset(sx, sy)]
+				if x%2 == 0 && y%2 == 0 {
+					si, di := src.COffset(sx, sy), dst.COffset(x, y)
+					dst.Cb[di], dst.Cr[di] = src.Cb[si], src.Cr[si]
+				}
+				continue
+			}
+			c := color.YCbCrModel.Convert(img.At(sx, sy)).(color.YCbCr)
+			dst.Y[dst.YOffset(x, y)] = c.Y
+			if x%2 == 0 && y%2 == 0 {
+				i := dst.COffset(x, y)
+				dst.Cb[i], dst.Cr[i] = c.Cb, c.Cr
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/stretchr/testify/require"
+)
+
+// counter's frames are filled with their number
+type counter struct {
+	n int
+}
+
+func (c *counter) Read() (image.Image, func(), error) {
+	c.n++
+	img := image.NewYCbCr(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio420)
+	for _, plane := range [][]byte{img.Y, img.Cb, img.Cr} {
+		for i := range plane {
+			plane[i] = byte(c.n)
+		}
+	}
+	return img, func() {}, nil
+}
+
+func (c *counter) size() (int, int) {
+	return 8, 4
+}
+
+func (c *counter) frameRate() float64 {
+	return 30
+}
+
+func TestSimulcastLayers(t *testing.T) {
+	encoder := &testEncoder{}
+	parts, err := parsePart("pattern", "", Options{Width: 640, Height: 360, FrameRate: 30, Encoder: encoder, Bitrate: 1000000, Simulcast: true})
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	video := parts[0].(*simulcastPart)
+	require.Equal(t, 640, video.options.VideoWidth)
+
+	var layers []*livekit.VideoLayer
+	for _, l := range video.layers {
+		layers = append(layers, l.info)
+	}
+	require.Equal(t, []*livekit.VideoLayer{
+		{Quality: livekit.VideoQuality_HIGH, Width: 640, Height: 360, Bitrate: 1000000},
+		{Quality: livekit.VideoQuality_MEDIUM, Width: 320, Height: 180, Bitrate: 300000},
+		{Quality: livekit.VideoQuality_LOW, Width: 160, Height: 90, Bitrate: 100000},
+	}, layers)
+	require.Equal(t, []int{1000000, 300000, 100000}, encoder.bitrates)
+
+	require.NoError(t, video.close())
+	require.True(t, encoder.closed)
+}
+
+func TestFanout(t *testing.T) {
+	src := &counter{}
+	fan := &fanout{src: src}
+	full := &layerFrames{fan: fan, width: 8, height: 4}
+	half := &layerFrames{fan: fan, width: 4, height: 2}
+
+	read := func(l *layerFrames) *image.YCbCr {
+		img, _, err := l.Read()
+		require.NoError(t, err)
+		return img.(*image.YCbCr)
+	}
+	// layers get every frame once
+	require.Equal(t, uint8(1), read(full).Y[0])
+	frame := read(half)
+	require.Equal(t, image.Rect(0, 0, 4, 2), frame.Rect)
+	require.Equal(t, []byte{1, 1, 1, 1, 1, 1, 1, 1}, frame.Y)
+	require.Equal(t, []byte{1, 1}, frame.Cb)
+	require.Equal(t, uint8(2), read(full).Y[0])
+	require.Equal(t, uint8(2), read(half).Y[0])
+	// a paused layer doesn't hold the others back
+	require.Equal(t, uint8(3), read(half).Y[0])
+	require.Equal(t, uint8(4), read(half).Y[0])
+	require.Equal(t, uint8(4), read(full).Y[0])
+	require.Equal(t, 4, src.n)
+}
+
+func TestScale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 60), 0, 0, 255})
+		}
+	}
+	require.Same(t, img, scale(img, 4, 4))
+
+	scaled := scale(img, 2, 2).(*image.YCbCr)
+	require.Equal(t, image.Rect(0, 0, 2, 2), scaled.Rect)
+	left := color.YCbCrModel.Convert(img.At(0, 0)).(color.YCbCr)
+	right := color.YCbCrModel.Convert(img.At(2, 0)).(color.YCbCr)
+	require.Equal(t, []byte{left.Y, right.Y, left.Y, right.Y}, scaled.Y)
+	require.Equal(t, []byte{left.Cb}, scaled.Cb)
+}
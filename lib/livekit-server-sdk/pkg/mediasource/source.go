@@ -0,0 +1,307 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mediasource provides what the publisher publishes: test patterns,
+// image sequences, Y4M video, IVF/H264/Ogg files and stdin. A source is
+// parsed from a spec like "pattern" or "y4m:clip.y4m,file:music.ogg", its
+// tracks are created for every join while the media goes on.
+package mediasource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/livekit/protocol/livekit"
+	protoLogger "github.com/livekit/protocol/logger"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+var (
+	ErrUnknownSource = errors.New("mediasource: unknown source")
+	ErrNoEncoder     = errors.New("mediasource: raw video needs an encoder")
+)
+
+// MediaSource provides the tracks of a participant
+type MediaSource interface {
+	// Tracks creates the tracks to publish, for every join. The tracks of
+	// the previous join have to be closed before.
+	Tracks() ([]Track, error)
+	// Close stops the media, encoders and readers
+	Close() error
+}
+
+// Track is published with its options
+type Track struct {
+	// nil for simulcast tracks
+	*lksdk.LocalTrack
+	// Simulcast layers, high to low
+	Layers  []*lksdk.LocalTrack
+	Options *lksdk.TrackPublicationOptions
+}
+
+// Publish publishes the track, or its layers as simulcast track
+func (t Track) Publish(p *lksdk.LocalParticipant) (*lksdk.LocalTrackPublication, error) {
+	if len(t.Layers) > 0 {
+		return p.PublishSimulcastTrack(t.Layers, t.Options)
+	}
+	return p.PublishTrack(t.LocalTrack, t.Options)
+}
+
+// Close closes the track or its layers
+func (t Track) Close() error {
+	for _, l := range t.Layers {
+		_ = l.Close()
+	}
+	if t.LocalTrack != nil {
+		return t.LocalTrack.Close()
+	}
+	return nil
+}
+
+type Options struct {
+	// Size and frame rate of test patterns, images use the rate only and
+	// y4m brings both
+	Width, Height, FrameRate int
+	// Encodes test patterns, images and y4m
+	Encoder VideoEncoder
+	// Bits per second of encoded video, the full layer's for simulcast
+	Bitrate int
+	// Raw video is published as simulcast track of full, half and quarter
+	// size layers
+	Simulcast bool
+}
+
+// part is a piece of a source publishing one track
+type part interface {
+	track() (Track, error)
+	close() error
+}
+
+type source struct {
+	parts []part
+}
+
+// Parse creates a source from a comma separated list of
+//
+//	pattern          color bars with a moving box and timecode, and silence
+//	images:<glob>    PNG or JPEG files, one per frame
+//	y4m:<file>       raw 4:2:0 video
+//	file:<file>      .ivf, .h264 or .ogg published as is
+//	stdin:<format>   h264, ivf, ogg or y4m from stdin
+//
+// Everything but stdin starts over at its end.
+func Parse(spec string, opts Options) (MediaSource, error) {
+	s := &source{}
+	for _, item := range strings.Split(spec, ",") {
+		kind, arg, _ := strings.Cut(strings.TrimSpace(item), ":")
+		parts, err := parsePart(kind, arg, opts)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("%s: %w", item, err)
+		}
+		s.parts = append(s.parts, parts...)
+	}
+	return s, nil
+}
+
+func parsePart(kind, arg string, opts Options) ([]part, error) {
+	switch kind {
+	case "pattern":
+		video, err := rawVideo(newPattern(opts.Width, opts.Height, opts.FrameRate), opts, "pattern")
+		if err != nil {
+			return nil, err
+		}
+		audio := newProviderPart(webrtc.MimeTypeOpus, silenceProvider{}, "silence")
+		return []part{video, audio}, nil
+	case "images":
+		images, err := newImages(arg, opts.FrameRate)
+		if err != nil {
+			return nil, err
+		}
+		video, err := rawVideo(images, opts, "images")
+		if err != nil {
+			return nil, err
+		}
+		return []part{video}, nil
+	case "y4m":
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, err
+		}
+		video, err := y4mVideo(f, opts, filepath.Base(arg))
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return []part{video}, nil
+	case "file":
+		p, err := newFilePart(arg)
+		if err != nil {
+			return nil, err
+		}
+		return []part{p}, nil
+	case "stdin":
+		p, err := stdinPart(arg, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []part{p}, nil
+	}
+	return nil, ErrUnknownSource
+}
+
+// Tracks creates a track per part
+func (s *source) Tracks() ([]Track, error) {
+	var tracks []Track
+	for _, p := range s.parts {
+		t, err := p.track()
+		if err != nil {
+			for _, t := range tracks {
+				_ = t.Close()
+			}
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+func (s *source) Close() error {
+	var errs []error
+	for _, p := range s.parts {
+		errs = append(errs, p.close())
+	}
+	return errors.Join(errs...)
+}
+
+// providerPart writes one provider to the tracks of all joins, so the media
+// goes on where it was
+type providerPart struct {
+	codec    webrtc.RTPCodecCapability
+	provider lksdk.SampleProvider
+	options  lksdk.TrackPublicationOptions
+	onRTCP   func(rtcp.Packet)
+	// called when the server pauses or resumes the track
+	onSubscribed func(bool)
+}
+
+func newProviderPart(mime string, provider lksdk.SampleProvider, name string) *providerPart {
+	p := &providerPart{
+		codec:    webrtc.RTPCodecCapability{MimeType: mime},
+		provider: provider,
+		options:  lksdk.TrackPublicationOptions{Name: name, Source: livekit.TrackSource_CAMERA},
+	}
+	if mime == webrtc.MimeTypeOpus {
+		p.options.Source = livekit.TrackSource_MICROPHONE
+	}
+	return p
+}
+
+// rawVideo encodes the frames of a raw video source
+func rawVideo(f frames, opts Options, name string) (part, error) {
+	if opts.Simulcast {
+		return newSimulcastPart(f, opts, name)
+	}
+	if opts.Encoder == nil {
+		return nil, ErrNoEncoder
+	}
+	provider, err := newEncodedProvider(f, opts.Encoder, opts.Bitrate)
+	if err != nil {
+		return nil, err
+	}
+	p := newProviderPart(opts.Encoder.MimeType(), provider, name)
+	p.options.VideoWidth, p.options.VideoHeight = f.size()
+	p.onRTCP = provider.onRTCP
+	p.onSubscribed = provider.onSubscribed
+	return p, nil
+}
+
+func y4mVideo(in io.ReadCloser, opts Options, name string) (part, error) {
+	y, err := newY4M(in)
+	if err != nil {
+		return nil, err
+	}
+	p, err := rawVideo(y, opts, name)
+	if err != nil {
+		return nil, err
+	}
+	return closing{p, in}, nil
+}
+
+func (p *providerPart) track() (Track, error) {
+	var opts []lksdk.LocalTrackOptions
+	if p.onRTCP != nil {
+		opts = append(opts, lksdk.WithRTCPHandler(p.onRTCP))
+	}
+	track, err := lksdk.NewLocalTrack(p.codec, opts...)
+	if err != nil {
+		return Track{}, err
+	}
+	if p.onSubscribed != nil {
+		// a new track starts subscribed
+		p.onSubscribed(true)
+		track.OnLayerSubscribed(p.onSubscribed)
+	}
+
+	// the track closes its provider, the part's provider outlives it
+	var provider lksdk.SampleProvider = shared{p.provider}
+	if audio, ok := p.provider.(lksdk.AudioSampleProvider); ok && p.codec.MimeType == webrtc.MimeTypeOpus {
+		provider = sharedAudio{audio}
+	}
+	track.OnBind(func() {
+		if err := track.StartWrite(provider, nil); err != nil {
+			protoLogger.GetLogger().Warnw("could not start writing", err, "track", p.options.Name)
+		}
+	})
+
+	options := p.options
+	return Track{LocalTrack: track, Options: &options}, nil
+}
+
+func (p *providerPart) close() error {
+	return p.provider.Close()
+}
+
+// closing closes a reader with its part, e.g. the file read
+type closing struct {
+	part
+	closer io.Closer
+}
+
+func (c closing) close() error {
+	return errors.Join(c.part.close(), c.closer.Close())
+}
+
+type shared struct {
+	lksdk.SampleProvider
+}
+
+func (shared) Close() error {
+	return nil
+}
+
+type sharedAudio struct {
+	lksdk.AudioSampleProvider
+}
+
+func (sharedAudio) Close() error {
+	return nil
+}
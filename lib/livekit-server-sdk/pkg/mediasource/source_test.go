@@ -0,0 +1,162 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/stretchr/testify/require"
+)
+
+// testEncoder "encodes" a frame to its first luma byte
+type testEncoder struct {
+	size      image.Point
+	rate      float64
+	bitrates  []int
+	keyFrames int
+	closed    bool
+}
+
+func (e *testEncoder) MimeType() string {
+	return webrtc.MimeTypeH264
+}
+
+func (e *testEncoder) Encode(frames FrameReader, width, height int, frameRate float64, bitrate int) (EncodedReader, error) {
+	e.size, e.rate = image.Pt(width, height), frameRate
+	e.bitrates = append(e.bitrates, bitrate)
+	return &testEncoded{e, frames}, nil
+}
+
+type testEncoded struct {
+	*testEncoder
+	frames FrameReader
+}
+
+func (r *testEncoded) Read() ([]byte, func(), error) {
+	img, release, err := r.frames.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	release()
+	return img.(*image.YCbCr).Y[:1], func() {}, nil
+}
+
+func (r *testEncoded) ForceKeyFrame() error {
+	r.keyFrames++
+	return nil
+}
+
+func (r *testEncoded) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestParsePattern(t *testing.T) {
+	encoder := &testEncoder{}
+	parts, err := parsePart("pattern", "", Options{Width: 64, Height: 36, FrameRate: 25, Encoder: encoder, Bitrate: 500000})
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	require.Equal(t, image.Pt(64, 36), encoder.size)
+	require.Equal(t, 25.0, encoder.rate)
+	require.Equal(t, []int{500000}, encoder.bitrates)
+
+	video := parts[0].(*providerPart)
+	require.Equal(t, webrtc.MimeTypeH264, video.codec.MimeType)
+	require.Equal(t, livekit.TrackSource_CAMERA, video.options.Source)
+	require.Equal(t, 64, video.options.VideoWidth)
+	sample, err := video.provider.NextSample(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 40*time.Millisecond, sample.Duration)
+	require.Len(t, sample.Data, 1)
+
+	video.onRTCP(&rtcp.PictureLossIndication{})
+	video.onRTCP(&rtcp.ReceiverReport{})
+	require.Equal(t, 1, encoder.keyFrames)
+
+	// paused by the server, resumed with a key frame
+	video.onSubscribed(false)
+	sample, err = video.provider.NextSample(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, sample.Data)
+	require.Equal(t, 40*time.Millisecond, sample.Duration)
+	video.onSubscribed(true)
+	require.Equal(t, 2, encoder.keyFrames)
+	video.onSubscribed(true)
+	require.Equal(t, 2, encoder.keyFrames)
+	sample, err = video.provider.NextSample(context.Background())
+	require.NoError(t, err)
+	require.Len(t, sample.Data, 1)
+
+	audio := parts[1].(*providerPart)
+	require.Equal(t, webrtc.MimeTypeOpus, audio.codec.MimeType)
+	require.Equal(t, livekit.TrackSource_MICROPHONE, audio.options.Source)
+	sample, err = audio.provider.NextSample(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, opusFrameDuration, sample.Duration)
+
+	for _, p := range parts {
+		require.NoError(t, p.close())
+	}
+	require.True(t, encoder.closed)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("pattern", Options{Width: 64, Height: 36, FrameRate: 25})
+	require.ErrorIs(t, err, ErrNoEncoder)
+	_, err = Parse("pattern,camera", Options{Width: 64, Height: 36, FrameRate: 25, Encoder: &testEncoder{}})
+	require.ErrorIs(t, err, ErrUnknownSource)
+	_, err = Parse("stdin:mp4", Options{})
+	require.ErrorIs(t, err, ErrUnknownSource)
+	_, err = Parse("y4m:missing.y4m", Options{Encoder: &testEncoder{}})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileStartsOver(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audio.ogg")
+	w, err := oggwriter.New(name, 48000, 2)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(i * 960)},
+			Payload: opusSilence,
+		}))
+	}
+	require.NoError(t, w.Close())
+
+	parts, err := parsePart("file", name, Options{})
+	require.NoError(t, err)
+	file := parts[0].(*providerPart)
+	require.Equal(t, webrtc.MimeTypeOpus, file.codec.MimeType)
+	require.Equal(t, "audio.ogg", file.options.Name)
+
+	require.NoError(t, file.provider.OnBind())
+	for i := 0; i < 7; i++ {
+		sample, err := file.provider.NextSample(context.Background())
+		require.NoError(t, err, i)
+		require.Equal(t, opusSilence, sample.Data)
+		require.Equal(t, opusFrameDuration, sample.Duration)
+	}
+	require.NoError(t, file.close())
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errY4M = errors.New("mediasource: not a 4:2:0 y4m stream")
+
+// y4m reads raw I420 frames of a YUV4MPEG2 stream. Seekable streams start
+// over at their end.
+type y4m struct {
+	mu     sync.Mutex
+	in     io.Reader
+	r      *bufio.Reader
+	width  int
+	height int
+	rate   float64
+	// offset of the first frame, for starting over
+	start int64
+}
+
+func newY4M(in io.Reader) (*y4m, error) {
+	r := bufio.NewReader(in)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return nil, errY4M
+	}
+
+	y := &y4m{in: in, r: r, rate: 30, start: int64(len(header))}
+	for _, f := range fields[1:] {
+		v := f[1:]
+		switch f[0] {
+		case 'W':
+			y.width, err = strconv.Atoi(v)
+		case 'H':
+			y.height, err = strconv.Atoi(v)
+		case 'F':
+			num, den, _ := strings.Cut(v, ":")
+			var n, d int
+			if n, err = strconv.Atoi(num); err == nil {
+				if d, err = strconv.Atoi(den); err == nil && n > 0 && d > 0 {
+					y.rate = float64(n) / float64(d)
+				}
+			}
+		case 'C':
+			if !strings.HasPrefix(v, "420") {
+				return nil, fmt.Errorf("%w: colorspace %s", errY4M, v)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errY4M, f)
+		}
+	}
+	if y.width <= 0 || y.height <= 0 {
+		return nil, fmt.Errorf("%w: no size", errY4M)
+	}
+	return y, nil
+}
+
+func (y *y4m) size() (int, int) {
+	return y.width, y.height
+}
+
+func (y *y4m) frameRate() float64 {
+	return y.rate
+}
+
+// Read reads the next frame
+func (y *y4m) Read() (image.Image, func(), error) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	line, err := y.r.ReadSlice('\n')
+	if err == io.EOF && len(line) == 0 {
+		seeker, ok := y.in.(io.Seeker)
+		if !ok {
+			return nil, nil, io.EOF
+		}
+		if _, err = seeker.Seek(y.start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		y.r.Reset(y.in)
+		line, err = y.r.ReadSlice('\n')
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.HasPrefix(line, []byte("FRAME")) {
+		return nil, nil, fmt.Errorf("%w: no frame header", errY4M)
+	}
+
+	img := image.NewYCbCr(image.Rect(0, 0, y.width, y.height), image.YCbCrSubsampleRatio420)
+	for _, plane := range [][]byte{img.Y, img.Cb, img.Cr} {
+		if _, err = io.ReadFull(y.r, plane); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, nil, err
+		}
+	}
+	return img, func() {}, nil
+}
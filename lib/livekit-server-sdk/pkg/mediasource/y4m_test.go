@@ -0,0 +1,91 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mediasource
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// y4mStream is a 4x2 stream whose frames are filled with their number
+func y4mStream(frames int) []byte {
+	var b bytes.Buffer
+	b.WriteString("YUV4MPEG2 W4 H2 F25:1 Ip A1:1 C420jpeg XYSCSS=420JPEG\n")
+	for i := 0; i < frames; i++ {
+		b.WriteString("FRAME\n")
+		// 4x2 luma, 2x1 cb and cr
+		b.Write(bytes.Repeat([]byte{byte(i + 1)}, 4*2+2*2))
+	}
+	return b.Bytes()
+}
+
+func TestY4M(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "clip.y4m")
+	require.NoError(t, os.WriteFile(name, y4mStream(2), 0600))
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	y, err := newY4M(f)
+	require.NoError(t, err)
+	w, h := y.size()
+	require.Equal(t, 4, w)
+	require.Equal(t, 2, h)
+	require.Equal(t, 25.0, y.frameRate())
+
+	// files start over
+	for _, expected := range []byte{1, 2, 1, 2} {
+		img, _, err := y.Read()
+		require.NoError(t, err)
+		ycbcr := img.(*image.YCbCr)
+		require.Equal(t, image.Rect(0, 0, 4, 2), ycbcr.Rect)
+		require.Equal(t, bytes.Repeat([]byte{expected}, 8), ycbcr.Y)
+		require.Equal(t, []byte{expected, expected}, ycbcr.Cb)
+		require.Equal(t, []byte{expected, expected}, ycbcr.Cr)
+	}
+}
+
+func TestY4MPipe(t *testing.T) {
+	// pipes end
+	y, err := newY4M(io.MultiReader(bytes.NewReader(y4mStream(1))))
+	require.NoError(t, err)
+	_, _, err = y.Read()
+	require.NoError(t, err)
+	_, _, err = y.Read()
+	require.ErrorIs(t, err, io.EOF)
+
+	y, err = newY4M(io.MultiReader(bytes.NewReader(y4mStream(1)[:70])))
+	require.NoError(t, err)
+	_, _, err = y.Read()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestY4MInvalid(t *testing.T) {
+	for _, header := range []string{
+		"RIFF\n",
+		"YUV4MPEG2 W4\n",
+		"YUV4MPEG2 W4 Hx\n",
+		"YUV4MPEG2 W4 H2 C444\n",
+	} {
+		_, err := newY4M(bytes.NewReader([]byte(header)))
+		require.ErrorIs(t, err, errY4M, header)
+	}
+}
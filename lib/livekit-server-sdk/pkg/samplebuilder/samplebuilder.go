@@ -27,6 +27,12 @@ import (
 	"github.com/pion/webrtc/v3/pkg/media"
 )
 
+// maxLate values for New that suit subscribed tracks
+const (
+	MaxVideoLate = 1000 // nearly 2s for fhd video
+	MaxAudioLate = 200  // 4s for audio
+)
+
 type packet struct {
 	start, end bool
 	packet     *rtp.Packet
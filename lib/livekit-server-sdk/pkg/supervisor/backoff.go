@@ -0,0 +1,67 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponential delays between attempts, with jitter so
+// clients dropped together don't reconnect together.
+type Backoff struct {
+	// Delay before the first retry, 1s if 0
+	Min time.Duration
+	// Upper bound of the delay, 1m if 0
+	Max time.Duration
+	// Growth per attempt, 2 if < 1
+	Multiplier float64
+	// Fraction of the delay randomized, e.g. 0.2 for +-20%. 0 disables jitter.
+	Jitter float64
+}
+
+// DefaultBackoff goes from 1s to 1m, doubling with +-20% jitter.
+var DefaultBackoff = Backoff{
+	Min:        time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the wait before retry number attempt, starting at 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	minDelay, maxDelay, multiplier := b.Min, b.Max, b.Multiplier
+	if minDelay <= 0 {
+		minDelay = time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = time.Minute
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(minDelay)
+	for i := 0; i < attempt && delay < float64(maxDelay); i++ {
+		delay *= multiplier
+	}
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,335 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package supervisor keeps a participant in a LiveKit room over ziti. It
+// owns the ziti runtime and the room, rejoins with backoff when the room is
+// lost and disconnects gracefully on shutdown.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	protoLogger "github.com/livekit/protocol/logger"
+	"github.com/ziti-livekit-example/lib/openziti"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+type Options struct {
+	// Identity file without .json. The default runtime is set up from it
+	// with openziti.InitCon and its certificate is renewed in the same
+	// directory. Ignored if Runtime is set.
+	Identity string
+	// Runtime to join over instead of one created from Identity, it's not
+	// closed by the supervisor
+	Runtime *openziti.Runtime
+
+	URL string
+	// Returns the access token for each join
+	Token          func() (string, error)
+	Callback       *lksdk.RoomCallback
+	ConnectOptions []lksdk.ConnectOption
+
+	// Called before each join, e.g. to create the room with the room service
+	Prepare func(ctx context.Context, r *openziti.Runtime) error
+	// Called after each join, e.g. to publish tracks. An error disconnects
+	// and rejoins, or stops Run if it's fatal.
+	OnJoined func(ctx context.Context, room *lksdk.Room) error
+
+	// DefaultBackoff if zero
+	Backoff Backoff
+	// Reports errors that stop Run instead of being retried, IsFatal if nil
+	Fatal func(error) bool
+	// How often the connection state is checked besides OnDisconnected, 5s if 0
+	CheckInterval time.Duration
+	// Rejoin when the room is reconnecting for longer than this, 1m if 0
+	ReconnectTimeout time.Duration
+}
+
+// IsFatal reports errors retrying won't fix: bad url or token, a room that
+// doesn't exist and missing ziti configuration.
+func IsFatal(err error) bool {
+	return errors.Is(err, lksdk.ErrURLNotProvided) ||
+		errors.Is(err, lksdk.ErrUnauthorized) ||
+		errors.Is(err, lksdk.ErrRoomNotFound) ||
+		errors.Is(err, lksdk.ErrZitiNotConfigured) ||
+		errors.Is(err, lksdk.ErrInvalidParameter)
+}
+
+// room is the part of lksdk.Room the supervisor watches
+type room interface {
+	ConnectionState() lksdk.ConnectionState
+	Disconnect()
+}
+
+type Supervisor struct {
+	opts Options
+	log  protoLogger.Logger
+
+	// joins the room, replaced in tests
+	join func(ctx context.Context, r *openziti.Runtime, cb *lksdk.RoomCallback) (room, error)
+
+	mu   sync.Mutex
+	room *lksdk.Room
+}
+
+func New(opts Options) *Supervisor {
+	s := &Supervisor{opts: opts, log: protoLogger.GetLogger()}
+	s.join = s.joinRoom
+	return s
+}
+
+// Room returns the room joined last, nil before the first join.
+func (s *Supervisor) Room() *lksdk.Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.room
+}
+
+// RunUntilSignal runs until SIGINT or SIGTERM.
+func (s *Supervisor) RunUntilSignal() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Run(ctx)
+}
+
+// Run joins the room and rejoins whenever it's lost until ctx is done, then
+// disconnects. Returns nil after ctx is done, the error otherwise.
+func (s *Supervisor) Run(ctx context.Context) error {
+	var r *openziti.Runtime
+	var teardown func()
+	defer func() {
+		if teardown != nil {
+			teardown()
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := s.backoff().Delay(attempt - 1)
+			s.log.Infow("rejoining", "attempt", attempt, "delay", delay)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+		}
+
+		if r == nil {
+			var err error
+			r, teardown, err = s.setupRuntime(ctx)
+			if err != nil {
+				if s.fatal(err) {
+					return err
+				}
+				s.log.Warnw("could not set up ziti runtime", err)
+				continue
+			}
+		}
+
+		joined, err := s.joinOnce(ctx, r)
+		if err != nil {
+			if s.fatal(err) {
+				return err
+			}
+			s.log.Warnw("could not join room", err)
+			if errors.Is(err, lksdk.ErrZitiSessionExpired) && teardown != nil {
+				// a new context authenticates again
+				teardown()
+				r, teardown = nil, nil
+			}
+			continue
+		}
+		attempt = 0
+
+		if !s.watch(ctx, joined) {
+			return nil
+		}
+	}
+}
+
+func (s *Supervisor) setupRuntime(ctx context.Context) (*openziti.Runtime, func(), error) {
+	if s.opts.Runtime != nil {
+		return s.opts.Runtime, nil, nil
+	}
+	if s.opts.Identity == "" {
+		return nil, nil, lksdk.ErrZitiNotConfigured
+	}
+
+	// The runtime becomes the default one, so clients sending through
+	// openziti.DefaultClient follow it when it's recreated
+	err := openziti.InitCon(s.opts.Identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := openziti.Default()
+
+	// Renew the identity certificate before it expires, open connections
+	// keep using the old ziti context until they close
+	renewer := openziti.NewCertRenewer(openziti.CertRenewerOptions{
+		Name:    filepath.Base(s.opts.Identity),
+		Store:   openziti.FileIdentityStore{Dir: filepath.Dir(s.opts.Identity)},
+		Runtime: r,
+	})
+	if err = renewer.Start(ctx); err != nil {
+		closeDefaultRuntime(r)
+		return nil, nil, err
+	}
+	return r, func() {
+		renewer.Close()
+		closeDefaultRuntime(r)
+	}, nil
+}
+
+// closeDefaultRuntime closes a runtime set up by InitCon and clears the
+// default runtime unless it was replaced since, so clients sending through
+// it fail with openziti.ErrNoRuntime instead of using a closed context.
+func closeDefaultRuntime(r *openziti.Runtime) {
+	r.Close()
+	if openziti.Default() == r {
+		openziti.SetDefault(nil)
+	}
+}
+
+func (s *Supervisor) joinOnce(ctx context.Context, r *openziti.Runtime) (*watchedRoom, error) {
+	if s.opts.Prepare != nil {
+		if err := s.opts.Prepare(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &watchedRoom{disconnected: make(chan lksdk.DisconnectionReason, 1)}
+	cb := lksdk.NewRoomCallback()
+	cb.Merge(s.opts.Callback)
+	onDisconnected := cb.OnDisconnectedWithReason
+	cb.OnDisconnectedWithReason = func(reason lksdk.DisconnectionReason) {
+		onDisconnected(reason)
+		select {
+		case w.disconnected <- reason:
+		default:
+		}
+	}
+
+	joined, err := s.join(ctx, r, cb)
+	if err != nil {
+		return nil, err
+	}
+	w.room = joined
+	return w, nil
+}
+
+func (s *Supervisor) joinRoom(ctx context.Context, r *openziti.Runtime, cb *lksdk.RoomCallback) (room, error) {
+	token, err := s.opts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	lkRoom := lksdk.NewRoom(cb)
+	opts := append([]lksdk.ConnectOption{lksdk.WithZitiRuntime(r)}, s.opts.ConnectOptions...)
+	if err = lkRoom.JoinWithToken(s.opts.URL, token, opts...); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.room = lkRoom
+	s.mu.Unlock()
+	s.log.Infow("joined room", "room", lkRoom.Name())
+
+	if s.opts.OnJoined != nil {
+		if err = s.opts.OnJoined(ctx, lkRoom); err != nil {
+			lkRoom.Disconnect()
+			return nil, err
+		}
+	}
+	return lkRoom, nil
+}
+
+type watchedRoom struct {
+	room         room
+	disconnected chan lksdk.DisconnectionReason
+}
+
+// watch waits until the room is lost(true) or ctx is done(false). The room
+// is disconnected either way.
+func (s *Supervisor) watch(ctx context.Context, w *watchedRoom) bool {
+	ticker := time.NewTicker(s.checkInterval())
+	defer ticker.Stop()
+
+	var reconnectingSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Infow("disconnecting")
+			w.room.Disconnect()
+			return false
+
+		case reason := <-w.disconnected:
+			s.log.Infow("room disconnected", "reason", reason)
+			w.room.Disconnect()
+			return true
+
+		case <-ticker.C:
+			switch w.room.ConnectionState() {
+			case lksdk.ConnectionStateDisconnected:
+				s.log.Infow("room disconnected")
+				w.room.Disconnect()
+				return true
+			case lksdk.ConnectionStateReconnecting:
+				if reconnectingSince.IsZero() {
+					reconnectingSince = time.Now()
+				} else if time.Since(reconnectingSince) > s.reconnectTimeout() {
+					s.log.Infow("room reconnecting for too long", "since", reconnectingSince)
+					w.room.Disconnect()
+					return true
+				}
+			default:
+				reconnectingSince = time.Time{}
+			}
+		}
+	}
+}
+
+func (s *Supervisor) fatal(err error) bool {
+	if s.opts.Fatal != nil {
+		return s.opts.Fatal(err)
+	}
+	return IsFatal(err)
+}
+
+func (s *Supervisor) backoff() Backoff {
+	if s.opts.Backoff == (Backoff{}) {
+		return DefaultBackoff
+	}
+	return s.opts.Backoff
+}
+
+func (s *Supervisor) checkInterval() time.Duration {
+	if s.opts.CheckInterval > 0 {
+		return s.opts.CheckInterval
+	}
+	return 5 * time.Second
+}
+
+func (s *Supervisor) reconnectTimeout() time.Duration {
+	if s.opts.ReconnectTimeout > 0 {
+		return s.opts.ReconnectTimeout
+	}
+	return time.Minute
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/stretchr/testify/require"
+	"github.com/ziti-livekit-example/lib/openziti"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+type fakeRoom struct {
+	mu           sync.Mutex
+	state        lksdk.ConnectionState
+	disconnected atomic.Bool
+}
+
+func (r *fakeRoom) ConnectionState() lksdk.ConnectionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+func (r *fakeRoom) setState(state lksdk.ConnectionState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+}
+
+func (r *fakeRoom) Disconnect() {
+	r.disconnected.Store(true)
+}
+
+func testSupervisor(join func(cb *lksdk.RoomCallback) (room, error)) *Supervisor {
+	s := New(Options{
+		Runtime:          &openziti.Runtime{},
+		Backoff:          Backoff{Min: time.Millisecond, Max: 5 * time.Millisecond},
+		CheckInterval:    5 * time.Millisecond,
+		ReconnectTimeout: 20 * time.Millisecond,
+	})
+	s.join = func(_ context.Context, _ *openziti.Runtime, cb *lksdk.RoomCallback) (room, error) {
+		return join(cb)
+	}
+	return s
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	require.Equal(t, time.Second, b.Delay(0))
+	require.Equal(t, 4*time.Second, b.Delay(2))
+	require.Equal(t, 10*time.Second, b.Delay(10))
+
+	for i := 0; i < 100; i++ {
+		delay := DefaultBackoff.Delay(1)
+		require.GreaterOrEqual(t, delay, 1600*time.Millisecond)
+		require.LessOrEqual(t, delay, 2400*time.Millisecond)
+	}
+}
+
+func TestSupervisorRejoins(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		rooms []*fakeRoom
+		cbs   []*lksdk.RoomCallback
+	)
+	s := testSupervisor(func(cb *lksdk.RoomCallback) (room, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(rooms) == 1 {
+			// first rejoin fails and is retried
+			rooms = append(rooms, nil)
+			return nil, errors.New("dial failed")
+		}
+		r := &fakeRoom{state: lksdk.ConnectionStateConnected}
+		rooms = append(rooms, r)
+		cbs = append(cbs, cb)
+		return r, nil
+	})
+	joined := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(rooms)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// lost room reported by the callback
+	require.Eventually(t, func() bool { return joined() == 1 }, time.Second, time.Millisecond)
+	mu.Lock()
+	cbs[0].OnDisconnectedWithReason(lksdk.Failed)
+	mu.Unlock()
+	require.Eventually(t, func() bool { return joined() == 3 }, time.Second, time.Millisecond)
+	require.True(t, rooms[0].disconnected.Load())
+
+	// lost room found by the state check
+	rooms[2].setState(lksdk.ConnectionStateDisconnected)
+	require.Eventually(t, func() bool { return joined() == 4 }, time.Second, time.Millisecond)
+
+	// room stuck reconnecting
+	rooms[3].setState(lksdk.ConnectionStateReconnecting)
+	require.Eventually(t, func() bool { return joined() == 5 }, time.Second, time.Millisecond)
+	require.True(t, rooms[3].disconnected.Load())
+
+	cancel()
+	require.NoError(t, <-done)
+	require.True(t, rooms[4].disconnected.Load())
+}
+
+func TestSupervisorFatal(t *testing.T) {
+	var joins atomic.Int32
+	s := testSupervisor(func(cb *lksdk.RoomCallback) (room, error) {
+		joins.Add(1)
+		return nil, &lksdk.SignalError{Kind: lksdk.ErrUnauthorized, StatusCode: 401}
+	})
+	err := s.Run(context.Background())
+	require.ErrorIs(t, err, lksdk.ErrUnauthorized)
+	require.EqualValues(t, 1, joins.Load())
+}
+
+type fakeZitiContext struct {
+	ziti.Context
+	closed atomic.Bool
+}
+
+func (c *fakeZitiContext) GetId() string { return "fake" }
+func (c *fakeZitiContext) Close()        { c.closed.Store(true) }
+
+func TestCloseDefaultRuntime(t *testing.T) {
+	zctx := &fakeZitiContext{}
+	r := openziti.NewRuntimeFromContext(zctx)
+	openziti.SetDefault(r)
+
+	closeDefaultRuntime(r)
+	require.True(t, zctx.closed.Load())
+	require.Nil(t, openziti.Default())
+	require.Nil(t, openziti.ZitiContext)
+
+	// a runtime that replaced it stays the default
+	other := openziti.NewRuntimeFromContext(&fakeZitiContext{})
+	openziti.SetDefault(other)
+	closeDefaultRuntime(r)
+	require.Same(t, other, openziti.Default())
+	openziti.SetDefault(nil)
+}
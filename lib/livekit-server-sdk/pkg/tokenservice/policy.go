@@ -0,0 +1,130 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/livekit/protocol/auth"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrNoGrant = errors.New("no grant for identity")
+
+// Policy maps role attributes of ziti identities to room permissions.
+// Loaded from yaml or json, e.g.
+//
+//	grants:
+//	  - attribute: publisher
+//	    rooms: [testroom]
+//	    canPublish: true
+//	    canPublishData: true
+//	    canUpdateOwnMetadata: true
+//	  - attribute: subscriber
+//	    rooms: ["*"]
+//	    canSubscribe: true
+//	    canPublishData: true
+//
+// An identity gets the permissions of every grant matching one of its
+// attributes and the room.
+type Policy struct {
+	Grants []Grant `yaml:"grants" json:"grants"`
+}
+
+type Grant struct {
+	// Role attribute of the identity, #all matches every identity
+	Attribute string `yaml:"attribute" json:"attribute"`
+	// Rooms the grant applies to, * for all
+	Rooms []string `yaml:"rooms" json:"rooms"`
+
+	CanPublish           bool `yaml:"canPublish" json:"canPublish"`
+	CanSubscribe         bool `yaml:"canSubscribe" json:"canSubscribe"`
+	CanPublishData       bool `yaml:"canPublishData" json:"canPublishData"`
+	CanUpdateOwnMetadata bool `yaml:"canUpdateOwnMetadata" json:"canUpdateOwnMetadata"`
+	Hidden               bool `yaml:"hidden" json:"hidden"`
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// Parses a yaml or json policy
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Policy) Validate() error {
+	for i, g := range p.Grants {
+		if g.Attribute == "" {
+			return fmt.Errorf("grant %d: attribute is required", i)
+		}
+		if len(g.Rooms) == 0 {
+			return fmt.Errorf("grant %d(%s): rooms are required", i, g.Attribute)
+		}
+	}
+	return nil
+}
+
+func (g Grant) matches(attributes []string, room string) bool {
+	if g.Attribute != "#all" && !slices.Contains(attributes, g.Attribute) {
+		return false
+	}
+	return slices.Contains(g.Rooms, "*") || slices.Contains(g.Rooms, room)
+}
+
+// VideoGrant returns the permissions in room of an identity with the given
+// role attributes, ErrNoGrant if no grant matches.
+func (p *Policy) VideoGrant(attributes []string, room string) (*auth.VideoGrant, error) {
+	var merged Grant
+	matched := false
+	for _, g := range p.Grants {
+		if !g.matches(attributes, room) {
+			continue
+		}
+		matched = true
+		merged.CanPublish = merged.CanPublish || g.CanPublish
+		merged.CanSubscribe = merged.CanSubscribe || g.CanSubscribe
+		merged.CanPublishData = merged.CanPublishData || g.CanPublishData
+		merged.CanUpdateOwnMetadata = merged.CanUpdateOwnMetadata || g.CanUpdateOwnMetadata
+		merged.Hidden = merged.Hidden || g.Hidden
+	}
+	if !matched {
+		return nil, ErrNoGrant
+	}
+
+	return &auth.VideoGrant{
+		RoomJoin:             true,
+		Room:                 room,
+		CanPublish:           &merged.CanPublish,
+		CanSubscribe:         &merged.CanSubscribe,
+		CanPublishData:       &merged.CanPublishData,
+		CanUpdateOwnMetadata: &merged.CanUpdateOwnMetadata,
+		Hidden:               merged.Hidden,
+	}, nil
+}
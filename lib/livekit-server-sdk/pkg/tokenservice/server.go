@@ -0,0 +1,171 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tokenservice issues LiveKit access tokens to ziti identities. The
+// service is only reachable over ziti: the caller is the identity that dialed
+// it and its role attributes decide the grants, so apps don't need the API
+// secret.
+package tokenservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+	protoLogger "github.com/livekit/protocol/logger"
+	"github.com/ziti-livekit-example/lib/openziti"
+)
+
+// IdentityLookup finds ziti identities by name, e.g. openziti.ManagementClient
+type IdentityLookup interface {
+	IdentityByName(ctx context.Context, name string) (openziti.Identity, error)
+}
+
+type Options struct {
+	APIKey    string
+	APISecret string
+	Policy    *Policy
+	// Resolves the role attributes of callers
+	Identities IdentityLookup
+	// Validity of issued tokens, 10m if 0
+	TTL time.Duration
+}
+
+// Request body of POST /token
+type TokenRequest struct {
+	Room string `json:"room"`
+}
+
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type Server struct {
+	opts Options
+	log  protoLogger.Logger
+	http *http.Server
+}
+
+func NewServer(opts Options) (*Server, error) {
+	if opts.APIKey == "" || opts.APISecret == "" {
+		return nil, errors.New("token service: api key and secret are required")
+	}
+	if opts.Policy == nil || opts.Identities == nil {
+		return nil, errors.New("token service: policy and identity lookup are required")
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Minute
+	}
+
+	s := &Server{opts: opts, log: protoLogger.GetLogger()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", s.handleToken)
+	s.http = &http.Server{
+		Handler:     mux,
+		ConnContext: ConnContext,
+	}
+	return s, nil
+}
+
+type callerKey struct{}
+
+// ConnContext remembers the identity that dialed a ziti connection, for
+// http.Server.ConnContext of servers with their own listener setup.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn, ok := c.(interface{ SourceIdentifier() string }); ok {
+		return context.WithValue(ctx, callerKey{}, conn.SourceIdentifier())
+	}
+	return ctx
+}
+
+// Caller returns the ziti identity that sent the request, empty if the
+// request didn't come over ziti.
+func Caller(req *http.Request) string {
+	caller, _ := req.Context().Value(callerKey{}).(string)
+	return caller
+}
+
+// Serve accepts requests on l until Close, l is usually a listener of the
+// token service, e.g. from ziti.Context.Listen.
+func (s *Server) Serve(l net.Listener) error {
+	err := s.http.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP serves the token api, requests without a caller in their context
+// are refused.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.http.Handler.ServeHTTP(w, req)
+}
+
+func (s *Server) Close() error {
+	return s.http.Close()
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, req *http.Request) {
+	caller := Caller(req)
+	if caller == "" {
+		http.Error(w, "caller is not a ziti identity", http.StatusUnauthorized)
+		return
+	}
+
+	var body TokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Room == "" {
+		http.Error(w, "room is required", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := s.opts.Identities.IdentityByName(req.Context(), caller)
+	if errors.Is(err, openziti.ErrNotFound) {
+		s.log.Infow("token denied, unknown identity", "identity", caller)
+		http.Error(w, "unknown identity", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.log.Warnw("could not look up identity", err, "identity", caller)
+		http.Error(w, "identity lookup failed", http.StatusBadGateway)
+		return
+	}
+
+	grant, err := s.opts.Policy.VideoGrant(identity.RoleAttributes, body.Room)
+	if err != nil {
+		s.log.Infow("token denied", "identity", caller, "room", body.Room, "attributes", identity.RoleAttributes)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	expiresAt := time.Now().Add(s.opts.TTL)
+	token, err := auth.NewAccessToken(s.opts.APIKey, s.opts.APISecret).
+		AddGrant(grant).
+		SetIdentity(identity.Name).
+		SetValidFor(s.opts.TTL).
+		ToJWT()
+	if err != nil {
+		s.log.Errorw("could not sign token", err)
+		http.Error(w, "could not sign token", http.StatusInternalServerError)
+		return
+	}
+	s.log.Infow("token issued", "identity", caller, "room", body.Room, "expiresAt", expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(TokenResponse{Token: token, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tokenservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/auth"
+	"github.com/stretchr/testify/require"
+	"github.com/ziti-livekit-example/lib/openziti"
+)
+
+const testPolicy = `
+grants:
+  - attribute: publisher
+    rooms: [testroom]
+    canPublish: true
+    canPublishData: true
+  - attribute: subscriber
+    rooms: ["*"]
+    canSubscribe: true
+  - attribute: "#all"
+    rooms: [lobby]
+    canSubscribe: true
+`
+
+type fakeIdentities map[string][]string
+
+func (f fakeIdentities) IdentityByName(_ context.Context, name string) (openziti.Identity, error) {
+	attributes, ok := f[name]
+	if !ok {
+		return openziti.Identity{}, openziti.ErrNotFound
+	}
+	return openziti.Identity{Name: name, RoleAttributes: attributes}, nil
+}
+
+// callerListener hands out connections dialed by a ziti identity
+type callerListener struct {
+	net.Listener
+	caller string
+}
+
+func (l callerListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return callerConn{Conn: c, caller: l.caller}, nil
+}
+
+type callerConn struct {
+	net.Conn
+	caller string
+}
+
+func (c callerConn) SourceIdentifier() string { return c.caller }
+
+func TestPolicyVideoGrant(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+
+	grant, err := p.VideoGrant([]string{"publisher", "subscriber"}, "testroom")
+	require.NoError(t, err)
+	require.True(t, grant.RoomJoin)
+	require.Equal(t, "testroom", grant.Room)
+	require.True(t, *grant.CanPublish)
+	require.True(t, *grant.CanSubscribe)
+	require.True(t, *grant.CanPublishData)
+	require.False(t, *grant.CanUpdateOwnMetadata)
+
+	grant, err = p.VideoGrant([]string{"device"}, "lobby")
+	require.NoError(t, err)
+	require.False(t, *grant.CanPublish)
+	require.True(t, *grant.CanSubscribe)
+
+	_, err = p.VideoGrant([]string{"publisher"}, "otherroom")
+	require.ErrorIs(t, err, ErrNoGrant)
+
+	_, err = ParsePolicy([]byte(`grants: [{attribute: publisher}]`))
+	require.Error(t, err)
+}
+
+func TestServerIssuesTokens(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	s, err := NewServer(Options{
+		APIKey:     "key",
+		APISecret:  "secret-secret-secret-secret-secret",
+		Policy:     p,
+		Identities: fakeIdentities{"publisher": {"publisher"}, "device": {"device"}},
+		TTL:        time.Minute,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	request := func(caller, room string) *http.Response {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() { _ = s.Serve(callerListener{Listener: l, caller: caller}) }()
+
+		body, _ := json.Marshal(TokenRequest{Room: room})
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		res, err := client.Post("http://"+l.Addr().String()+"/token", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		return res
+	}
+
+	res := request("publisher", "testroom")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var token TokenResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&token))
+	require.WithinDuration(t, time.Now().Add(time.Minute), token.ExpiresAt, 5*time.Second)
+
+	verifier, err := auth.ParseAPIToken(token.Token)
+	require.NoError(t, err)
+	claims, err := verifier.Verify("secret-secret-secret-secret-secret")
+	require.NoError(t, err)
+	require.Equal(t, "publisher", claims.Identity)
+	require.Equal(t, "testroom", claims.Video.Room)
+	require.True(t, *claims.Video.CanPublish)
+	require.False(t, *claims.Video.CanSubscribe)
+
+	for _, denied := range []struct {
+		caller, room string
+		status       int
+	}{
+		{"device", "testroom", http.StatusForbidden},
+		{"stranger", "lobby", http.StatusForbidden},
+		{"", "lobby", http.StatusUnauthorized},
+		{"publisher", "", http.StatusBadRequest},
+	} {
+		res := request(denied.caller, denied.room)
+		_ = res.Body.Close()
+		require.Equal(t, denied.status, res.StatusCode, "%s in %q", denied.caller, denied.room)
+	}
+}
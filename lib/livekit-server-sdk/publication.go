@@ -338,6 +338,31 @@ func (p *LocalTrackPublication) setMuted(muted bool, byRemote bool) {
 	}
 }
 
+// setSubscribedQualities pauses the layers no subscriber wants, a track
+// without layers is paused when no quality is wanted
+func (p *LocalTrackPublication) setSubscribedQualities(qualities []*livekit.SubscribedQuality) {
+	enabled := make(map[livekit.VideoQuality]bool)
+	for _, q := range qualities {
+		enabled[q.Quality] = enabled[q.Quality] || q.Enabled
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if len(p.simulcastTracks) > 0 {
+		for quality, st := range p.simulcastTracks {
+			st.setLayerSubscribed(enabled[quality])
+		}
+		return
+	}
+	if t, ok := p.track.(*LocalTrack); ok {
+		wanted := false
+		for _, e := range enabled {
+			wanted = wanted || e
+		}
+		t.setLayerSubscribed(wanted)
+	}
+}
+
 func (p *LocalTrackPublication) addSimulcastTrack(st *LocalTrack) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
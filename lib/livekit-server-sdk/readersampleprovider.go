@@ -93,16 +93,47 @@ func ReaderTrackWithSampleOptions(opts ...LocalTrackOptions) func(provider *Read
 
 // NewLocalFileTrack creates an *os.File reader for NewLocalReaderTrack
 func NewLocalFileTrack(file string, options ...ReaderSampleProviderOption) (*LocalTrack, error) {
+	fp, mime, err := openMediaFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := NewLocalReaderTrack(fp, mime, options...)
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return track, nil
+}
+
+// NewFileSampleProvider creates the provider of NewLocalFileTrack without a
+// track, see NewReaderSampleProvider
+func NewFileSampleProvider(file string, options ...ReaderSampleProviderOption) (*ReaderSampleProvider, error) {
+	fp, mime, err := openMediaFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := NewReaderSampleProvider(fp, mime, options...)
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return provider, nil
+}
+
+// openMediaFile opens a file and determines its mime type from the extension
+func openMediaFile(file string) (*os.File, string, error) {
 	// File health check
 	var err error
 	if _, err = os.Stat(file); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Open the file
 	fp, err := os.Open(file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Determine mime type from extension
@@ -114,7 +145,8 @@ func NewLocalFileTrack(file string, options ...ReaderSampleProviderOption) (*Loc
 		buf := make([]byte, 3)
 		_, err = fp.ReadAt(buf, 8)
 		if err != nil {
-			return nil, err
+			_ = fp.Close()
+			return nil, "", err
 		}
 		switch string(buf) {
 		case "VP8":
@@ -123,27 +155,44 @@ func NewLocalFileTrack(file string, options ...ReaderSampleProviderOption) (*Loc
 			mime = webrtc.MimeTypeVP9
 		default:
 			_ = fp.Close()
-			return nil, ErrCannotDetermineMime
+			return nil, "", ErrCannotDetermineMime
 		}
 		_, _ = fp.Seek(0, 0)
 	case ".ogg":
 		mime = webrtc.MimeTypeOpus
 	default:
 		_ = fp.Close()
-		return nil, ErrCannotDetermineMime
+		return nil, "", ErrCannotDetermineMime
 	}
+	return fp, mime, nil
+}
 
-	track, err := NewLocalReaderTrack(fp, mime, options...)
+// NewLocalReaderTrack uses io.ReadCloser interface to adapt to various ingress types
+// - mime: has to be one of webrtc.MimeType... (e.g. webrtc.MimeTypeOpus)
+func NewLocalReaderTrack(in io.ReadCloser, mime string, options ...ReaderSampleProviderOption) (*LocalTrack, error) {
+	provider, err := NewReaderSampleProvider(in, mime, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create sample track & bind handler
+	track, err := NewLocalTrack(webrtc.RTPCodecCapability{MimeType: provider.Mime}, provider.trackOpts...)
 	if err != nil {
-		_ = fp.Close()
 		return nil, err
 	}
+	track.OnBind(func() {
+		if err := track.StartWrite(provider, provider.OnWriteComplete); err != nil {
+			track.log.Errorw("Could not start writing", err)
+		}
+	})
+
 	return track, nil
 }
 
-// NewLocalReaderTrack uses io.ReadCloser interface to adapt to various ingress types
-// - mime: has to be one of webrtc.MimeType... (e.g. webrtc.MimeTypeOpus)
-func NewLocalReaderTrack(in io.ReadCloser, mime string, options ...ReaderSampleProviderOption) (*LocalTrack, error) {
+// NewReaderSampleProvider creates the provider of NewLocalReaderTrack without
+// a track. It keeps its position in the reader when it's rebound, so it can
+// be written to the tracks of successive joins with LocalTrack.StartWrite.
+func NewReaderSampleProvider(in io.ReadCloser, mime string, options ...ReaderSampleProviderOption) (*ReaderSampleProvider, error) {
 	provider := &ReaderSampleProvider{
 		Mime:   mime,
 		reader: in,
@@ -161,19 +210,7 @@ func NewLocalReaderTrack(in io.ReadCloser, mime string, options ...ReaderSampleP
 	default:
 		return nil, ErrUnsupportedFileType
 	}
-
-	// Create sample track & bind handler
-	track, err := NewLocalTrack(webrtc.RTPCodecCapability{MimeType: provider.Mime}, provider.trackOpts...)
-	if err != nil {
-		return nil, err
-	}
-	track.OnBind(func() {
-		if err := track.StartWrite(provider, provider.OnWriteComplete); err != nil {
-			track.log.Errorw("Could not start writing", err)
-		}
-	})
-
-	return track, nil
+	return provider, nil
 }
 
 func (p *ReaderSampleProvider) OnBind() error {
@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
@@ -32,6 +33,7 @@ import (
 	"github.com/livekit/mediatransportutil/pkg/pacer"
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 // -----------------------------------------------
@@ -101,6 +103,11 @@ type connectParams struct {
 	Interceptors []interceptor.Factory
 
 	ICETransportPolicy webrtc.ICETransportPolicy
+
+	ZitiRuntime *openziti.Runtime
+	ZitiOnly    bool
+
+	SignalDialer *websocket.Dialer
 }
 
 type ConnectOption func(*connectParams)
@@ -139,6 +146,32 @@ func WithICETransportPolicy(iceTransportPolicy webrtc.ICETransportPolicy) Connec
 	}
 }
 
+// WithZitiRuntime makes the signal connection and ICE use the given openziti runtime
+// instead of the default one set up by openziti.InitCon
+func WithZitiRuntime(r *openziti.Runtime) ConnectOption {
+	return func(p *connectParams) {
+		p.ZitiRuntime = r
+	}
+}
+
+// WithZitiOnly gathers relay candidates over ziti services only and never
+// signals host or srflx candidates, see webrtc.SettingEngine.SetZitiOnly.
+// The TURN servers have to be intercepted by a ziti service.
+func WithZitiOnly() ConnectOption {
+	return func(p *connectParams) {
+		p.ZitiOnly = true
+	}
+}
+
+// WithSignalDialer dials the signal connection with the given websocket dialer,
+// e.g. one with a ziti service override or TLS settings from
+// openziti.Runtime.WebsocketDialer. Takes precedence over WithZitiRuntime.
+func WithSignalDialer(d *websocket.Dialer) ConnectOption {
+	return func(p *connectParams) {
+		p.SignalDialer = d
+	}
+}
+
 func WithDisableRegionDiscovery() ConnectOption {
 	return func(p *connectParams) {
 		p.DisableRegionDiscovery = true
@@ -199,6 +232,7 @@ func NewRoom(callback *RoomCallback) *Room {
 	engine.OnResumed = r.handleResumed
 	engine.client.OnLocalTrackUnpublished = r.handleLocalTrackUnpublished
 	engine.client.OnTrackRemoteMuted = r.handleTrackRemoteMuted
+	engine.client.OnSubscribedQualityUpdate = r.handleSubscribedQualityUpdate
 
 	return r
 }
@@ -667,6 +701,19 @@ func (r *Room) handleTrackRemoteMuted(msg *livekit.MuteTrackRequest) {
 	}
 }
 
+func (r *Room) handleSubscribedQualityUpdate(msg *livekit.SubscribedQualityUpdate) {
+	for _, pub := range r.LocalParticipant.TrackPublications() {
+		if pub.SID() == msg.TrackSid {
+			qualities := msg.SubscribedQualities
+			// newer servers send the qualities per codec
+			for _, codec := range msg.SubscribedCodecs {
+				qualities = append(qualities, codec.Qualities...)
+			}
+			pub.(*LocalTrackPublication).setSubscribedQualities(qualities)
+		}
+	}
+}
+
 func (r *Room) handleLocalTrackUnpublished(msg *livekit.TrackUnpublishedResponse) {
 	err := r.LocalParticipant.UnpublishTrack(msg.TrackSid)
 	if err != nil {
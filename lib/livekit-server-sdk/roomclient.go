@@ -16,11 +16,11 @@ package lksdk
 
 import (
 	"context"
-	"net/http"
 
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
 	"github.com/twitchtv/twirp"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 type RoomServiceClient struct {
@@ -29,10 +29,17 @@ type RoomServiceClient struct {
 }
 
 func NewRoomServiceClient(url string, apiKey string, secretKey string, opts ...twirp.ClientOption) *RoomServiceClient {
+	return NewRoomServiceClientWithHTTPClient(url, apiKey, secretKey, openziti.DefaultClient, opts...)
+}
+
+// NewRoomServiceClientWithHTTPClient is like NewRoomServiceClient with requests sent through
+// client instead of the default openziti runtime, e.g. the Client of another
+// openziti.Runtime or a plain http.Client.
+func NewRoomServiceClientWithHTTPClient(url string, apiKey string, secretKey string, client livekit.HTTPClient, opts ...twirp.ClientOption) *RoomServiceClient {
 	url = ToHttpURL(url)
-	client := livekit.NewRoomServiceProtobufClient(url, &http.Client{}, opts...)
+	svc := livekit.NewRoomServiceProtobufClient(url, client, opts...)
 	return &RoomServiceClient{
-		roomService: client,
+		roomService: svc,
 		authBase: authBase{
 			apiKey:    apiKey,
 			apiSecret: secretKey,
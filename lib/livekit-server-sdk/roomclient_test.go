@@ -0,0 +1,57 @@
+package lksdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRoomService stands in for the twirp room service of a LiveKit server
+type fakeRoomService struct {
+	livekit.RoomService
+}
+
+func (fakeRoomService) ListRooms(context.Context, *livekit.ListRoomsRequest) (*livekit.ListRoomsResponse, error) {
+	return &livekit.ListRoomsResponse{Rooms: []*livekit.Room{{Name: "testroom"}}}, nil
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRoomServiceClientWithHTTPClient(t *testing.T) {
+	var authorized atomic.Bool
+	handler := livekit.NewRoomServiceServer(fakeRoomService{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized.Store(r.Header.Get("Authorization") != "")
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	client := NewRoomServiceClientWithHTTPClient(srv.URL, "key", "secret-secret-secret-secret-secret", &http.Client{Transport: transport})
+	res, err := client.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Rooms, 1)
+	require.Equal(t, "testroom", res.Rooms[0].Name)
+	require.EqualValues(t, 1, transport.requests.Load())
+	require.True(t, authorized.Load())
+}
+
+func TestServiceClientsWithoutRuntime(t *testing.T) {
+	// without openziti.InitCon the default clients fail instead of panicking
+	client := NewRoomServiceClient("http://livekit.example.com", "key", "secret-secret-secret-secret-secret")
+	_, err := client.ListRooms(context.Background(), &livekit.ListRoomsRequest{})
+	require.Error(t, err)
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime"
@@ -58,6 +59,9 @@ type SignalClient struct {
 	OnLocalTrackUnpublished func(response *livekit.TrackUnpublishedResponse)
 	OnTokenRefresh          func(refreshToken string)
 	OnLeave                 func(*livekit.LeaveRequest)
+	// OnSubscribedQualityUpdate is called when the qualities subscribers
+	// want of a local video track change
+	OnSubscribedQualityUpdate func(update *livekit.SubscribedQualityUpdate)
 }
 
 func NewSignalClient() *SignalClient {
@@ -145,7 +149,7 @@ func (c *SignalClient) connect(urlPrefix string, token string, params connectPar
 	}
 
 	header := newHeaderWithToken(token)
-	conn, hresp, err := websocket.DefaultDialer.Dial(u.String(), header)
+	conn, hresp, err := signalDialer(params).Dial(u.String(), header)
 	if err != nil {
 		var fields []interface{}
 		if hresp != nil {
@@ -153,9 +157,9 @@ func (c *SignalClient) connect(urlPrefix string, token string, params connectPar
 		}
 		c.log.Errorw("error establishing signal connection", err, fields...)
 
-		if strings.HasSuffix(err.Error(), ":53: server misbehaving") {
-			// DNS issue, abort
-			return nil, ErrCannotDialSignal
+		if kind := dialErrorKind(err); kind != nil {
+			// validating would fail the same way
+			return nil, &SignalError{Kind: kind, Err: err}
 		}
 		// use validate endpoint to get the actual error
 		validateSuffix := strings.Replace(urlSuffix, "/rtc", "/rtc/validate", 1)
@@ -163,33 +167,24 @@ func (c *SignalClient) connect(urlPrefix string, token string, params connectPar
 		validateReq, err1 := http.NewRequest(http.MethodGet, ToHttpURL(urlPrefix)+validateSuffix, nil)
 		if err1 != nil {
 			c.log.Errorw("error creating validate request", err1)
-			return nil, ErrCannotDialSignal
+			return nil, &SignalError{Kind: ErrCannotDialSignal, Err: err}
 		}
 		validateReq.Header = header
-		hresp, err := openziti.ZitiClient.Do(validateReq)
-		if err != nil {
-			c.log.Errorw("error getting validation", err, "httpResponse", hresp)
-			return nil, ErrCannotDialSignal
-		} else if hresp.StatusCode == http.StatusOK {
+		hresp, err1 = validateClient(params.ZitiRuntime).Do(validateReq)
+		if err1 != nil {
+			c.log.Errorw("error getting validation", err1, "httpResponse", hresp)
+			if kind := dialErrorKind(err1); kind != nil {
+				return nil, &SignalError{Kind: kind, Err: err1}
+			}
+			return nil, &SignalError{Kind: ErrCannotDialSignal, Err: err}
+		}
+		defer hresp.Body.Close()
+		if hresp.StatusCode == http.StatusOK {
 			// no specific errors to return if validate succeeds
 			c.log.Infow("validate succeeded")
-			return nil, ErrCannotConnectSignal
-		} else {
-			var errString string
-			switch hresp.StatusCode {
-			case http.StatusUnauthorized:
-				errString = "unauthorized: "
-			case http.StatusNotFound:
-				errString = "not found: "
-			case http.StatusServiceUnavailable:
-				errString = "unavailable: "
-			}
-			body, err := io.ReadAll(hresp.Body)
-			if err == nil {
-				errString += string(body)
-			}
-			return nil, errors.New(errString)
+			return nil, &SignalError{Kind: ErrCannotConnectSignal, StatusCode: hresp.StatusCode, Err: err}
 		}
+		return nil, validateError(hresp, err)
 	}
 	c.Close() // close previous conn, if any
 	c.conn.Store(conn)
@@ -203,6 +198,70 @@ func (c *SignalClient) connect(urlPrefix string, token string, params connectPar
 	return res, nil
 }
 
+// dialErrorKind classifies errors dialing the signal connection or the
+// validate endpoint, nil if the server may tell more
+func dialErrorKind(err error) error {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, openziti.ErrSessionExpired):
+		return ErrZitiSessionExpired
+	case errors.Is(err, openziti.ErrNoService), errors.Is(err, openziti.ErrServiceNotFound),
+		errors.Is(err, openziti.ErrNoTerminators), errors.Is(err, openziti.ErrNoEdgeRouters):
+		return ErrZitiServiceUnavailable
+	case errors.Is(err, websocket.ErrZitiNotConfigured), errors.Is(err, openziti.ErrNoRuntime):
+		return ErrZitiNotConfigured
+	case errors.As(err, &dnsErr):
+		// DNS issue, abort
+		return ErrCannotDialSignal
+	}
+	return nil
+}
+
+// validateError builds the error of a failed /rtc/validate response
+func validateError(hresp *http.Response, err error) error {
+	kind := ErrCannotConnectSignal
+	switch hresp.StatusCode {
+	case http.StatusUnauthorized:
+		kind = ErrUnauthorized
+	case http.StatusNotFound:
+		kind = ErrRoomNotFound
+	case http.StatusServiceUnavailable:
+		kind = ErrServerUnavailable
+	}
+	body, _ := io.ReadAll(io.LimitReader(hresp.Body, 4096))
+	return &SignalError{
+		Kind:       kind,
+		StatusCode: hresp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		Err:        err,
+	}
+}
+
+// signalDialer returns the websocket dialer for the signal connection:
+// the configured one, else one over the ziti runtime(the default runtime
+// if none was given)
+func signalDialer(params connectParams) *websocket.Dialer {
+	if params.SignalDialer != nil {
+		return params.SignalDialer
+	}
+	r := params.ZitiRuntime
+	if r == nil {
+		r = openziti.Default()
+	}
+	if r == nil {
+		// fails with websocket.ErrZitiNotConfigured
+		return websocket.DefaultDialer
+	}
+	return r.WebsocketDialer("")
+}
+
+func validateClient(r *openziti.Runtime) livekit.HTTPClient {
+	if r == nil {
+		return openziti.DefaultClient
+	}
+	return r.Client
+}
+
 func (c *SignalClient) Close() {
 	isStarted := c.IsStarted()
 	readerClosedCh := c.readerClosedCh
@@ -373,6 +432,10 @@ func (c *SignalClient) handleResponse(res *livekit.SignalResponse) {
 		if c.OnLocalTrackUnpublished != nil {
 			c.OnLocalTrackUnpublished(msg.TrackUnpublished)
 		}
+	case *livekit.SignalResponse_SubscribedQualityUpdate:
+		if c.OnSubscribedQualityUpdate != nil {
+			c.OnSubscribedQualityUpdate(msg.SubscribedQualityUpdate)
+		}
 	}
 }
 
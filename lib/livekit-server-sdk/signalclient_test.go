@@ -15,9 +15,16 @@
 package lksdk
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 func TestSignalClient_Join(t *testing.T) {
@@ -33,4 +40,77 @@ func TestSignalClient_Join(t *testing.T) {
 		require.Error(t, err)
 		require.NotEqual(t, ErrURLNotProvided, err)
 	})
+
+	t.Run("dials with the configured dialer", func(t *testing.T) {
+		var dialed []string
+		d := *websocket.DefaultDialer
+		d.Proxy = nil
+		d.NetDialContext = func(_ context.Context, _, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, errors.New("refused")
+		}
+
+		c := NewSignalClient()
+		_, err := c.Join("wss://livekit.example.com", "", connectParams{SignalDialer: &d})
+		require.Error(t, err)
+		require.Equal(t, []string{"livekit.example.com:443"}, dialed)
+	})
+}
+
+func TestSignalClient_ConnectErrors(t *testing.T) {
+	validateStatus := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/rtc/validate", r.URL.Path)
+		w.WriteHeader(validateStatus)
+		_, _ = w.Write([]byte("invalid token\n"))
+	}))
+	defer srv.Close()
+
+	failingDialer := func(dialErr error) *websocket.Dialer {
+		d := *websocket.DefaultDialer
+		d.Proxy = nil
+		d.NetDialContext = func(context.Context, string, string) (net.Conn, error) {
+			return nil, dialErr
+		}
+		return &d
+	}
+	params := func(dialErr error) connectParams {
+		return connectParams{
+			SignalDialer: failingDialer(dialErr),
+			ZitiRuntime:  &openziti.Runtime{Client: srv.Client()},
+		}
+	}
+	refused := errors.New("connection refused")
+
+	cases := []struct {
+		name       string
+		dialErr    error
+		status     int
+		kind       error
+		statusCode int
+	}{
+		{"no terminators", &openziti.DialError{Service: "livekit", Err: errors.New("service livekit has no terminators")}, 0, ErrZitiServiceUnavailable, 0},
+		{"session expired", &openziti.DialError{Service: "livekit", Err: errors.New("INVALID_SESSION")}, 0, ErrZitiSessionExpired, 0},
+		{"strict routing", openziti.ErrNoService, 0, ErrZitiServiceUnavailable, 0},
+		{"unauthorized", refused, http.StatusUnauthorized, ErrUnauthorized, http.StatusUnauthorized},
+		{"room not found", refused, http.StatusNotFound, ErrRoomNotFound, http.StatusNotFound},
+		{"unavailable", refused, http.StatusServiceUnavailable, ErrServerUnavailable, http.StatusServiceUnavailable},
+		{"validate succeeds", refused, http.StatusOK, ErrCannotConnectSignal, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			validateStatus = tc.status
+			c := NewSignalClient()
+			_, err := c.Join(srv.URL, "", params(tc.dialErr))
+			require.ErrorIs(t, err, tc.kind)
+			require.ErrorIs(t, err, tc.dialErr)
+
+			var signalErr *SignalError
+			require.ErrorAs(t, err, &signalErr)
+			require.Equal(t, tc.statusCode, signalErr.StatusCode)
+			if tc.statusCode == http.StatusUnauthorized {
+				require.Equal(t, "invalid token", signalErr.Message)
+			}
+		})
+	}
 }
@@ -16,11 +16,11 @@ package lksdk
 
 import (
 	"context"
-	"net/http"
 	"time"
 
 	"github.com/livekit/protocol/livekit"
 	"github.com/twitchtv/twirp"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 //lint:file-ignore SA1019 We still support some deprecated functions for backward compatibility
@@ -32,8 +32,13 @@ type SIPClient struct {
 
 // NewSIPClient creates a LiveKit SIP client.
 func NewSIPClient(url string, apiKey string, secretKey string, opts ...twirp.ClientOption) *SIPClient {
+	return NewSIPClientWithHTTPClient(url, apiKey, secretKey, openziti.DefaultClient, opts...)
+}
+
+// NewSIPClientWithHTTPClient is like NewSIPClient with requests sent through client.
+func NewSIPClientWithHTTPClient(url string, apiKey string, secretKey string, client livekit.HTTPClient, opts ...twirp.ClientOption) *SIPClient {
 	return &SIPClient{
-		sipClient: livekit.NewSIPProtobufClient(ToHttpURL(url), &http.Client{}, opts...),
+		sipClient: livekit.NewSIPProtobufClient(ToHttpURL(url), client, opts...),
 		authBase: authBase{
 			apiKey:    apiKey,
 			apiSecret: secretKey,
@@ -0,0 +1,243 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lksdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/protocol/livekit"
+	"github.com/ziti-livekit-example/lib/openziti"
+
+	"github.com/livekit/server-sdk-go/v2/pkg/tokenservice"
+)
+
+// Wait before retrying a failed background refresh
+const tokenRetryInterval = 10 * time.Second
+
+// TokenClient fetches access tokens for a room from a token service (see
+// pkg/tokenservice) and reuses them until they're close to expiring. After
+// Start the current token is refreshed in the background once two thirds of
+// its validity passed, so a (re)join doesn't wait for the token service.
+type TokenClient struct {
+	url    string
+	room   string
+	client livekit.HTTPClient
+
+	mu        sync.Mutex
+	token     string
+	issuedAt  time.Time
+	expiresAt time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// wakes the background refresh when a new token is stored
+	stored chan struct{}
+}
+
+// NewTokenClient fetches tokens from the token service hosted as the ziti
+// service, dialed over r or the default runtime if r is nil.
+func NewTokenClient(r *openziti.Runtime, service string, room string) *TokenClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			runtime := r
+			if runtime == nil {
+				runtime = openziti.Default()
+			}
+			if runtime == nil {
+				return nil, openziti.ErrNoRuntime
+			}
+			return runtime.DialRoute(ctx, openziti.Route{
+				Network: network,
+				Address: addr,
+				Source:  openziti.RouteMapped,
+				Service: service,
+			})
+		},
+	}
+	return NewTokenClientWithHTTPClient("http://"+service, room, &http.Client{Transport: transport, Timeout: 30 * time.Second})
+}
+
+// NewTokenClientWithHTTPClient fetches tokens from the token service at url
+// through client.
+func NewTokenClientWithHTTPClient(url string, room string, client livekit.HTTPClient) *TokenClient {
+	return &TokenClient{
+		url:    strings.TrimSuffix(url, "/") + "/token",
+		room:   room,
+		client: client,
+	}
+}
+
+// Token returns a token for the room. The current one is returned while more
+// than a third of its validity is left, otherwise a new one is fetched.
+func (c *TokenClient) Token() (string, error) {
+	return c.TokenContext(context.Background())
+}
+
+func (c *TokenClient) TokenContext(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.refreshAt()) {
+		return c.token, nil
+	}
+	if err := c.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+// Start refreshes the current token in the background until ctx is done or
+// Close is called. The first token is still fetched by Token, failed
+// refreshes are retried every 10s.
+func (c *TokenClient) Start(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel != nil {
+		return
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	c.stored = make(chan struct{}, 1)
+	go c.run(ctx, c.done, c.stored)
+}
+
+// Close stops refreshing tokens in the background.
+func (c *TokenClient) Close() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done, c.stored = nil, nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (c *TokenClient) run(ctx context.Context, done chan struct{}, stored chan struct{}) {
+	defer close(done)
+
+	for {
+		timer := time.NewTimer(c.refreshIn())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-stored:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		c.mu.Lock()
+		var err error
+		if c.token != "" && !time.Now().Before(c.refreshAt()) {
+			err = c.refreshLocked(ctx)
+		}
+		c.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			logger.Warnw("could not refresh token", err, "room", c.room, "retryIn", tokenRetryInterval)
+		}
+	}
+}
+
+// refreshIn returns how long until the current token is due for a refresh,
+// tokenRetryInterval without a token or when it's overdue(a refresh failed)
+func (c *TokenClient) refreshIn() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" {
+		return tokenRetryInterval
+	}
+	if wait := time.Until(c.refreshAt()); wait > 0 {
+		return wait
+	}
+	return tokenRetryInterval
+}
+
+// refreshAt is when two thirds of the current token's validity passed
+func (c *TokenClient) refreshAt() time.Time {
+	return c.expiresAt.Add(-c.expiresAt.Sub(c.issuedAt) / 3)
+}
+
+func (c *TokenClient) refreshLocked(ctx context.Context) error {
+	now := time.Now()
+	res, err := c.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	c.token, c.issuedAt, c.expiresAt = res.Token, now, res.ExpiresAt
+	select {
+	case c.stored <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ExpiresAt returns when the current token expires, zero before the first one
+// was fetched.
+func (c *TokenClient) ExpiresAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expiresAt
+}
+
+func (c *TokenClient) fetch(ctx context.Context) (*tokenservice.TokenResponse, error) {
+	body, err := json.Marshal(tokenservice.TokenRequest{Room: c.room})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	hresp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(hresp.Body, 1024))
+		kind := ErrServerUnavailable
+		switch hresp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			kind = ErrUnauthorized
+		case http.StatusBadRequest:
+			kind = ErrInvalidParameter
+		}
+		return nil, fmt.Errorf("token service: %w: %s", kind, strings.TrimSpace(string(msg)))
+	}
+
+	res := &tokenservice.TokenResponse{}
+	if err = json.NewDecoder(hresp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 LiveKit, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lksdk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/livekit/server-sdk-go/v2/pkg/tokenservice"
+)
+
+func TestTokenClientRefresh(t *testing.T) {
+	var issued atomic.Int32
+	validFor := atomic.Int64{}
+	validFor.Store(int64(time.Minute))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req tokenservice.TokenRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Room != "testroom" {
+			http.Error(w, "no grant for identity", http.StatusForbidden)
+			return
+		}
+		n := issued.Add(1)
+		_ = json.NewEncoder(w).Encode(tokenservice.TokenResponse{
+			Token:     "token-" + string(rune('0'+n)),
+			ExpiresAt: time.Now().Add(time.Duration(validFor.Load())),
+		})
+	}))
+	defer srv.Close()
+
+	c := NewTokenClientWithHTTPClient(srv.URL, "testroom", srv.Client())
+	token, err := c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	// still valid, reused
+	token, err = c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	// a token with less than a third of its validity left is replaced
+	validFor.Store(int64(30 * time.Millisecond))
+	c = NewTokenClientWithHTTPClient(srv.URL, "testroom", srv.Client())
+	_, err = c.Token()
+	require.NoError(t, err)
+	time.Sleep(25 * time.Millisecond)
+	token, err = c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-3", token)
+
+	_, err = NewTokenClientWithHTTPClient(srv.URL, "otherroom", srv.Client()).Token()
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestTokenClientRefreshThreshold(t *testing.T) {
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := issued.Add(1)
+		_ = json.NewEncoder(w).Encode(tokenservice.TokenResponse{
+			Token:     "token-" + string(rune('0'+n)),
+			ExpiresAt: time.Now().Add(9 * time.Minute),
+		})
+	}))
+	defer srv.Close()
+
+	c := NewTokenClientWithHTTPClient(srv.URL, "testroom", srv.Client())
+	_, err := c.Token()
+	require.NoError(t, err)
+
+	// valid for 9m, replaced once less than 3m are left
+	now := time.Now()
+	c.issuedAt, c.expiresAt = now.Add(-5*time.Minute), now.Add(4*time.Minute)
+	token, err := c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	c.issuedAt, c.expiresAt = now.Add(-6*time.Minute-time.Second), now.Add(3*time.Minute-time.Second)
+	token, err = c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token)
+	require.Equal(t, int32(2), issued.Load())
+}
+
+func TestTokenClientStart(t *testing.T) {
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := issued.Add(1)
+		_ = json.NewEncoder(w).Encode(tokenservice.TokenResponse{
+			Token:     "token-" + string(rune('0'+n)),
+			ExpiresAt: time.Now().Add(150 * time.Millisecond),
+		})
+	}))
+	defer srv.Close()
+
+	c := NewTokenClientWithHTTPClient(srv.URL, "testroom", srv.Client())
+	c.Start(context.Background())
+
+	// nothing to refresh before the first token
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int32(0), issued.Load())
+
+	token, err := c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	// refreshed in the background after 100ms, Token doesn't fetch
+	require.Eventually(t, func() bool { return issued.Load() == 2 }, time.Second, 5*time.Millisecond)
+	token, err = c.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token)
+	require.Equal(t, int32(2), issued.Load())
+
+	c.Close()
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, int32(2), issued.Load(), "should stop refreshing after Close")
+}
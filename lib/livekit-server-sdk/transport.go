@@ -28,7 +28,9 @@ import (
 	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/interceptor/pkg/twcc"
 	"github.com/pion/sdp/v3"
+	"github.com/pion/transport/v2/stdnet"
 	"github.com/pion/webrtc/v3"
+	"github.com/ziti-livekit-example/lib/openziti"
 
 	lkinterceptor "github.com/livekit/mediatransportutil/pkg/interceptor"
 	"github.com/livekit/mediatransportutil/pkg/pacer"
@@ -75,6 +77,8 @@ type PCTransportParams struct {
 	Interceptors         []interceptor.Factory
 	OnRTTUpdate          func(rtt uint32)
 	IsSender             bool
+	ZitiRuntime          *openziti.Runtime
+	ZitiOnly             bool
 }
 
 func (t *PCTransport) registerDefaultInterceptors(params PCTransportParams, i *interceptor.Registry) error {
@@ -183,6 +187,20 @@ func NewPCTransport(params PCTransportParams) (*PCTransport, error) {
 	se.SetSRTPProtectionProfiles(dtls.SRTP_AEAD_AES_128_GCM, dtls.SRTP_AES128_CM_HMAC_SHA1_80)
 	se.SetDTLSRetransmissionInterval(dtlsRetransmissionInterval)
 	se.SetICETimeouts(iceDisconnectedTimeout, iceFailedTimeout, iceKeepaliveInterval)
+	if params.ZitiOnly {
+		n, err := stdnet.NewZitiOnlyNet(params.ZitiRuntime)
+		if err != nil {
+			return nil, err
+		}
+		se.SetNet(n)
+		se.SetZitiOnly(true)
+	} else if params.ZitiRuntime != nil {
+		n, err := stdnet.NewNetWithRuntime(params.ZitiRuntime)
+		if err != nil {
+			return nil, err
+		}
+		se.SetNet(n)
+	}
 
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(se), webrtc.WithInterceptorRegistry(i))
 	pc, err := api.NewPeerConnection(params.Configuration)
@@ -1,141 +1,97 @@
 package openziti
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"time"
-
-	"github.com/openziti/identity"
-	edge_apis "github.com/openziti/sdk-golang/edge-apis"
-	"github.com/openziti/ziti/ziti/cmd/common"
+	"path/filepath"
 )
 
 var SessionToken string
 
 func EnrollIfNeeded(zitiIDPath string) error {
+	return EnrollIfNeededContext(context.Background(), zitiIDPath)
+}
+
+func EnrollIfNeededContext(ctx context.Context, zitiIDPath string) error {
+	return EnrollIfNeededWithConfig(ctx, zitiIDPath, EnrollConfig{})
+}
+
+// Enrolls <zitiIDPath>.jwt into <zitiIDPath>.json if the jwt exists,
+// the jwt is removed afterwards
+func EnrollIfNeededWithConfig(ctx context.Context, zitiIDPath string, opts EnrollConfig) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Enroll openziti's apis identity if jwt exists
-	_, err := os.Stat(zitiIDPath + ".jwt")
+	token, err := os.ReadFile(zitiIDPath + ".jwt")
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-		} else {
-			// File may exist but there's another error (e.g., permission denied)
-			log.Print(err)
-			return err
-		}
-	} else {
-		// Enroll the openziti identity
-		p := common.NewOptionsProvider(os.Stdout, os.Stdout)
-		action := &EnrollAction{
-			EnrollOptions: EnrollOptions{
-				CommonOptions: p(),
-				JwtPath:       zitiIDPath + ".jwt",
-				KeyAlg:        "RSA",
-			},
-		}
-		log.Printf("Enrolled identity: %s", zitiIDPath)
-		_, err = action.Run()
-		if err != nil {
-			log.Print(err)
-			return err
+			return nil
 		}
+		// File may exist but there's another error (e.g., permission denied)
+		log.Print(err)
+		return err
 	}
-	return nil
-}
 
-// Using .json identity file, authenticates to openziti controller and gets api session
-// stores it as global var
-func CreateApiSession(ctrlUrl string, zitiIDPath string) error {
-	// Read the JSON file
-	jsonFile, err := os.ReadFile(zitiIDPath + ".json")
+	// Enroll the openziti identity
+	identity, err := Enroll(ctx, string(token), opts)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-
-	var jsonData map[string]interface{}
-	err = json.Unmarshal(jsonFile, &jsonData)
-	if err != nil {
+	store := FileIdentityStore{Dir: filepath.Dir(zitiIDPath)}
+	if err = store.Save(filepath.Base(zitiIDPath), identity); err != nil {
 		log.Print(err)
 		return err
 	}
+	if err = os.Remove(zitiIDPath + ".jwt"); err != nil {
+		log.Printf("Unable to remove enrollment token %s.jwt: %v", zitiIDPath, err)
+	}
+	log.Printf("Enrolled identity: %s", zitiIDPath)
+	return nil
+}
 
-	// Create a new map[interface{}]interface{}
-	convertedMap := make(map[interface{}]interface{})
-
-	// Copy each key-value pair from the original map to the new map
-	for key, value := range jsonData["id"].(map[string]interface{}) {
-		convertedMap[key] = value
+// Enrolls with an enrollment token(jwt) and a generated RSA key
+func EnrollJWT(ctx context.Context, jwt string) ([]byte, error) {
+	identity, err := Enroll(ctx, jwt, EnrollConfig{})
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
+	return identity, nil
+}
 
-	// Get api session from openziti
-	urls := make([]*url.URL, 1)
-	apiUrl, _ := url.Parse(ctrlUrl + "/edge/management/v1")
-	urls[0] = apiUrl
+// Using .json identity file, authenticates to openziti controller and gets api session
+// stores it as global var
+func CreateApiSession(ctrlUrl string, zitiIDPath string) error {
+	return CreateApiSessionContext(context.Background(), ctrlUrl, zitiIDPath)
+}
 
-	// Create Identitiy credentials from .json identity
-	conf, err := identity.NewConfigFromMap(convertedMap)
+func CreateApiSessionContext(ctx context.Context, ctrlUrl string, zitiIDPath string) error {
+	auth, err := NewIdentityAuthenticator(ctrlUrl, zitiIDPath)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	credentials := edge_apis.NewIdentityCredentialsFromConfig(*conf)
 
 	// Authenticate and get token
-	var configTypes []string
-	managementClient := edge_apis.NewManagementApiClient(urls, credentials.GetCaPool(), func(ch chan string) {})
-	apiSesionDetial, err := managementClient.Authenticate(credentials, configTypes)
+	token, _, err := auth.Authenticate(ctx)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	_, token := apiSesionDetial.GetAccessHeader()
 	SessionToken = token
+	logSessionToken(token)
+	return nil
+}
 
+func logSessionToken(token string) {
 	if os.Getenv("DEV_ENV") == "true" {
-		log.Printf("Ziti session token: %s", SessionToken)
+		log.Printf("Ziti session token: %s", token)
 	} else {
 		log.Print("Created ziti api session")
 	}
-	return nil
-}
-
-// Pings openziti controller every X seconds to keep the api session alive
-func KeepAlive(ctrlUrl string) {
-	ticker := time.NewTicker(time.Minute * 10)
-
-	for range ticker.C {
-		// Make the HTTPS GET request
-		url := ctrlUrl + "/edge/management/v1/"
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Add("zt-session", SessionToken)
-
-		// Perform the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		defer resp.Body.Close()
-
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-
-		// Print the response body
-		log.Printf("Performed keep alive for openziti session response: %s", body)
-	}
 }
@@ -18,11 +18,22 @@ package openziti
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
 	"github.com/openziti/sdk-golang/ziti"
 	"github.com/openziti/ziti/ziti/cmd/common"
 
@@ -89,62 +100,298 @@ func (e *EnrollAction) Run() (string, error) {
 		tokenStr = string(tokenByte)
 	}
 
-	pfxlog.Logger().Debugf("jwt to parse: %s", tokenStr)
-	tkn, _, err := enroll.ParseToken(tokenStr)
+	conf, err := enrollToken(tokenStr, e.config(), func(flags *enroll.EnrollmentFlags) {
+		flags.Username = e.Username
+		flags.Password = e.Password
+		flags.Verbose = e.Verbose
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if e.JWTString != "" {
+		output, encErr := encodeIdentity(conf)
+		if encErr != nil {
+			return "", fmt.Errorf("enrollment successfull, but failed to encode to json: %s", encErr)
+		}
+		return string(output), nil
+	}
 
+	output, err := os.Create(e.OutputPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse JWT: %s", err.Error())
+		return "", fmt.Errorf("failed to open file '%s': %s", e.OutputPath, err.Error())
+	}
+	defer func() { _ = output.Close() }()
+
+	enc := json.NewEncoder(output)
+	enc.SetEscapeHTML(false)
+	encErr := enc.Encode(conf)
+
+	if err = os.Remove(e.JwtPath); err != nil {
+		pfxlog.Logger().WithError(err).Warnf("unable to remove JWT file as requested: %v", e.JwtPath)
+	}
+
+	if encErr != nil {
+		return "", fmt.Errorf("enrollment successful but the identity file was not able to be written to: %s [%s]", e.OutputPath, encErr)
+	}
+	pfxlog.Logger().Infof("enrolled successfully. identity file written to: %s", e.OutputPath)
+	return "", nil
+}
+
+// config maps the cli options onto EnrollConfig. The cli ignores a key
+// algorithm given with a key and a name given without a certificate, so
+// those are dropped rather than failing validation.
+func (e *EnrollAction) config() EnrollConfig {
+	opts := EnrollConfig{
+		Key:        e.KeyPath,
+		Cert:       e.CertPath,
+		CaOverride: e.CaOverride,
+	}
+	if e.KeyPath == "" {
+		opts.KeyAlg = KeyAlgRSA
+		if e.KeyAlg.EC() {
+			opts.KeyAlg = KeyAlgECP384
+		}
+	}
+	if e.CertPath != "" {
+		opts.IdName = e.IdName
+	}
+	return opts
+}
+
+type KeyAlgorithm string
+
+const (
+	// 4096 bit RSA
+	KeyAlgRSA    KeyAlgorithm = "RSA"
+	KeyAlgECP256 KeyAlgorithm = "EC-P256"
+	KeyAlgECP384 KeyAlgorithm = "EC-P384"
+)
+
+// EnrollConfig selects how the identity key and certificate are obtained.
+// The zero value generates a RSA key, like the ziti cli.
+type EnrollConfig struct {
+	// Algorithm of the generated key. Ignored when Key is set
+	KeyAlg KeyAlgorithm
+	// Bring your own key instead of generating one: a pem encoded key,
+	// a file path or an engine url(e.g. pkcs11://...)
+	Key string
+	// Bring your own CSR(pem), signed by Key. Only for ott enrollment,
+	// the key is never loaded so Key may be a reference the process can't read
+	CSR []byte
+	// Certificate file signed by a 3rd party CA, for ottca and ca(auto) enrollment
+	Cert string
+	// Name of the identity created by 3rd party CA auto enrollment
+	IdName string
+	// CA bundle file trusted for the controller in addition to the jwt signer
+	CaOverride string
+}
+
+func (o EnrollConfig) Validate() error {
+	switch o.KeyAlg {
+	case "", KeyAlgRSA, KeyAlgECP256, KeyAlgECP384:
+	default:
+		return fmt.Errorf("unsupported key algorithm %q", o.KeyAlg)
+	}
+	if o.Key != "" && o.KeyAlg != "" {
+		return errors.New("key algorithm is set together with a key")
+	}
+	if len(o.CSR) > 0 {
+		if o.Key == "" {
+			return errors.New("a csr needs the key it was signed with")
+		}
+		if o.Cert != "" {
+			return errors.New("a csr can't be used with a certificate")
+		}
+	}
+	if o.Cert != "" && o.Key == "" {
+		return errors.New("a certificate needs its key")
+	}
+	if o.IdName != "" && o.Cert == "" {
+		return errors.New("identity name is only used by 3rd party CA auto enrollment")
+	}
+	return nil
+}
+
+// Enrolls with an enrollment token(jwt) and returns the identity json,
+// nothing is written to the filesystem
+func Enroll(ctx context.Context, jwt string, opts EnrollConfig) ([]byte, error) {
+	type result struct {
+		identity []byte
+		err      error
+	}
+	// enroll doesn't take a context, don't let it block cancellation
+	resc := make(chan result, 1)
+	go func() {
+		conf, err := enrollToken(jwt, opts, nil)
+		if err != nil {
+			resc <- result{nil, err}
+			return
+		}
+		identity, err := encodeIdentity(conf)
+		resc <- result{identity, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-resc:
+		return res.identity, res.err
+	}
+}
+
+func enrollToken(tokenStr string, opts EnrollConfig, setFlags func(flags *enroll.EnrollmentFlags)) (*ziti.Config, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
 
+	pfxlog.Logger().Debugf("jwt to parse: %s", tokenStr)
+	tkn, _, err := enroll.ParseToken(strings.TrimSpace(tokenStr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT: %s", err.Error())
+	}
+
+	if len(opts.CSR) > 0 {
+		conf, err := enrollCSR(tkn, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to enroll: %v", err)
+		}
+		return conf, nil
+	}
+
+	key, err := opts.keyRef()
+	if err != nil {
+		return nil, err
+	}
 	flags := enroll.EnrollmentFlags{
-		CertFile:      e.CertPath,
-		KeyFile:       e.KeyPath,
-		KeyAlg:        e.KeyAlg,
+		CertFile:      opts.Cert,
+		KeyFile:       key,
+		KeyAlg:        "RSA",
 		Token:         tkn,
-		IDName:        e.IdName,
-		AdditionalCAs: e.CaOverride,
-		Username:      e.Username,
-		Password:      e.Password,
-		Verbose:       e.Verbose,
+		IDName:        opts.IdName,
+		AdditionalCAs: opts.CaOverride,
+	}
+	if opts.KeyAlg == KeyAlgECP384 {
+		flags.KeyAlg = "EC"
+	}
+	if setFlags != nil {
+		setFlags(&flags)
 	}
 
 	conf, err := enroll.Enroll(flags)
 	if err != nil {
-		return "", fmt.Errorf("failed to enroll: %v", err)
+		return nil, fmt.Errorf("failed to enroll: %v", err)
 	}
+	return conf, nil
+}
 
-	if e.JWTString != "" {
-		output := new(bytes.Buffer)
-		enc := json.NewEncoder(output)
-		enc.SetEscapeHTML(false)
-		encErr := enc.Encode(&conf)
-		if encErr != nil {
-			return "", fmt.Errorf("enrollment successfull, but failed to encode to json: %s", encErr)
+// Key passed to the sdk, which only generates RSA 4096 and EC P-384 keys
+func (o EnrollConfig) keyRef() (string, error) {
+	switch {
+	case o.Key != "":
+		return pemRef(o.Key), nil
+	case o.KeyAlg == KeyAlgECP256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
 		}
-		return output.String(), nil
-	} else {
-		output, err := os.Create(e.OutputPath)
+		der, err := x509.MarshalECPrivateKey(key)
 		if err != nil {
-			return "", fmt.Errorf("failed to open file '%s': %s", e.OutputPath, err.Error())
+			return "", err
 		}
-		defer func() { _ = output.Close() }()
+		return "pem:" + string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	default:
+		return "", nil
+	}
+}
 
-		enc := json.NewEncoder(output)
-		enc.SetEscapeHTML(false)
-		encErr := enc.Encode(&conf)
+// Inline pem values need the pem: prefix in identity configs
+func pemRef(value string) string {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return "pem:" + strings.TrimSpace(value)
+	}
+	return value
+}
 
-		if err = os.Remove(e.JwtPath); err != nil {
-			pfxlog.Logger().WithError(err).Warnf("unable to remove JWT file as requested: %v", e.JwtPath)
-		}
+// ott enrollment with a CSR made outside of this process
+func enrollCSR(tkn *ziti.EnrollmentClaims, opts EnrollConfig) (*ziti.Config, error) {
+	if tkn.EnrollmentMethod != "ott" {
+		return nil, fmt.Errorf("a csr can't be used with enrollment method '%s'", tkn.EnrollmentMethod)
+	}
+	if block, _ := pem.Decode(opts.CSR); block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("csr is not a pem encoded certificate request")
+	}
+
+	flags := enroll.EnrollmentFlags{AdditionalCAs: opts.CaOverride}
+	caPool, allowedCerts := flags.GetCertPool()
+
+	signerPool := x509.NewCertPool()
+	signerPool.AddCert(tkn.SignatureCert)
+	for _, cert := range enroll.FetchCertificates(tkn.Issuer, signerPool) {
+		allowedCerts = append(allowedCerts, cert)
+		caPool.AddCert(cert)
+	}
+	if len(allowedCerts) == 0 {
+		return nil, errors.New("expected 1 or more CAs from controller, got 0")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: caPool},
+			Proxy:           http.ProxyFromEnvironment,
+		},
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Post(tkn.EnrolmentUrl(), "application/x-pem-file", bytes.NewReader(opts.CSR))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("enroll error: %s: %s", resp.Status, body)
+	}
 
-		if encErr == nil {
-			pfxlog.Logger().Infof("enrolled successfully. identity file written to: %s", e.OutputPath)
-			return "", nil
-		} else {
-			return "", fmt.Errorf("enrollment successful but the identity file was not able to be written to: %s [%s]", e.OutputPath, encErr)
+	certPem := string(body)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		r := struct {
+			Data struct {
+				Cert string `json:"cert"`
+			} `json:"data"`
+		}{}
+		if err = json.Unmarshal(body, &r); err != nil {
+			return nil, fmt.Errorf("could not parse json enrollment response: %v", err)
 		}
+		certPem = r.Data.Cert
 	}
-	return "", nil
+	if certPem == "" {
+		return nil, errors.New("enrollment response has no certificate")
+	}
+
+	var caPem bytes.Buffer
+	for _, cert := range allowedCerts {
+		_ = pem.Encode(&caPem, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+
+	conf := &ziti.Config{ZtAPI: edge_apis.ClientUrl(tkn.Issuer)}
+	conf.ID.Key = pemRef(opts.Key)
+	conf.ID.Cert = "pem:" + certPem
+	conf.ID.CA = "pem:" + caPem.String()
+	return conf, nil
+}
+
+func encodeIdentity(conf *ziti.Config) ([]byte, error) {
+	output := new(bytes.Buffer)
+	enc := json.NewEncoder(output)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(conf); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
 }
 
 func outPathFromJwt(jwt string) (string, error) {
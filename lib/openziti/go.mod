@@ -7,10 +7,12 @@ replace github.com/gorilla/websocket v1.5.3 => ../websocket
 require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/michaelquigley/pfxlog v0.6.10
+	github.com/openziti/edge-api v0.26.30
 	github.com/openziti/identity v1.0.84
 	github.com/openziti/sdk-golang v0.23.40
 	github.com/openziti/ziti v1.1.4
 	github.com/pkg/errors v0.9.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -54,7 +56,6 @@ require (
 	github.com/oklog/ulid v1.3.1 // indirect
 	github.com/opentracing/opentracing-go v1.2.1-0.20220228012449-10b1cf09e00b // indirect
 	github.com/openziti/channel/v2 v2.0.136 // indirect
-	github.com/openziti/edge-api v0.26.30 // indirect
 	github.com/openziti/foundation/v2 v2.0.49 // indirect
 	github.com/openziti/metrics v1.2.58 // indirect
 	github.com/openziti/secretstream v0.1.21 // indirect
@@ -88,6 +89,5 @@ require (
 	golang.org/x/text v0.18.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 	gopkg.in/go-jose/go-jose.v2 v2.6.3 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	nhooyr.io/websocket v1.8.17 // indirect
 )
@@ -0,0 +1,257 @@
+package openziti
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("openziti resource not found")
+
+// Default page size when listing all entities of a resource
+const managementPageSize = 100
+
+// TokenSource provides the zt-session token for management api requests.
+// SessionManager is a TokenSource.
+type TokenSource interface {
+	Token() string
+}
+
+// StaticToken is a TokenSource for a token that never changes
+type StaticToken string
+
+func (t StaticToken) Token() string {
+	return string(t)
+}
+
+// Token of the managed session started by the Setup functions, or the
+// package level SessionToken when there is none
+type globalSessionToken struct{}
+
+func (globalSessionToken) Token() string {
+	if m := defaultSession.Load(); m != nil {
+		return m.Token()
+	}
+	return SessionToken
+}
+
+// APIError is an error envelope returned by the edge management api
+type APIError struct {
+	StatusCode   int
+	Method       string
+	Path         string
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	CauseMessage string `json:"causeMessage"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("openziti %s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Code)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.CauseMessage != "" {
+		msg += ": " + e.CauseMessage
+	}
+	return msg
+}
+
+// Lets errors.Is match ErrNotFound and ErrSessionUnauthorized
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized:
+		return ErrSessionUnauthorized
+	}
+	return nil
+}
+
+type ListOptions struct {
+	// ziti filter query, e.g. FilterEq("name", "admin1")
+	Filter string
+	Limit  int
+	Offset int
+}
+
+type Pagination struct {
+	Limit      int `json:"limit"`
+	Offset     int `json:"offset"`
+	TotalCount int `json:"totalCount"`
+}
+
+// Filter matching entities whose field equals value
+func FilterEq(field string, value string) string {
+	return fmt.Sprintf("%s=%s", field, strconv.Quote(value))
+}
+
+// Filter matching entities whose field contains value
+func FilterContains(field string, value string) string {
+	return fmt.Sprintf("%s contains %s", field, strconv.Quote(value))
+}
+
+// Joins filters with and
+func FilterAnd(filters ...string) string {
+	return strings.Join(filters, " and ")
+}
+
+// ManagementClient is a typed client for the edge management api
+type ManagementClient struct {
+	CtrlUrl string
+	Tokens  TokenSource
+	Client  *http.Client
+}
+
+func NewManagementClient(ctrlUrl string, tokens TokenSource, client *http.Client) *ManagementClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ManagementClient{CtrlUrl: ctrlUrl, Tokens: tokens, Client: client}
+}
+
+// Client for ZITI_CTRL_URL using the managed session or the package level
+// SessionToken
+func DefaultManagementClient() *ManagementClient {
+	return NewManagementClient(zitiCtrlUrl(), globalSessionToken{}, nil)
+}
+
+func zitiCtrlUrl() string {
+	return strings.TrimSuffix(os.Getenv("ZITI_CTRL_URL"), "/")
+}
+
+type dataEnvelope[T any] struct {
+	Data T `json:"data"`
+	Meta struct {
+		Pagination *Pagination `json:"pagination"`
+	} `json:"meta"`
+}
+
+type errorEnvelope struct {
+	Error *APIError `json:"error"`
+}
+
+type createdResp struct {
+	ID string `json:"id"`
+}
+
+// do sends in as json(if not nil) and decodes the data envelope into out(if not nil)
+func (c *ManagementClient) do(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		jsonValue, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(jsonValue)
+	}
+
+	u := c.CtrlUrl + "/edge/management/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Tokens != nil {
+		req.Header.Add("zt-session", c.Tokens.Token())
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		env := errorEnvelope{Error: apiErr}
+		_ = json.Unmarshal(respBody, &env)
+		apiErr.StatusCode = resp.StatusCode
+		apiErr.Method = method
+		apiErr.Path = path
+		if apiErr.Message == "" && apiErr.Code == "" {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+func managementGet[T any](ctx context.Context, c *ManagementClient, path string) (T, error) {
+	env := dataEnvelope[T]{}
+	err := c.do(ctx, http.MethodGet, path, nil, nil, &env)
+	return env.Data, err
+}
+
+func managementList[T any](ctx context.Context, c *ManagementClient, path string, opts ListOptions) ([]T, Pagination, error) {
+	query := url.Values{}
+	if opts.Filter != "" {
+		query.Set("filter", opts.Filter)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	env := dataEnvelope[[]T]{}
+	if err := c.do(ctx, http.MethodGet, path, query, nil, &env); err != nil {
+		return nil, Pagination{}, err
+	}
+	page := Pagination{Limit: opts.Limit, Offset: opts.Offset, TotalCount: len(env.Data)}
+	if env.Meta.Pagination != nil {
+		page = *env.Meta.Pagination
+	}
+	return env.Data, page, nil
+}
+
+// Lists every page of path
+func managementListAll[T any](ctx context.Context, c *ManagementClient, path string, filter string) ([]T, error) {
+	var all []T
+	opts := ListOptions{Filter: filter, Limit: managementPageSize}
+	for {
+		items, page, err := managementList[T](ctx, c, path, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		opts.Offset += len(items)
+		if len(items) == 0 || opts.Offset >= page.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+func managementCreate(ctx context.Context, c *ManagementClient, path string, in interface{}) (string, error) {
+	env := dataEnvelope[createdResp]{}
+	err := c.do(ctx, http.MethodPost, path, nil, in, &env)
+	return env.Data.ID, err
+}
+
+func managementPatch(ctx context.Context, c *ManagementClient, path string, in interface{}) error {
+	return c.do(ctx, http.MethodPatch, path, nil, in, nil)
+}
+
+func managementDelete(ctx context.Context, c *ManagementClient, path string) error {
+	return c.do(ctx, http.MethodDelete, path, nil, nil, nil)
+}
@@ -0,0 +1,390 @@
+package openziti
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	ConfigTypeIntercept = "intercept.v1"
+	ConfigTypeHost      = "host.v1"
+
+	PolicyTypeDial = "Dial"
+	PolicyTypeBind = "Bind"
+
+	SemanticAnyOf = "AnyOf"
+	SemanticAllOf = "AllOf"
+)
+
+type EnrollmentOTT struct {
+	ID        string    `json:"id,omitempty"`
+	JWT       string    `json:"jwt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+type Identity struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Type           string   `json:"type,omitempty"`
+	IsAdmin        bool     `json:"isAdmin"`
+	RoleAttributes []string `json:"roleAttributes"`
+	Enrollment     struct {
+		OTT EnrollmentOTT `json:"ott"`
+	} `json:"enrollment"`
+	Tags map[string]interface{} `json:"tags,omitempty"`
+}
+
+type IdentityCreate struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	IsAdmin        bool     `json:"isAdmin"`
+	RoleAttributes []string `json:"roleAttributes"`
+	// Create an ott enrollment(jwt) with the identity
+	EnrollOTT bool                   `json:"-"`
+	Tags      map[string]interface{} `json:"tags,omitempty"`
+}
+
+func (i IdentityCreate) MarshalJSON() ([]byte, error) {
+	type plain IdentityCreate
+	body := struct {
+		plain
+		Enrollment map[string]interface{} `json:"enrollment,omitempty"`
+	}{plain: plain(i)}
+	if body.Type == "" {
+		body.Type = "User"
+	}
+	if i.EnrollOTT {
+		body.Enrollment = map[string]interface{}{"ott": true}
+	}
+	return json.Marshal(body)
+}
+
+// Fields left nil are not changed
+type IdentityUpdate struct {
+	Name           *string                 `json:"name,omitempty"`
+	IsAdmin        *bool                   `json:"isAdmin,omitempty"`
+	RoleAttributes *[]string               `json:"roleAttributes,omitempty"`
+	Tags           *map[string]interface{} `json:"tags,omitempty"`
+}
+
+type Service struct {
+	ID                 string                 `json:"id,omitempty"`
+	Name               string                 `json:"name"`
+	RoleAttributes     []string               `json:"roleAttributes"`
+	Configs            []string               `json:"configs"`
+	EncryptionRequired bool                   `json:"encryptionRequired"`
+	TerminatorStrategy string                 `json:"terminatorStrategy,omitempty"`
+	Tags               map[string]interface{} `json:"tags,omitempty"`
+}
+
+type ConfigType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Config struct {
+	ID           string          `json:"id,omitempty"`
+	Name         string          `json:"name"`
+	ConfigTypeID string          `json:"configTypeId"`
+	Data         json.RawMessage `json:"data"`
+}
+
+// Unmarshals the config data, e.g. into InterceptV1 or HostV1
+func (c Config) Decode(v interface{}) error {
+	return json.Unmarshal(c.Data, v)
+}
+
+type PortRange struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// Data of an intercept.v1 config
+type InterceptV1 struct {
+	Protocols  []string    `json:"protocols"`
+	Addresses  []string    `json:"addresses"`
+	PortRanges []PortRange `json:"portRanges"`
+}
+
+// Data of a host.v1 config
+type HostV1 struct {
+	Protocol string `json:"protocol,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	// Forward the intercepted protocol, address or port instead of the fixed ones
+	ForwardProtocol        bool        `json:"forwardProtocol,omitempty"`
+	ForwardAddress         bool        `json:"forwardAddress,omitempty"`
+	ForwardPort            bool        `json:"forwardPort,omitempty"`
+	AllowedProtocols       []string    `json:"allowedProtocols,omitempty"`
+	AllowedAddresses       []string    `json:"allowedAddresses,omitempty"`
+	AllowedPortRanges      []PortRange `json:"allowedPortRanges,omitempty"`
+	AllowedSourceAddresses []string    `json:"allowedSourceAddresses,omitempty"`
+}
+
+type ServicePolicy struct {
+	ID                string   `json:"id,omitempty"`
+	Name              string   `json:"name"`
+	Type              string   `json:"type"`
+	Semantic          string   `json:"semantic"`
+	IdentityRoles     []string `json:"identityRoles"`
+	ServiceRoles      []string `json:"serviceRoles"`
+	PostureCheckRoles []string `json:"postureCheckRoles"`
+}
+
+type EdgeRouterPolicy struct {
+	ID              string   `json:"id,omitempty"`
+	Name            string   `json:"name"`
+	Semantic        string   `json:"semantic"`
+	IdentityRoles   []string `json:"identityRoles"`
+	EdgeRouterRoles []string `json:"edgeRouterRoles"`
+}
+
+type Enrollment struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	IdentityID string    `json:"identityId"`
+	JWT        string    `json:"jwt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func entityPath(collection string, id string) string {
+	return "/" + collection + "/" + url.PathEscape(id)
+}
+
+// Identities
+
+func (c *ManagementClient) ListIdentities(ctx context.Context, opts ListOptions) ([]Identity, Pagination, error) {
+	return managementList[Identity](ctx, c, "/identities", opts)
+}
+
+// All identities matching filter, across every page
+func (c *ManagementClient) AllIdentities(ctx context.Context, filter string) ([]Identity, error) {
+	return managementListAll[Identity](ctx, c, "/identities", filter)
+}
+
+func (c *ManagementClient) GetIdentity(ctx context.Context, id string) (Identity, error) {
+	return managementGet[Identity](ctx, c, entityPath("identities", id))
+}
+
+// Identity with the given name, ErrNotFound if there is none
+func (c *ManagementClient) IdentityByName(ctx context.Context, name string) (Identity, error) {
+	return managementFindByName[Identity](ctx, c, "identities", name)
+}
+
+func (c *ManagementClient) CreateIdentity(ctx context.Context, iden IdentityCreate) (string, error) {
+	return managementCreate(ctx, c, "/identities", iden)
+}
+
+func (c *ManagementClient) UpdateIdentity(ctx context.Context, id string, update IdentityUpdate) error {
+	return managementPatch(ctx, c, entityPath("identities", id), update)
+}
+
+func (c *ManagementClient) DeleteIdentity(ctx context.Context, id string) error {
+	return managementDelete(ctx, c, entityPath("identities", id))
+}
+
+// Services
+
+func (c *ManagementClient) ListServices(ctx context.Context, opts ListOptions) ([]Service, Pagination, error) {
+	return managementList[Service](ctx, c, "/services", opts)
+}
+
+func (c *ManagementClient) AllServices(ctx context.Context, filter string) ([]Service, error) {
+	return managementListAll[Service](ctx, c, "/services", filter)
+}
+
+func (c *ManagementClient) GetService(ctx context.Context, id string) (Service, error) {
+	return managementGet[Service](ctx, c, entityPath("services", id))
+}
+
+func (c *ManagementClient) ServiceByName(ctx context.Context, name string) (Service, error) {
+	return managementFindByName[Service](ctx, c, "services", name)
+}
+
+func (c *ManagementClient) CreateService(ctx context.Context, service Service) (string, error) {
+	service.ID = ""
+	return managementCreate(ctx, c, "/services", service)
+}
+
+// Replaces the service with id by service
+func (c *ManagementClient) UpdateService(ctx context.Context, id string, service Service) error {
+	service.ID = ""
+	return managementPatch(ctx, c, entityPath("services", id), service)
+}
+
+func (c *ManagementClient) DeleteService(ctx context.Context, id string) error {
+	return managementDelete(ctx, c, entityPath("services", id))
+}
+
+// Configs
+
+func (c *ManagementClient) ConfigTypeByName(ctx context.Context, name string) (ConfigType, error) {
+	return managementFindByName[ConfigType](ctx, c, "config-types", name)
+}
+
+func (c *ManagementClient) ListConfigs(ctx context.Context, opts ListOptions) ([]Config, Pagination, error) {
+	return managementList[Config](ctx, c, "/configs", opts)
+}
+
+func (c *ManagementClient) AllConfigs(ctx context.Context, filter string) ([]Config, error) {
+	return managementListAll[Config](ctx, c, "/configs", filter)
+}
+
+func (c *ManagementClient) GetConfig(ctx context.Context, id string) (Config, error) {
+	return managementGet[Config](ctx, c, entityPath("configs", id))
+}
+
+func (c *ManagementClient) ConfigByName(ctx context.Context, name string) (Config, error) {
+	return managementFindByName[Config](ctx, c, "configs", name)
+}
+
+// Creates a config named name of the config type named typeName with data
+func (c *ManagementClient) CreateConfig(ctx context.Context, name string, typeName string, data interface{}) (string, error) {
+	configType, err := c.ConfigTypeByName(ctx, typeName)
+	if err != nil {
+		return "", fmt.Errorf("config type %s: %w", typeName, err)
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return managementCreate(ctx, c, "/configs", Config{
+		Name:         name,
+		ConfigTypeID: configType.ID,
+		Data:         jsonData,
+	})
+}
+
+func (c *ManagementClient) CreateInterceptConfig(ctx context.Context, name string, data InterceptV1) (string, error) {
+	return c.CreateConfig(ctx, name, ConfigTypeIntercept, data)
+}
+
+func (c *ManagementClient) CreateHostConfig(ctx context.Context, name string, data HostV1) (string, error) {
+	return c.CreateConfig(ctx, name, ConfigTypeHost, data)
+}
+
+// Replaces the data of the config with id
+func (c *ManagementClient) UpdateConfigData(ctx context.Context, id string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return managementPatch(ctx, c, entityPath("configs", id), map[string]json.RawMessage{"data": jsonData})
+}
+
+func (c *ManagementClient) DeleteConfig(ctx context.Context, id string) error {
+	return managementDelete(ctx, c, entityPath("configs", id))
+}
+
+// Service policies
+
+func (c *ManagementClient) ListServicePolicies(ctx context.Context, opts ListOptions) ([]ServicePolicy, Pagination, error) {
+	return managementList[ServicePolicy](ctx, c, "/service-policies", opts)
+}
+
+func (c *ManagementClient) AllServicePolicies(ctx context.Context, filter string) ([]ServicePolicy, error) {
+	return managementListAll[ServicePolicy](ctx, c, "/service-policies", filter)
+}
+
+func (c *ManagementClient) GetServicePolicy(ctx context.Context, id string) (ServicePolicy, error) {
+	return managementGet[ServicePolicy](ctx, c, entityPath("service-policies", id))
+}
+
+func (c *ManagementClient) CreateServicePolicy(ctx context.Context, policy ServicePolicy) (string, error) {
+	policy.ID = ""
+	if policy.Semantic == "" {
+		policy.Semantic = SemanticAnyOf
+	}
+	return managementCreate(ctx, c, "/service-policies", policy)
+}
+
+func (c *ManagementClient) UpdateServicePolicy(ctx context.Context, id string, policy ServicePolicy) error {
+	policy.ID = ""
+	return managementPatch(ctx, c, entityPath("service-policies", id), policy)
+}
+
+func (c *ManagementClient) DeleteServicePolicy(ctx context.Context, id string) error {
+	return managementDelete(ctx, c, entityPath("service-policies", id))
+}
+
+// Edge router policies
+
+func (c *ManagementClient) ListEdgeRouterPolicies(ctx context.Context, opts ListOptions) ([]EdgeRouterPolicy, Pagination, error) {
+	return managementList[EdgeRouterPolicy](ctx, c, "/edge-router-policies", opts)
+}
+
+func (c *ManagementClient) AllEdgeRouterPolicies(ctx context.Context, filter string) ([]EdgeRouterPolicy, error) {
+	return managementListAll[EdgeRouterPolicy](ctx, c, "/edge-router-policies", filter)
+}
+
+func (c *ManagementClient) GetEdgeRouterPolicy(ctx context.Context, id string) (EdgeRouterPolicy, error) {
+	return managementGet[EdgeRouterPolicy](ctx, c, entityPath("edge-router-policies", id))
+}
+
+func (c *ManagementClient) CreateEdgeRouterPolicy(ctx context.Context, policy EdgeRouterPolicy) (string, error) {
+	policy.ID = ""
+	if policy.Semantic == "" {
+		policy.Semantic = SemanticAnyOf
+	}
+	return managementCreate(ctx, c, "/edge-router-policies", policy)
+}
+
+func (c *ManagementClient) UpdateEdgeRouterPolicy(ctx context.Context, id string, policy EdgeRouterPolicy) error {
+	policy.ID = ""
+	return managementPatch(ctx, c, entityPath("edge-router-policies", id), policy)
+}
+
+func (c *ManagementClient) DeleteEdgeRouterPolicy(ctx context.Context, id string) error {
+	return managementDelete(ctx, c, entityPath("edge-router-policies", id))
+}
+
+// Enrollments
+
+func (c *ManagementClient) GetEnrollment(ctx context.Context, id string) (Enrollment, error) {
+	return managementGet[Enrollment](ctx, c, entityPath("enrollments", id))
+}
+
+// Replaces the ott enrollment of an identity with a new one valid until expiresAt.
+// Used when a device lost its jwt or the old one expired before enrolling.
+// return the new jwt
+func (c *ManagementClient) ReissueEnrollment(ctx context.Context, identityID string, expiresAt time.Time) (string, error) {
+	existing, err := managementListAll[Enrollment](ctx, c, "/enrollments",
+		FilterAnd(FilterEq("identity", identityID), FilterEq("method", "ott")))
+	if err != nil {
+		return "", err
+	}
+	for _, e := range existing {
+		if err = managementDelete(ctx, c, entityPath("enrollments", e.ID)); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := managementCreate(ctx, c, "/enrollments", map[string]interface{}{
+		"method":     "ott",
+		"identityId": identityID,
+		"expiresAt":  expiresAt.UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enrollment, err := c.GetEnrollment(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return enrollment.JWT, nil
+}
+
+func managementFindByName[T any](ctx context.Context, c *ManagementClient, collection string, name string) (T, error) {
+	var zero T
+	items, _, err := managementList[T](ctx, c, "/"+collection, ListOptions{Filter: FilterEq("name", name), Limit: 1})
+	if err != nil {
+		return zero, err
+	}
+	if len(items) == 0 {
+		return zero, fmt.Errorf("%s %q: %w", collection, name, ErrNotFound)
+	}
+	return items[0], nil
+}
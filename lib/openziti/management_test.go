@@ -0,0 +1,353 @@
+package openziti
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ziti-livekit-example/lib/openziti/zititest"
+)
+
+func newTestManagementClient(t *testing.T) (*ManagementClient, *zititest.Controller) {
+	t.Helper()
+	ctrl := zititest.NewController()
+	t.Cleanup(ctrl.Close)
+	return NewManagementClient(ctrl.URL, StaticToken(ctrl.Login()), ctrl.Client()), ctrl
+}
+
+func TestManagementIdentities(t *testing.T) {
+	client, _ := newTestManagementClient(t)
+	ctx := context.Background()
+
+	id, err := client.CreateIdentity(ctx, IdentityCreate{
+		Name:           "device1",
+		RoleAttributes: []string{"livekit.dial"},
+		EnrollOTT:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iden, err := client.GetIdentity(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iden.Name != "device1" || iden.Type != "User" || iden.Enrollment.OTT.JWT == "" {
+		t.Fatalf("unexpected identity %+v", iden)
+	}
+
+	attrs := []string{"livekit.dial", "turn.dial"}
+	if err = client.UpdateIdentity(ctx, id, IdentityUpdate{RoleAttributes: &attrs}); err != nil {
+		t.Fatal(err)
+	}
+	iden, err = client.IdentityByName(ctx, "device1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iden.ID != id || len(iden.RoleAttributes) != 2 {
+		t.Fatalf("unexpected identity %+v", iden)
+	}
+
+	if err = client.DeleteIdentity(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.GetIdentity(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err = client.IdentityByName(ctx, "device1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManagementPaginationAndFilter(t *testing.T) {
+	client, ctrl := newTestManagementClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 250; i++ {
+		role := "device"
+		if i%10 == 0 {
+			role = "admin"
+		}
+		ctrl.Put("identities", map[string]interface{}{
+			"name":           fmt.Sprintf("iden-%03d", i),
+			"roleAttributes": []string{role},
+		})
+	}
+
+	items, page, err := client.ListIdentities(ctx, ListOptions{Limit: 20, Offset: 240})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 10 || page.TotalCount != 250 || page.Offset != 240 {
+		t.Fatalf("unexpected page %d %+v", len(items), page)
+	}
+
+	all, err := client.AllIdentities(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 250 {
+		t.Fatalf("expected 250 identities, got %d", len(all))
+	}
+
+	admins, err := client.AllIdentities(ctx, FilterContains("roleAttributes", "admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(admins) != 25 {
+		t.Fatalf("expected 25 admins, got %d", len(admins))
+	}
+
+	one, err := client.AllIdentities(ctx, FilterAnd(FilterEq("name", "iden-010"), FilterContains("roleAttributes", "admin")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(one) != 1 || one[0].Name != "iden-010" {
+		t.Fatalf("unexpected filter result %+v", one)
+	}
+}
+
+func TestManagementAPIErrors(t *testing.T) {
+	client, ctrl := newTestManagementClient(t)
+	ctx := context.Background()
+
+	if _, err := client.CreateService(ctx, Service{Name: "livekit"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.CreateService(ctx, Service{Name: "livekit"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+	if apiErr.StatusCode != 409 || apiErr.Code != "CONFLICT" || apiErr.Method != "POST" || apiErr.Path != "/services" {
+		t.Fatalf("unexpected error %+v", apiErr)
+	}
+
+	if _, _, err = client.ListServices(ctx, ListOptions{Filter: "name ~ 1"}); !errors.As(err, &apiErr) || apiErr.Code != "INVALID_FILTER" {
+		t.Fatalf("expected INVALID_FILTER, got %v", err)
+	}
+
+	ctrl.RevokeSessions()
+	if _, err = client.GetService(ctx, "x"); !errors.Is(err, ErrSessionUnauthorized) {
+		t.Fatalf("expected ErrSessionUnauthorized, got %v", err)
+	}
+}
+
+func TestManagementConfigsAndServices(t *testing.T) {
+	client, _ := newTestManagementClient(t)
+	ctx := context.Background()
+
+	interceptID, err := client.CreateInterceptConfig(ctx, "livekit.intercept", InterceptV1{
+		Protocols:  []string{"tcp"},
+		Addresses:  []string{"livekit.ziti"},
+		PortRanges: []PortRange{{Low: 7880, High: 7880}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostID, err := client.CreateHostConfig(ctx, "livekit.host", HostV1{Protocol: "tcp", Address: "localhost", Port: 7880})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := client.GetConfig(ctx, interceptID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intercept := InterceptV1{}
+	if err = config.Decode(&intercept); err != nil {
+		t.Fatal(err)
+	}
+	if config.ConfigTypeID != "intercept-v1-id" || intercept.Addresses[0] != "livekit.ziti" {
+		t.Fatalf("unexpected config %+v %+v", config, intercept)
+	}
+
+	if err = client.UpdateConfigData(ctx, hostID, HostV1{Protocol: "tcp", Address: "livekit", Port: 7881}); err != nil {
+		t.Fatal(err)
+	}
+	config, err = client.ConfigByName(ctx, "livekit.host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := HostV1{}
+	if err = config.Decode(&host); err != nil {
+		t.Fatal(err)
+	}
+	if host.Address != "livekit" || host.Port != 7881 {
+		t.Fatalf("unexpected host config %+v", host)
+	}
+
+	if _, err = client.CreateConfig(ctx, "bad", "nope.v1", nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	serviceID, err := client.CreateService(ctx, Service{
+		Name:               "livekit",
+		RoleAttributes:     []string{"livekit"},
+		Configs:            []string{interceptID, hostID},
+		EncryptionRequired: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := client.ServiceByName(ctx, "livekit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ID != serviceID || len(service.Configs) != 2 || !service.EncryptionRequired {
+		t.Fatalf("unexpected service %+v", service)
+	}
+}
+
+func TestManagementPolicies(t *testing.T) {
+	client, _ := newTestManagementClient(t)
+	ctx := context.Background()
+
+	spID, err := client.CreateServicePolicy(ctx, ServicePolicy{
+		Name:          "livekit.dial",
+		Type:          PolicyTypeDial,
+		IdentityRoles: []string{"#livekit.dial"},
+		ServiceRoles:  []string{"@livekit"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := client.GetServicePolicy(ctx, spID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Semantic != SemanticAnyOf || sp.Type != PolicyTypeDial {
+		t.Fatalf("unexpected service policy %+v", sp)
+	}
+	sp.IdentityRoles = []string{"#all"}
+	if err = client.UpdateServicePolicy(ctx, spID, sp); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := client.AllServicePolicies(ctx, FilterEq("type", PolicyTypeDial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].IdentityRoles[0] != "#all" {
+		t.Fatalf("unexpected service policies %+v", policies)
+	}
+	if err = client.DeleteServicePolicy(ctx, spID); err != nil {
+		t.Fatal(err)
+	}
+
+	erpID, err := client.CreateEdgeRouterPolicy(ctx, EdgeRouterPolicy{
+		Name:            "all",
+		IdentityRoles:   []string{"#all"},
+		EdgeRouterRoles: []string{"#all"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	erp, err := client.GetEdgeRouterPolicy(ctx, erpID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erp.Name != "all" || erp.Semantic != SemanticAnyOf {
+		t.Fatalf("unexpected edge router policy %+v", erp)
+	}
+	if err = client.DeleteEdgeRouterPolicy(ctx, erpID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.GetEdgeRouterPolicy(ctx, erpID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManagementReissueEnrollment(t *testing.T) {
+	client, ctrl := newTestManagementClient(t)
+	ctx := context.Background()
+
+	id, err := client.CreateIdentity(ctx, IdentityCreate{Name: "device1", EnrollOTT: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iden, err := client.GetIdentity(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldJWT := iden.Enrollment.OTT.JWT
+
+	jwt, err := client.ReissueEnrollment(ctx, id, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt == "" || jwt == oldJWT {
+		t.Fatalf("expected a new jwt, got %q", jwt)
+	}
+	if n := len(ctrl.List("enrollments")); n != 1 {
+		t.Fatalf("expected 1 enrollment, got %d", n)
+	}
+	iden, err = client.GetIdentity(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iden.Enrollment.OTT.JWT != jwt {
+		t.Fatalf("identity shows jwt %q, want %q", iden.Enrollment.OTT.JWT, jwt)
+	}
+}
+
+func TestManagementWithSessionManager(t *testing.T) {
+	ctrl := zititest.NewController()
+	defer ctrl.Close()
+
+	m := NewSessionManager(SessionManagerOptions{
+		CtrlUrl: ctrl.URL,
+		Authenticator: &UpdbAuthenticator{
+			CtrlUrl:  ctrl.URL,
+			Username: zititest.Username,
+			Password: zititest.Password,
+		},
+	})
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	client := NewManagementClient(ctrl.URL, m, nil)
+	if _, err := client.CreateIdentity(context.Background(), IdentityCreate{Name: "device1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyIdentityFunctions(t *testing.T) {
+	ctrl := zititest.NewController()
+	defer ctrl.Close()
+
+	t.Setenv("ZITI_CTRL_URL", ctrl.URL)
+	t.Setenv("ZITI_SERVICE_DMZ", "dmz")
+	oldToken := SessionToken
+	SessionToken = ctrl.Login()
+	defer func() { SessionToken = oldToken }()
+
+	id, jwt, err := CreateIdentity("device1", RoleEnroller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt == "" {
+		t.Fatal("expected enrollment jwt")
+	}
+
+	if err = UpdateIdentity(id, "admin1", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	iden, err := GetIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !iden.Data.IsAdmin || iden.Data.Name != "admin1" {
+		t.Fatalf("unexpected identity %+v", iden.Data)
+	}
+
+	if err = DeleteIdentity(id); err != nil {
+		t.Fatal(err)
+	}
+	if err = DeleteIdentity(id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package openziti
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RolePolicy maps roles to the role attributes of their identities.
+// Loaded from yaml or json, e.g.
+//
+//	roles:
+//	  device:
+//	    attributes: ["${ZITI_SERVICE_LIVEKIT}.dial", "${ZITI_SERVICE_TURN}.dial"]
+//	  recorder:
+//	    inherits: [device]
+//	    attributes: [recorder]
+//
+// ${VAR} in attributes is replaced by the environment variable VAR.
+type RolePolicy struct {
+	Roles map[string]RoleSpec `yaml:"roles" json:"roles"`
+}
+
+type RoleSpec struct {
+	// Roles whose attributes are included
+	Inherits   []string `yaml:"inherits" json:"inherits"`
+	Attributes []string `yaml:"attributes" json:"attributes"`
+	// Identities of the role are ziti admins
+	IsAdmin bool `yaml:"isAdmin" json:"isAdmin"`
+}
+
+// Policy matching the roles that were built in before policy files
+func DefaultRolePolicy() *RolePolicy {
+	return &RolePolicy{Roles: map[string]RoleSpec{
+		RoleAdmin: {
+			IsAdmin: true,
+			Attributes: []string{
+				"${ZITI_SERVICE_API}.dial",
+				"${ZITI_SERVICE_FRONTEND}.dial",
+				"${ZITI_SERVICE_LIVEKIT_RTC}.dial",
+				"${ZITI_SERVICE_LIVEKIT}.dial",
+				"${ZITI_SERVICE_TURN}.dial",
+				"${ZITI_SERVICE_ZAC}.dial",
+			},
+		},
+		RoleEnroller: {
+			Attributes: []string{"${ZITI_SERVICE_DMZ}.dial"},
+		},
+		RoleDevicePendingEnroll: {
+			Attributes: []string{"${ZITI_SERVICE_DMZ}.dial"},
+		},
+		RoleDevice: {
+			Attributes: []string{
+				"${ZITI_SERVICE_LIVEKIT_RTC}.dial",
+				"${ZITI_SERVICE_LIVEKIT}.dial",
+				"${ZITI_SERVICE_NATS}.dial",
+				"${ZITI_SERVICE_TURN}.dial",
+			},
+		},
+		RoleInactive: {},
+	}}
+}
+
+var rolePolicy atomic.Pointer[RolePolicy]
+
+// Policy used by GetRoleAttributes, CreateIdentity and UpdateIdentity
+func CurrentRolePolicy() *RolePolicy {
+	if p := rolePolicy.Load(); p != nil {
+		return p
+	}
+	return DefaultRolePolicy()
+}
+
+func SetRolePolicy(p *RolePolicy) {
+	rolePolicy.Store(p)
+}
+
+// Reads and validates a yaml or json policy file
+func LoadRolePolicy(path string) (*RolePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseRolePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("role policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parses and validates a yaml or json policy
+func ParseRolePolicy(data []byte) (*RolePolicy, error) {
+	p := &RolePolicy{}
+	// json is valid yaml
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Checks for unknown or cyclic inherited roles, undefined variables and
+// malformed attributes. Returns all problems joined.
+func (p *RolePolicy) Validate() error {
+	var errs []error
+	if len(p.Roles) == 0 {
+		errs = append(errs, errors.New("no roles defined"))
+	}
+
+	for _, name := range p.roleNames() {
+		spec := p.Roles[name]
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("empty role name"))
+		}
+		for _, parent := range spec.Inherits {
+			if _, ok := p.Roles[parent]; !ok {
+				errs = append(errs, fmt.Errorf("role %s inherits unknown role %s", name, parent))
+			}
+		}
+		for _, attr := range spec.Attributes {
+			if err := validateAttribute(attr); err != nil {
+				errs = append(errs, fmt.Errorf("role %s: %w", name, err))
+			}
+		}
+		if cycle := p.findCycle(name, nil); cycle != nil {
+			errs = append(errs, fmt.Errorf("role inheritance cycle: %s", strings.Join(cycle, " -> ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateAttribute(attr string) error {
+	var missing []string
+	expanded := os.Expand(attr, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("attribute %q uses undefined variables %s", attr, strings.Join(missing, ", "))
+	case expanded == "":
+		return fmt.Errorf("attribute %q is empty", attr)
+	case strings.ContainsAny(expanded, " \t\n"):
+		return fmt.Errorf("attribute %q contains whitespace", attr)
+	case strings.HasPrefix(expanded, "#") || strings.HasPrefix(expanded, "@"):
+		// #attr and @id are role selectors in policies, not attributes
+		return fmt.Errorf("attribute %q must not start with # or @", attr)
+	}
+	return nil
+}
+
+// Returns the path of an inheritance cycle starting at role, nil if none
+func (p *RolePolicy) findCycle(role string, path []string) []string {
+	for i, r := range path {
+		if r == role {
+			return append(path[i:], role)
+		}
+	}
+	path = append(path, role)
+	for _, parent := range p.Roles[role].Inherits {
+		if cycle := p.findCycle(parent, path); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (p *RolePolicy) roleNames() []string {
+	names := make([]string, 0, len(p.Roles))
+	for name := range p.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sorted attributes of role including inherited ones
+func (p *RolePolicy) Attributes(role string) ([]string, error) {
+	if _, ok := p.Roles[role]; !ok {
+		return nil, errors.New("invalid role")
+	}
+
+	set := map[string]struct{}{}
+	if err := p.collect(role, set, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return sortedKeys(set), nil
+}
+
+func (p *RolePolicy) collect(role string, set map[string]struct{}, visiting map[string]bool) error {
+	spec, ok := p.Roles[role]
+	if !ok {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	if visiting[role] {
+		return fmt.Errorf("role inheritance cycle at %s", role)
+	}
+	visiting[role] = true
+	defer delete(visiting, role)
+
+	for _, parent := range spec.Inherits {
+		if err := p.collect(parent, set, visiting); err != nil {
+			return err
+		}
+	}
+	for _, attr := range spec.Attributes {
+		set[os.ExpandEnv(attr)] = struct{}{}
+	}
+	return nil
+}
+
+// Whether identities of role are admins, directly or through inheritance
+func (p *RolePolicy) IsAdmin(role string) bool {
+	return p.isAdmin(role, map[string]bool{})
+}
+
+func (p *RolePolicy) isAdmin(role string, seen map[string]bool) bool {
+	if seen[role] {
+		return false
+	}
+	seen[role] = true
+
+	spec := p.Roles[role]
+	if spec.IsAdmin {
+		return true
+	}
+	for _, parent := range spec.Inherits {
+		if p.isAdmin(parent, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// Every attribute some role of the policy grants
+func (p *RolePolicy) ManagedAttributes() map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, spec := range p.Roles {
+		for _, attr := range spec.Attributes {
+			set[os.ExpandEnv(attr)] = struct{}{}
+		}
+	}
+	return set
+}
+
+// Attributes in desired but not in current and the other way round
+func DiffAttributes(current []string, desired []string) (added []string, removed []string) {
+	currentSet := toSet(current)
+	desiredSet := toSet(desired)
+	for _, attr := range sortedKeys(desiredSet) {
+		if _, ok := currentSet[attr]; !ok {
+			added = append(added, attr)
+		}
+	}
+	for _, attr := range sortedKeys(currentSet) {
+		if _, ok := desiredSet[attr]; !ok {
+			removed = append(removed, attr)
+		}
+	}
+	return added, removed
+}
+
+// RoleReconciler brings the role attributes of identities in line with a RolePolicy
+type RoleReconciler struct {
+	Client *ManagementClient
+	Policy *RolePolicy
+	// Keep attributes no role of the policy grants, e.g. ones set by hand in ZAC
+	KeepUnmanaged bool
+}
+
+type ReconcileResult struct {
+	IdentityID string
+	Added      []string
+	Removed    []string
+	// UpdateIdentity was called
+	Updated bool
+}
+
+// Updates the identity only if its attributes or admin flag differ from role
+func (r *RoleReconciler) Reconcile(ctx context.Context, identityID string, role string) (ReconcileResult, error) {
+	result := ReconcileResult{IdentityID: identityID}
+
+	desired, err := r.Policy.Attributes(role)
+	if err != nil {
+		return result, err
+	}
+	iden, err := r.Client.GetIdentity(ctx, identityID)
+	if err != nil {
+		return result, err
+	}
+
+	if r.KeepUnmanaged {
+		managed := r.Policy.ManagedAttributes()
+		for _, attr := range iden.RoleAttributes {
+			if _, ok := managed[attr]; !ok {
+				desired = append(desired, attr)
+			}
+		}
+		desired = sortedKeys(toSet(desired))
+	}
+
+	result.Added, result.Removed = DiffAttributes(iden.RoleAttributes, desired)
+	isAdmin := r.Policy.IsAdmin(role)
+	if len(result.Added) == 0 && len(result.Removed) == 0 && iden.IsAdmin == isAdmin {
+		return result, nil
+	}
+
+	update := IdentityUpdate{RoleAttributes: &desired}
+	if iden.IsAdmin != isAdmin {
+		update.IsAdmin = &isAdmin
+	}
+	if err = r.Client.UpdateIdentity(ctx, identityID, update); err != nil {
+		return result, err
+	}
+	result.Updated = true
+	return result, nil
+}
+
+func toSet(items []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		set[item] = struct{}{}
+	}
+	return set
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
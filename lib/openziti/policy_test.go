@@ -0,0 +1,217 @@
+package openziti
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+roles:
+  device:
+    attributes: ["${TEST_SERVICE_LIVEKIT}.dial", "turn.dial"]
+  observer:
+    inherits: [device]
+    attributes: [observer]
+  recorder:
+    inherits: [observer]
+    attributes: [recorder, turn.dial]
+  operator:
+    inherits: [recorder]
+    isAdmin: true
+`
+
+func TestParseRolePolicy(t *testing.T) {
+	t.Setenv("TEST_SERVICE_LIVEKIT", "livekit")
+
+	p, err := ParseRolePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := p.Attributes("recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"livekit.dial", "observer", "recorder", "turn.dial"}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Fatalf("got %v, want %v", attrs, want)
+	}
+
+	if p.IsAdmin("recorder") || !p.IsAdmin("operator") {
+		t.Fatal("only operator should be admin")
+	}
+	if _, err = p.Attributes("sip-gateway"); err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+}
+
+func TestParseRolePolicyJSON(t *testing.T) {
+	p, err := ParseRolePolicy([]byte(`{"roles": {"sip-gateway": {"attributes": ["sip.dial"]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := p.Attributes("sip-gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, []string{"sip.dial"}) {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+}
+
+func TestRolePolicyValidate(t *testing.T) {
+	_, err := ParseRolePolicy([]byte(`
+roles:
+  a:
+    inherits: [b]
+  b:
+    inherits: [a]
+  c:
+    inherits: [missing]
+    attributes: ["${TEST_UNDEFINED_VAR}.dial", "#all", "two words", ""]
+`))
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{
+		"inheritance cycle",
+		"unknown role missing",
+		"undefined variables TEST_UNDEFINED_VAR",
+		"must not start with #",
+		"contains whitespace",
+		"is empty",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	if _, err = ParseRolePolicy([]byte(`roles: {}`)); err == nil {
+		t.Fatal("expected error for empty policy")
+	}
+}
+
+func TestLoadRolePolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.yaml")
+	if err := os.WriteFile(path, []byte(`roles: {observer: {attributes: [observer]}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadRolePolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetRolePolicy(p)
+	defer SetRolePolicy(nil)
+	attrs, err := GetRoleAttributes("observer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, []string{"observer"}) {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	if _, err = GetRoleAttributes(RoleDevice); err == nil {
+		t.Fatal("expected error for role missing from the policy")
+	}
+}
+
+func TestDefaultRolePolicy(t *testing.T) {
+	t.Setenv("ZITI_SERVICE_DMZ", "dmz")
+
+	attrs, err := GetRoleAttributes(RoleEnroller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, []string{"dmz.dial"}) {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	if attrs, err = GetRoleAttributes(RoleInactive); err != nil || len(attrs) != 0 {
+		t.Fatalf("unexpected inactive attributes %v %v", attrs, err)
+	}
+	if !CurrentRolePolicy().IsAdmin(RoleAdmin) || CurrentRolePolicy().IsAdmin(RoleDevice) {
+		t.Fatal("only admin should be admin")
+	}
+}
+
+func TestDiffAttributes(t *testing.T) {
+	added, removed := DiffAttributes([]string{"a", "b", "b"}, []string{"c", "b"})
+	if !reflect.DeepEqual(added, []string{"c"}) || !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Fatalf("unexpected diff +%v -%v", added, removed)
+	}
+	added, removed = DiffAttributes([]string{"b", "a"}, []string{"a", "b"})
+	if added != nil || removed != nil {
+		t.Fatalf("expected no diff, got +%v -%v", added, removed)
+	}
+}
+
+func TestRoleReconciler(t *testing.T) {
+	t.Setenv("TEST_SERVICE_LIVEKIT", "livekit")
+	p, err := ParseRolePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, ctrl := newTestManagementClient(t)
+	ctx := context.Background()
+
+	id := ctrl.Put("identities", map[string]interface{}{
+		"name":           "rec1",
+		"isAdmin":        false,
+		"roleAttributes": []string{"livekit.dial", "turn.dial", "manual"},
+	})
+	patches := func() int {
+		n := 0
+		for _, req := range ctrl.Requests() {
+			if strings.HasPrefix(req, "PATCH ") {
+				n++
+			}
+		}
+		return n
+	}
+
+	r := &RoleReconciler{Client: client, Policy: p, KeepUnmanaged: true}
+	result, err := r.Reconcile(ctx, id, "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated || !reflect.DeepEqual(result.Added, []string{"observer", "recorder"}) || result.Removed != nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// nothing changed, no second update
+	result, err = r.Reconcile(ctx, id, "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated || patches() != 1 {
+		t.Fatalf("expected no update, got %+v after %d patches", result, patches())
+	}
+
+	r.KeepUnmanaged = false
+	result, err = r.Reconcile(ctx, id, "operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated || !reflect.DeepEqual(result.Removed, []string{"manual"}) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	iden, err := client.GetIdentity(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !iden.IsAdmin || !reflect.DeepEqual(iden.RoleAttributes, []string{"livekit.dial", "observer", "recorder", "turn.dial"}) {
+		t.Fatalf("unexpected identity %+v", iden)
+	}
+
+	// admin flag alone triggers an update
+	result, err = r.Reconcile(ctx, id, "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Updated || result.Added != nil || result.Removed != nil {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,462 @@
+package openziti
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openziti/identity"
+	"github.com/openziti/sdk-golang/ziti"
+)
+
+// Certificate of an identity json
+func IdentityCertificate(identityJSON []byte) (*x509.Certificate, error) {
+	_, id, err := loadIdentityJSON(identityJSON)
+	if err != nil {
+		return nil, err
+	}
+	return leafCertificate(id.Cert())
+}
+
+func loadIdentityJSON(identityJSON []byte) (*ziti.Config, identity.Identity, error) {
+	cfg := &ziti.Config{}
+	if err := json.Unmarshal(identityJSON, cfg); err != nil {
+		return nil, nil, err
+	}
+	id, err := identity.LoadIdentity(cfg.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, id, nil
+}
+
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, errors.New("identity has no certificate")
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+type CertRenewerOptions struct {
+	// Identity to renew, it's rewritten in Store after every renewal.
+	// Renewals continue from the last renewed identity, also when Store is
+	// read only or saving it failed
+	Name  string
+	Store IdentityStore
+	// Runtime whose ziti context is swapped for one with the new certificate, optional
+	Runtime *Runtime
+	// Renew when less than this is left before the certificate expires,
+	// default a third of the certificate lifetime
+	RenewBefore time.Duration
+	// How often the certificate is checked, default 1 hour
+	CheckInterval time.Duration
+	// Wait before retrying a failed renewal, default 1 minute
+	RetryInterval time.Duration
+	// How long the old ziti context keeps serving open connections after a
+	// swap, default until its certificate expires
+	Drain time.Duration
+	// Called with the new identity json after each renewal
+	OnRenew func(identityJSON []byte)
+}
+
+// CertRenewer extends the identity certificate with the controller before it
+// expires, rewrites the identity and hot-swaps the runtime's ziti context.
+type CertRenewer struct {
+	opts CertRenewerOptions
+
+	mu       sync.Mutex
+	notAfter time.Time
+	// last renewed identity json, the store may be read only or failed to save it
+	renewed []byte
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewCertRenewer(opts CertRenewerOptions) *CertRenewer {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = time.Hour
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Minute
+	}
+	return &CertRenewer{opts: opts}
+}
+
+// Checks the identity certificate can be read and starts checking it in
+// background, renewing right away if due, until ctx is done or Close is called
+func (r *CertRenewer) Start(ctx context.Context) error {
+	identityJSON, err := r.identity()
+	if err != nil {
+		return err
+	}
+	cert, err := IdentityCertificate(identityJSON)
+	if err != nil {
+		return err
+	}
+	r.setNotAfter(cert.NotAfter)
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	go r.run(ctx)
+	return nil
+}
+
+func (r *CertRenewer) Close() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Expiry of the current certificate
+func (r *CertRenewer) NotAfter() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.notAfter
+}
+
+func (r *CertRenewer) run(ctx context.Context) {
+	defer close(r.done)
+
+	var wait time.Duration
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		wait = r.opts.CheckInterval
+		if _, err := r.RenewIfDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Renewing identity %s failed, retry in %s: %v", r.opts.Name, r.opts.RetryInterval, err)
+			wait = r.opts.RetryInterval
+		}
+	}
+}
+
+// Renews the certificate if it's within RenewBefore of expiring.
+// return whether it was renewed
+func (r *CertRenewer) RenewIfDue(ctx context.Context) (bool, error) {
+	identityJSON, err := r.identity()
+	if err != nil {
+		return false, err
+	}
+	cert, err := IdentityCertificate(identityJSON)
+	if err != nil {
+		return false, err
+	}
+	r.setNotAfter(cert.NotAfter)
+
+	renewBefore := r.opts.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = cert.NotAfter.Sub(cert.NotBefore) / 3
+	}
+	if time.Until(cert.NotAfter) > renewBefore {
+		return false, nil
+	}
+
+	log.Printf("Identity %s certificate expires %s, renewing", r.opts.Name, cert.NotAfter.Format(time.RFC3339))
+	return true, r.renew(ctx, identityJSON, cert)
+}
+
+// Renews the certificate regardless of its expiry
+func (r *CertRenewer) RenewNow(ctx context.Context) error {
+	identityJSON, err := r.identity()
+	if err != nil {
+		return err
+	}
+	cert, err := IdentityCertificate(identityJSON)
+	if err != nil {
+		return err
+	}
+	return r.renew(ctx, identityJSON, cert)
+}
+
+func (r *CertRenewer) renew(ctx context.Context, identityJSON []byte, oldCert *x509.Certificate) error {
+	newJSON, err := ExtendIdentityCertificate(ctx, identityJSON)
+	if err != nil {
+		return err
+	}
+	newCert, err := IdentityCertificate(newJSON)
+	if err != nil {
+		return err
+	}
+
+	err = r.opts.Store.Save(r.opts.Name, newJSON)
+	switch {
+	case errors.Is(err, ErrStoreReadOnly):
+		log.Printf("Identity %s renewed in memory only, the store is read only", r.opts.Name)
+	case err != nil:
+		// the controller already switched to the new certificate, keep using it
+		log.Printf("Saving renewed identity %s failed: %v", r.opts.Name, err)
+	}
+	r.mu.Lock()
+	r.renewed = newJSON
+	r.notAfter = newCert.NotAfter
+	r.mu.Unlock()
+
+	if r.opts.Runtime != nil {
+		zctx, err := newZitiContextFromJSON(newJSON)
+		if err != nil {
+			return err
+		}
+		drain := r.opts.Drain
+		if drain <= 0 {
+			drain = time.Until(oldCert.NotAfter)
+		}
+		r.opts.Runtime.SwapContext(zctx, drain)
+	}
+
+	if r.opts.OnRenew != nil {
+		r.opts.OnRenew(newJSON)
+	}
+	log.Printf("Identity %s renewed, certificate expires %s", r.opts.Name, newCert.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// Current identity json, the last renewed one or else the one in the store
+func (r *CertRenewer) identity() ([]byte, error) {
+	r.mu.Lock()
+	renewed := r.renewed
+	r.mu.Unlock()
+	if renewed != nil {
+		return renewed, nil
+	}
+	return r.opts.Store.Load(r.opts.Name)
+}
+
+func (r *CertRenewer) setNotAfter(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notAfter = t
+}
+
+type clientAuthenticator struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+}
+
+// Asks the controller for a new certificate for the identity and returns the
+// identity json with it. A new key of the same type is generated, keys that
+// can't be generated here(engine keys) are kept.
+func ExtendIdentityCertificate(ctx context.Context, identityJSON []byte) ([]byte, error) {
+	cfg, id, err := loadIdentityJSON(identityJSON)
+	if err != nil {
+		return nil, err
+	}
+	oldCert, err := leafCertificate(id.Cert())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &clientAPI{
+		base:   strings.TrimSuffix(cfg.ZtAPI, "/"),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: id.ClientTLSConfig()}, Timeout: 30 * time.Second},
+	}
+	if err = c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	defer c.logout()
+
+	var authenticators []clientAuthenticator
+	if err = c.do(ctx, http.MethodGet, "/current-identity/authenticators", nil, &authenticators); err != nil {
+		return nil, err
+	}
+	authID := ""
+	for _, a := range authenticators {
+		if a.Method == "cert" {
+			authID = a.ID
+			break
+		}
+	}
+	if authID == "" {
+		return nil, errors.New("identity has no certificate authenticator")
+	}
+
+	signer, keyPem, err := renewalKey(id.Cert().PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: oldCert.Subject}, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	extended := struct {
+		ClientCert string `json:"clientCert"`
+		CA         string `json:"ca"`
+	}{}
+	authPath := "/current-identity/authenticators/" + url.PathEscape(authID)
+	err = c.do(ctx, http.MethodPost, authPath+"/extend", map[string]string{
+		"clientCertCsr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	}, &extended)
+	if err != nil {
+		return nil, err
+	}
+	if extended.ClientCert == "" {
+		return nil, errors.New("controller returned no certificate")
+	}
+	err = c.do(ctx, http.MethodPost, authPath+"/extend-verify", map[string]string{
+		"clientCert": extended.ClientCert,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep everything else in the identity as is
+	var raw map[string]interface{}
+	if err = json.Unmarshal(identityJSON, &raw); err != nil {
+		return nil, err
+	}
+	idSection, ok := raw["id"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("identity has no id section")
+	}
+	if keyPem != "" {
+		idSection["key"] = "pem:" + keyPem
+	}
+	idSection["cert"] = "pem:" + extended.ClientCert
+	if extended.CA != "" {
+		idSection["ca"] = "pem:" + extended.CA
+	}
+
+	output := new(bytes.Buffer)
+	enc := json.NewEncoder(output)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(raw); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// New key of the same type and size as key, or key itself with an empty pem
+// if it can't be generated here
+func renewalKey(key crypto.PrivateKey) (crypto.Signer, string, error) {
+	var (
+		newKey crypto.Signer
+		block  *pem.Block
+	)
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		ecKey, err := ecdsa.GenerateKey(k.Curve, rand.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, "", err
+		}
+		newKey, block = ecKey, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case *rsa.PrivateKey:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, k.N.BitLen())
+		if err != nil {
+			return nil, "", err
+		}
+		newKey, block = rsaKey, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	case crypto.Signer:
+		return k, "", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported identity key %T", key)
+	}
+	return newKey, string(pem.EncodeToMemory(block)), nil
+}
+
+// Minimal edge client api session authenticated with the identity certificate
+type clientAPI struct {
+	base   string
+	client *http.Client
+	token  string
+}
+
+func (c *clientAPI) authenticate(ctx context.Context) error {
+	session := struct {
+		Token string `json:"token"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "/authenticate?method=cert", map[string]interface{}{}, &session); err != nil {
+		return err
+	}
+	if session.Token == "" {
+		return ErrSessionUnauthorized
+	}
+	c.token = session.Token
+	return nil
+}
+
+func (c *clientAPI) logout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.do(ctx, http.MethodDelete, "/current-api-session", nil, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+func (c *clientAPI) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		jsonValue, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(jsonValue)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Add("zt-session", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{}
+		_ = json.Unmarshal(respBody, &errorEnvelope{Error: apiErr})
+		apiErr.StatusCode = resp.StatusCode
+		apiErr.Method = method
+		apiErr.Path = path
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	env := dataEnvelope[json.RawMessage]{}
+	if err = json.Unmarshal(respBody, &env); err != nil {
+		return err
+	}
+	return json.Unmarshal(env.Data, out)
+}
@@ -0,0 +1,381 @@
+package openziti
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/sdk-golang/ziti"
+)
+
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{key: key, cert: cert}
+}
+
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, pub interface{}, notBefore, notAfter time.Time) string {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, pub, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// fakeClientAPI stands in for the edge client api certificate extension
+type fakeClientAPI struct {
+	*httptest.Server
+	ca *testCA
+
+	mu       sync.Mutex
+	issued   string
+	verified bool
+	logouts  int
+	// certificate the last authentication was made with
+	authCert *x509.Certificate
+}
+
+func newFakeClientAPI(t *testing.T, ca *testCA) *fakeClientAPI {
+	f := &fakeClientAPI{ca: ca}
+	mux := http.NewServeMux()
+	write := func(w http.ResponseWriter, data interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("zt-session") != "client-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("/edge/client/v1/authenticate", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("method") != "cert" || len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		f.authCert = r.TLS.PeerCertificates[0]
+		f.mu.Unlock()
+		write(w, map[string]string{"token": "client-token"})
+	})
+	mux.HandleFunc("/edge/client/v1/current-identity/authenticators", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			write(w, []map[string]string{{"id": "updb1", "method": "updb"}, {"id": "cert1", "method": "cert"}})
+		}
+	})
+	mux.HandleFunc("/edge/client/v1/current-identity/authenticators/cert1/extend", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		block, _ := pem.Decode([]byte(body["clientCertCsr"]))
+		if block == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.issued = f.ca.issue(t, csr.Subject, csr.PublicKey, time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+		issued := f.issued
+		f.mu.Unlock()
+		write(w, map[string]string{"clientCert": issued})
+	})
+	mux.HandleFunc("/edge/client/v1/current-identity/authenticators/cert1/extend-verify", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if body["clientCert"] != f.issued {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.verified = true
+		write(w, map[string]string{})
+	})
+	mux.HandleFunc("/edge/client/v1/current-api-session", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) && r.Method == http.MethodDelete {
+			f.mu.Lock()
+			f.logouts++
+			f.mu.Unlock()
+			write(w, map[string]string{})
+		}
+	})
+
+	f.Server = httptest.NewUnstartedServer(mux)
+	f.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	f.StartTLS()
+	return f
+}
+
+// Identity json with a P-256 key and a certificate valid for lifetime, expiring in left
+func (f *fakeClientAPI) identity(t *testing.T, lifetime, left time.Duration) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	notAfter := time.Now().Add(left)
+	cert := f.ca.issue(t, pkix.Name{CommonName: "publisher"}, &key.PublicKey, notAfter.Add(-lifetime), notAfter)
+
+	identityJSON, err := json.Marshal(map[string]interface{}{
+		"ztAPI": f.URL + "/edge/client/v1",
+		"id": map[string]string{
+			"key":  "pem:" + string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+			"cert": "pem:" + cert,
+			"ca":   "pem:" + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw})),
+		},
+		"configTypes": []string{"intercept.v1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identityJSON
+}
+
+func TestExtendIdentityCertificate(t *testing.T) {
+	api := newFakeClientAPI(t, newTestCA(t))
+	defer api.Close()
+
+	oldJSON := api.identity(t, time.Hour, 10*time.Minute)
+	newJSON, err := ExtendIdentityCertificate(context.Background(), oldJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !api.verified || api.logouts != 1 {
+		t.Fatalf("expected verified extension and logout, got %v %d", api.verified, api.logouts)
+	}
+
+	oldCert, _ := IdentityCertificate(oldJSON)
+	newCert, err := IdentityCertificate(newJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newCert.NotAfter.After(oldCert.NotAfter) || newCert.Subject.CommonName != "publisher" {
+		t.Fatalf("unexpected certificate %s %s", newCert.Subject, newCert.NotAfter)
+	}
+
+	oldCfg, newCfg := ziti.Config{}, ziti.Config{}
+	_ = json.Unmarshal(oldJSON, &oldCfg)
+	_ = json.Unmarshal(newJSON, &newCfg)
+	if newCfg.ID.Key == oldCfg.ID.Key || newCfg.ID.CA != oldCfg.ID.CA || newCfg.ZtAPI != oldCfg.ZtAPI {
+		t.Fatal("expected a new key and the same ca and controller")
+	}
+	if len(newCfg.ConfigTypes) != 1 {
+		t.Fatalf("other identity fields were dropped: %s", newJSON)
+	}
+}
+
+func TestCertRenewer(t *testing.T) {
+	api := newFakeClientAPI(t, newTestCA(t))
+	defer api.Close()
+
+	store := NewMemoryIdentityStore()
+	if err := store.Save("publisher", api.identity(t, time.Hour, 50*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	var renewed atomic.Int32
+	r := NewCertRenewer(CertRenewerOptions{
+		Name:          "publisher",
+		Store:         store,
+		CheckInterval: 10 * time.Millisecond,
+		OnRenew:       func([]byte) { renewed.Add(1) },
+	})
+
+	// 50 of 60 minutes left, not due yet
+	done, err := r.RenewIfDue(context.Background())
+	if err != nil || done {
+		t.Fatalf("unexpected renewal %v %v", done, err)
+	}
+
+	if err = store.Save("publisher", api.identity(t, time.Hour, 10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	waitFor(t, "renewal", func() bool {
+		return renewed.Load() == 1
+	})
+	if time.Until(r.NotAfter()) < 50*time.Minute {
+		t.Fatalf("unexpected expiry %s", r.NotAfter())
+	}
+
+	// the renewed certificate isn't due, background checks leave it alone
+	time.Sleep(50 * time.Millisecond)
+	if renewed.Load() != 1 {
+		t.Fatalf("expected 1 renewal, got %d", renewed.Load())
+	}
+
+	identityJSON, err := store.Load("publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := IdentityCertificate(identityJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.NotAfter.Equal(r.NotAfter()) {
+		t.Fatalf("store has certificate expiring %s, renewer %s", cert.NotAfter, r.NotAfter())
+	}
+}
+
+func TestCertRenewerReadOnlyStore(t *testing.T) {
+	api := newFakeClientAPI(t, newTestCA(t))
+	defer api.Close()
+
+	store := EnvIdentityStore{Prefix: "TEST_IDENTITY_"}
+	t.Setenv("TEST_IDENTITY_PUBLISHER", string(api.identity(t, time.Hour, 10*time.Minute)))
+
+	var renewals [][]byte
+	r := NewCertRenewer(CertRenewerOptions{
+		Name:    "publisher",
+		Store:   store,
+		OnRenew: func(identityJSON []byte) { renewals = append(renewals, identityJSON) },
+	})
+
+	done, err := r.RenewIfDue(context.Background())
+	if err != nil || !done {
+		t.Fatalf("expected renewal, got %v %v", done, err)
+	}
+	first, err := IdentityCertificate(renewals[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store still has the old certificate, the renewed one isn't due
+	done, err = r.RenewIfDue(context.Background())
+	if err != nil || done {
+		t.Fatalf("unexpected renewal %v %v", done, err)
+	}
+	if !r.NotAfter().Equal(first.NotAfter) {
+		t.Fatalf("renewer went back to certificate expiring %s", r.NotAfter())
+	}
+
+	// the next renewal authenticates with the renewed certificate
+	if err = r.RenewNow(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	api.mu.Lock()
+	authCert := api.authCert
+	api.mu.Unlock()
+	if !authCert.Equal(first) {
+		t.Fatalf("renewed with certificate expiring %s, not the renewed one", authCert.NotAfter)
+	}
+	if len(renewals) != 2 {
+		t.Fatalf("expected 2 renewals, got %d", len(renewals))
+	}
+	second, err := IdentityCertificate(renewals[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.NotAfter().Equal(second.NotAfter) {
+		t.Fatalf("renewer has certificate expiring %s, want %s", r.NotAfter(), second.NotAfter)
+	}
+}
+
+type fakeZitiContext struct {
+	ziti.Context
+	id     string
+	closed atomic.Bool
+}
+
+func (c *fakeZitiContext) GetId() string { return c.id }
+func (c *fakeZitiContext) Close()        { c.closed.Store(true) }
+
+func TestRuntimeSwapContext(t *testing.T) {
+	old := &fakeZitiContext{id: "old"}
+	r := NewRuntimeFromContext(old)
+
+	renewed := &fakeZitiContext{id: "renewed"}
+	r.SwapContext(renewed, 20*time.Millisecond)
+	if r.CurrentContext() != renewed || r.CurrentDialer().ZitiContext != renewed {
+		t.Fatal("expected the renewed context")
+	}
+	if old.closed.Load() {
+		t.Fatal("old context closed before draining")
+	}
+	waitFor(t, "old context closed", old.closed.Load)
+
+	// Close doesn't wait for draining contexts
+	last := &fakeZitiContext{id: "last"}
+	r.SwapContext(last, time.Hour)
+	r.Close()
+	if !renewed.closed.Load() || !last.closed.Load() {
+		t.Fatal("expected all contexts closed")
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	r := NewRuntimeFromContext(&fakeZitiContext{id: "default"})
+	SetDefault(r)
+	if Default() != r || ZitiContext == nil || ZitiClient != r.Client || websocket.ZitiTransport != r.Transport {
+		t.Fatal("expected the globals to mirror the default runtime")
+	}
+
+	r.Close()
+	SetDefault(nil)
+	if Default() != nil || ZitiContext != nil || ZitiClient != nil || websocket.ZitiTransport != nil {
+		t.Fatal("expected the globals cleared")
+	}
+	if _, err := DefaultClient.Do(&http.Request{}); !errors.Is(err, ErrNoRuntime) {
+		t.Fatalf("expected ErrNoRuntime, got %v", err)
+	}
+}
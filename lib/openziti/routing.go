@@ -0,0 +1,422 @@
+package openziti
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"gopkg.in/yaml.v3"
+)
+
+// Returned by Route and DialContext in strict mode for addresses no service matches
+var ErrNoService = errors.New("no ziti service for address")
+
+// ServiceMap maps addresses to ziti services, checked before the intercept
+// configs of the identity. Loaded from yaml or json, e.g.
+//
+//	routes:
+//	  - match: wss://livekit.example.com
+//	    service: livekit
+//	  - match: turn.example.com:3478
+//	    network: udp
+//	    service: turn
+//	    identity: turn-eu-1
+type ServiceMap struct {
+	Routes []ServiceRoute `yaml:"routes" json:"routes"`
+}
+
+type ServiceRoute struct {
+	// host:port, host(any port) or URL(port defaults to the scheme's)
+	Match string `yaml:"match" json:"match"`
+	// tcp or udp, empty for both
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+	Service string `yaml:"service" json:"service"`
+	// Hosting identity of the terminator to dial, empty for any
+	Identity string `yaml:"identity,omitempty" json:"identity,omitempty"`
+}
+
+// Default ports of URL schemes in route matches
+var schemePorts = map[string]string{
+	"http":  "80",
+	"ws":    "80",
+	"https": "443",
+	"wss":   "443",
+	"stun":  "3478",
+	"turn":  "3478",
+	"turns": "5349",
+	"nats":  "4222",
+}
+
+func LoadServiceMap(path string) (*ServiceMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseServiceMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("service map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parses and validates a yaml or json service map
+func ParseServiceMap(data []byte) (*ServiceMap, error) {
+	m := &ServiceMap{}
+	// json is valid yaml
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Checks for malformed matches, unknown networks and routes without a
+// service. Returns all problems joined.
+func (m *ServiceMap) Validate() error {
+	var errs []error
+	for i, route := range m.Routes {
+		if _, _, err := route.hostPort(); err != nil {
+			errs = append(errs, fmt.Errorf("route %d: %w", i, err))
+		}
+		if strings.TrimSpace(route.Service) == "" {
+			errs = append(errs, fmt.Errorf("route %d(%s): no service", i, route.Match))
+		}
+		switch route.Network {
+		case "", "tcp", "udp":
+		default:
+			errs = append(errs, fmt.Errorf("route %d(%s): unknown network %q", i, route.Match, route.Network))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the first route matching addr(host:port), host names compare case insensitive
+func (m *ServiceMap) Lookup(network, addr string) (ServiceRoute, bool) {
+	if m == nil {
+		return ServiceRoute{}, false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ServiceRoute{}, false
+	}
+	network = normalizeNetwork(network)
+
+	for _, route := range m.Routes {
+		if route.Network != "" && route.Network != network {
+			continue
+		}
+		matchHost, matchPort, err := route.hostPort()
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(matchHost, host) && (matchPort == "" || matchPort == port) {
+			return route, true
+		}
+	}
+	return ServiceRoute{}, false
+}
+
+// Identity of the route for addr if it goes to service, else of the first
+// route to service
+func (m *ServiceMap) identity(service, network, addr string) string {
+	if m == nil {
+		return ""
+	}
+	if route, ok := m.Lookup(network, addr); ok && route.Service == service {
+		return route.Identity
+	}
+	for _, route := range m.Routes {
+		if route.Service == service {
+			return route.Identity
+		}
+	}
+	return ""
+}
+
+// Host and port of the match, port is empty if any port matches
+func (route ServiceRoute) hostPort() (string, string, error) {
+	match := strings.TrimSpace(route.Match)
+	if match == "" {
+		return "", "", errors.New("empty match")
+	}
+
+	if strings.Contains(match, "://") {
+		u, err := url.Parse(match)
+		if err != nil {
+			return "", "", err
+		}
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("no host in %s", match)
+		}
+		port := u.Port()
+		if port == "" {
+			if port = schemePorts[u.Scheme]; port == "" {
+				return "", "", fmt.Errorf("no port in %s", match)
+			}
+		}
+		return u.Hostname(), port, nil
+	}
+
+	host, port, err := net.SplitHostPort(match)
+	if err != nil {
+		// host only, [::1] style without a port included
+		return strings.Trim(match, "[]"), "", nil
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("bad port in %s", match)
+	}
+	return host, port, nil
+}
+
+// Where a dial is sent
+type RouteSource string
+
+const (
+	RouteMapped      RouteSource = "mapped"
+	RouteIntercepted RouteSource = "intercepted"
+	RouteUnderlay    RouteSource = "underlay"
+	RouteRejected    RouteSource = "rejected"
+)
+
+// Route is the decision for one dial
+type Route struct {
+	Network string
+	Address string
+	Source  RouteSource
+	// Empty for the underlay
+	Service  string
+	Identity string
+	// Details of the service if the context knows it
+	Detail *rest_model.ServiceDetail
+}
+
+// RoutingOptions decide how the runtime dials addresses
+type RoutingOptions struct {
+	// Checked before intercept configs
+	Services *ServiceMap
+	// Addresses neither mapped nor intercepted fail with ErrNoService
+	// instead of going to the underlay
+	Strict bool
+	// Called with every decision, e.g. to export metrics
+	OnRoute func(Route)
+}
+
+func (r *Runtime) SetRouting(opts RoutingOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routing = opts
+}
+
+func (r *Runtime) Routing() RoutingOptions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.routing
+}
+
+// Number of routing decisions per source since the runtime was created
+func (r *Runtime) RouteCounts() map[RouteSource]uint64 {
+	return map[RouteSource]uint64{
+		RouteMapped:      r.routeCounts[0].Load(),
+		RouteIntercepted: r.routeCounts[1].Load(),
+		RouteUnderlay:    r.routeCounts[2].Load(),
+		RouteRejected:    r.routeCounts[3].Load(),
+	}
+}
+
+type routeCounters [4]atomic.Uint64
+
+func (c *routeCounters) add(source RouteSource) {
+	switch source {
+	case RouteMapped:
+		c[0].Add(1)
+	case RouteIntercepted:
+		c[1].Add(1)
+	case RouteUnderlay:
+		c[2].Add(1)
+	case RouteRejected:
+		c[3].Add(1)
+	}
+}
+
+// Decides how addr(host:port) is dialed: the service map first, then the
+// intercept configs of the current context, then the underlay unless the
+// runtime is strict. Every decision is logged and counted.
+func (r *Runtime) Route(network, addr string) (Route, error) {
+	opts := r.Routing()
+	route := Route{Network: network, Address: addr}
+
+	var err error
+	if mapped, ok := opts.Services.Lookup(network, addr); ok {
+		route.Source = RouteMapped
+		route.Service = mapped.Service
+		route.Identity = mapped.Identity
+		route.Detail, _ = r.CurrentContext().GetService(mapped.Service)
+	} else if svc, ok := r.ServiceForAddr(network, addr); ok {
+		route.Source = RouteIntercepted
+		route.Service = *svc.Name
+		route.Detail = svc
+	} else if opts.Strict {
+		route.Source = RouteRejected
+		err = fmt.Errorf("%w: %s %s", ErrNoService, network, addr)
+	} else {
+		route.Source = RouteUnderlay
+	}
+
+	r.reportRoute(opts, route)
+	return route, err
+}
+
+// Counts, logs and reports a decided route to OnRoute
+func (r *Runtime) reportRoute(opts RoutingOptions, route Route) {
+	r.routeCounts.add(route.Source)
+	if route.Service != "" {
+		log.Printf("ziti route %s %s: %s service %s identity %q", route.Network, route.Address, route.Source, route.Service, route.Identity)
+	} else {
+		log.Printf("ziti route %s %s: %s", route.Network, route.Address, route.Source)
+	}
+	if opts.OnRoute != nil {
+		opts.OnRoute(route)
+	}
+}
+
+// Dials a route decided by Route. Service routes are dialed on the current
+// context with the destination as app data, like intercepted dials of the sdk.
+func (r *Runtime) DialRoute(ctx context.Context, route Route) (net.Conn, error) {
+	if route.Service == "" {
+		if route.Source == RouteRejected {
+			return nil, fmt.Errorf("%w: %s %s", ErrNoService, route.Network, route.Address)
+		}
+		return (&net.Dialer{}).DialContext(ctx, route.Network, route.Address)
+	}
+
+	host, port, err := net.SplitHostPort(route.Address)
+	if err != nil {
+		return nil, err
+	}
+	appData := map[string]string{
+		"dst_protocol": normalizeNetwork(route.Network),
+		"dst_port":     port,
+	}
+	if net.ParseIP(host) != nil {
+		appData["dst_ip"] = host
+	} else {
+		appData["dst_hostname"] = host
+	}
+	appDataJSON, err := json.Marshal(appData)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &ziti.DialOptions{
+		ConnectTimeout: 5 * time.Second,
+		Identity:       route.Identity,
+		AppData:        appDataJSON,
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < options.ConnectTimeout {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		options.ConnectTimeout = time.Until(deadline)
+	}
+	conn, err := r.CurrentContext().DialWithOptions(route.Service, options)
+	if err != nil {
+		return nil, &DialError{Service: route.Service, Address: route.Address, Err: err}
+	}
+	return conn, nil
+}
+
+// Kinds of DialError, matched with errors.Is
+var (
+	ErrServiceNotFound = errors.New("ziti service not found")
+	ErrNoTerminators   = errors.New("ziti service has no terminators")
+	ErrNoEdgeRouters   = errors.New("no ziti edge routers available")
+	ErrSessionExpired  = errors.New("ziti session expired")
+)
+
+// DialError is a failed dial of a ziti service. The sdk reports most
+// failures as plain messages, Unwrap classifies them into the kinds above.
+type DialError struct {
+	Service string
+	Address string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("ziti dial %s(%s): %v", e.Service, e.Address, e.Err)
+}
+
+func (e *DialError) Unwrap() []error {
+	if kind := dialErrorKind(e.Err); kind != nil {
+		return []error{kind, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// Messages of the sdk(and the edge router for sessions) per kind. Service
+// names may contain any of the other words, so they are matched first.
+var (
+	serviceNotFoundMsg = regexp.MustCompile(`service '[^']*' not found|did not find service named `)
+	noTerminatorsMsg   = regexp.MustCompile(`has no terminators`)
+	noEdgeRoutersMsg   = regexp.MustCompile(`no edge routers (available|connected in time)`)
+	sessionExpiredMsg  = regexp.MustCompile(`no apiSession, authentication attempt failed|not authenticated to controller|(?i:invalid[ _]session)`)
+)
+
+func dialErrorKind(err error) error {
+	msg := err.Error()
+	switch {
+	case serviceNotFoundMsg.MatchString(msg):
+		return ErrServiceNotFound
+	case noTerminatorsMsg.MatchString(msg):
+		return ErrNoTerminators
+	case noEdgeRoutersMsg.MatchString(msg):
+		return ErrNoEdgeRouters
+	case sessionExpiredMsg.MatchString(msg):
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// Websocket dialer over the runtime, addresses are routed like DialContext.
+// With service set every address is dialed on that service instead, as the
+// identity the service map routes the address or else the service to.
+func (r *Runtime) WebsocketDialer(service string) *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.Ziti = &websocket.ZitiDialer{
+		DialContext: r.DialContext,
+		Service:     service,
+		DialService: func(ctx context.Context, service, network, addr string) (net.Conn, error) {
+			opts := r.Routing()
+			route := Route{Network: network, Address: addr, Source: RouteMapped, Service: service}
+			route.Identity = opts.Services.identity(service, network, addr)
+			route.Detail, _ = r.CurrentContext().GetService(service)
+			r.reportRoute(opts, route)
+			return r.DialRoute(ctx, route)
+		},
+	}
+	return &d
+}
+
+func normalizeNetwork(network string) string {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return "tcp"
+	case "udp", "udp4", "udp6":
+		return "udp"
+	}
+	return network
+}
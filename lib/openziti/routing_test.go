@@ -0,0 +1,312 @@
+package openziti
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+)
+
+var errNotIntercepted = errors.New("not intercepted")
+
+type dialRecord struct {
+	service string
+	options ziti.DialOptions
+}
+
+// fakeServiceContext intercepts addresses(host:port -> service) and answers
+// dials with a http server on a pipe
+type fakeServiceContext struct {
+	fakeZitiContext
+	intercepts map[string]string
+
+	mu     sync.Mutex
+	dialed []dialRecord
+}
+
+func (c *fakeServiceContext) GetServiceForAddr(network, host string, port uint16) (*rest_model.ServiceDetail, int, error) {
+	name, ok := c.intercepts[network+":"+net.JoinHostPort(host, strconv.Itoa(int(port)))]
+	if !ok {
+		return nil, -1, errNotIntercepted
+	}
+	return &rest_model.ServiceDetail{Name: &name}, 0, nil
+}
+
+func (c *fakeServiceContext) GetService(name string) (*rest_model.ServiceDetail, bool) {
+	return &rest_model.ServiceDetail{Name: &name, RoleAttributes: &rest_model.Attributes{"mapped"}}, true
+}
+
+func (c *fakeServiceContext) DialWithOptions(service string, options *ziti.DialOptions) (edge.Conn, error) {
+	c.mu.Lock()
+	c.dialed = append(c.dialed, dialRecord{service: service, options: *options})
+	c.mu.Unlock()
+
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		req, err := http.ReadRequest(bufio.NewReader(remote))
+		if err != nil {
+			return
+		}
+		resp := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1, Request: req, Close: true}
+		_ = resp.Write(remote)
+	}()
+	return pipeEdgeConn{pipe: local}, nil
+}
+
+func (c *fakeServiceContext) dials() []dialRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]dialRecord(nil), c.dialed...)
+}
+
+// pipeEdgeConn is an edge.Conn over a pipe, edge specific methods are not implemented
+type pipeEdgeConn struct {
+	edge.Conn
+	pipe net.Conn
+}
+
+func (c pipeEdgeConn) Read(b []byte) (int, error)         { return c.pipe.Read(b) }
+func (c pipeEdgeConn) Write(b []byte) (int, error)        { return c.pipe.Write(b) }
+func (c pipeEdgeConn) Close() error                       { return c.pipe.Close() }
+func (c pipeEdgeConn) LocalAddr() net.Addr                { return c.pipe.LocalAddr() }
+func (c pipeEdgeConn) RemoteAddr() net.Addr               { return c.pipe.RemoteAddr() }
+func (c pipeEdgeConn) SetDeadline(t time.Time) error      { return c.pipe.SetDeadline(t) }
+func (c pipeEdgeConn) SetReadDeadline(t time.Time) error  { return c.pipe.SetReadDeadline(t) }
+func (c pipeEdgeConn) SetWriteDeadline(t time.Time) error { return c.pipe.SetWriteDeadline(t) }
+
+func TestParseServiceMap(t *testing.T) {
+	m, err := ParseServiceMap([]byte(`
+routes:
+  - match: wss://livekit.example.com
+    service: livekit
+  - match: https://api.example.com:8443/twirp
+    service: api
+  - match: turn.example.com:3478
+    network: udp
+    service: turn-udp
+    identity: turn-eu-1
+  - match: TURN.example.com
+    service: turn-tcp
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := []struct {
+		network, addr, service string
+	}{
+		{"tcp", "livekit.example.com:443", "livekit"},
+		{"tcp", "livekit.example.com:80", ""},
+		{"tcp4", "api.example.com:8443", "api"},
+		{"udp4", "turn.example.com:3478", "turn-udp"},
+		{"tcp", "turn.example.com:3478", "turn-tcp"},
+		{"tcp", "turn.example.com:5349", "turn-tcp"},
+		{"tcp", "other.example.com:443", ""},
+		{"tcp", "no-port", ""},
+	}
+	for _, l := range lookups {
+		route, ok := m.Lookup(l.network, l.addr)
+		if ok != (l.service != "") || route.Service != l.service {
+			t.Errorf("%s %s: expected %q, got %q", l.network, l.addr, l.service, route.Service)
+		}
+	}
+	if route, _ := m.Lookup("udp", "turn.example.com:3478"); route.Identity != "turn-eu-1" {
+		t.Errorf("unexpected identity %q", route.Identity)
+	}
+
+	invalid := []string{
+		`{"routes": [{"match": "", "service": "a"}]}`,
+		`{"routes": [{"match": "a.example.com"}]}`,
+		`{"routes": [{"match": "a.example.com:99999", "service": "a"}]}`,
+		`{"routes": [{"match": "ftp://a.example.com", "service": "a"}]}`,
+		`{"routes": [{"match": "a.example.com", "network": "sctp", "service": "a"}]}`,
+	}
+	for _, data := range invalid {
+		if _, err = ParseServiceMap([]byte(data)); err == nil {
+			t.Errorf("%s: expected validation error", data)
+		}
+	}
+}
+
+func TestRuntimeRoute(t *testing.T) {
+	zctx := &fakeServiceContext{
+		fakeZitiContext: fakeZitiContext{id: "fake"},
+		intercepts:      map[string]string{"tcp:livekit.ziti:7880": "livekit"},
+	}
+	r := NewRuntimeFromContext(zctx)
+
+	var routes []Route
+	r.SetRouting(RoutingOptions{
+		Services: &ServiceMap{Routes: []ServiceRoute{
+			{Match: "http://api.example.com", Service: "api", Identity: "api-1"},
+		}},
+		OnRoute: func(route Route) { routes = append(routes, route) },
+	})
+
+	// the twirp client and websocket transport dial through DialContext
+	resp, err := r.Client.Get("http://api.example.com/twirp/livekit.RoomService/ListRooms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	conn, err := r.Transport.DialContext(context.Background(), "tcp", "livekit.ziti:7880")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	dials := zctx.dials()
+	if len(dials) != 2 || dials[0].service != "api" || dials[1].service != "livekit" {
+		t.Fatalf("unexpected dials %+v", dials)
+	}
+	if dials[0].options.Identity != "api-1" || dials[1].options.Identity != "" {
+		t.Fatalf("unexpected identities %q %q", dials[0].options.Identity, dials[1].options.Identity)
+	}
+	appData := map[string]string{}
+	if err = json.Unmarshal(dials[1].options.AppData, &appData); err != nil {
+		t.Fatal(err)
+	}
+	if appData["dst_protocol"] != "tcp" || appData["dst_port"] != "7880" || appData["dst_hostname"] != "livekit.ziti" {
+		t.Fatalf("unexpected app data %v", appData)
+	}
+	if len(routes) != 2 || routes[0].Source != RouteMapped || routes[1].Source != RouteIntercepted {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+	if routes[0].Detail == nil || *routes[0].Detail.Name != "api" {
+		t.Fatal("expected details of the mapped service")
+	}
+
+	// anything else goes to the underlay
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			_, _ = io.WriteString(c, "underlay")
+			_ = c.Close()
+		}
+	}()
+	conn, err = r.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(conn); string(data) != "underlay" {
+		t.Fatalf("unexpected underlay data %q", data)
+	}
+	_ = conn.Close()
+
+	// unless the runtime is strict
+	opts := r.Routing()
+	opts.Strict = true
+	r.SetRouting(opts)
+	if _, err = r.Dial("tcp", l.Addr().String()); !errors.Is(err, ErrNoService) {
+		t.Fatalf("expected ErrNoService, got %v", err)
+	}
+	if _, err = r.Client.Get("http://" + l.Addr().String()); !errors.Is(err, ErrNoService) {
+		t.Fatalf("expected ErrNoService from the client, got %v", err)
+	}
+	if len(zctx.dials()) != 2 {
+		t.Fatal("unexpected ziti dials")
+	}
+
+	counts := r.RouteCounts()
+	if counts[RouteMapped] != 1 || counts[RouteIntercepted] != 1 || counts[RouteUnderlay] != 1 || counts[RouteRejected] != 2 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
+
+func TestRuntimeWebsocketDialer(t *testing.T) {
+	zctx := &fakeServiceContext{fakeZitiContext: fakeZitiContext{id: "fake"}}
+	r := NewRuntimeFromContext(zctx)
+	var routes []Route
+	r.SetRouting(RoutingOptions{
+		Services: &ServiceMap{Routes: []ServiceRoute{
+			{Match: "livekit.internal:7880", Service: "livekit", Identity: "livekit-1"},
+		}},
+		OnRoute: func(route Route) { routes = append(routes, route) },
+	})
+
+	// the fake service answers 200 instead of switching protocols
+	_, resp, err := r.WebsocketDialer("livekit").Dial("ws://livekit.example.com:7880/rtc", nil)
+	if !errors.Is(err, websocket.ErrBadHandshake) || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected handshake %v", err)
+	}
+	dials := zctx.dials()
+	if len(dials) != 1 || dials[0].service != "livekit" || dials[0].options.Identity != "livekit-1" {
+		t.Fatalf("unexpected dials %+v", dials)
+	}
+	if len(routes) != 1 || routes[0].Source != RouteMapped || routes[0].Address != "livekit.example.com:7880" {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+
+	// without a service override the address is routed
+	r.SetRouting(RoutingOptions{Strict: true})
+	_, _, err = r.WebsocketDialer("").Dial("ws://unknown.example.com:7880/rtc", nil)
+	if !errors.Is(err, ErrNoService) || len(zctx.dials()) != 1 {
+		t.Fatalf("expected ErrNoService, got %v", err)
+	}
+}
+
+func TestDialRouteExpiredContext(t *testing.T) {
+	zctx := &fakeServiceContext{fakeZitiContext: fakeZitiContext{id: "fake"}}
+	r := NewRuntimeFromContext(zctx)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := r.DialRoute(ctx, Route{Network: "tcp", Address: "livekit.ziti:7880", Service: "livekit"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(zctx.dials()) != 0 {
+		t.Fatal("expected no dial with an expired context")
+	}
+}
+
+func TestDialErrorKinds(t *testing.T) {
+	kinds := []struct {
+		msg  string
+		kind error
+	}{
+		{"service 'livekit' not found", ErrServiceNotFound},
+		{"service 'livekit' not found in ziti network", ErrServiceNotFound},
+		{"did not find service named livekit", ErrServiceNotFound},
+		// names don't make it another kind
+		{"service 'session-expired' not found", ErrServiceNotFound},
+		{"service 'no-terminators' not found", ErrServiceNotFound},
+		{"service livekit has no terminators", ErrNoTerminators},
+		{"no edge routers available, refresh yielded no new edge routers", ErrNoEdgeRouters},
+		{"no edge routers connected in time", ErrNoEdgeRouters},
+		{"INVALID_SESSION", ErrSessionExpired},
+		{"no apiSession, authentication attempt failed: EOF", ErrSessionExpired},
+		{"not authenticated to controller", ErrSessionExpired},
+		{"session token not found in cache", nil},
+		{"connection refused", nil},
+	}
+	for _, k := range kinds {
+		cause := errors.New(k.msg)
+		err := error(&DialError{Service: "livekit", Address: "livekit.example.com:443", Err: cause})
+		if !errors.Is(err, cause) {
+			t.Errorf("%s: cause not wrapped", k.msg)
+		}
+		for _, kind := range []error{ErrServiceNotFound, ErrNoTerminators, ErrNoEdgeRouters, ErrSessionExpired} {
+			if errors.Is(err, kind) != (kind == k.kind) {
+				t.Errorf("%s: unexpected match of %v", k.msg, kind)
+			}
+		}
+	}
+}
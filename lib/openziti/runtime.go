@@ -0,0 +1,216 @@
+package openziti
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+)
+
+var ErrNoRuntime = errors.New("openziti runtime is not initialized")
+
+// Runtime owns a ziti context and everything built on top of it:
+// the http transport and client (twirp, livekit validate endpoint),
+// the dialer used for websockets and the packet dialer used by stdnet.
+// Several runtimes can live in one process, one per identity.
+type Runtime struct {
+	Contexts  *ziti.CtxCollection
+	Transport *http.Transport
+	Client    *http.Client
+
+	mu sync.RWMutex
+	// change on SwapContext, read with CurrentContext and CurrentDialer
+	zctx        ziti.Context
+	dialer      CustomDialer
+	draining    map[ziti.Context]*time.Timer
+	routing     RoutingOptions
+	routeCounts routeCounters
+}
+
+// Runtime used by the package level shims(InitCon, ZitiClient, ...)
+var defaultRuntime atomic.Pointer[Runtime]
+
+// Creates a runtime from <zitiIDPath>.json identity file
+func NewRuntime(zitiIDPath string) (*Runtime, error) {
+	zctx, err := newZitiContext(zitiIDPath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return NewRuntimeFromContext(zctx), nil
+}
+
+// Creates a runtime from identity json held in memory
+func NewRuntimeFromIdentity(identityJSON []byte) (*Runtime, error) {
+	zctx, err := newZitiContextFromJSON(identityJSON)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return NewRuntimeFromContext(zctx), nil
+}
+
+// Creates a runtime around an already created ziti context
+func NewRuntimeFromContext(zctx ziti.Context) *Runtime {
+	r := &Runtime{
+		Contexts: ziti.NewSdkCollection(),
+		zctx:     zctx,
+		dialer:   CustomDialer{ZitiContext: zctx},
+	}
+	r.Contexts.Add(zctx)
+
+	r.Transport = http.DefaultTransport.(*http.Transport).Clone() // copy default transport
+	r.Transport.DialContext = r.DialContext
+	r.Transport.Dial = r.Dial
+	r.Client = &http.Client{
+		Transport: r.Transport,
+		Timeout:   30 * time.Second,
+	}
+	return r
+}
+
+// Dials addr over the ziti service Route picks for it, over the underlay if
+// none matches and the runtime isn't strict
+func (r *Runtime) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	route, err := r.Route(network, addr)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return r.DialRoute(ctx, route)
+}
+
+func (r *Runtime) Dial(network, addr string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, addr)
+}
+
+// Returns the ziti service whose intercept config matches addr(host:port)
+func (r *Runtime) ServiceForAddr(network, addr string) (*rest_model.ServiceDetail, bool) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, false
+	}
+	svc, _, err := r.CurrentContext().GetServiceForAddr(normalizeNetwork(network), host, uint16(port))
+	if err != nil || svc == nil {
+		return nil, false
+	}
+	return svc, true
+}
+
+func (r *Runtime) CurrentContext() ziti.Context {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.zctx
+}
+
+// Dialer of the current ziti context, for websockets
+func (r *Runtime) CurrentDialer() CustomDialer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.dialer
+}
+
+// Replaces the ziti context, e.g. after the identity certificate was renewed.
+// New dials use zctx, the old context keeps serving its open connections(room
+// websocket, turn allocations) for drain and is closed afterwards.
+func (r *Runtime) SwapContext(zctx ziti.Context, drain time.Duration) {
+	r.mu.Lock()
+	old := r.zctx
+	r.zctx = zctx
+	r.dialer = CustomDialer{ZitiContext: zctx}
+	r.Contexts.Add(zctx)
+	r.Contexts.Remove(old)
+
+	if r.draining == nil {
+		r.draining = map[ziti.Context]*time.Timer{}
+	}
+	r.draining[old] = time.AfterFunc(drain, func() {
+		r.mu.Lock()
+		delete(r.draining, old)
+		r.mu.Unlock()
+		old.Close()
+	})
+	r.mu.Unlock()
+
+	// idle connections were dialed with the old context
+	r.Transport.CloseIdleConnections()
+	if Default() == r {
+		SetDefault(r)
+	}
+}
+
+// Closes the ziti context, contexts still draining after a swap and idle http connections
+func (r *Runtime) Close() {
+	r.Transport.CloseIdleConnections()
+
+	r.mu.Lock()
+	draining := r.draining
+	r.draining = nil
+	r.mu.Unlock()
+	for zctx, timer := range draining {
+		if timer.Stop() {
+			zctx.Close()
+		}
+	}
+	r.CurrentContext().Close()
+}
+
+// Returns the default runtime, nil if InitCon/SetDefault wasn't called
+func Default() *Runtime {
+	return defaultRuntime.Load()
+}
+
+// Sets the default runtime and the package globals that mirror it, nil
+// clears them(e.g. after closing the default runtime). Websocket dialers
+// without a ziti dialer(e.g. websocket.DefaultDialer) fall back to its
+// transport, see Runtime.WebsocketDialer.
+func SetDefault(r *Runtime) {
+	defaultRuntime.Store(r)
+
+	if r == nil {
+		ZitiContext = nil
+		ZitiContexts = nil
+		ZitiTransport = nil
+		ZitiClient = nil
+		ZitiCustomDialer = CustomDialer{}
+		websocket.ZitiTransport = nil
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ZitiContext = r.zctx
+	ZitiContexts = r.Contexts
+	ZitiTransport = r.Transport
+	ZitiClient = r.Client
+	ZitiCustomDialer = r.dialer
+	websocket.ZitiTransport = r.Transport
+}
+
+// DefaultClient is a http client that sends requests through the default
+// runtime at the time of the request. Useful for clients created before InitCon
+// or kept across InitCon calls.
+var DefaultClient = defaultClient{}
+
+type defaultClient struct{}
+
+func (defaultClient) Do(req *http.Request) (*http.Response, error) {
+	r := Default()
+	if r == nil {
+		return nil, ErrNoRuntime
+	}
+	return r.Client.Do(req)
+}
@@ -0,0 +1,425 @@
+package openziti
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	edge_apis "github.com/openziti/sdk-golang/edge-apis"
+	"github.com/openziti/sdk-golang/ziti"
+)
+
+var ErrSessionUnauthorized = errors.New("openziti api session is not valid")
+
+type SessionState int
+
+const (
+	SessionStateActive SessionState = iota
+	// Refresh or re-authentication failed, retrying with backoff
+	SessionStateRefreshFailed
+	// Session expired or was revoked by the controller, re-authenticating
+	SessionStateExpired
+	SessionStateClosed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionStateActive:
+		return "active"
+	case SessionStateRefreshFailed:
+		return "refresh-failed"
+	case SessionStateExpired:
+		return "expired"
+	case SessionStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Authenticator creates a new management api session
+type Authenticator interface {
+	Authenticate(ctx context.Context) (token string, expiresAt time.Time, err error)
+}
+
+// Authenticates with a .json identity file(client certificate)
+type IdentityAuthenticator struct {
+	CtrlUrl     string
+	credentials *edge_apis.IdentityCredentials
+}
+
+// Loads <zitiIDPath>.json identity used to authenticate to ctrlUrl
+func NewIdentityAuthenticator(ctrlUrl string, zitiIDPath string) (*IdentityAuthenticator, error) {
+	jsonFile, err := os.ReadFile(zitiIDPath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	return NewIdentityAuthenticatorFromJSON(ctrlUrl, jsonFile)
+}
+
+// Uses identity json held in memory, e.g. from an IdentityStore
+func NewIdentityAuthenticatorFromJSON(ctrlUrl string, identityJSON []byte) (*IdentityAuthenticator, error) {
+	cfg := ziti.Config{}
+	if err := json.Unmarshal(identityJSON, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.ID.Cert == "" || cfg.ID.Key == "" {
+		return nil, errors.New("identity has no id section")
+	}
+
+	return &IdentityAuthenticator{
+		CtrlUrl:     ctrlUrl,
+		credentials: edge_apis.NewIdentityCredentialsFromConfig(cfg.ID),
+	}, nil
+}
+
+func (a *IdentityAuthenticator) Authenticate(ctx context.Context) (string, time.Time, error) {
+	apiUrl, err := url.Parse(a.CtrlUrl + "/edge/management/v1")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	type result struct {
+		session edge_apis.ApiSession
+		err     error
+	}
+	// edge_apis doesn't take a context, don't let it block cancellation
+	resc := make(chan result, 1)
+	go func() {
+		var configTypes []string
+		managementClient := edge_apis.NewManagementApiClient([]*url.URL{apiUrl}, a.credentials.GetCaPool(), func(ch chan string) {})
+		session, err := managementClient.Authenticate(a.credentials, configTypes)
+		resc <- result{session, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", time.Time{}, ctx.Err()
+	case res := <-resc:
+		if res.err != nil {
+			return "", time.Time{}, res.err
+		}
+		_, token := res.session.GetAccessHeader()
+		var expiresAt time.Time
+		if t := res.session.GetExpiresAt(); t != nil {
+			expiresAt = *t
+		}
+		return token, expiresAt, nil
+	}
+}
+
+// Http client that trusts the controller CA of the identity
+func (a *IdentityAuthenticator) HTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: a.credentials.GetCaPool()}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+// Authenticates with username and password(updb)
+type UpdbAuthenticator struct {
+	CtrlUrl  string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (a *UpdbAuthenticator) Authenticate(ctx context.Context) (string, time.Time, error) {
+	body, err := json.Marshal(map[string]string{
+		"username": a.Username,
+		"password": a.Password,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		a.CtrlUrl+"/edge/management/v1/authenticate?method=password", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return doSessionRequest(client, req)
+}
+
+type zitiApiSessionResp struct {
+	Data struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	} `json:"data"`
+}
+
+func doSessionRequest(client *http.Client, req *http.Request) (string, time.Time, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", time.Time{}, ErrSessionUnauthorized
+	case resp.StatusCode != http.StatusOK:
+		return "", time.Time{}, fmt.Errorf("openziti api session request failed, status: %d, body: %s", resp.StatusCode, body)
+	}
+
+	r := zitiApiSessionResp{}
+	if err = json.Unmarshal(body, &r); err != nil {
+		return "", time.Time{}, err
+	}
+	return r.Data.Token, r.Data.ExpiresAt, nil
+}
+
+type SessionManagerOptions struct {
+	CtrlUrl       string
+	Authenticator Authenticator
+	// Client used for refresh and logout requests, defaults to http.DefaultClient
+	Client *http.Client
+	// How long before expiry the session is refreshed, default 1 minute
+	RefreshBefore time.Duration
+	// Longest time between two refreshes, default 10 minutes
+	MaxRefreshInterval time.Duration
+	// Backoff after a failed refresh, doubles up to MaxBackoff.
+	// Defaults 1 second and 1 minute
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Called on every state change, err is set for failed states
+	OnStateChange func(state SessionState, err error)
+}
+
+// SessionManager keeps a management api session alive.
+// It refreshes the session before it expires, re-authenticates when
+// the controller drops it and logs out on Close.
+type SessionManager struct {
+	opts SessionManagerOptions
+
+	mu        sync.RWMutex
+	token     string
+	expiresAt time.Time
+	state     SessionState
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSessionManager(opts SessionManagerOptions) *SessionManager {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = time.Minute
+	}
+	if opts.MaxRefreshInterval <= 0 {
+		opts.MaxRefreshInterval = 10 * time.Minute
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(time.Minute, opts.MinBackoff)
+	}
+	return &SessionManager{opts: opts, state: SessionStateClosed}
+}
+
+// Authenticates and starts refreshing the session in background
+// until ctx is done or Close is called
+func (m *SessionManager) Start(ctx context.Context) error {
+	if err := m.authenticate(ctx); err != nil {
+		return err
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx)
+	return nil
+}
+
+// Current session token
+func (m *SessionManager) Token() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token
+}
+
+func (m *SessionManager) ExpiresAt() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.expiresAt
+}
+
+func (m *SessionManager) State() SessionState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Stops refreshing and logs the session out
+func (m *SessionManager) Close() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+func (m *SessionManager) run(ctx context.Context) {
+	defer close(m.done)
+
+	var backoff time.Duration
+	for {
+		wait := m.nextRefresh()
+		if backoff > 0 {
+			wait = backoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.logout()
+			m.setState(SessionStateClosed, nil)
+			return
+		case <-timer.C:
+		}
+
+		err := m.refreshOrAuthenticate(ctx)
+		switch {
+		case err == nil:
+			backoff = 0
+		case ctx.Err() != nil:
+			// stopped during the request, handled on next loop
+		default:
+			backoff = m.nextBackoff(backoff)
+			log.Printf("openziti api session refresh failed, retry in %s: %v", backoff, err)
+			m.setState(SessionStateRefreshFailed, err)
+		}
+	}
+}
+
+func (m *SessionManager) refreshOrAuthenticate(ctx context.Context) error {
+	// without an expiry only the controller can tell the session is gone
+	if expiresAt := m.ExpiresAt(); expiresAt.IsZero() || time.Now().Before(expiresAt) {
+		err := m.refresh(ctx)
+		if !errors.Is(err, ErrSessionUnauthorized) {
+			return err
+		}
+		m.setState(SessionStateExpired, err)
+	} else {
+		m.setState(SessionStateExpired, ErrSessionUnauthorized)
+	}
+	return m.authenticate(ctx)
+}
+
+func (m *SessionManager) authenticate(ctx context.Context) error {
+	token, expiresAt, err := m.opts.Authenticator.Authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	m.setSession(token, expiresAt)
+	m.setState(SessionStateActive, nil)
+	return nil
+}
+
+// Any authenticated request extends the session, current-api-session
+// also tells the new expiry
+func (m *SessionManager) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.opts.CtrlUrl+"/edge/management/v1/current-api-session", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("zt-session", m.Token())
+
+	_, expiresAt, err := doSessionRequest(m.opts.Client, req)
+	if err != nil {
+		return err
+	}
+	m.setSession(m.Token(), expiresAt)
+	m.setState(SessionStateActive, nil)
+	return nil
+}
+
+func (m *SessionManager) logout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, m.opts.CtrlUrl+"/edge/management/v1/current-api-session", nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	req.Header.Add("zt-session", m.Token())
+
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (m *SessionManager) nextRefresh() time.Duration {
+	expiresAt := m.ExpiresAt()
+	if expiresAt.IsZero() {
+		return m.opts.MaxRefreshInterval
+	}
+	wait := time.Until(expiresAt) - m.opts.RefreshBefore
+	if wait > m.opts.MaxRefreshInterval {
+		wait = m.opts.MaxRefreshInterval
+	}
+	if wait < m.opts.MinBackoff {
+		wait = m.opts.MinBackoff
+	}
+	return wait
+}
+
+// Doubles the backoff with up to 20% jitter, capped at MaxBackoff
+func (m *SessionManager) nextBackoff(prev time.Duration) time.Duration {
+	next := prev * 2
+	if next < m.opts.MinBackoff {
+		next = m.opts.MinBackoff
+	}
+	next += time.Duration(rand.Int63n(int64(next)/5 + 1))
+	if next > m.opts.MaxBackoff {
+		next = m.opts.MaxBackoff
+	}
+	return next
+}
+
+func (m *SessionManager) setSession(token string, expiresAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = token
+	m.expiresAt = expiresAt
+}
+
+func (m *SessionManager) setState(state SessionState, err error) {
+	m.mu.Lock()
+	changed := m.state != state
+	m.state = state
+	m.mu.Unlock()
+
+	if m.opts.OnStateChange != nil && (changed || err != nil) {
+		m.opts.OnStateChange(state, err)
+	}
+}
@@ -0,0 +1,330 @@
+package openziti
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeController stands in for the edge management api session endpoints
+type fakeController struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]time.Time
+	nextID    int
+	logins    int
+	refreshes int
+	logouts   int
+	failing   bool
+	noExpiry  bool
+}
+
+func newFakeController(ttl time.Duration) *fakeController {
+	c := &fakeController{ttl: ttl, sessions: map[string]time.Time{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/edge/management/v1/authenticate", c.handleAuthenticate)
+	mux.HandleFunc("/edge/management/v1/current-api-session", c.handleCurrentSession)
+	c.Server = httptest.NewServer(mux)
+	return c
+}
+
+func (c *fakeController) writeSession(w http.ResponseWriter, token string, expiresAt time.Time) {
+	resp := zitiApiSessionResp{}
+	resp.Data.Token = token
+	if !c.noExpiry {
+		resp.Data.ExpiresAt = expiresAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (c *fakeController) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["password"] != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if c.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	c.logins++
+	c.nextID++
+	token := fmt.Sprintf("token-%d", c.nextID)
+	c.sessions[token] = time.Now().Add(c.ttl)
+	c.writeSession(w, token, c.sessions[token])
+}
+
+func (c *fakeController) handleCurrentSession(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token := r.Header.Get("zt-session")
+	expiresAt, ok := c.sessions[token]
+	if !ok || time.Now().After(expiresAt) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		c.logouts++
+		delete(c.sessions, token)
+	case http.MethodGet:
+		if c.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c.refreshes++
+		c.sessions[token] = time.Now().Add(c.ttl)
+		c.writeSession(w, token, c.sessions[token])
+	}
+}
+
+func (c *fakeController) revokeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = map[string]time.Time{}
+}
+
+func (c *fakeController) setFailing(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = failing
+}
+
+func (c *fakeController) counts() (logins, refreshes, logouts int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logins, c.refreshes, c.logouts
+}
+
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []SessionState
+}
+
+func (s *stateRecorder) record(state SessionState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states = append(s.states, state)
+}
+
+func (s *stateRecorder) seen(state SessionState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.states {
+		if st == state {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestSessionManager(ctrl *fakeController, states *stateRecorder) *SessionManager {
+	return NewSessionManager(SessionManagerOptions{
+		CtrlUrl: ctrl.URL,
+		Authenticator: &UpdbAuthenticator{
+			CtrlUrl:  ctrl.URL,
+			Username: "admin",
+			Password: "secret",
+		},
+		RefreshBefore:      150 * time.Millisecond,
+		MaxRefreshInterval: time.Minute,
+		MinBackoff:         10 * time.Millisecond,
+		MaxBackoff:         50 * time.Millisecond,
+		OnStateChange:      states.record,
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionManagerRefreshesBeforeExpiry(t *testing.T) {
+	ctrl := newFakeController(200 * time.Millisecond)
+	defer ctrl.Close()
+
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if m.Token() != "token-1" || m.State() != SessionStateActive {
+		t.Fatalf("unexpected session %q %s", m.Token(), m.State())
+	}
+
+	waitFor(t, "refreshes", func() bool {
+		_, refreshes, _ := ctrl.counts()
+		return refreshes >= 3
+	})
+
+	// the session never lapsed, so no second login
+	if logins, _, _ := ctrl.counts(); logins != 1 {
+		t.Fatalf("expected 1 login, got %d", logins)
+	}
+	if m.Token() != "token-1" {
+		t.Fatalf("token changed to %q", m.Token())
+	}
+}
+
+func TestSessionManagerReauthenticatesRevokedSession(t *testing.T) {
+	ctrl := newFakeController(200 * time.Millisecond)
+	defer ctrl.Close()
+
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	ctrl.revokeAll()
+	waitFor(t, "new token", func() bool {
+		return m.Token() == "token-2"
+	})
+	if !states.seen(SessionStateExpired) {
+		t.Fatal("expected expired state")
+	}
+	if m.State() != SessionStateActive {
+		t.Fatalf("expected active state, got %s", m.State())
+	}
+}
+
+func TestSessionManagerBacksOffOnFailure(t *testing.T) {
+	ctrl := newFakeController(200 * time.Millisecond)
+	defer ctrl.Close()
+
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	ctrl.setFailing(true)
+	waitFor(t, "refresh failure", func() bool {
+		return states.seen(SessionStateRefreshFailed)
+	})
+
+	ctrl.setFailing(false)
+	waitFor(t, "recovery", func() bool {
+		return m.State() == SessionStateActive
+	})
+}
+
+func TestSessionManagerUnknownExpiry(t *testing.T) {
+	ctrl := newFakeController(time.Minute)
+	ctrl.noExpiry = true
+	defer ctrl.Close()
+
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	m.opts.MaxRefreshInterval = 20 * time.Millisecond
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if !m.ExpiresAt().IsZero() {
+		t.Fatalf("unexpected expiry %s", m.ExpiresAt())
+	}
+	if wait := m.nextRefresh(); wait != m.opts.MaxRefreshInterval {
+		t.Fatalf("expected refresh in %s, got %s", m.opts.MaxRefreshInterval, wait)
+	}
+	waitFor(t, "refreshes", func() bool {
+		_, refreshes, _ := ctrl.counts()
+		return refreshes >= 3
+	})
+	if logins, _, _ := ctrl.counts(); logins != 1 {
+		t.Fatalf("expected 1 login, got %d", logins)
+	}
+
+	// a revoked session is still noticed through the refresh
+	ctrl.revokeAll()
+	waitFor(t, "new token", func() bool {
+		return m.Token() == "token-2"
+	})
+}
+
+func TestSessionManagerClose(t *testing.T) {
+	ctrl := newFakeController(time.Minute)
+	defer ctrl.Close()
+
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	if m.State() != SessionStateClosed {
+		t.Fatalf("expected closed state, got %s", m.State())
+	}
+	if _, _, logouts := ctrl.counts(); logouts != 1 {
+		t.Fatalf("expected 1 logout, got %d", logouts)
+	}
+	m.Close()
+}
+
+func TestSessionManagerContextCancel(t *testing.T) {
+	ctrl := newFakeController(time.Minute)
+	defer ctrl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	states := &stateRecorder{}
+	m := newTestSessionManager(ctrl, states)
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	waitFor(t, "closed state", func() bool {
+		return m.State() == SessionStateClosed
+	})
+}
+
+func TestSessionManagerStartErrors(t *testing.T) {
+	ctrl := newFakeController(time.Minute)
+	defer ctrl.Close()
+
+	m := NewSessionManager(SessionManagerOptions{
+		CtrlUrl: ctrl.URL,
+		Authenticator: &UpdbAuthenticator{
+			CtrlUrl:  ctrl.URL,
+			Username: "admin",
+			Password: "wrong",
+		},
+	})
+	if err := m.Start(context.Background()); !errors.Is(err, ErrSessionUnauthorized) {
+		t.Fatalf("expected ErrSessionUnauthorized, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m = newTestSessionManager(ctrl, &stateRecorder{})
+	if err := m.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -2,16 +2,17 @@ package openziti
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net"
 	"net/http"
 	"strings"
-	"time"
+	"sync/atomic"
 
-	"github.com/gorilla/websocket"
 	"github.com/openziti/sdk-golang/ziti"
 )
 
+// Mirrors of the default runtime, see SetDefault
 var ZitiContext ziti.Context
 var ZitiContexts *ziti.CtxCollection
 var ZitiTransport *http.Transport
@@ -39,84 +40,168 @@ func (z FallbackDialer) Dial(network, address string) (net.Conn, error) {
 
 // Enrolls api identity if needed
 // Creates openziti api session
-// Creates a keepalive goroutine which pings openziti api every X minutes(default 10)
+// Creates a keepalive goroutine which refreshes the api session before it expires
 func SetupOpenziti(ctrlUrl string, zitiIDPath string) error {
-	err := EnrollIfNeeded(zitiIDPath)
+	_, err := SetupOpenzitiContext(context.Background(), ctrlUrl, zitiIDPath)
+	return err
+}
+
+// Like SetupOpenziti, the session is kept alive until ctx is done or the
+// returned manager is closed. SessionToken is set to the token the session
+// starts with, the default management client follows the managed session.
+func SetupOpenzitiContext(ctx context.Context, ctrlUrl string, zitiIDPath string) (*SessionManager, error) {
+	err := EnrollIfNeededContext(ctx, zitiIDPath)
 	if err != nil {
 		log.Print(err)
-		return err
+		return nil, err
 	}
-	err = CreateApiSession(ctrlUrl, zitiIDPath)
+
+	auth, err := NewIdentityAuthenticator(ctrlUrl, zitiIDPath)
 	if err != nil {
 		log.Print(err)
-		return err
+		return nil, err
+	}
+	m, err := startSessionManager(ctx, ctrlUrl, auth)
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
+
 	err = InitCon(zitiIDPath)
 	if err != nil {
 		log.Print(err)
-		return err
+		m.Close()
+		return nil, err
 	}
+	return m, nil
+}
 
-	go KeepAlive(ctrlUrl)
-	return nil
+// Like SetupOpenzitiContext with the identity loaded from(or enrolled into)
+// an IdentityStore instead of <zitiIDPath>.json
+func SetupOpenzitiStore(ctx context.Context, ctrlUrl string, cfg IdentityConfig) (*SessionManager, error) {
+	identityJSON, err := LoadOrEnrollIdentity(ctx, cfg)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	auth, err := NewIdentityAuthenticatorFromJSON(ctrlUrl, identityJSON)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	m, err := startSessionManager(ctx, ctrlUrl, auth)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	err = InitConFromIdentity(identityJSON)
+	if err != nil {
+		log.Print(err)
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Session read by the default management client, nil unless a managed
+// session is running
+var defaultSession atomic.Pointer[SessionManager]
+
+func startSessionManager(ctx context.Context, ctrlUrl string, auth *IdentityAuthenticator) (*SessionManager, error) {
+	var m *SessionManager
+	m = NewSessionManager(SessionManagerOptions{
+		CtrlUrl:       ctrlUrl,
+		Authenticator: auth,
+		Client:        auth.HTTPClient(),
+		OnStateChange: func(state SessionState, err error) {
+			switch state {
+			case SessionStateActive:
+				logSessionToken(m.Token())
+			case SessionStateClosed:
+				defaultSession.CompareAndSwap(m, nil)
+				fallthrough
+			default:
+				log.Printf("Ziti api session %s: %v", state, err)
+			}
+		},
+	})
+	if err := m.Start(ctx); err != nil {
+		return nil, err
+	}
+	SessionToken = m.Token()
+	defaultSession.Store(m)
+	return m, nil
 }
 
+// Creates the default runtime from <zitiIDPath>.json and sets the package globals
 func InitCon(zitiIDPath string) error {
-	err := SetupZitiContext(zitiIDPath)
+	r, err := NewRuntime(zitiIDPath)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	err = SetupZitiTransport()
+	SetDefault(r)
+	return nil
+}
+
+// Like InitCon with the identity json held in memory
+func InitConFromIdentity(identityJSON []byte) error {
+	r, err := NewRuntimeFromIdentity(identityJSON)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	ZitiClient = &http.Client{
-		Transport: ZitiTransport,
-		Timeout:   30 * time.Second,
-	}
-	ZitiCustomDialer = CustomDialer{ZitiContext: ZitiContext}
-
-	// Set ziti transport for websocket
-	websocket.ZitiTransport = ZitiTransport
+	SetDefault(r)
 	return nil
 }
 
 func SetupZitiContext(path string) (err error) {
+	ZitiContext, err = newZitiContext(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func newZitiContext(path string) (ziti.Context, error) {
 	identityFile := path + ".json"
 
 	cfg, err := ziti.NewConfigFromFile(identityFile)
 	if err != nil {
 		log.Print(err)
-		return err
+		return nil, err
 	}
+	return newZitiContextFromConfig(cfg)
+}
+
+// Builds a ziti context from identity json, key and certs are inline(pem:)
+// so nothing is read from the filesystem
+func newZitiContextFromJSON(identityJSON []byte) (ziti.Context, error) {
+	cfg := &ziti.Config{}
+	if err := json.Unmarshal(identityJSON, cfg); err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	return newZitiContextFromConfig(cfg)
+}
+
+func newZitiContextFromConfig(cfg *ziti.Config) (ziti.Context, error) {
 	cfg.ConfigTypes = append(cfg.ConfigTypes, "all")
 
-	ZitiContext, err = ziti.NewContext(cfg)
+	zctx, err := ziti.NewContext(cfg)
 	if err != nil {
 		log.Print(err)
-		return err
+		return nil, err
 	}
-	_ = ZitiContext.RefreshServices()
-	return nil
+	_ = zctx.RefreshServices()
+	return zctx, nil
 }
 
+// Builds the default runtime around ZitiContext
 func SetupZitiTransport() error {
-	ZitiContexts = ziti.NewSdkCollection()
-	ZitiContexts.Add(ZitiContext)
-	fallback := &FallbackDialer{
-		UnderlayDialer: &net.Dialer{},
-	}
-
-	ZitiTransport = http.DefaultTransport.(*http.Transport).Clone() // copy default transport
-	ZitiTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		dialer := ZitiContexts.NewDialerWithFallback(ctx, fallback)
-		return dialer.Dial(network, addr)
-	}
-	ZitiTransport.Dial = func(network, addr string) (net.Conn, error) {
-		dialer := ZitiContexts.NewDialerWithFallback(context.Background(), fallback)
-		return dialer.Dial(network, addr)
-	}
+	SetDefault(NewRuntimeFromContext(ZitiContext))
 	return nil
 }
@@ -0,0 +1,226 @@
+package openziti
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var ErrIdentityNotFound = errors.New("openziti identity not found")
+var ErrStoreReadOnly = errors.New("openziti identity store is read only")
+
+// IdentityStore keeps enrolled identities(.json config) by name
+type IdentityStore interface {
+	// Returns ErrIdentityNotFound if there is no identity named name
+	Load(name string) ([]byte, error)
+	// Returns ErrStoreReadOnly if the store can't be written
+	Save(name string, data []byte) error
+}
+
+// Keeps identities in process memory only
+type MemoryIdentityStore struct {
+	mu         sync.Mutex
+	identities map[string][]byte
+}
+
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{identities: map[string][]byte{}}
+}
+
+func (s *MemoryIdentityStore) Load(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.identities[name]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemoryIdentityStore) Save(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.identities[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Keeps identities as <Dir>/<name>.json, same layout as store/*.json
+type FileIdentityStore struct {
+	Dir string
+}
+
+func (s FileIdentityStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
+
+func (s FileIdentityStore) Load(name string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrIdentityNotFound
+	}
+	return data, err
+}
+
+// Writes to a temporary file and renames it, readers never see a partial identity
+func (s FileIdentityStore) Save(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.Dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+// Reads identities from environment variables <Prefix><NAME>, e.g.
+// ZITI_IDENTITY_API for name "api" and prefix "ZITI_IDENTITY_".
+// Values are the identity json or its base64 encoding. Read only.
+type EnvIdentityStore struct {
+	Prefix string
+}
+
+func (s EnvIdentityStore) Load(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(EnvName(s.Prefix, name))
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, ErrIdentityNotFound
+	}
+	return decodeSecret(value)
+}
+
+func (s EnvIdentityStore) Save(name string, data []byte) error {
+	return ErrStoreReadOnly
+}
+
+// Reads identities from a mounted Kubernetes secret, one key per identity
+// named <name>.json. Read only, like secret volumes.
+type SecretDirIdentityStore struct {
+	Dir string
+}
+
+func (s SecretDirIdentityStore) Load(name string) ([]byte, error) {
+	// keys are symlinks into ..data, ReadFile follows them
+	data, err := os.ReadFile(filepath.Join(s.Dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSecret(string(data))
+}
+
+func (s SecretDirIdentityStore) Save(name string, data []byte) error {
+	return ErrStoreReadOnly
+}
+
+// Creates a store from a spec:
+//
+//	memory:
+//	file:<dir>
+//	env:<prefix>
+//	secret-dir:<dir>
+func ParseIdentityStore(spec string) (IdentityStore, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "memory":
+		return NewMemoryIdentityStore(), nil
+	case "file":
+		return FileIdentityStore{Dir: arg}, nil
+	case "env":
+		return EnvIdentityStore{Prefix: arg}, nil
+	case "secret-dir":
+		return SecretDirIdentityStore{Dir: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown identity store %q", spec)
+	}
+}
+
+// Environment variable name for name, upper case with _ for anything
+// that isn't a letter or digit
+func EnvName(prefix string, name string) string {
+	return prefix + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// Secrets are json or base64 encoded json
+func decodeSecret(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		return []byte(value), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("identity is neither json nor base64: %w", err)
+	}
+	return data, nil
+}
+
+type IdentityConfig struct {
+	// Name of the identity in Store
+	Name  string
+	Store IdentityStore
+	// Enrollment token used when Store has no identity yet
+	JWT string
+	// Environment variable holding the enrollment token, used if JWT is empty
+	JWTEnv string
+	// Key and certificate options for the enrollment
+	Enroll EnrollConfig
+}
+
+// Loads the identity from the store, enrolling and saving it first if the
+// store has none. Enrollment happens in memory, if the store is read only
+// the identity only lives as long as the process.
+func LoadOrEnrollIdentity(ctx context.Context, cfg IdentityConfig) ([]byte, error) {
+	data, err := cfg.Store.Load(cfg.Name)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	jwt := cfg.JWT
+	if jwt == "" && cfg.JWTEnv != "" {
+		jwt = os.Getenv(cfg.JWTEnv)
+	}
+	if strings.TrimSpace(jwt) == "" {
+		return nil, fmt.Errorf("%s: %w and no enrollment token", cfg.Name, ErrIdentityNotFound)
+	}
+
+	data, err = Enroll(ctx, jwt, cfg.Enroll)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Store.Save(cfg.Name, data)
+	switch {
+	case errors.Is(err, ErrStoreReadOnly):
+		log.Printf("Identity %s enrolled in memory only, the store is read only", cfg.Name)
+	case err != nil:
+		return nil, err
+	}
+	return data, nil
+}
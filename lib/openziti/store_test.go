@@ -0,0 +1,124 @@
+package openziti
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testIdentityJSON = `{"ztAPI":"https://ctrl:1280","id":{"key":"pem:key","cert":"pem:cert","ca":"pem:ca"}}`
+
+func TestIdentityStores(t *testing.T) {
+	dir := t.TempDir()
+	secretDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(secretDir, "api.json"),
+		[]byte(base64.StdEncoding.EncodeToString([]byte(testIdentityJSON))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_IDENTITY_API", testIdentityJSON)
+
+	stores := map[string]IdentityStore{
+		"memory":     NewMemoryIdentityStore(),
+		"file":       FileIdentityStore{Dir: dir},
+		"env":        EnvIdentityStore{Prefix: "TEST_IDENTITY_"},
+		"secret-dir": SecretDirIdentityStore{Dir: secretDir},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Load("missing"); !errors.Is(err, ErrIdentityNotFound) {
+				t.Fatalf("expected ErrIdentityNotFound, got %v", err)
+			}
+
+			err := store.Save("api", []byte(testIdentityJSON))
+			if errors.Is(err, ErrStoreReadOnly) {
+				// env and secret-dir are preloaded above
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := store.Load("api")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != testIdentityJSON {
+				t.Fatalf("unexpected identity %s", data)
+			}
+		})
+	}
+
+	// no temp files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "api.json" {
+		t.Fatalf("unexpected files %v", entries)
+	}
+}
+
+func TestParseIdentityStore(t *testing.T) {
+	for spec, want := range map[string]IdentityStore{
+		"file:/run/ziti":       FileIdentityStore{Dir: "/run/ziti"},
+		"env:ZITI_IDENTITY_":   EnvIdentityStore{Prefix: "ZITI_IDENTITY_"},
+		"secret-dir:/var/ziti": SecretDirIdentityStore{Dir: "/var/ziti"},
+	} {
+		store, err := ParseIdentityStore(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store != want {
+			t.Fatalf("%s: got %#v, want %#v", spec, store, want)
+		}
+	}
+	if store, err := ParseIdentityStore("memory:"); err != nil || store == nil {
+		t.Fatalf("unexpected memory store %v %v", store, err)
+	}
+	if _, err := ParseIdentityStore("vault:secret/ziti"); err == nil {
+		t.Fatal("expected error for unknown store")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName("ZITI_IDENTITY_", "livekit-rtc.api"); name != "ZITI_IDENTITY_LIVEKIT_RTC_API" {
+		t.Fatalf("unexpected env name %s", name)
+	}
+}
+
+func TestLoadOrEnrollIdentity(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdentityStore()
+
+	_, err := LoadOrEnrollIdentity(ctx, IdentityConfig{Name: "api", Store: store})
+	if !errors.Is(err, ErrIdentityNotFound) {
+		t.Fatalf("expected ErrIdentityNotFound, got %v", err)
+	}
+
+	t.Setenv("TEST_JWT", "not-a-jwt")
+	if _, err = LoadOrEnrollIdentity(ctx, IdentityConfig{Name: "api", Store: store, JWTEnv: "TEST_JWT"}); err == nil {
+		t.Fatal("expected enrollment error for a bad token")
+	}
+
+	// an enrolled identity is used without enrolling again
+	if err = store.Save("api", []byte(testIdentityJSON)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := LoadOrEnrollIdentity(ctx, IdentityConfig{Name: "api", Store: store, JWT: "not-a-jwt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testIdentityJSON {
+		t.Fatalf("unexpected identity %s", data)
+	}
+}
+
+func TestNewIdentityAuthenticatorFromJSON(t *testing.T) {
+	if _, err := NewIdentityAuthenticatorFromJSON("https://ctrl:1280", []byte(`{"ztAPI":"https://ctrl:1280"}`)); err == nil {
+		t.Fatal("expected error for identity without id section")
+	}
+	if _, err := NewIdentityAuthenticatorFromJSON("https://ctrl:1280", []byte(`{`)); err == nil {
+		t.Fatal("expected error for bad json")
+	}
+}
@@ -0,0 +1,411 @@
+// Package zititest provides an in-memory edge management api for tests.
+package zititest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Username = "admin"
+	Password = "admin"
+)
+
+const apiPrefix = "/edge/management/v1"
+
+// Collections served by the fake controller
+var collections = []string{
+	"identities",
+	"services",
+	"configs",
+	"config-types",
+	"service-policies",
+	"edge-router-policies",
+	"enrollments",
+}
+
+// Controller is an httptest server implementing the parts of the edge
+// management api used by openziti.ManagementClient. Entities are kept as
+// plain json objects, list requests support limit, offset and filters made
+// of `field="value"` and `field contains "value"` joined by and.
+type Controller struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	sessions map[string]time.Time
+	entities map[string]map[string]map[string]interface{}
+	requests []string
+}
+
+func NewController() *Controller {
+	c := &Controller{
+		sessions: map[string]time.Time{},
+		entities: map[string]map[string]map[string]interface{}{},
+	}
+	for _, name := range collections {
+		c.entities[name] = map[string]map[string]interface{}{}
+	}
+	c.entities["config-types"]["intercept-v1-id"] = map[string]interface{}{"id": "intercept-v1-id", "name": "intercept.v1"}
+	c.entities["config-types"]["host-v1-id"] = map[string]interface{}{"id": "host-v1-id", "name": "host.v1"}
+
+	c.Server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	return c
+}
+
+// Creates an api session and returns its token
+func (c *Controller) Login() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newSession()
+}
+
+// Drops every api session, later requests get 401
+func (c *Controller) RevokeSessions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions = map[string]time.Time{}
+}
+
+// Stores entity in collection, the id is generated if missing.
+// return id
+func (c *Controller) Put(collection string, entity map[string]interface{}) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.put(collection, entity)
+}
+
+// Copy of the entity with id in collection
+func (c *Controller) Get(collection string, id string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entity, ok := c.entities[collection][id]
+	return copyEntity(entity), ok
+}
+
+// Copies of all entities in collection, ordered by id
+func (c *Controller) List(collection string) []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sorted(collection)
+}
+
+// "METHOD path" of every request served so far
+func (c *Controller) Requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.requests...)
+}
+
+func (c *Controller) newSession() string {
+	c.nextID++
+	token := fmt.Sprintf("session-%d", c.nextID)
+	c.sessions[token] = time.Now().Add(30 * time.Minute)
+	return token
+}
+
+func (c *Controller) put(collection string, entity map[string]interface{}) string {
+	entity = copyEntity(entity)
+	id, _ := entity["id"].(string)
+	if id == "" {
+		c.nextID++
+		id = fmt.Sprintf("%s-%d", strings.TrimSuffix(collection, "s"), c.nextID)
+		entity["id"] = id
+	}
+	c.entities[collection][id] = entity
+	return id
+}
+
+func (c *Controller) sorted(collection string) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(c.entities[collection]))
+	for _, e := range c.entities[collection] {
+		items = append(items, copyEntity(e))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i]["id"].(string) < items[j]["id"].(string)
+	})
+	return items
+}
+
+func (c *Controller) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, r.Method+" "+r.URL.Path)
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+
+	if path == "/authenticate" {
+		c.authenticate(w, r)
+		return
+	}
+
+	token := r.Header.Get("zt-session")
+	expiresAt, ok := c.sessions[token]
+	if !ok || time.Now().After(expiresAt) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "the request could not be completed. The session is not authorized or the credentials are invalid")
+		return
+	}
+
+	if path == "/current-api-session" {
+		switch r.Method {
+		case http.MethodDelete:
+			delete(c.sessions, token)
+			writeData(w, http.StatusOK, map[string]interface{}{})
+		default:
+			writeData(w, http.StatusOK, map[string]interface{}{"token": token, "expiresAt": expiresAt})
+		}
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	collection := parts[0]
+	if _, ok := c.entities[collection]; !ok || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "the resource requested was not found or is no longer available")
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			c.list(w, r, collection)
+		case http.MethodPost:
+			c.create(w, r, collection)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id := parts[1]
+	entity, ok := c.entities[collection][id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "the resource requested was not found or is no longer available")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeData(w, http.StatusOK, entity)
+	case http.MethodPatch, http.MethodPut:
+		var patch map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, "COULD_NOT_PARSE_BODY", err.Error())
+			return
+		}
+		for k, v := range patch {
+			if k != "id" {
+				entity[k] = v
+			}
+		}
+		writeData(w, http.StatusOK, map[string]interface{}{})
+	case http.MethodDelete:
+		delete(c.entities[collection], id)
+		if collection == "enrollments" {
+			c.detachEnrollment(entity)
+		}
+		writeData(w, http.StatusOK, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *Controller) authenticate(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["username"] != Username || body["password"] != Password {
+		writeError(w, http.StatusUnauthorized, "INVALID_AUTH", "the authentication request failed")
+		return
+	}
+	token := c.newSession()
+	writeData(w, http.StatusOK, map[string]interface{}{"token": token, "expiresAt": c.sessions[token]})
+}
+
+func (c *Controller) list(w http.ResponseWriter, r *http.Request, collection string) {
+	query := r.URL.Query()
+	clauses, err := parseFilter(query.Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_FILTER", err.Error())
+		return
+	}
+
+	matched := []map[string]interface{}{}
+	for _, e := range c.sorted(collection) {
+		if matchAll(e, clauses) {
+			matched = append(matched, e)
+		}
+	}
+
+	limit, offset := 10, 0
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil {
+		limit = v
+	}
+	if v, err := strconv.Atoi(query.Get("offset")); err == nil {
+		offset = v
+	}
+	total := len(matched)
+	page := matched[min(offset, total):min(offset+limit, total)]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": page,
+		"meta": map[string]interface{}{
+			"pagination": map[string]int{"limit": limit, "offset": offset, "totalCount": total},
+		},
+	})
+}
+
+func (c *Controller) create(w http.ResponseWriter, r *http.Request, collection string) {
+	var entity map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&entity); err != nil {
+		writeError(w, http.StatusBadRequest, "COULD_NOT_PARSE_BODY", err.Error())
+		return
+	}
+	delete(entity, "id")
+
+	if collection != "enrollments" {
+		name, _ := entity["name"].(string)
+		if name == "" {
+			writeError(w, http.StatusBadRequest, "COULD_NOT_VALIDATE", "name is required")
+			return
+		}
+		for _, e := range c.entities[collection] {
+			if e["name"] == name {
+				writeError(w, http.StatusConflict, "CONFLICT", fmt.Sprintf("name %q is already in use", name))
+				return
+			}
+		}
+	}
+
+	switch collection {
+	case "identities":
+		enrollment, _ := entity["enrollment"].(map[string]interface{})
+		entity["enrollment"] = map[string]interface{}{}
+		id := c.put(collection, entity)
+		if enrollment["ott"] == true {
+			c.putEnrollment(id, time.Now().Add(24*time.Hour))
+		}
+		writeData(w, http.StatusCreated, map[string]interface{}{"id": id})
+	case "enrollments":
+		identityID, _ := entity["identityId"].(string)
+		if _, ok := c.entities["identities"][identityID]; !ok {
+			writeError(w, http.StatusBadRequest, "COULD_NOT_VALIDATE", "identityId is invalid")
+			return
+		}
+		expiresAt, err := time.Parse(time.RFC3339, fmt.Sprint(entity["expiresAt"]))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "COULD_NOT_VALIDATE", "expiresAt is invalid")
+			return
+		}
+		id := c.putEnrollment(identityID, expiresAt)
+		writeData(w, http.StatusCreated, map[string]interface{}{"id": id})
+	default:
+		id := c.put(collection, entity)
+		writeData(w, http.StatusCreated, map[string]interface{}{"id": id})
+	}
+}
+
+// Creates an ott enrollment and shows it on the identity
+func (c *Controller) putEnrollment(identityID string, expiresAt time.Time) string {
+	c.nextID++
+	jwt := fmt.Sprintf("jwt-%s-%d", identityID, c.nextID)
+	id := c.put("enrollments", map[string]interface{}{
+		"method":     "ott",
+		"identityId": identityID,
+		"identity":   identityID,
+		"jwt":        jwt,
+		"expiresAt":  expiresAt.UTC().Format(time.RFC3339),
+	})
+	c.entities["identities"][identityID]["enrollment"] = map[string]interface{}{
+		"ott": map[string]interface{}{"id": id, "jwt": jwt, "expiresAt": expiresAt.UTC().Format(time.RFC3339)},
+	}
+	return id
+}
+
+func (c *Controller) detachEnrollment(enrollment map[string]interface{}) {
+	identityID, _ := enrollment["identityId"].(string)
+	if identity, ok := c.entities["identities"][identityID]; ok {
+		identity["enrollment"] = map[string]interface{}{}
+	}
+}
+
+type filterClause struct {
+	field    string
+	contains bool
+	value    string
+}
+
+var filterClauseRe = regexp.MustCompile(`^\s*([A-Za-z.]+)\s*(=|contains)\s*("(?:[^"\\]|\\.)*")\s*$`)
+
+func parseFilter(filter string) ([]filterClause, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	var clauses []filterClause
+	for _, part := range strings.Split(filter, " and ") {
+		m := filterClauseRe.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("unsupported filter %q", part)
+		}
+		value, err := strconv.Unquote(m[3])
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, filterClause{field: m[1], contains: m[2] == "contains", value: value})
+	}
+	return clauses, nil
+}
+
+func matchAll(entity map[string]interface{}, clauses []filterClause) bool {
+	for _, clause := range clauses {
+		switch v := entity[clause.field].(type) {
+		case string:
+			if clause.contains && !strings.Contains(v, clause.value) || !clause.contains && v != clause.value {
+				return false
+			}
+		case []interface{}:
+			// contains on a list matches one element, as for roleAttributes
+			found := false
+			for _, item := range v {
+				found = found || item == clause.value
+			}
+			if !found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func copyEntity(entity map[string]interface{}) map[string]interface{} {
+	if entity == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(entity)
+	out := map[string]interface{}{}
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data, "meta": map[string]interface{}{}})
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+		"meta":  map[string]interface{}{},
+	})
+}
@@ -25,6 +25,12 @@ const (
 	stunGatherTimeout = time.Second * 5
 )
 
+// packetListenerTo is implemented by nets that listen for packets to a
+// known destination, like a stdnet.Net dialing over ziti per destination
+type packetListenerTo interface {
+	ListenPacketTo(network, raddr string) (net.PacketConn, error)
+}
+
 // Close a net.Conn and log if we have a failure
 func closeConnAndLog(c io.Closer, log logging.LeveledLogger, msg string, args ...interface{}) {
 	if c == nil || (reflect.ValueOf(c).Kind() == reflect.Ptr && reflect.ValueOf(c).IsNil()) {
@@ -550,8 +556,14 @@ func (a *Agent) gatherCandidatesRelay(ctx context.Context, urls []*stun.URI) { /
 
 			switch {
 			case url.Proto == stun.ProtoTypeUDP && url.Scheme == stun.SchemeTypeTURN:
-				addr := fmt.Sprintf("%s:%d", url.Host, url.Port)
-				if locConn, err = a.net.ListenPacket(network, addr); err != nil {
+				// a ziti net resolves the TURN server by its hostname, so
+				// intercepts of the hostname match
+				if l, ok := a.net.(packetListenerTo); ok {
+					locConn, err = l.ListenPacketTo(network, turnServerAddr)
+				} else {
+					locConn, err = a.net.ListenPacket(network, "0.0.0.0:0")
+				}
+				if err != nil {
 					a.log.Warnf("Failed to listen %s: %v", network, err)
 					return
 				}
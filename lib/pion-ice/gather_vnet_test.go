@@ -8,15 +8,22 @@ package ice
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/pion/dtls/v2/pkg/crypto/selfsign"
 	"github.com/pion/logging"
 	"github.com/pion/stun"
+	"github.com/pion/transport/v2"
+	"github.com/pion/transport/v2/stdnet"
 	"github.com/pion/transport/v2/test"
 	"github.com/pion/transport/v2/vnet"
+	"github.com/pion/turn/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -485,3 +492,175 @@ func TestVNetGather_TURNConnectionLeak(t *testing.T) {
 	// Assert relay conn leak on close.
 	assert.NoError(t, aAgent.Close())
 }
+
+// pipeListener accepts the server side of pipes dialed by zitiTCPNet
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(vnetSTUNServerIP), Port: vnetSTUNServerPort}
+}
+
+// the server sees the agent at a TCP address, like a hosting ziti router
+// forwarding to the TURN server
+type tcpPipeConn struct {
+	net.Conn
+	remote *net.TCPAddr
+}
+
+func (c tcpPipeConn) RemoteAddr() net.Addr { return c.remote }
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- tcpPipeConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("1.2.3.5"), Port: 50000}}:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// zitiTCPNet stands in for stdnet with the TURN server intercepted by a ziti
+// service. vnet has no TCP, a TCP/TLS relay can only come from a ziti conn.
+type zitiTCPNet struct {
+	*vnet.Net
+	listener *pipeListener
+	dialed   atomic.Int32
+}
+
+func (n *zitiTCPNet) DialTCP(_ string, _, raddr *net.TCPAddr) (transport.TCPConn, error) {
+	conn, err := n.listener.dial()
+	if err != nil {
+		return nil, err
+	}
+	n.dialed.Add(1)
+	return stdnet.NewZitiTCPConn(conn, raddr, "turn"), nil
+}
+
+func TestVNetGather_TURNOverZiti(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: loggerFactory,
+	})
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	serverNet, err := vnet.NewNet(&vnet.NetConfig{StaticIP: vnetSTUNServerIP})
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	agentNet, err := vnet.NewNet(&vnet.NetConfig{StaticIP: "1.2.3.5"})
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	assert.NoError(t, wan.AddNet(serverNet))
+	assert.NoError(t, wan.AddNet(agentNet))
+	if !assert.NoError(t, wan.Start(), "should succeed") {
+		return
+	}
+	defer wan.Stop() //nolint:errcheck
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+
+	for _, tc := range []struct {
+		scheme        stun.SchemeType
+		relayProtocol string
+	}{
+		{stun.SchemeTypeTURN, tcp},
+		{stun.SchemeTypeTURNS, "tls"},
+	} {
+		t.Run(tc.relayProtocol, func(t *testing.T) {
+			pipes := newPipeListener()
+			var listener net.Listener = pipes
+			if tc.scheme == stun.SchemeTypeTURNS {
+				listener = tls.NewListener(pipes, &tls.Config{ //nolint:gosec
+					Certificates: []tls.Certificate{certificate},
+				})
+			}
+
+			server, err := turn.NewServer(turn.ServerConfig{
+				Realm:       "pion.ly",
+				AuthHandler: optimisticAuthHandler,
+				ListenerConfigs: []turn.ListenerConfig{
+					{
+						Listener: listener,
+						RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+							RelayAddress: net.ParseIP(vnetSTUNServerIP),
+							Address:      "0.0.0.0",
+							Net:          serverNet,
+						},
+					},
+				},
+				LoggerFactory: loggerFactory,
+			})
+			if !assert.NoError(t, err, "should succeed") {
+				return
+			}
+			defer server.Close() //nolint:errcheck
+
+			turnServerURL := &stun.URI{
+				Scheme:   tc.scheme,
+				Host:     vnetSTUNServerIP,
+				Port:     vnetSTUNServerPort,
+				Username: "username",
+				Password: "password",
+				Proto:    stun.ProtoTypeTCP,
+			}
+			nw := &zitiTCPNet{Net: agentNet, listener: pipes}
+			a, err := NewAgent(&AgentConfig{
+				Urls:               []*stun.URI{turnServerURL},
+				CandidateTypes:     []CandidateType{CandidateTypeRelay},
+				NetworkTypes:       supportedNetworkTypes(),
+				MulticastDNSMode:   MulticastDNSModeDisabled,
+				InsecureSkipVerify: true,
+				Net:                nw,
+			})
+			if !assert.NoError(t, err, "should succeed") {
+				return
+			}
+
+			a.gatherCandidatesRelay(context.Background(), []*stun.URI{turnServerURL})
+
+			candidates, err := a.GetLocalCandidates()
+			assert.NoError(t, err, "should succeed")
+			if assert.Len(t, candidates, 1, "should gather a relay candidate") {
+				relay, ok := candidates[0].(*CandidateRelay)
+				if assert.True(t, ok, "should be a relay candidate") {
+					assert.Equal(t, tc.relayProtocol, relay.RelayProtocol())
+					assert.Equal(t, vnetSTUNServerIP, relay.Address())
+				}
+			}
+			assert.Equal(t, int32(1), nw.dialed.Load(), "should dial the TURN server over ziti")
+
+			assert.NoError(t, a.Close())
+		})
+	}
+}
@@ -5,6 +5,8 @@ go 1.22.4
 replace github.com/ziti-livekit-example/lib/openziti v0.0.0 => ../openziti
 
 require (
+	github.com/openziti/edge-api v0.26.30
+	github.com/openziti/sdk-golang v0.23.41
 	github.com/pion/logging v0.2.2
 	github.com/pion/transport/v3 v3.0.7
 	github.com/stretchr/testify v1.9.0
@@ -58,11 +60,9 @@ require (
 	github.com/oklog/ulid v1.3.1 // indirect
 	github.com/opentracing/opentracing-go v1.2.1-0.20220228012449-10b1cf09e00b // indirect
 	github.com/openziti/channel/v3 v3.0.2 // indirect
-	github.com/openziti/edge-api v0.26.30 // indirect
 	github.com/openziti/foundation/v2 v2.0.49 // indirect
 	github.com/openziti/identity v1.0.85 // indirect
 	github.com/openziti/metrics v1.2.58 // indirect
-	github.com/openziti/sdk-golang v0.23.41 // indirect
 	github.com/openziti/secretstream v0.1.21 // indirect
 	github.com/openziti/transport/v2 v2.0.146 // indirect
 	github.com/openziti/ziti v1.1.4 // indirect
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package stdnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// FramingMode describes how datagrams are delimited on a stream based
+// ziti connection.
+type FramingMode int
+
+const (
+	// FramingNone maps every datagram onto a single Read/Write of the
+	// underlying connection. Boundaries survive only if the fabric
+	// neither coalesces nor splits writes.
+	FramingNone FramingMode = iota
+	// FramingLengthPrefix prefixes every datagram with its length as a
+	// 2 byte big endian integer.
+	FramingLengthPrefix
+)
+
+// FramingAttribute is the ziti service role attribute that enables
+// FramingLengthPrefix for a service. Dial and host side both read it
+// from the service so they always agree on the framing.
+const FramingAttribute = "datagram-framing"
+
+const (
+	frameHeaderSize  = 2
+	maxDatagramSize  = 1<<16 - 1
+	udpReadBufferLen = 1 << 16
+)
+
+var (
+	errDatagramTooLarge = errors.New("datagram too large for framing")
+	errShortBuffer      = errors.New("buffer too small for datagram")
+)
+
+// String returns the string representation of the framing mode.
+func (m FramingMode) String() string {
+	switch m {
+	case FramingNone:
+		return "none"
+	case FramingLengthPrefix:
+		return "length-prefix"
+	default:
+		return "unknown"
+	}
+}
+
+// FramingModeFromAttributes returns the framing mode for a service
+// with the given role attributes.
+func FramingModeFromAttributes(attributes []string) FramingMode {
+	for _, a := range attributes {
+		if a == FramingAttribute {
+			return FramingLengthPrefix
+		}
+	}
+	return FramingNone
+}
+
+// frameWriter writes length prefixed datagrams. Header and payload go out
+// in one Write so concurrent writers never interleave.
+type frameWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	return &frameWriter{w: w}
+}
+
+func (f *frameWriter) WriteFrame(b []byte) (int, error) {
+	if len(b) > maxDatagramSize {
+		return 0, errDatagramTooLarge
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.buf = append(f.buf[:0], 0, 0)
+	binary.BigEndian.PutUint16(f.buf, uint16(len(b)))
+	f.buf = append(f.buf, b...)
+	if _, err := f.w.Write(f.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// frameReader reads length prefixed datagrams.
+type frameReader struct {
+	mu  sync.Mutex
+	r   *bufio.Reader
+	hdr [frameHeaderSize]byte
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: bufio.NewReader(r)}
+}
+
+// ReadFrame reads exactly one datagram into b. If b is too small the
+// datagram is discarded and errShortBuffer is returned, the stream stays
+// in sync.
+func (f *frameReader) ReadFrame(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := io.ReadFull(f.r, f.hdr[:]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(f.hdr[:]))
+
+	if size > len(b) {
+		if _, err := f.r.Discard(size); err != nil {
+			return 0, err
+		}
+		return 0, errShortBuffer
+	}
+
+	return io.ReadFull(f.r, b[:size])
+}
+
+// ServeFramedUDP is the hosting side counterpart of a ZitiPacketConn using
+// FramingLengthPrefix. It unframes datagrams read from conn and sends them
+// as real UDP packets to target (usually the TURN server), and frames
+// packets coming back from target onto conn. It returns when either side
+// fails and closes both.
+func ServeFramedUDP(conn net.Conn, target *net.UDPAddr) error {
+	udpConn, err := net.DialUDP("udp", nil, target)
+	if err != nil {
+		return err
+	}
+	return serveFramed(conn, udpConn)
+}
+
+func serveFramed(conn net.Conn, udpConn net.Conn) error {
+	defer func() {
+		_ = conn.Close()
+		_ = udpConn.Close()
+	}()
+
+	errc := make(chan error, 2)
+	go func() {
+		fr := newFrameReader(conn)
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := fr.ReadFrame(buf)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if _, err = udpConn.Write(buf[:n]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		fw := newFrameWriter(conn)
+		buf := make([]byte, udpReadBufferLen)
+		for {
+			n, err := udpConn.Read(buf)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if _, err = fw.WriteFrame(buf[:n]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	err := <-errc
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
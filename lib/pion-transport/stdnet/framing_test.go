@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package stdnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const stunMagicCookie = 0x2112A442
+
+// stunMessage builds a STUN binding request with attribute padding
+// so that the whole message is size bytes long.
+func stunMessage(size int) []byte {
+	m := make([]byte, size)
+	binary.BigEndian.PutUint16(m[0:], 0x0001)
+	binary.BigEndian.PutUint16(m[2:], uint16(size-20))
+	binary.BigEndian.PutUint32(m[4:], stunMagicCookie)
+	for i := 8; i < size; i++ {
+		m[i] = byte(i)
+	}
+	return m
+}
+
+// channelData builds a TURN ChannelData message carrying payloadLen bytes.
+func channelData(channel uint16, payloadLen int) []byte {
+	m := make([]byte, 4+payloadLen)
+	binary.BigEndian.PutUint16(m[0:], channel)
+	binary.BigEndian.PutUint16(m[2:], uint16(payloadLen))
+	for i := 4; i < len(m); i++ {
+		m[i] = byte(channel) ^ byte(i)
+	}
+	return m
+}
+
+// burst returns a mix of STUN and ChannelData messages of varying size.
+func burst(count int) [][]byte {
+	rnd := rand.New(rand.NewSource(1)) //nolint:gosec
+	msgs := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if i%3 == 0 {
+			msgs = append(msgs, stunMessage(20+4*rnd.Intn(64)))
+		} else {
+			msgs = append(msgs, channelData(0x4000+uint16(i%16), 1+rnd.Intn(1400)))
+		}
+	}
+	return msgs
+}
+
+// choppedConn returns reads in small random sized pieces, like a fabric
+// that splits writes.
+type choppedConn struct {
+	net.Conn
+	rnd *rand.Rand
+}
+
+func (c *choppedConn) Read(b []byte) (int, error) {
+	n := 1 + c.rnd.Intn(7)
+	if n > len(b) {
+		n = len(b)
+	}
+	return c.Conn.Read(b[:n])
+}
+
+var testAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3478}
+
+func TestFramingSplitReads(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+
+	client := newZitiPacketConn(a, udpString, testAddr, FramingLengthPrefix)
+	server := newZitiPacketConn(&choppedConn{Conn: b, rnd: rand.New(rand.NewSource(2))}, udpString, testAddr, FramingLengthPrefix) //nolint:gosec
+	defer func() { _ = server.Close() }()
+
+	msgs := burst(200)
+	go func() {
+		for _, m := range msgs {
+			if _, err := client.WriteTo(m, testAddr); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	for i, want := range msgs {
+		n, addr, err := server.ReadFrom(buf)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, testAddr, addr, "should report the dialed address")
+		assert.Equal(t, want, buf[:n], "message %d should arrive intact", i)
+	}
+}
+
+func TestFramingCoalescedWrites(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+
+	msgs := burst(100)
+	var stream bytes.Buffer
+	fw := newFrameWriter(&stream)
+	for _, m := range msgs {
+		_, err := fw.WriteFrame(m)
+		assert.NoError(t, err, "should succeed")
+	}
+
+	// The whole burst arrives as one write
+	go func() {
+		_, _ = a.Write(stream.Bytes())
+	}()
+
+	conn := newZitiPacketConn(b, udpString, testAddr, FramingLengthPrefix)
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, 1500)
+	for i, want := range msgs {
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, want, buf[:n], "message %d should arrive intact", i)
+	}
+}
+
+func TestFramingShortBuffer(t *testing.T) {
+	var stream bytes.Buffer
+	fw := newFrameWriter(&stream)
+	_, err := fw.WriteFrame(channelData(0x4001, 100))
+	assert.NoError(t, err, "should succeed")
+	small := stunMessage(28)
+	_, err = fw.WriteFrame(small)
+	assert.NoError(t, err, "should succeed")
+
+	fr := newFrameReader(&stream)
+	buf := make([]byte, 32)
+
+	_, err = fr.ReadFrame(buf)
+	assert.ErrorIs(t, err, errShortBuffer, "oversized datagram should be dropped")
+
+	n, err := fr.ReadFrame(buf)
+	assert.NoError(t, err, "stream should stay in sync")
+	assert.Equal(t, small, buf[:n])
+
+	_, err = fr.ReadFrame(buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestFramingTooLarge(t *testing.T) {
+	fw := newFrameWriter(io.Discard)
+	_, err := fw.WriteFrame(make([]byte, maxDatagramSize+1))
+	assert.ErrorIs(t, err, errDatagramTooLarge)
+}
+
+func TestFramingModeFromAttributes(t *testing.T) {
+	assert.Equal(t, FramingNone, FramingModeFromAttributes(nil))
+	assert.Equal(t, FramingNone, FramingModeFromAttributes([]string{"turn"}))
+	assert.Equal(t, FramingLengthPrefix, FramingModeFromAttributes([]string{"turn", FramingAttribute}))
+}
+
+func TestServeFramedUDP(t *testing.T) {
+	// Stand-in for the TURN server: echoes every datagram back
+	turnConn, err := net.ListenUDP(udpString, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer func() { _ = turnConn.Close() }()
+
+	received := make(chan []byte, 64)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := turnConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- append([]byte{}, buf[:n]...)
+			_, _ = turnConn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	a, b := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeFramedUDP(b, turnConn.LocalAddr().(*net.UDPAddr)) //nolint:forcetypeassert
+	}()
+
+	client := newZitiPacketConn(a, udpString, testAddr, FramingLengthPrefix)
+
+	msgs := burst(30)
+	buf := make([]byte, 1500)
+	for i, m := range msgs {
+		_, err = client.WriteTo(m, testAddr)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		select {
+		case got := <-received:
+			assert.Equal(t, m, got, "TURN server should get message %d as one datagram", i)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for datagram")
+		}
+
+		assert.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := client.ReadFrom(buf)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, m, buf[:n], "echo of message %d should arrive intact", i)
+	}
+
+	assert.NoError(t, client.Close())
+	select {
+	case err = <-done:
+		assert.NoError(t, err, "closing the ziti side should stop the adapter")
+	case <-time.After(5 * time.Second):
+		t.Fatal("adapter did not stop")
+	}
+}
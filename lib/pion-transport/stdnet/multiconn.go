@@ -0,0 +1,280 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package stdnet
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v2/deadline"
+)
+
+// DefaultPeerIdleTimeout is how long a per-destination connection of a
+// ZitiMultiPacketConn may stay unused before it is closed.
+const DefaultPeerIdleTimeout = 2 * time.Minute
+
+const readQueueSize = 128
+
+var errMultiConnClosed = errors.New("ziti packet conn closed")
+
+// peerDialer dials the connection used for one remote address.
+type peerDialer func(network, address string) (*ZitiPacketConn, error)
+
+type zitiPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+type zitiPeer struct {
+	conn       *ZitiPacketConn
+	addr       net.Addr
+	err        error
+	ready      chan struct{}
+	lastActive time.Time
+}
+
+// ZitiMultiPacketConn is a net.PacketConn that honors the WriteTo address.
+// It lazily dials one ziti connection per remote address, reports the
+// remote address of the connection a datagram came in on from ReadFrom
+// and closes per-destination connections that have been idle for
+// longer than the idle timeout.
+type ZitiMultiPacketConn struct {
+	network     string
+	dial        peerDialer
+	idleTimeout time.Duration
+
+	mu            sync.Mutex
+	peers         map[string]*zitiPeer
+	writeDeadline time.Time
+
+	readCh       chan zitiPacket
+	readDeadline *deadline.Deadline
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func newZitiMultiPacketConn(network string, dial peerDialer, idleTimeout time.Duration) *ZitiMultiPacketConn {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultPeerIdleTimeout
+	}
+	c := &ZitiMultiPacketConn{
+		network:      network,
+		dial:         dial,
+		idleTimeout:  idleTimeout,
+		peers:        map[string]*zitiPeer{},
+		readCh:       make(chan zitiPacket, readQueueSize),
+		readDeadline: deadline.New(),
+		closed:       make(chan struct{}),
+	}
+	go c.reapIdle()
+	return c
+}
+
+// ReadFrom reads the next datagram from any of the destinations.
+func (c *ZitiMultiPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.readCh:
+		return copy(b, p.data), p.addr, nil
+	case <-c.readDeadline.Done():
+		return 0, nil, c.readDeadline.Err()
+	case <-c.closed:
+		return 0, nil, errMultiConnClosed
+	}
+}
+
+// WriteTo writes a datagram to addr, dialing it first if needed.
+func (c *ZitiMultiPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	p, err := c.peer(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	p.lastActive = time.Now()
+	writeDeadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if err = p.conn.SetWriteDeadline(writeDeadline); err != nil {
+		return 0, err
+	}
+	return p.conn.WriteTo(b, addr)
+}
+
+// peer returns the connection for addr, dialing it once. Concurrent
+// writers to the same address wait for the same dial.
+func (c *ZitiMultiPacketConn) peer(addr net.Addr) (*zitiPeer, error) {
+	key := addr.String()
+
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		return nil, errMultiConnClosed
+	default:
+	}
+	p, ok := c.peers[key]
+	if !ok {
+		p = &zitiPeer{addr: addr, ready: make(chan struct{})}
+		c.peers[key] = p
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-p.ready
+		return p, p.err
+	}
+
+	conn, err := c.dial(c.network, key)
+
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		if err == nil {
+			_ = conn.Close()
+		}
+		err = errMultiConnClosed
+	default:
+	}
+	if err != nil {
+		p.err = err
+		if c.peers[key] == p {
+			delete(c.peers, key)
+		}
+	} else {
+		p.conn = conn
+		p.lastActive = time.Now()
+	}
+	c.mu.Unlock()
+	close(p.ready)
+
+	if err != nil {
+		return p, err
+	}
+	go c.readPeer(key, p)
+	return p, nil
+}
+
+// readPeer forwards datagrams of one destination to ReadFrom.
+func (c *ZitiMultiPacketConn) readPeer(key string, p *zitiPeer) {
+	buf := make([]byte, udpReadBufferLen)
+	for {
+		n, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			c.removePeer(key, p)
+			return
+		}
+
+		c.mu.Lock()
+		p.lastActive = time.Now()
+		c.mu.Unlock()
+
+		select {
+		case c.readCh <- zitiPacket{data: append([]byte{}, buf[:n]...), addr: p.addr}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *ZitiMultiPacketConn) removePeer(key string, p *zitiPeer) {
+	c.mu.Lock()
+	if c.peers[key] == p {
+		delete(c.peers, key)
+	}
+	c.mu.Unlock()
+	_ = p.conn.Close()
+}
+
+// reapIdle closes destinations nobody wrote to or read from for idleTimeout.
+func (c *ZitiMultiPacketConn) reapIdle() {
+	ticker := time.NewTicker(c.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			var idle []*zitiPeer
+			c.mu.Lock()
+			for key, p := range c.peers {
+				if p.conn != nil && now.Sub(p.lastActive) > c.idleTimeout {
+					delete(c.peers, key)
+					idle = append(idle, p)
+				}
+			}
+			c.mu.Unlock()
+
+			for _, p := range idle {
+				_ = p.conn.Close()
+			}
+		}
+	}
+}
+
+// Peers returns the connections currently open, one per destination.
+func (c *ZitiMultiPacketConn) Peers() []*ZitiPacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := make([]*ZitiPacketConn, 0, len(c.peers))
+	for _, p := range c.peers {
+		if p.conn != nil {
+			conns = append(conns, p.conn)
+		}
+	}
+	return conns
+}
+
+// Close closes all per-destination connections.
+func (c *ZitiMultiPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		close(c.closed)
+		conns := make([]*ZitiPacketConn, 0, len(c.peers))
+		for _, p := range c.peers {
+			if p.conn != nil {
+				conns = append(conns, p.conn)
+			}
+		}
+		c.peers = map[string]*zitiPeer{}
+		c.mu.Unlock()
+
+		// Dials still in flight close their conn when they finish
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	return nil
+}
+
+// LocalAddr returns a placeholder address, ziti abstracts it.
+func (c *ZitiMultiPacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 0}
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *ZitiMultiPacketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for ReadFrom.
+func (c *ZitiMultiPacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for WriteTo, applied to
+// every destination.
+func (c *ZitiMultiPacketConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
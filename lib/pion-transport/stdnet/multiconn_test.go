@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package stdnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRemotes hands out pipes to per-address echo servers that prepend
+// their own address to every reply.
+type fakeRemotes struct {
+	dials  atomic.Int32
+	mu     sync.Mutex
+	remote map[string][]net.Conn
+}
+
+func (f *fakeRemotes) dial(network, address string) (*ZitiPacketConn, error) {
+	f.dials.Add(1)
+	if address == "10.0.0.9:3478" {
+		return nil, errors.New("no terminators")
+	}
+
+	udpAddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	local, remote := net.Pipe()
+	f.mu.Lock()
+	if f.remote == nil {
+		f.remote = map[string][]net.Conn{}
+	}
+	f.remote[address] = append(f.remote[address], remote)
+	f.mu.Unlock()
+
+	go func() {
+		server := newZitiPacketConn(remote, network, udpAddr, FramingLengthPrefix)
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply := append([]byte(address+"|"), buf[:n]...)
+			if _, err = server.WriteTo(reply, nil); err != nil {
+				return
+			}
+		}
+	}()
+
+	return newZitiPacketConn(local, network, udpAddr, FramingLengthPrefix), nil
+}
+
+func TestZitiMultiPacketConn(t *testing.T) {
+	stunAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3478}
+	turnAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3478}
+
+	t.Run("WriteToHonorsAddress", func(t *testing.T) {
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, remotes.dial, time.Minute)
+		defer func() { _ = conn.Close() }()
+
+		for _, addr := range []*net.UDPAddr{stunAddr, turnAddr, stunAddr} {
+			_, err := conn.WriteTo([]byte("ping"), addr)
+			if !assert.NoError(t, err, "should succeed") {
+				return
+			}
+
+			buf := make([]byte, 1500)
+			assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err := conn.ReadFrom(buf)
+			if !assert.NoError(t, err, "should succeed") {
+				return
+			}
+			assert.Equal(t, addr.String(), from.String(), "should report the true source address")
+			assert.Equal(t, addr.String()+"|ping", string(buf[:n]), "should reach the right destination")
+		}
+
+		assert.Equal(t, int32(2), remotes.dials.Load(), "should dial once per destination")
+		assert.Len(t, conn.Peers(), 2)
+	})
+
+	t.Run("ConcurrentWritesDialOnce", func(t *testing.T) {
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, remotes.dial, time.Minute)
+		defer func() { _ = conn.Close() }()
+
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := conn.ReadFrom(buf); err != nil {
+					return
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := conn.WriteTo([]byte("ping"), turnAddr)
+				assert.NoError(t, err, "should succeed")
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), remotes.dials.Load(), "should dial once")
+	})
+
+	t.Run("DialError", func(t *testing.T) {
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, remotes.dial, time.Minute)
+		defer func() { _ = conn.Close() }()
+
+		badAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 3478}
+		_, err := conn.WriteTo([]byte("ping"), badAddr)
+		assert.Error(t, err, "should fail")
+		_, err = conn.WriteTo([]byte("ping"), badAddr)
+		assert.Error(t, err, "should fail")
+		assert.Equal(t, int32(2), remotes.dials.Load(), "failed dials should not be cached")
+		assert.Len(t, conn.Peers(), 0)
+	})
+
+	t.Run("IdlePeersAreClosed", func(t *testing.T) {
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, remotes.dial, 50*time.Millisecond)
+		defer func() { _ = conn.Close() }()
+
+		_, err := conn.WriteTo([]byte("ping"), stunAddr)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		buf := make([]byte, 1500)
+		_, _, err = conn.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+
+		assert.Eventually(t, func() bool {
+			return len(conn.Peers()) == 0
+		}, 5*time.Second, 10*time.Millisecond, "idle destination should be closed")
+
+		_, err = conn.WriteTo([]byte("ping"), stunAddr)
+		assert.NoError(t, err, "should redial")
+		assert.Equal(t, int32(2), remotes.dials.Load())
+	})
+
+	t.Run("ReadDeadline", func(t *testing.T) {
+		conn := newZitiMultiPacketConn(udpString, (&fakeRemotes{}).dial, time.Minute)
+		defer func() { _ = conn.Close() }()
+
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+		_, _, err := conn.ReadFrom(make([]byte, 10))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, remotes.dial, time.Minute)
+
+		_, err := conn.WriteTo([]byte("ping"), stunAddr)
+		assert.NoError(t, err, "should succeed")
+
+		done := make(chan error, 1)
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := conn.ReadFrom(buf); err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+
+		assert.NoError(t, conn.Close())
+		select {
+		case err = <-done:
+			assert.ErrorIs(t, err, errMultiConnClosed)
+		case <-time.After(5 * time.Second):
+			t.Fatal("ReadFrom did not return after Close")
+		}
+
+		_, err = conn.WriteTo([]byte("ping"), turnAddr)
+		assert.ErrorIs(t, err, errMultiConnClosed)
+	})
+}
@@ -7,9 +7,14 @@ package stdnet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/transport/v2"
@@ -22,19 +27,76 @@ const (
 	udpString = "udp"
 )
 
+// ErrNotIntercepted is returned by a ziti only Net for addresses no ziti
+// service intercepts, they would go to the underlay.
+var ErrNotIntercepted = errors.New("address is not intercepted by a ziti service")
+
+// syntheticPrefix is the range hostnames routed to ziti services resolve
+// in, the one ziti tunnelers assign intercepted hostnames from.
+var syntheticPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
 // Net is an implementation of the net.Net interface
 // based on functions of the standard net package.
 type Net struct {
-	interfaces []*transport.Interface
+	interfaces  []*transport.Interface
+	zitiRuntime *openziti.Runtime
+	zitiOnly    bool
+
+	mu          sync.Mutex
+	framing     map[string]FramingMode
+	idleTimeout time.Duration
+	registry    *ConnRegistry
+
+	// hostnames routed to ziti services by their synthetic IPs and back,
+	// so dials for the IP route on the hostname
+	hostIPs map[string]netip.Addr
+	ipHosts map[netip.Addr]string
+	lastIP  netip.Addr
+
+	// dials conns over ziti, the runtime's DialRoute if nil
+	zitiDial func(r *openziti.Runtime, route openziti.Route) (net.Conn, error)
 }
 
 // NewNet creates a new StdNet instance.
+// Packet conns are dialed through the default openziti runtime.
 func NewNet() (*Net, error) {
 	n := &Net{}
 
 	return n, n.UpdateInterfaces()
 }
 
+// NewNetWithRuntime creates a new StdNet instance whose packet conns
+// are dialed through the given openziti runtime.
+func NewNetWithRuntime(r *openziti.Runtime) (*Net, error) {
+	n := &Net{zitiRuntime: r}
+
+	return n, n.UpdateInterfaces()
+}
+
+// NewZitiOnlyNet creates a StdNet instance that never touches the underlay:
+// it reports no interfaces and only connects to addresses intercepted by a
+// ziti service of r, or of the default openziti runtime if r is nil.
+// With it ICE can only gather relay candidates, over ziti.
+func NewZitiOnlyNet(r *openziti.Runtime) (*Net, error) {
+	n := &Net{zitiRuntime: r, zitiOnly: true}
+
+	return n, n.UpdateInterfaces()
+}
+
+// ZitiOnly reports whether n was created by NewZitiOnlyNet.
+func (n *Net) ZitiOnly() bool {
+	return n.zitiOnly
+}
+
+// runtime returns the openziti runtime used for dialing,
+// nil if neither an explicit nor a default runtime is set.
+func (n *Net) runtime() *openziti.Runtime {
+	if n.zitiRuntime != nil {
+		return n.zitiRuntime
+	}
+	return openziti.Default()
+}
+
 // Compile-time assertion
 var _ transport.Net = &Net{}
 
@@ -42,6 +104,10 @@ var _ transport.Net = &Net{}
 // and associated addresses.
 func (n *Net) UpdateInterfaces() error {
 	ifs := []*transport.Interface{}
+	if n.zitiOnly {
+		n.interfaces = ifs
+		return nil
+	}
 
 	oifs, err := anet.Interfaces()
 	if err != nil {
@@ -100,27 +166,98 @@ func (n *Net) InterfaceByName(name string) (*transport.Interface, error) {
 	return nil, fmt.Errorf("%w: %s", transport.ErrInterfaceNotFound, name)
 }
 
+// ZitiPacketConn is a net.PacketConn over a single ziti connection.
 type ZitiPacketConn struct {
 	zitiCon net.Conn
 	network string
 	address net.Addr
+	service string
+	framing FramingMode
+	reader  *frameReader
+	writer  *frameWriter
+
+	created   time.Time
+	bytesIn   atomic.Uint64
+	bytesOut  atomic.Uint64
+	registry  *ConnRegistry
+	closeOnce sync.Once
+}
+
+func newZitiPacketConn(conn net.Conn, network string, address net.Addr, framing FramingMode) *ZitiPacketConn {
+	z := &ZitiPacketConn{zitiCon: conn, network: network, address: address, framing: framing, created: time.Now()}
+	if framing == FramingLengthPrefix {
+		z.reader = newFrameReader(conn)
+		z.writer = newFrameWriter(conn)
+	}
+	return z
+}
+
+// RemoteAddr returns the address the connection was dialed for.
+func (z *ZitiPacketConn) RemoteAddr() net.Addr {
+	return z.address
+}
+
+// Service returns the ziti service the connection was dialed over,
+// empty if it goes to the underlay.
+func (z *ZitiPacketConn) Service() string {
+	return z.service
+}
+
+// Framing returns the framing mode used on the ziti connection.
+func (z *ZitiPacketConn) Framing() FramingMode {
+	return z.framing
 }
 
 func (z *ZitiPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	var (
+		n   int
+		err error
+	)
 	// Read data from the Ziti connection
-	n, err := z.zitiCon.Read(b)
+	if z.reader != nil {
+		n, err = z.reader.ReadFrame(b)
+	} else {
+		n, err = z.zitiCon.Read(b)
+	}
+	z.bytesIn.Add(uint64(n))
 	return n, z.address, err
 }
 
 func (z *ZitiPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	var (
+		n   int
+		err error
+	)
 	// Write data to the Ziti connection
-	return z.zitiCon.Write(b)
+	if z.writer != nil {
+		n, err = z.writer.WriteFrame(b)
+	} else {
+		n, err = z.zitiCon.Write(b)
+	}
+	z.bytesOut.Add(uint64(n))
+	return n, err
 }
 
+// Close closes the ziti connection and removes it from its registry.
 func (z *ZitiPacketConn) Close() error {
+	z.closeOnce.Do(func() {
+		if z.registry != nil {
+			z.registry.remove(z)
+		}
+	})
 	return z.zitiCon.Close()
 }
 
+func (z *ZitiPacketConn) stats(now time.Time) ConnStats {
+	return ConnStats{
+		RemoteAddr: z.address,
+		Service:    z.service,
+		BytesIn:    z.bytesIn.Load(),
+		BytesOut:   z.bytesOut.Load(),
+		Age:        now.Sub(z.created),
+	}
+}
+
 func (z *ZitiPacketConn) LocalAddr() net.Addr {
 	// Return a placeholder address; Ziti abstracts this
 	return &net.UDPAddr{IP: net.IPv4zero, Port: 0}
@@ -138,55 +275,193 @@ func (z *ZitiPacketConn) SetWriteDeadline(t time.Time) error {
 	return z.zitiCon.SetWriteDeadline(t)
 }
 
-var allcons []*ZitiPacketConn
-
 // ListenPacket announces on the local network address.
+// The returned conn dials a ziti connection for every address it
+// writes to, see ZitiMultiPacketConn.
+// Without an openziti runtime it behaves like net.ListenPacket.
 func (n *Net) ListenPacket(network string, address string) (net.PacketConn, error) {
-	fallback := &openziti.FallbackDialer{
-		UnderlayDialer: &net.Dialer{},
+	r, err := n.dialRuntime()
+	if err != nil {
+		return nil, err
 	}
-	dialer := openziti.ZitiContexts.NewDialerWithFallback(context.Background(), fallback)
+	if r == nil {
+		return net.ListenPacket(network, address)
+	}
+
+	return newZitiMultiPacketConn(network, func(network, address string) (*ZitiPacketConn, error) {
+		return n.dialPacketConn(r, network, address)
+	}, n.peerIdleTimeout()), nil
+}
 
-	// Dial the Ziti service
+// ListenPacketTo acts like ListenPacket for a conn writing to raddr, such as
+// the server of a TURN client. With an openziti runtime raddr is resolved
+// like ResolveUDPAddr, so a hostname a ziti service intercepts is dialed by
+// name. Without one it listens on the unspecified address.
+func (n *Net) ListenPacketTo(network, raddr string) (net.PacketConn, error) {
+	r, err := n.dialRuntime()
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return net.ListenPacket(network, ":0")
+	}
+
+	if _, err := n.ResolveUDPAddr(network, raddr); err != nil {
+		return nil, err
+	}
+	return n.ListenPacket(network, raddr)
+}
+
+// dialPacketConn dials address over the ziti service the runtime routes it
+// to, or over the underlay if there is none. Synthetic IPs are routed by the
+// hostname they were resolved from.
+func (n *Net) dialPacketConn(r *openziti.Runtime, network, address string) (*ZitiPacketConn, error) {
 	udpAddr, err := net.ResolveUDPAddr(network, address)
 	if err != nil {
 		log.Println("Error resolving UDP address:", err)
 		return nil, err
 	}
 
-	conn, err := dialer.Dial(network, address)
+	routeAddr := n.hostFor(address)
+	route, err := r.Route(network, routeAddr)
 	if err != nil {
-		log.Print("error dialing ", err)
 		return nil, err
 	}
+	if route.Service == "" && n.zitiOnly {
+		return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, routeAddr)
+	}
 
-	zpc := &ZitiPacketConn{zitiCon: conn, network: network, address: udpAddr}
-	allcons = append(allcons, zpc)
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			log.Printf("amountof conns: %d", len(allcons))
+	conn, err := n.dialRoute(r, route)
+	if err != nil {
+		log.Print("error dialing ", err)
+		return nil, err
+	}
 
-			for _, c := range allcons {
-				log.Printf("conn: %s", c.address.String())
-			}
-		}
-	}()
+	zpc := newZitiPacketConn(conn, network, udpAddr, n.framingFor(route))
+	zpc.service = route.Service
+	zpc.registry = n.ConnRegistry()
+	zpc.registry.add(zpc)
 	return zpc, nil
 }
 
+// SetConnRegistry sets the registry ziti packet conns dialed by n are
+// tracked in, DefaultConnRegistry if never set.
+func (n *Net) SetConnRegistry(r *ConnRegistry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.registry = r
+}
+
+// ConnRegistry returns the registry ziti packet conns dialed by n are tracked in.
+func (n *Net) ConnRegistry() *ConnRegistry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.registry == nil {
+		return DefaultConnRegistry
+	}
+	return n.registry
+}
+
+// Conns returns a snapshot of the open ziti packet conns of n's registry.
+func (n *Net) Conns() []ConnStats {
+	return n.ConnRegistry().Snapshot()
+}
+
+// SetPeerIdleTimeout sets how long a per-destination ziti connection of
+// packet conns created afterwards may stay unused before it is closed.
+func (n *Net) SetPeerIdleTimeout(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.idleTimeout = d
+}
+
+func (n *Net) peerIdleTimeout() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.idleTimeout
+}
+
+// SetFraming overrides the framing mode for a ziti service,
+// taking precedence over the FramingAttribute of the service.
+func (n *Net) SetFraming(service string, mode FramingMode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.framing == nil {
+		n.framing = map[string]FramingMode{}
+	}
+	n.framing[service] = mode
+}
+
+// framingFor returns the framing mode of the service a route dials,
+// FramingNone for the underlay.
+func (n *Net) framingFor(route openziti.Route) FramingMode {
+	if route.Service == "" {
+		return FramingNone
+	}
+
+	n.mu.Lock()
+	mode, ok := n.framing[route.Service]
+	n.mu.Unlock()
+	if ok {
+		return mode
+	}
+
+	if route.Detail == nil || route.Detail.RoleAttributes == nil {
+		return FramingNone
+	}
+	return FramingModeFromAttributes(*route.Detail.RoleAttributes)
+}
+
 // ListenUDP acts like ListenPacket for UDP networks.
+// UDP conns are always on the underlay, a ziti only Net refuses them.
 func (n *Net) ListenUDP(network string, locAddr *net.UDPAddr) (transport.UDPConn, error) {
+	if n.zitiOnly {
+		return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, locAddr)
+	}
 	return net.ListenUDP(network, locAddr)
 }
 
 // Dial connects to the address on the named network.
+// TCP addresses the runtime routes to a ziti service are dialed over ziti,
+// anything else over the underlay.
 func (n *Net) Dial(network, address string) (net.Conn, error) {
-	return net.Dial(network, address)
+	r, err := n.dialRuntime()
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return net.Dial(network, address)
+	}
+
+	if isTCP(network) {
+		route, err := r.Route(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if route.Service != "" {
+			tcpAddr, err := zitiTCPAddr(network, address)
+			if err != nil {
+				return nil, err
+			}
+			return n.dialZitiTCP(r, route, tcpAddr)
+		}
+	}
+	// udp goes over ziti through ListenPacket
+	return n.dialUnderlay(network, address)
 }
 
 // DialUDP acts like Dial for UDP networks.
+// UDP conns are always on the underlay, a ziti only Net or strict runtime
+// refuses them.
 func (n *Net) DialUDP(network string, laddr, raddr *net.UDPAddr) (transport.UDPConn, error) {
+	if !n.underlayAllowed() {
+		return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, raddr)
+	}
 	return net.DialUDP(network, laddr, raddr)
 }
 
@@ -196,22 +471,222 @@ func (n *Net) ResolveIPAddr(network, address string) (*net.IPAddr, error) {
 }
 
 // ResolveUDPAddr returns an address of UDP end point.
+// Hostnames the runtime routes to a ziti service resolve to a synthetic IP
+// in 100.64.0.0/10, stable for the Net, without asking the underlay DNS.
+// Packet conns and DialTCP route and dial it by the hostname, so intercepts
+// of the hostname match. A ziti only Net or strict runtime refuses to
+// resolve other hostnames over the underlay.
 func (n *Net) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
+	addrPort, ok, err := n.resolveZiti(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return net.UDPAddrFromAddrPort(addrPort), nil
+	}
 	return net.ResolveUDPAddr(network, address)
 }
 
 // ResolveTCPAddr returns an address of TCP end point.
+// Hostnames resolve like for ResolveUDPAddr.
 func (n *Net) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
+	addrPort, ok, err := n.resolveZiti(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return net.TCPAddrFromAddrPort(addrPort), nil
+	}
 	return net.ResolveTCPAddr(network, address)
 }
 
+// resolveZiti returns the synthetic address of a host:port whose hostname
+// the runtime routes to a ziti service. ok is false for IPs and for
+// hostnames that may go to the underlay.
+func (n *Net) resolveZiti(network, address string) (netip.AddrPort, bool, error) {
+	r := n.runtime()
+	host, portStr, err := net.SplitHostPort(address)
+	if r == nil || err != nil || host == "" {
+		return netip.AddrPort{}, false, nil
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return netip.AddrPort{}, false, nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, false, err
+	}
+
+	if !routesToZiti(r, network, address) {
+		if !n.underlayAllowed() {
+			return netip.AddrPort{}, false, fmt.Errorf("%w: %s", ErrNotIntercepted, address)
+		}
+		return netip.AddrPort{}, false, nil
+	}
+
+	ip, err := n.syntheticIP(host)
+	if err != nil {
+		return netip.AddrPort{}, false, err
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), true, nil
+}
+
+// routesToZiti reports whether the runtime routes address to a ziti service,
+// without counting or reporting it like Route.
+func routesToZiti(r *openziti.Runtime, network, address string) bool {
+	if _, ok := r.Routing().Services.Lookup(network, address); ok {
+		return true
+	}
+	_, ok := r.ServiceForAddr(network, address)
+	return ok
+}
+
+// syntheticIP returns the synthetic IP of host, assigning the next free one
+// of syntheticPrefix on first use.
+func (n *Net) syntheticIP(host string) (netip.Addr, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if ip, ok := n.hostIPs[host]; ok {
+		return ip, nil
+	}
+	ip := syntheticPrefix.Addr().Next()
+	if n.lastIP.IsValid() {
+		ip = n.lastIP.Next()
+	}
+	if !syntheticPrefix.Contains(ip) {
+		return netip.Addr{}, fmt.Errorf("no synthetic address left for %s", host)
+	}
+
+	if n.hostIPs == nil {
+		n.hostIPs = map[string]netip.Addr{}
+		n.ipHosts = map[netip.Addr]string{}
+	}
+	n.hostIPs[host] = ip
+	n.ipHosts[ip] = host
+	n.lastIP = ip
+	return ip, nil
+}
+
+// hostFor returns address with a synthetic IP replaced by the hostname it
+// was resolved from, address itself otherwise.
+func (n *Net) hostFor(address string) string {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return address
+	}
+
+	n.mu.Lock()
+	host, ok := n.ipHosts[addrPort.Addr().Unmap()]
+	n.mu.Unlock()
+	if !ok {
+		return address
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(addrPort.Port())))
+}
+
 // DialTCP acts like Dial for TCP networks.
+// laddr is ignored for connections dialed over ziti. Synthetic IPs are
+// routed by the hostname they were resolved from.
 func (n *Net) DialTCP(network string, laddr, raddr *net.TCPAddr) (transport.TCPConn, error) {
-	return net.DialTCP(network, laddr, raddr)
+	r, err := n.dialRuntime()
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || raddr == nil {
+		return net.DialTCP(network, laddr, raddr)
+	}
+
+	routeAddr := n.hostFor(raddr.String())
+	route, err := r.Route(network, routeAddr)
+	if err != nil {
+		return nil, err
+	}
+	if route.Service == "" {
+		if n.zitiOnly {
+			return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, routeAddr)
+		}
+		return net.DialTCP(network, laddr, raddr)
+	}
+	return n.dialZitiTCP(r, route, raddr)
+}
+
+// dialRuntime returns the runtime to dial over, nil to use the underlay.
+// A ziti only Net has no underlay and fails without a runtime.
+func (n *Net) dialRuntime() (*openziti.Runtime, error) {
+	r := n.runtime()
+	if r == nil && n.zitiOnly {
+		return nil, openziti.ErrNoRuntime
+	}
+	return r, nil
+}
+
+func (n *Net) dialUnderlay(network, address string) (net.Conn, error) {
+	if !n.underlayAllowed() {
+		return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, address)
+	}
+	return net.Dial(network, address)
+}
+
+// underlayAllowed reports whether dials may bypass ziti, not for a ziti only
+// Net or a runtime in strict routing mode.
+func (n *Net) underlayAllowed() bool {
+	if n.zitiOnly {
+		return false
+	}
+	r := n.runtime()
+	return r == nil || !r.Routing().Strict
+}
+
+// dialRoute dials a route, over n.zitiDial if set and the route goes to a
+// ziti service.
+func (n *Net) dialRoute(r *openziti.Runtime, route openziti.Route) (net.Conn, error) {
+	if n.zitiDial != nil && route.Service != "" {
+		return n.zitiDial(r, route)
+	}
+	return r.DialRoute(context.Background(), route)
+}
+
+func (n *Net) dialZitiTCP(r *openziti.Runtime, route openziti.Route, raddr *net.TCPAddr) (*ZitiTCPConn, error) {
+	conn, err := n.dialRoute(r, route)
+	if err != nil {
+		log.Print("error dialing ", err)
+		return nil, err
+	}
+	return NewZitiTCPConn(conn, raddr, route.Service), nil
+}
+
+// zitiTCPAddr resolves the address of a ziti TCP conn. Names only known to
+// the overlay don't resolve, they get an address without IP.
+func zitiTCPAddr(network, address string) (*net.TCPAddr, error) {
+	if tcpAddr, err := net.ResolveTCPAddr(network, address); err == nil {
+		return tcpAddr, nil
+	}
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{Port: int(port)}, nil
+}
+
+func isTCP(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	}
+	return false
 }
 
 // ListenTCP acts like Listen for TCP networks.
+// A ziti only Net has no local addresses to listen on.
 func (n *Net) ListenTCP(network string, laddr *net.TCPAddr) (transport.TCPListener, error) {
+	if n.zitiOnly {
+		return nil, fmt.Errorf("%w: %s", ErrNotIntercepted, laddr)
+	}
 	l, err := net.ListenTCP(network, laddr)
 	if err != nil {
 		return nil, err
@@ -236,7 +711,18 @@ func (d stdDialer) Dial(network, address string) (net.Conn, error) {
 	return d.Dialer.Dial(network, address)
 }
 
+type zitiOnlyDialer struct {
+	n *Net
+}
+
+func (d zitiOnlyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.n.Dial(network, address)
+}
+
 // CreateDialer creates an instance of vnet.Dialer
 func (n *Net) CreateDialer(d *net.Dialer) transport.Dialer {
+	if n.zitiOnly {
+		return zitiOnlyDialer{n}
+	}
 	return stdDialer{d}
 }
@@ -7,11 +7,13 @@
 package stdnet
 
 import (
+	"io"
 	"net"
 	"testing"
 
 	"github.com/pion/logging"
 	"github.com/stretchr/testify/assert"
+	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 func TestStdNet(t *testing.T) {
@@ -220,3 +222,124 @@ func TestStdNet(t *testing.T) {
 		assert.Error(t, err, "should fail")
 	})
 }
+
+func TestZitiOnlyNet(t *testing.T) {
+	nw, err := NewZitiOnlyNet(newFakeRuntime())
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	dialed := &[]string{}
+	nw.zitiDial = echoZitiDial(dialed)
+	assert.True(t, nw.ZitiOnly())
+
+	interfaces, err := nw.Interfaces()
+	assert.NoError(t, err, "should succeed")
+	assert.Empty(t, interfaces, "should report no interfaces")
+
+	t.Run("Intercepted", func(t *testing.T) {
+		conn, err := nw.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assertEcho(t, conn)
+		assert.NoError(t, conn.Close())
+
+		conn2, err := nw.CreateDialer(&net.Dialer{}).Dial("tcp4", "127.0.0.1:3478")
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assertEcho(t, conn2)
+		assert.NoError(t, conn2.Close())
+		assert.Equal(t, []string{"127.0.0.1:3478", "127.0.0.1:3478"}, *dialed)
+	})
+
+	t.Run("Underlay", func(t *testing.T) {
+		local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+		_, err := nw.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+		_, err = nw.Dial("tcp4", "127.0.0.1:80")
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+		_, err = nw.Dial("udp4", "127.0.0.1:3478")
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+		_, err = nw.ListenUDP("udp4", local)
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+		_, err = nw.DialUDP("udp4", nil, local)
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+		_, err = nw.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+
+		conn, err := nw.ListenPacket("udp4", "0.0.0.0:0")
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+		_, err = conn.WriteTo([]byte("binding"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+		assert.ErrorIs(t, err, ErrNotIntercepted)
+	})
+
+	t.Run("NoRuntime", func(t *testing.T) {
+		nw, err := NewZitiOnlyNet(nil)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		_, err = nw.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478})
+		assert.ErrorIs(t, err, openziti.ErrNoRuntime)
+		_, err = nw.ListenPacket("udp4", "0.0.0.0:0")
+		assert.ErrorIs(t, err, openziti.ErrNoRuntime)
+	})
+}
+
+func TestNetHostnameIntercept(t *testing.T) {
+	r := openziti.NewRuntimeFromContext(&fakeZitiContext{services: map[string]string{"turn.ziti.example:3478": "turn"}})
+	nw, err := NewZitiOnlyNet(r)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	var routes []openziti.Route
+	nw.zitiDial = func(_ *openziti.Runtime, route openziti.Route) (net.Conn, error) {
+		routes = append(routes, route)
+		local, remote := net.Pipe()
+		go func() {
+			_, _ = io.Copy(remote, remote)
+			_ = remote.Close()
+		}()
+		return local, nil
+	}
+
+	udpAddr, err := nw.ResolveUDPAddr("udp4", "turn.ziti.example:3478")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	assert.Equal(t, "100.64.0.1:3478", udpAddr.String())
+	again, err := nw.ResolveUDPAddr("udp4", "turn.ziti.example:3478")
+	assert.NoError(t, err, "should succeed")
+	assert.Equal(t, udpAddr, again, "should resolve to the same synthetic IP")
+
+	_, err = nw.ResolveUDPAddr("udp4", "stun.example.com:3478")
+	assert.ErrorIs(t, err, ErrNotIntercepted)
+
+	conn, err := nw.ListenPacketTo("udp4", "turn.ziti.example:3478")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+
+	_, err = conn.WriteTo([]byte("allocate"), udpAddr)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	buf := make([]byte, 1500)
+	n, addr, err := conn.ReadFrom(buf)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	assert.Equal(t, "allocate", string(buf[:n]))
+	assert.Equal(t, udpAddr.String(), addr.String())
+
+	if assert.Len(t, routes, 1) {
+		assert.Equal(t, openziti.RouteIntercepted, routes[0].Source)
+		assert.Equal(t, "turn", routes[0].Service)
+		assert.Equal(t, "turn.ziti.example:3478", routes[0].Address, "should route on the hostname")
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package stdnet
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnStats is a snapshot of one ziti packet connection.
+type ConnStats struct {
+	RemoteAddr net.Addr
+	Service    string
+	BytesIn    uint64
+	BytesOut   uint64
+	Age        time.Duration
+}
+
+// ConnRegistry tracks the open ziti packet connections of one or more Nets.
+// Connections are added when dialed and removed when closed.
+type ConnRegistry struct {
+	mu    sync.Mutex
+	conns map[*ZitiPacketConn]struct{}
+
+	reporterStop chan struct{}
+}
+
+// DefaultConnRegistry is used by Nets that have no registry of their own.
+var DefaultConnRegistry = NewConnRegistry()
+
+// NewConnRegistry creates an empty registry.
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{conns: map[*ZitiPacketConn]struct{}{}}
+}
+
+func (r *ConnRegistry) add(c *ZitiPacketConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conns[c] = struct{}{}
+}
+
+func (r *ConnRegistry) remove(c *ZitiPacketConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, c)
+}
+
+// Len returns the number of open connections.
+func (r *ConnRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.conns)
+}
+
+// Snapshot returns the stats of all open connections, oldest first.
+func (r *ConnRegistry) Snapshot() []ConnStats {
+	r.mu.Lock()
+	conns := make([]*ZitiPacketConn, 0, len(r.conns))
+	for c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]ConnStats, 0, len(conns))
+	for _, c := range conns {
+		stats = append(stats, c.stats(now))
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Age > stats[j].Age
+	})
+	return stats
+}
+
+// StartDebugReporter logs a snapshot of the registry every interval until
+// StopDebugReporter is called. Only one reporter runs per registry,
+// starting it again is a no-op.
+func (r *ConnRegistry) StartDebugReporter(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reporterStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	r.reporterStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				stats := r.Snapshot()
+				log.Printf("ziti packet conns: %d", len(stats))
+				for _, s := range stats {
+					log.Printf("conn: %s service: %q in: %d out: %d age: %s",
+						s.RemoteAddr, s.Service, s.BytesIn, s.BytesOut, s.Age.Round(time.Second))
+				}
+			}
+		}
+	}()
+}
+
+// StopDebugReporter stops the reporter started by StartDebugReporter.
+func (r *ConnRegistry) StopDebugReporter() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reporterStop != nil {
+		close(r.reporterStop)
+		r.reporterStop = nil
+	}
+}
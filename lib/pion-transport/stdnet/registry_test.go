@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package stdnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func registeredConn(r *ConnRegistry, service string) (*ZitiPacketConn, net.Conn) {
+	local, remote := net.Pipe()
+	c := newZitiPacketConn(local, udpString, testAddr, FramingNone)
+	c.service = service
+	c.registry = r
+	r.add(c)
+	return c, remote
+}
+
+func TestConnRegistry(t *testing.T) {
+	t.Run("Snapshot", func(t *testing.T) {
+		r := NewConnRegistry()
+		c, remote := registeredConn(r, "turn")
+		defer func() { _ = c.Close() }()
+
+		go func() {
+			buf := make([]byte, 100)
+			n, _ := remote.Read(buf)
+			_, _ = remote.Write(buf[:n*2])
+		}()
+
+		_, err := c.WriteTo([]byte("ping"), testAddr)
+		assert.NoError(t, err, "should succeed")
+		_, _, err = c.ReadFrom(make([]byte, 100))
+		assert.NoError(t, err, "should succeed")
+
+		stats := r.Snapshot()
+		if !assert.Len(t, stats, 1) {
+			return
+		}
+		assert.Equal(t, testAddr, stats[0].RemoteAddr)
+		assert.Equal(t, "turn", stats[0].Service)
+		assert.Equal(t, uint64(4), stats[0].BytesOut)
+		assert.Equal(t, uint64(8), stats[0].BytesIn)
+		assert.True(t, stats[0].Age >= 0)
+	})
+
+	t.Run("CloseRemoves", func(t *testing.T) {
+		r := NewConnRegistry()
+		c1, _ := registeredConn(r, "turn")
+		c2, _ := registeredConn(r, "stun")
+		assert.Equal(t, 2, r.Len())
+
+		assert.NoError(t, c1.Close())
+		assert.NoError(t, c1.Close())
+		assert.Equal(t, 1, r.Len())
+		assert.Equal(t, "stun", r.Snapshot()[0].Service)
+
+		assert.NoError(t, c2.Close())
+		assert.Equal(t, 0, r.Len())
+	})
+
+	t.Run("IdlePeersAreRemoved", func(t *testing.T) {
+		r := NewConnRegistry()
+		remotes := &fakeRemotes{}
+		conn := newZitiMultiPacketConn(udpString, func(network, address string) (*ZitiPacketConn, error) {
+			c, err := remotes.dial(network, address)
+			if err == nil {
+				c.registry = r
+				r.add(c)
+			}
+			return c, err
+		}, 50*time.Millisecond)
+		defer func() { _ = conn.Close() }()
+
+		_, err := conn.WriteTo([]byte("ping"), testAddr)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, 1, r.Len())
+
+		assert.Eventually(t, func() bool {
+			return r.Len() == 0
+		}, 5*time.Second, 10*time.Millisecond, "idle conn should leave the registry")
+	})
+
+	t.Run("DebugReporter", func(t *testing.T) {
+		r := NewConnRegistry()
+		r.StartDebugReporter(time.Millisecond)
+		r.StartDebugReporter(time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		r.StopDebugReporter()
+		r.StopDebugReporter()
+	})
+}
+
+func TestNetConnRegistry(t *testing.T) {
+	nw, err := NewNet()
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	assert.Equal(t, DefaultConnRegistry, nw.ConnRegistry())
+
+	r := NewConnRegistry()
+	nw.SetConnRegistry(r)
+	assert.Equal(t, r, nw.ConnRegistry())
+	assert.Empty(t, nw.Conns())
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package stdnet
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/pion/transport/v2"
+)
+
+// ZitiTCPConn is a transport.TCPConn over a ziti connection, returned by
+// DialTCP for addresses intercepted by a ziti service. Socket options have
+// no meaning on the overlay and are ignored.
+type ZitiTCPConn struct {
+	net.Conn
+	address *net.TCPAddr
+	service string
+}
+
+// NewZitiTCPConn wraps conn, a ziti connection dialed for address over service.
+func NewZitiTCPConn(conn net.Conn, address *net.TCPAddr, service string) *ZitiTCPConn {
+	return &ZitiTCPConn{Conn: conn, address: address, service: service}
+}
+
+// Compile-time assertion
+var _ transport.TCPConn = &ZitiTCPConn{}
+
+// Service returns the ziti service the connection was dialed over.
+func (z *ZitiTCPConn) Service() string {
+	return z.service
+}
+
+// RemoteAddr returns the address the connection was dialed for.
+func (z *ZitiTCPConn) RemoteAddr() net.Addr {
+	return z.address
+}
+
+func (z *ZitiTCPConn) LocalAddr() net.Addr {
+	// Return a placeholder address; Ziti abstracts this
+	return &net.TCPAddr{IP: net.IPv4zero, Port: 0}
+}
+
+func (z *ZitiTCPConn) CloseRead() error {
+	if c, ok := z.Conn.(interface{ CloseRead() error }); ok {
+		return c.CloseRead()
+	}
+	return transport.ErrNotSupported
+}
+
+func (z *ZitiTCPConn) CloseWrite() error {
+	if c, ok := z.Conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return transport.ErrNotSupported
+}
+
+func (z *ZitiTCPConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(z.Conn, r)
+}
+
+func (z *ZitiTCPConn) SetLinger(int) error {
+	return nil
+}
+
+func (z *ZitiTCPConn) SetKeepAlive(bool) error {
+	return nil
+}
+
+func (z *ZitiTCPConn) SetKeepAlivePeriod(time.Duration) error {
+	return nil
+}
+
+func (z *ZitiTCPConn) SetNoDelay(bool) error {
+	return nil
+}
+
+func (z *ZitiTCPConn) SetWriteBuffer(int) error {
+	return nil
+}
+
+func (z *ZitiTCPConn) SetReadBuffer(int) error {
+	return nil
+}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package stdnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pion/transport/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/ziti-livekit-example/lib/openziti"
+)
+
+var errNoService = errors.New("no service")
+
+// fakeZitiContext intercepts the addresses of services, host:port -> service
+type fakeZitiContext struct {
+	ziti.Context
+	services map[string]string
+}
+
+func (c *fakeZitiContext) GetId() string { return "fake" }
+
+func (c *fakeZitiContext) GetServiceForAddr(_, hostname string, port uint16) (*rest_model.ServiceDetail, int, error) {
+	name, ok := c.services[net.JoinHostPort(hostname, strconv.Itoa(int(port)))]
+	if !ok {
+		return nil, -1, errNoService
+	}
+	return &rest_model.ServiceDetail{Name: &name}, 0, nil
+}
+
+func (c *fakeZitiContext) GetService(name string) (*rest_model.ServiceDetail, bool) {
+	return &rest_model.ServiceDetail{Name: &name}, true
+}
+
+// echoZitiDial dials pipes to echo servers in place of ziti connections,
+// recording the dialed addresses
+func echoZitiDial(dialed *[]string) func(*openziti.Runtime, openziti.Route) (net.Conn, error) {
+	return func(_ *openziti.Runtime, route openziti.Route) (net.Conn, error) {
+		*dialed = append(*dialed, route.Address)
+		local, remote := net.Pipe()
+		go func() {
+			_, _ = io.Copy(remote, remote)
+			_ = remote.Close()
+		}()
+		return local, nil
+	}
+}
+
+func newFakeRuntime() *openziti.Runtime {
+	return openziti.NewRuntimeFromContext(&fakeZitiContext{services: map[string]string{"127.0.0.1:3478": "turn"}})
+}
+
+// newZitiTCPNet returns a Net intercepting "turn" at 127.0.0.1:3478 whose
+// ziti connections are pipes to echo servers
+func newZitiTCPNet(t *testing.T) (*Net, *[]string) {
+	nw, err := NewNetWithRuntime(newFakeRuntime())
+	if !assert.NoError(t, err, "should succeed") {
+		t.FailNow()
+	}
+
+	dialed := &[]string{}
+	nw.zitiDial = echoZitiDial(dialed)
+	return nw, dialed
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_, err := conn.Write([]byte("allocate"))
+	assert.NoError(t, err, "should succeed")
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err, "should succeed")
+	assert.Equal(t, "allocate", string(buf))
+}
+
+func TestNetDialTCP(t *testing.T) {
+	t.Run("Intercepted", func(t *testing.T) {
+		nw, dialed := newZitiTCPNet(t)
+
+		conn, err := nw.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+
+		zconn, ok := conn.(*ZitiTCPConn)
+		if !assert.True(t, ok, "should be a ziti conn") {
+			return
+		}
+		assert.Equal(t, "turn", zconn.Service())
+		assert.Equal(t, []string{"127.0.0.1:3478"}, *dialed)
+		assert.Equal(t, "127.0.0.1:3478", conn.RemoteAddr().String())
+		_, ok = conn.LocalAddr().(*net.TCPAddr)
+		assert.True(t, ok, "local address should be a TCP address")
+
+		assertEcho(t, conn)
+		assert.NoError(t, conn.SetNoDelay(true))
+		assert.NoError(t, conn.SetKeepAlive(true))
+		assert.ErrorIs(t, conn.CloseWrite(), transport.ErrNotSupported)
+	})
+
+	t.Run("Underlay", func(t *testing.T) {
+		nw, dialed := newZitiTCPNet(t)
+
+		l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		defer l.Close() //nolint:errcheck
+
+		conn, err := nw.DialTCP("tcp4", nil, l.Addr().(*net.TCPAddr)) //nolint:forcetypeassert
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		defer conn.Close() //nolint:errcheck
+
+		_, ok := conn.(*net.TCPConn)
+		assert.True(t, ok, "should be an underlay conn")
+		assert.Empty(t, *dialed)
+	})
+}
+
+func TestNetDial(t *testing.T) {
+	nw, dialed := newZitiTCPNet(t)
+
+	conn, err := nw.Dial("tcp", "127.0.0.1:3478")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+
+	_, ok := conn.(*ZitiTCPConn)
+	assert.True(t, ok, "should be a ziti conn")
+	assertEcho(t, conn)
+
+	// udp goes through ListenPacket, Dial leaves it on the underlay
+	udpConn, err := nw.Dial("udp4", "127.0.0.1:3478")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer udpConn.Close() //nolint:errcheck
+
+	_, ok = udpConn.(*net.UDPConn)
+	assert.True(t, ok, "should be an underlay conn")
+	assert.Equal(t, []string{"127.0.0.1:3478"}, *dialed)
+}
+
+func TestNetDialTCPRouting(t *testing.T) {
+	r := newFakeRuntime()
+	r.SetRouting(openziti.RoutingOptions{
+		Services: &openziti.ServiceMap{Routes: []openziti.ServiceRoute{
+			{Match: "turn.example.com:443", Network: "tcp", Service: "turn-tls", Identity: "turn-1"},
+		}},
+		Strict: true,
+	})
+	nw, err := NewNetWithRuntime(r)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	var routes []openziti.Route
+	nw.zitiDial = func(_ *openziti.Runtime, route openziti.Route) (net.Conn, error) {
+		routes = append(routes, route)
+		local, remote := net.Pipe()
+		go func() {
+			_, _ = io.Copy(remote, remote)
+			_ = remote.Close()
+		}()
+		return local, nil
+	}
+
+	conn, err := nw.Dial("tcp", "turn.example.com:443")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+	assertEcho(t, conn)
+	assert.Equal(t, "turn-tls", conn.(*ZitiTCPConn).Service()) //nolint:forcetypeassert
+	if assert.Len(t, routes, 1) {
+		assert.Equal(t, openziti.RouteMapped, routes[0].Source)
+		assert.Equal(t, "turn-1", routes[0].Identity)
+	}
+
+	// strict routing leaves no way around ziti
+	_, err = nw.DialTCP("tcp4", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	assert.ErrorIs(t, err, openziti.ErrNoService)
+	_, err = nw.Dial("udp4", "127.0.0.1:3478")
+	assert.ErrorIs(t, err, ErrNotIntercepted)
+
+	pc, err := nw.ListenPacket("udp4", "0.0.0.0:0")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer pc.Close() //nolint:errcheck
+	_, err = pc.WriteTo([]byte("binding"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	assert.ErrorIs(t, err, openziti.ErrNoService)
+}
+
+func TestNetDialTCPHostnameIntercept(t *testing.T) {
+	r := openziti.NewRuntimeFromContext(&fakeZitiContext{services: map[string]string{"turn.ziti.example:443": "turn-tls"}})
+	nw, err := NewZitiOnlyNet(r)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	dialed := &[]string{}
+	nw.zitiDial = echoZitiDial(dialed)
+
+	tcpAddr, err := nw.ResolveTCPAddr("tcp4", "turn.ziti.example:443")
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	assert.Equal(t, "100.64.0.1:443", tcpAddr.String())
+
+	conn, err := nw.DialTCP("tcp4", nil, tcpAddr)
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+	assertEcho(t, conn)
+	assert.Equal(t, "turn-tls", conn.(*ZitiTCPConn).Service()) //nolint:forcetypeassert
+	assert.Equal(t, tcpAddr.String(), conn.RemoteAddr().String())
+	assert.Equal(t, []string{"turn.ziti.example:443"}, *dialed, "should route on the hostname")
+
+	_, err = nw.ResolveTCPAddr("tcp4", "turn.example.com:443")
+	assert.ErrorIs(t, err, ErrNotIntercepted)
+}
@@ -330,7 +330,7 @@ func (v *Net) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
 
 // ResolveTCPAddr returns an address of TCP end point.
 func (v *Net) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
-	if network != udp && network != "udp4" {
+	if network != "tcp" && network != "tcp4" {
 		return nil, fmt.Errorf("%w %s", errUnknownNetwork, network)
 	}
 
@@ -349,13 +349,13 @@ func (v *Net) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
 		return nil, errInvalidPortNumber
 	}
 
-	udpAddr := &net.TCPAddr{
+	tcpAddr := &net.TCPAddr{
 		IP:   ipAddr.IP,
 		Zone: ipAddr.Zone,
 		Port: port,
 	}
 
-	return udpAddr, nil
+	return tcpAddr, nil
 }
 
 func (v *Net) write(c Chunk) error {
@@ -282,6 +282,23 @@ func TestNetVirtual(t *testing.T) {
 		assert.Equal(t, 1234, udpAddr.Port, "should match")
 	})
 
+	t.Run("ResolveTCPAddr", func(t *testing.T) {
+		nw, err := NewNet(&NetConfig{})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		tcpAddr, err := nw.ResolveTCPAddr("tcp4", "localhost:1234")
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, "127.0.0.1", tcpAddr.IP.String(), "should match")
+		assert.Equal(t, 1234, tcpAddr.Port, "should match")
+
+		_, err = nw.ResolveTCPAddr(udp, "localhost:1234")
+		assert.Error(t, err, "should fail")
+	})
+
 	t.Run("UDPLoopback", func(t *testing.T) {
 		nw, err := NewNet(&NetConfig{})
 		if !assert.NoError(t, err, "should succeed") {
@@ -14,6 +14,7 @@ import (
 	"github.com/pion/ice/v2"
 	"github.com/pion/logging"
 	"github.com/pion/stun"
+	"github.com/pion/transport/v2/stdnet"
 )
 
 // ICEGatherer gathers local host, server reflexive and relay
@@ -71,8 +72,11 @@ func (g *ICEGatherer) createAgent() error {
 		return nil
 	}
 
+	zitiOnly := g.api.settingEngine.candidates.ZitiOnly
 	candidateTypes := []ice.CandidateType{}
-	if g.api.settingEngine.candidates.ICELite {
+	if zitiOnly {
+		candidateTypes = append(candidateTypes, ice.CandidateTypeRelay)
+	} else if g.api.settingEngine.candidates.ICELite {
 		candidateTypes = append(candidateTypes, ice.CandidateTypeHost)
 	} else if g.gatherPolicy == ICETransportPolicyRelay {
 		candidateTypes = append(candidateTypes, ice.CandidateTypeRelay)
@@ -94,8 +98,22 @@ func (g *ICEGatherer) createAgent() error {
 		mDNSMode = ice.MulticastDNSModeQueryOnly
 	}
 
+	nw := g.api.settingEngine.net
+	nat1To1IPs := g.api.settingEngine.candidates.NAT1To1IPs
+	if zitiOnly {
+		mDNSMode = ice.MulticastDNSModeDisabled
+		nat1To1IPs = nil
+		if nw == nil {
+			zitiNet, err := stdnet.NewZitiOnlyNet(nil)
+			if err != nil {
+				return err
+			}
+			nw = zitiNet
+		}
+	}
+
 	config := &ice.AgentConfig{
-		Lite:                   g.api.settingEngine.candidates.ICELite,
+		Lite:                   g.api.settingEngine.candidates.ICELite && !zitiOnly,
 		Urls:                   g.validatedServers,
 		PortMin:                g.api.settingEngine.ephemeralUDP.PortMin,
 		PortMax:                g.api.settingEngine.ephemeralUDP.PortMax,
@@ -110,10 +128,10 @@ func (g *ICEGatherer) createAgent() error {
 		RelayAcceptanceMinWait: g.api.settingEngine.timeout.ICERelayAcceptanceMinWait,
 		InterfaceFilter:        g.api.settingEngine.candidates.InterfaceFilter,
 		IPFilter:               g.api.settingEngine.candidates.IPFilter,
-		NAT1To1IPs:             g.api.settingEngine.candidates.NAT1To1IPs,
+		NAT1To1IPs:             nat1To1IPs,
 		NAT1To1IPCandidateType: nat1To1CandiTyp,
-		IncludeLoopback:        g.api.settingEngine.candidates.IncludeLoopbackCandidate,
-		Net:                    g.api.settingEngine.net,
+		IncludeLoopback:        g.api.settingEngine.candidates.IncludeLoopbackCandidate && !zitiOnly,
+		Net:                    nw,
 		MulticastDNSMode:       mDNSMode,
 		MulticastDNSHostName:   g.api.settingEngine.candidates.MulticastDNSHostName,
 		LocalUfrag:             g.api.settingEngine.candidates.UsernameFragment,
@@ -174,6 +192,14 @@ func (g *ICEGatherer) Gather() error {
 				g.log.Warnf("Failed to convert ice.Candidate: %s", err)
 				return
 			}
+			if g.api.settingEngine.candidates.ZitiOnly {
+				scrubbed := scrubZitiOnlyCandidates([]ICECandidate{c})
+				if len(scrubbed) == 0 {
+					g.log.Debugf("Dropping %s candidate in ziti only mode", c.Typ)
+					return
+				}
+				c = scrubbed[0]
+			}
 			onLocalCandidateHandler(&c)
 		} else {
 			g.setState(ICEGathererStateComplete)
@@ -245,7 +271,29 @@ func (g *ICEGatherer) GetLocalCandidates() ([]ICECandidate, error) {
 		return nil, err
 	}
 
-	return newICECandidatesFromICE(iceCandidates)
+	candidates, err := newICECandidatesFromICE(iceCandidates)
+	if err != nil {
+		return nil, err
+	}
+	if g.api.settingEngine.candidates.ZitiOnly {
+		candidates = scrubZitiOnlyCandidates(candidates)
+	}
+	return candidates, nil
+}
+
+// scrubZitiOnlyCandidates keeps relay candidates only and zeroes their
+// related address, the local address of the conn to the TURN server.
+func scrubZitiOnlyCandidates(candidates []ICECandidate) []ICECandidate {
+	scrubbed := []ICECandidate{}
+	for _, c := range candidates {
+		if c.Typ != ICECandidateTypeRelay {
+			continue
+		}
+		c.RelatedAddress = "0.0.0.0"
+		c.RelatedPort = 0
+		scrubbed = append(scrubbed, c)
+	}
+	return scrubbed
 }
 
 // OnLocalCandidate sets an event handler which fires when a new local ICE candidate is available
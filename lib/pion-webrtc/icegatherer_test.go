@@ -8,12 +8,16 @@ package webrtc
 
 import (
 	"context"
+	"net"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/pion/ice/v2"
+	"github.com/pion/logging"
 	"github.com/pion/transport/v2/test"
+	"github.com/pion/transport/v2/vnet"
+	"github.com/pion/turn/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -156,3 +160,87 @@ func TestICEGatherer_AlreadyClosed(t *testing.T) {
 		assert.ErrorIs(t, err, errICEAgentNotExist)
 	})
 }
+
+func TestICEGatherer_ZitiOnly(t *testing.T) {
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err)
+	serverNet, err := vnet.NewNet(&vnet.NetConfig{StaticIP: "1.2.3.4"})
+	assert.NoError(t, err)
+	clientNet, err := vnet.NewNet(&vnet.NetConfig{StaticIP: "1.2.3.5"})
+	assert.NoError(t, err)
+	assert.NoError(t, wan.AddNet(serverNet))
+	assert.NoError(t, wan.AddNet(clientNet))
+	assert.NoError(t, wan.Start())
+	defer wan.Stop() //nolint:errcheck
+
+	serverConn, err := serverNet.ListenPacket("udp4", "1.2.3.4:3478")
+	assert.NoError(t, err)
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: "pion.ly",
+		AuthHandler: func(username, realm string, _ net.Addr) ([]byte, bool) {
+			return turn.GenerateAuthKey(username, realm, "password"), true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: serverConn,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("1.2.3.4"),
+					Address:      "0.0.0.0",
+					Net:          serverNet,
+				},
+			},
+		},
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err)
+	defer server.Close() //nolint:errcheck
+
+	s := SettingEngine{}
+	s.SetNet(clientNet)
+	s.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryAndGather)
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetZitiOnly(true)
+
+	gatherer, err := NewAPI(WithSettingEngine(s)).NewICEGatherer(ICEGatherOptions{
+		ICEServers: []ICEServer{
+			{URLs: []string{"stun:1.2.3.4:3478"}},
+			{URLs: []string{"turn:1.2.3.4:3478"}, Username: "user", Credential: "password"},
+		},
+	})
+	assert.NoError(t, err)
+
+	var gathered []ICECandidate
+	gatherFinished := make(chan struct{})
+	gatherer.OnLocalCandidate(func(c *ICECandidate) {
+		if c == nil {
+			close(gatherFinished)
+			return
+		}
+		gathered = append(gathered, *c)
+	})
+	assert.NoError(t, gatherer.Gather())
+	<-gatherFinished
+
+	candidates, err := gatherer.GetLocalCandidates()
+	assert.NoError(t, err)
+	for _, list := range [][]ICECandidate{gathered, candidates} {
+		if assert.Len(t, list, 1, "should only gather the relay candidate") {
+			assert.Equal(t, ICECandidateTypeRelay, list[0].Typ)
+			assert.Equal(t, "1.2.3.4", list[0].Address)
+			assert.Equal(t, "0.0.0.0", list[0].RelatedAddress)
+			assert.Equal(t, uint16(0), list[0].RelatedPort)
+		}
+	}
+
+	assert.NoError(t, gatherer.Close())
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package fmp4writer
+
+import (
+	"encoding/binary"
+)
+
+// unity transformation matrix of mvhd and tkhd
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// box builds an ISO BMFF box of type typ holding payloads
+func box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullBox builds a box starting with a version and flags
+func fullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{header}, payloads...)...)
+}
+
+// be encodes values big endian, their type gives their size
+func be(values ...interface{}) []byte {
+	var b []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case []uint32:
+			for _, u := range v {
+				b = binary.BigEndian.AppendUint32(b, u)
+			}
+		case []byte:
+			b = append(b, v...)
+		case string:
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+func ftyp() []byte {
+	return box("ftyp", be("iso5", uint32(512), "iso5", "iso6", "mp41", "cmfc"))
+}
+
+func mvhd(nextTrackID uint32) []byte {
+	return fullBox("mvhd", 0, 0, be(
+		uint32(0), uint32(0), // creation and modification time
+		uint32(1000), uint32(0), // timescale, duration
+		uint32(0x00010000), uint16(0x0100), make([]byte, 10), // rate, volume, reserved
+		unityMatrix, make([]byte, 24), nextTrackID,
+	))
+}
+
+func (t *Track) trak() []byte {
+	volume := uint16(0)
+	handler, name := "vide", "VideoHandler"
+	mediaHeader := fullBox("vmhd", 0, 1, make([]byte, 8))
+	if !t.isVideo() {
+		volume = 0x0100
+		handler, name = "soun", "SoundHandler"
+		mediaHeader = fullBox("smhd", 0, 0, make([]byte, 4))
+	}
+
+	tkhd := fullBox("tkhd", 0, 3, be(
+		uint32(0), uint32(0), t.id, uint32(0), uint32(0), // times, track id, reserved, duration
+		make([]byte, 8), uint16(0), uint16(0), volume, uint16(0), // reserved, layer, alternate group, volume, reserved
+		unityMatrix, uint32(t.config.Width)<<16, uint32(t.config.Height)<<16,
+	))
+	mdhd := fullBox("mdhd", 0, 0, be(
+		uint32(0), uint32(0), t.config.ClockRate, uint32(0),
+		uint16(0x55c4), uint16(0), // language "und"
+	))
+	hdlr := fullBox("hdlr", 0, 0, be(uint32(0), handler, make([]byte, 12), name, uint8(0)))
+	dinf := box("dinf", fullBox("dref", 0, 0, be(uint32(1)), fullBox("url ", 0, 1)))
+
+	// samples are in the fragments, the sample tables are empty
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, be(uint32(1)), t.sampleEntry()),
+		fullBox("stts", 0, 0, be(uint32(0))),
+		fullBox("stsc", 0, 0, be(uint32(0))),
+		fullBox("stsz", 0, 0, be(uint32(0), uint32(0))),
+		fullBox("stco", 0, 0, be(uint32(0))),
+	)
+	return box("trak", tkhd, box("mdia", mdhd, hdlr, box("minf", mediaHeader, dinf, stbl)))
+}
+
+func (t *Track) sampleEntry() []byte {
+	if t.isVideo() {
+		avcC := box("avcC", be(
+			uint8(1), t.sps[1], t.sps[2], t.sps[3], uint8(0xff), // version, profile, compatibility, level, 4 bytes NALU length
+			uint8(0xe1), uint16(len(t.sps)), t.sps, // 1 SPS
+			uint8(1), uint16(len(t.pps)), t.pps, // 1 PPS
+		))
+		compressorName := make([]byte, 32)
+		return box("avc1", be(
+			make([]byte, 6), uint16(1), // reserved, data reference index
+			make([]byte, 16), uint16(t.config.Width), uint16(t.config.Height),
+			uint32(0x00480000), uint32(0x00480000), uint32(0), uint16(1), // 72 dpi, reserved, frame count
+			compressorName, uint16(0x0018), uint16(0xffff), // depth, pre defined
+		), avcC)
+	}
+
+	// Encapsulation of Opus in ISO Base Media File Format, pre-skip isn't
+	// known from RTP
+	dOps := box("dOps", be(
+		uint8(0), uint8(t.config.Channels), uint16(0), t.config.ClockRate, uint16(0), uint8(0),
+	))
+	return box("Opus", be(
+		make([]byte, 6), uint16(1),
+		make([]byte, 8), uint16(t.config.Channels), uint16(16), uint16(0), uint16(0),
+		uint32(48000)<<16,
+	), dOps)
+}
+
+func (t *Track) trex() []byte {
+	return fullBox("trex", 0, 0, be(t.id, uint32(1), uint32(0), uint32(0), uint32(0)))
+}
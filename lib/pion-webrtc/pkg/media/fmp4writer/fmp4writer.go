@@ -0,0 +1,641 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package fmp4writer implements a fragmented MP4 (CMAF) writer for H.264
+// and Opus tracks. The init segment and every fragment are written to the
+// output with one Write each, so they can be streamed or uploaded as they
+// come.
+package fmp4writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+)
+
+var (
+	errFileNotOpened    = errors.New("file not opened")
+	errInvalidNilPacket = errors.New("invalid nil packet")
+	errNoSuchCodec      = errors.New("no codec for this MimeType")
+	errTracksStarted    = errors.New("tracks can't be added after the first frame was written")
+)
+
+const (
+	mimeTypeH264 = "video/H264"
+	mimeTypeOpus = "audio/opus"
+
+	// fragment length of files without video, with video a fragment is a GOP
+	audioFragmentDuration = time.Second
+	// frames buffered while waiting for the key frames of all video tracks
+	maxPendingFrames = 1000
+
+	sampleFlagsKey    = 0x02000000 // sample_depends_on 2
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on 1, sample_is_non_sync_sample
+)
+
+// TrackConfig describes a track of the file
+type TrackConfig struct {
+	// video/H264 or audio/opus
+	MimeType string
+	// RTP clock rate, 90000 for video and 48000 for opus if 0
+	ClockRate uint32
+	// Video size, read from the SPS if 0
+	Width, Height int
+	// Audio channels, 2 if 0
+	Channels int
+}
+
+// SegmentWriter takes the init segment and the fragments of a FMP4Writer
+// with their timing, e.g. to cut them into HLS segments. It's closed with
+// the writer if it's an io.Closer.
+type SegmentWriter interface {
+	WriteInit(init []byte) error
+	WriteFragment(fragment []byte, info FragmentInfo) error
+}
+
+// FragmentInfo is the timing of a fragment, taken from the first video track
+// or the first track if there is no video
+type FragmentInfo struct {
+	Sequence uint32
+	// Start is the decode time of the first sample since the start of the file
+	Start    time.Duration
+	Duration time.Duration
+	// Independent is set if the fragment starts with a key frame
+	Independent bool
+}
+
+// streamWriter writes the init segment and fragments to an io.Writer
+type streamWriter struct {
+	io.Writer
+}
+
+func (s streamWriter) WriteInit(init []byte) error {
+	_, err := s.Write(init)
+	return err
+}
+
+func (s streamWriter) WriteFragment(fragment []byte, _ FragmentInfo) error {
+	_, err := s.Write(fragment)
+	return err
+}
+
+func (s streamWriter) Close() error {
+	if closer, ok := s.Writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// FMP4Writer is used to take RTP packets of several tracks and write them
+// as fragmented MP4, a fragment per GOP of the first video track
+type FMP4Writer struct {
+	mu      sync.Mutex
+	out     SegmentWriter
+	now     func() time.Time
+	created time.Time
+
+	tracks  []*Track
+	started bool
+	pending []frame
+	// pts of the file's time 0
+	startPTS time.Duration
+
+	sequence            uint32
+	fragmentStart       time.Duration
+	maxFragmentDuration time.Duration
+}
+
+// Track is a track of a FMP4Writer, its packets may be written from another
+// goroutine than the other tracks'
+type Track struct {
+	writer *FMP4Writer
+	id     uint32
+	config TrackConfig
+
+	// RTP timing
+	seenPacket bool
+	lastTS     uint32
+	elapsedTS  int64
+	firstPTS   time.Duration
+
+	// frame being assembled
+	frame        []byte
+	frameTS      uint32
+	framePTS     time.Duration
+	inFrame      bool
+	seenKeyFrame bool
+
+	h264     codecs.H264Packet
+	sps, pps []byte
+
+	// samples of the fragment, the last one is held until the next one
+	// gives its duration
+	samples      []sample
+	last         *sample
+	lastDuration uint32
+}
+
+type frame struct {
+	track *Track
+	data  []byte
+	pts   time.Duration
+	key   bool
+}
+
+type sample struct {
+	data     []byte
+	time     int64 // in the track's clock rate
+	duration uint32
+	key      bool
+}
+
+// New builds a new fragmented MP4 writer
+func New(fileName string) (*FMP4Writer, error) {
+	f, err := os.Create(fileName) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	return NewWith(f)
+}
+
+// NewWith initialize a new fragmented MP4 writer with an io.Writer output,
+// nothing is written before the first key frame
+func NewWith(out io.Writer) (*FMP4Writer, error) {
+	if out == nil {
+		return nil, errFileNotOpened
+	}
+	return NewWithSegmentWriter(streamWriter{out})
+}
+
+// NewWithSegmentWriter initialize a new fragmented MP4 writer passing the
+// init segment and fragments separately to out
+func NewWithSegmentWriter(out SegmentWriter) (*FMP4Writer, error) {
+	if out == nil {
+		return nil, errFileNotOpened
+	}
+
+	return &FMP4Writer{
+		out: out,
+		now: time.Now,
+	}, nil
+}
+
+// SetFragmentDuration bounds the length of fragments, e.g. to the part
+// duration of LL-HLS. A fragment is then cut before the frame of the first
+// video track that would take it past d, key frames still start a new one.
+// Without video fragments are 1s by default.
+func (w *FMP4Writer) SetFragmentDuration(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxFragmentDuration = d
+}
+
+// AddTrack adds a track, all tracks have to be added before the first packet
+// is written
+func (w *FMP4Writer) AddTrack(config TrackConfig) (*Track, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.out == nil {
+		return nil, errFileNotOpened
+	} else if w.started || len(w.pending) > 0 {
+		return nil, errTracksStarted
+	}
+
+	switch {
+	case strings.EqualFold(config.MimeType, mimeTypeH264):
+		if config.ClockRate == 0 {
+			config.ClockRate = 90000
+		}
+	case strings.EqualFold(config.MimeType, mimeTypeOpus):
+		if config.ClockRate == 0 {
+			config.ClockRate = 48000
+		}
+		if config.Channels == 0 {
+			config.Channels = 2
+		}
+	default:
+		return nil, errNoSuchCodec
+	}
+
+	t := &Track{writer: w, id: uint32(len(w.tracks) + 1), config: config}
+	w.tracks = append(w.tracks, t)
+	return t, nil
+}
+
+func (t *Track) isVideo() bool {
+	return strings.EqualFold(t.config.MimeType, mimeTypeH264)
+}
+
+// WriteRTP adds a packet of the track. The first packet of the track is
+// placed at the time it arrived since the writer was created, the following
+// ones by their RTP timestamps.
+func (t *Track) WriteRTP(packet *rtp.Packet) error {
+	if packet == nil {
+		return errInvalidNilPacket
+	}
+
+	t.writer.mu.Lock()
+	defer t.writer.mu.Unlock()
+
+	if !t.seenPacket {
+		if t.writer.created.IsZero() {
+			t.writer.created = t.writer.now()
+		}
+		t.firstPTS = t.writer.now().Sub(t.writer.created)
+	}
+	return t.writeRTP(packet, t.rtpPTS(packet))
+}
+
+// WriteRTPAt adds a packet of the track at the given presentation time, e.g.
+// from synchronizer.TrackSynchronizer.GetPTS to align the tracks of a
+// participant by their sender reports
+func (t *Track) WriteRTPAt(packet *rtp.Packet, pts time.Duration) error {
+	if packet == nil {
+		return errInvalidNilPacket
+	}
+
+	t.writer.mu.Lock()
+	defer t.writer.mu.Unlock()
+
+	t.rtpPTS(packet)
+	return t.writeRTP(packet, pts)
+}
+
+// rtpPTS returns the time of packet by its RTP timestamp, unwrapping the
+// timestamp
+func (t *Track) rtpPTS(packet *rtp.Packet) time.Duration {
+	if t.seenPacket {
+		t.elapsedTS += int64(int32(packet.Timestamp - t.lastTS))
+	}
+	t.seenPacket = true
+	t.lastTS = packet.Timestamp
+	return t.firstPTS + time.Duration(t.elapsedTS*int64(time.Second)/int64(t.config.ClockRate))
+}
+
+func (t *Track) writeRTP(packet *rtp.Packet, pts time.Duration) error {
+	if t.writer.out == nil {
+		return errFileNotOpened
+	} else if len(packet.Payload) == 0 {
+		return nil
+	}
+
+	if !t.isVideo() {
+		return t.writer.writeFrame(frame{track: t, data: append([]byte{}, packet.Payload...), pts: pts, key: true})
+	}
+
+	// the marker of the previous frame was lost
+	if t.inFrame && packet.Timestamp != t.frameTS {
+		if err := t.endFrame(); err != nil {
+			return err
+		}
+	}
+
+	// Annex B, split again by h264reader
+	nalus, err := t.h264.Unmarshal(packet.Payload)
+	if err != nil {
+		return err
+	}
+	if !t.inFrame {
+		t.inFrame = true
+		t.frame = nil
+		t.frameTS = packet.Timestamp
+		t.framePTS = pts
+	}
+	t.frame = append(t.frame, nalus...)
+
+	if packet.Marker {
+		return t.endFrame()
+	}
+	return nil
+}
+
+func (t *Track) endFrame() error {
+	data := t.frame
+	t.inFrame = false
+	t.frame = nil
+	if len(data) == 0 {
+		return nil
+	}
+
+	sample, key, err := t.avcSample(data)
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return nil
+	}
+	if !t.seenKeyFrame {
+		if !key {
+			// key frame not seen yet, discarding frame
+			return nil
+		}
+		t.seenKeyFrame = true
+		if t.config.Width == 0 || t.config.Height == 0 {
+			t.config.Width, t.config.Height = 640, 480
+			if width, height, err := spsSize(t.sps); err == nil {
+				t.config.Width, t.config.Height = width, height
+			}
+		}
+	}
+	return t.writer.writeFrame(frame{track: t, data: sample, pts: t.framePTS, key: key})
+}
+
+// avcSample converts an Annex B frame to length prefixed NALs. Parameter sets
+// are kept for the avcC box and left out of the sample, only the first ones
+// are used.
+func (t *Track) avcSample(annexB []byte) (sample []byte, key bool, err error) {
+	reader, err := h264reader.NewReader(bytes.NewReader(annexB))
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		nal, err := reader.NextNAL()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		switch nal.UnitType {
+		case h264reader.NalUnitTypeSPS:
+			if t.sps == nil && len(nal.Data) >= 4 {
+				t.sps = append([]byte{}, nal.Data...)
+			}
+			continue
+		case h264reader.NalUnitTypePPS:
+			if t.pps == nil {
+				t.pps = append([]byte{}, nal.Data...)
+			}
+			continue
+		case h264reader.NalUnitTypeAUD:
+			continue
+		case h264reader.NalUnitTypeCodedSliceIdr:
+			key = true
+		}
+		sample = binary.BigEndian.AppendUint32(sample, uint32(len(nal.Data)))
+		sample = append(sample, nal.Data...)
+	}
+	return sample, key && t.sps != nil && t.pps != nil, nil
+}
+
+// writeFrame adds f once the file started, the file starts when all video
+// tracks had a key frame so the audio doesn't start before the picture
+func (w *FMP4Writer) writeFrame(f frame) error {
+	if w.started {
+		return w.addSample(f)
+	}
+
+	if !f.track.isVideo() && w.hasVideo() && !w.hasVideoKeyFrame() {
+		return nil
+	}
+	w.pending = append(w.pending, f)
+
+	for _, t := range w.tracks {
+		if t.isVideo() && !t.seenKeyFrame && len(w.pending) < maxPendingFrames {
+			return nil
+		}
+	}
+	return w.start()
+}
+
+func (w *FMP4Writer) hasVideo() bool {
+	return w.firstVideoTrack() != nil
+}
+
+// firstVideoTrack returns the track whose key frames start fragments
+func (w *FMP4Writer) firstVideoTrack() *Track {
+	for _, t := range w.tracks {
+		if t.isVideo() {
+			return t
+		}
+	}
+	return nil
+}
+
+func (w *FMP4Writer) hasVideoKeyFrame() bool {
+	for _, t := range w.tracks {
+		if t.isVideo() && t.seenKeyFrame {
+			return true
+		}
+	}
+	return false
+}
+
+// start writes the init segment and the frames held until then, tracks
+// that didn't get a key frame are left out
+func (w *FMP4Writer) start() error {
+	w.started = true
+	sort.SliceStable(w.pending, func(i, j int) bool { return w.pending[i].pts < w.pending[j].pts })
+	if len(w.pending) > 0 {
+		w.startPTS = w.pending[0].pts
+	}
+
+	nextTrackID := uint32(len(w.tracks) + 1)
+	var tracks []*Track
+	for _, t := range w.tracks {
+		if !t.isVideo() || t.seenKeyFrame {
+			tracks = append(tracks, t)
+		}
+	}
+	w.tracks = tracks
+	if len(w.tracks) == 0 {
+		return nil
+	}
+
+	var moov [][]byte
+	moov = append(moov, mvhd(nextTrackID))
+	for _, t := range w.tracks {
+		moov = append(moov, t.trak())
+	}
+	var mvex [][]byte
+	for _, t := range w.tracks {
+		mvex = append(mvex, t.trex())
+	}
+	moov = append(moov, box("mvex", mvex...))
+	if err := w.out.WriteInit(append(ftyp(), box("moov", moov...)...)); err != nil {
+		return err
+	}
+
+	pending := w.pending
+	w.pending = nil
+	for _, f := range pending {
+		if err := w.addSample(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *FMP4Writer) isTrack(t *Track) bool {
+	for _, track := range w.tracks {
+		if track == t {
+			return true
+		}
+	}
+	return false
+}
+
+// addSample gives the previous sample of the track its duration and starts
+// a new fragment on a key frame of the first video track
+func (w *FMP4Writer) addSample(f frame) error {
+	t := f.track
+	if !w.isTrack(t) {
+		return nil
+	}
+
+	pts := f.pts - w.startPTS
+	s := &sample{
+		data: f.data,
+		time: int64(pts) * int64(t.config.ClockRate) / int64(time.Second),
+		key:  f.key,
+	}
+	if t.last != nil {
+		if s.time <= t.last.time {
+			s.time = t.last.time + 1
+		}
+		t.last.duration = uint32(s.time - t.last.time)
+		t.lastDuration = t.last.duration
+		t.samples = append(t.samples, *t.last)
+	}
+	t.last = s
+
+	// the fragment would reach past the limit with the next frame, frames
+	// are expected to last as long as the previous one
+	next := pts - w.fragmentStart + time.Duration(int64(t.lastDuration)*int64(time.Second)/int64(t.config.ClockRate))
+	var fragmentDone bool
+	if video := w.firstVideoTrack(); video != nil {
+		fragmentDone = t == video && (f.key || (w.maxFragmentDuration > 0 && next > w.maxFragmentDuration))
+	} else {
+		limit := w.maxFragmentDuration
+		if limit <= 0 {
+			limit = audioFragmentDuration
+		}
+		fragmentDone = next > limit
+	}
+	if fragmentDone {
+		w.fragmentStart = pts
+		return w.flushFragment()
+	}
+	return nil
+}
+
+// flushFragment writes a moof and mdat with the samples of every track
+func (w *FMP4Writer) flushFragment() error {
+	var trafs [][]byte
+	var dataOffsets []int
+	var mdat [][]byte
+	mdatSize := 0
+	var info *FragmentInfo
+	for _, t := range w.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		if info == nil || t == w.firstVideoTrack() {
+			info = t.fragmentInfo()
+		}
+
+		var entries []byte
+		for _, s := range t.samples {
+			flags := uint32(sampleFlagsNonKey)
+			if s.key {
+				flags = sampleFlagsKey
+			}
+			entries = be(entries, s.duration, uint32(len(s.data)), flags)
+			mdat = append(mdat, s.data)
+		}
+		// data offset, patched once the moof size is known
+		trun := fullBox("trun", 0, 0x000701, be(uint32(len(t.samples)), uint32(mdatSize)), entries)
+		for _, s := range t.samples {
+			mdatSize += len(s.data)
+		}
+
+		// default-base-is-moof
+		tfhd := fullBox("tfhd", 0, 0x020000, be(t.id))
+		tfdt := fullBox("tfdt", 1, 0, be(uint64(t.samples[0].time)))
+		trafs = append(trafs, box("traf", tfhd, tfdt, trun))
+		dataOffsets = append(dataOffsets, 8+len(tfhd)+len(tfdt)+16)
+		t.samples = nil
+	}
+	if len(trafs) == 0 {
+		return nil
+	}
+
+	w.sequence++
+	info.Sequence = w.sequence
+	moof := box("moof", append([][]byte{fullBox("mfhd", 0, 0, be(w.sequence))}, trafs...)...)
+	position := 8 + 16
+	for i, traf := range trafs {
+		offset := position + dataOffsets[i]
+		relative := binary.BigEndian.Uint32(moof[offset:])
+		binary.BigEndian.PutUint32(moof[offset:], uint32(len(moof)+8)+relative)
+		position += len(traf)
+	}
+	return w.out.WriteFragment(append(moof, box("mdat", mdat...)...), *info)
+}
+
+func (t *Track) fragmentInfo() *FragmentInfo {
+	var duration int64
+	for _, s := range t.samples {
+		duration += int64(s.duration)
+	}
+	clockRate := int64(t.config.ClockRate)
+	return &FragmentInfo{
+		Start:       time.Duration(t.samples[0].time * int64(time.Second) / clockRate),
+		Duration:    time.Duration(duration * int64(time.Second) / clockRate),
+		Independent: t.samples[0].key,
+	}
+}
+
+// Close writes the last fragment and closes the output
+func (w *FMP4Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.out == nil {
+		// Returns no error as it may be convenient to call
+		// Close() multiple times
+		return nil
+	}
+
+	defer func() {
+		w.out = nil
+	}()
+
+	if !w.started {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+
+	// the last samples last as long as the ones before
+	for _, t := range w.tracks {
+		if t.last == nil {
+			continue
+		}
+		t.last.duration = t.lastDuration
+		if t.last.duration == 0 {
+			t.last.duration = t.config.ClockRate / 50
+		}
+		t.samples = append(t.samples, *t.last)
+		t.last = nil
+	}
+	if err := w.flushFragment(); err != nil {
+		return err
+	}
+
+	if closer, ok := w.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package fmp4writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/stretchr/testify/assert"
+)
+
+// 320x240 baseline profile
+var testSPS = []byte{0x67, 0x42, 0xc0, 0x1f, 0xf4, 0x0a, 0x0f, 0xc8}
+
+var testPPS = []byte{0x68, 0xce, 0x3c, 0x80}
+
+type mp4Box struct {
+	typ      string
+	data     []byte
+	children []*mp4Box
+}
+
+var containerBoxes = map[string]int{
+	"moov": 0, "trak": 0, "mdia": 0, "minf": 0, "stbl": 0, "mvex": 0, "moof": 0, "traf": 0,
+	"stsd": 8,  // version, flags and entry count
+	"avc1": 78, // visual sample entry
+	"Opus": 28, // audio sample entry
+}
+
+func parseBoxes(t *testing.T, b []byte) []*mp4Box {
+	var boxes []*mp4Box
+	for len(b) > 0 {
+		if !assert.GreaterOrEqual(t, len(b), 8) {
+			return boxes
+		}
+		size := int(binary.BigEndian.Uint32(b))
+		if !assert.LessOrEqual(t, size, len(b)) || !assert.GreaterOrEqual(t, size, 8) {
+			return boxes
+		}
+		box := &mp4Box{typ: string(b[4:8]), data: b[8:size]}
+		if skip, ok := containerBoxes[box.typ]; ok {
+			box.children = parseBoxes(t, box.data[skip:])
+		}
+		boxes = append(boxes, box)
+		b = b[size:]
+	}
+	return boxes
+}
+
+func (b *mp4Box) child(path ...string) *mp4Box {
+	box := b
+	for _, typ := range path {
+		var found *mp4Box
+		for _, c := range box.children {
+			if c.typ == typ {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		box = found
+	}
+	return box
+}
+
+func (b *mp4Box) all(typ string) []*mp4Box {
+	var boxes []*mp4Box
+	for _, c := range b.children {
+		if c.typ == typ {
+			boxes = append(boxes, c)
+		}
+	}
+	return boxes
+}
+
+type fragmentSample struct {
+	time     uint64
+	duration uint32
+	key      bool
+	data     []byte
+}
+
+// parseFragment returns the samples of a moof and mdat by track id
+func parseFragment(t *testing.T, b []byte) (uint32, map[uint32][]fragmentSample) {
+	boxes := parseBoxes(t, b)
+	if !assert.Len(t, boxes, 2) || !assert.Equal(t, "moof", boxes[0].typ) || !assert.Equal(t, "mdat", boxes[1].typ) {
+		t.FailNow()
+	}
+	moof := boxes[0]
+	sequence := binary.BigEndian.Uint32(moof.child("mfhd").data[4:])
+
+	samples := map[uint32][]fragmentSample{}
+	for _, traf := range moof.all("traf") {
+		trackID := binary.BigEndian.Uint32(traf.child("tfhd").data[4:])
+		time := binary.BigEndian.Uint64(traf.child("tfdt").data[4:])
+		trun := traf.child("trun").data
+		count := int(binary.BigEndian.Uint32(trun[4:]))
+		offset := int(binary.BigEndian.Uint32(trun[8:]))
+		for i := 0; i < count; i++ {
+			entry := trun[12+i*12:]
+			duration := binary.BigEndian.Uint32(entry)
+			size := int(binary.BigEndian.Uint32(entry[4:]))
+			samples[trackID] = append(samples[trackID], fragmentSample{
+				time:     time,
+				duration: duration,
+				key:      binary.BigEndian.Uint32(entry[8:]) == sampleFlagsKey,
+				data:     b[offset : offset+size],
+			})
+			time += uint64(duration)
+			offset += size
+		}
+	}
+	return sequence, samples
+}
+
+// recorder keeps every write, a write is expected to be a full segment
+type recorder struct {
+	writes [][]byte
+	closed bool
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.writes = append(r.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (r *recorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+// h264Packets returns the packets of a frame, IDR frames are led by their
+// parameter sets and fragmented
+func h264Packets(ts uint32, key bool, n byte) []*rtp.Packet {
+	if !key {
+		return []*rtp.Packet{{Header: rtp.Header{Timestamp: ts, Marker: true}, Payload: []byte{0x41, 0x9a, n, n}}}
+	}
+	stapA := []byte{0x78}
+	for _, nalu := range [][]byte{testSPS, testPPS} {
+		stapA = binary.BigEndian.AppendUint16(stapA, uint16(len(nalu)))
+		stapA = append(stapA, nalu...)
+	}
+	return []*rtp.Packet{
+		{Header: rtp.Header{Timestamp: ts}, Payload: stapA},
+		{Header: rtp.Header{Timestamp: ts}, Payload: []byte{0x7c, 0x85, 0x88, n}},
+		{Header: rtp.Header{Timestamp: ts, Marker: true}, Payload: []byte{0x7c, 0x45, n, n}},
+	}
+}
+
+func opusPacket(ts uint32, n byte) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{Timestamp: ts}, Payload: []byte{0xfc, n, n, n}}
+}
+
+func TestFMP4Writer_H264OpusRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	out := &recorder{}
+	writer, err := NewWith(out)
+	assert.NoError(err)
+	now := time.Unix(0, 0)
+	writer.now = func() time.Time { return now }
+
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus"})
+	assert.NoError(err)
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/h264"})
+	assert.NoError(err)
+
+	annexB := &bytes.Buffer{}
+	h264Writer := h264writer.NewWith(annexB)
+	ogg := &bytes.Buffer{}
+	oggWriter, err := oggwriter.NewWith(ogg, 48000, 2)
+	assert.NoError(err)
+
+	// dropped, before the first key frame
+	assert.NoError(video.WriteRTP(h264Packets(0, false, 0xee)[0]))
+	assert.NoError(audio.WriteRTP(opusPacket(0, 0xee)))
+
+	// 3 GOPs of 1s at 10fps and 20ms audio frames
+	for i := 0; i < 30; i++ {
+		if i == 20 {
+			assert.Len(out.writes, 2, "init segment and first GOP written as they are ready")
+		}
+		for _, packet := range h264Packets(uint32(9000+i*9000), i%10 == 0, byte(i)) {
+			assert.NoError(video.WriteRTP(packet))
+			assert.NoError(h264Writer.WriteRTP(packet))
+		}
+		for j := 0; j < 5; j++ {
+			packet := opusPacket(uint32(4800+(i*5+j)*960), byte(i))
+			assert.NoError(audio.WriteRTP(packet))
+			assert.NoError(oggWriter.WriteRTP(packet))
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	assert.NoError(writer.Close())
+	assert.NoError(writer.Close(), "closing twice")
+	assert.True(out.closed)
+	if !assert.Len(out.writes, 4) {
+		return
+	}
+
+	// init segment
+	init := parseBoxes(t, out.writes[0])
+	assert.Len(init, 2)
+	assert.Equal("ftyp", init[0].typ)
+	moov := init[1]
+	traks := moov.all("trak")
+	assert.Len(traks, 2)
+	assert.Len(moov.child("mvex").all("trex"), 2)
+
+	assert.Equal("soun", string(traks[0].child("mdia", "hdlr").data[8:12]))
+	assert.Equal(uint32(48000), binary.BigEndian.Uint32(traks[0].child("mdia", "mdhd").data[12:]))
+	dOps := traks[0].child("mdia", "minf", "stbl", "stsd", "Opus", "dOps")
+	assert.Equal([]byte{0, 2, 0, 0, 0, 0, 0xbb, 0x80, 0, 0, 0}, dOps.data)
+
+	assert.Equal("vide", string(traks[1].child("mdia", "hdlr").data[8:12]))
+	tkhd := traks[1].child("tkhd").data
+	assert.Equal(uint32(320<<16), binary.BigEndian.Uint32(tkhd[76:]))
+	assert.Equal(uint32(240<<16), binary.BigEndian.Uint32(tkhd[80:]))
+	avcC := traks[1].child("mdia", "minf", "stbl", "stsd", "avc1", "avcC").data
+	expected := append([]byte{1, 0x42, 0xc0, 0x1f, 0xff, 0xe1, 0, byte(len(testSPS))}, testSPS...)
+	expected = append(append(expected, 1, 0, byte(len(testPPS))), testPPS...)
+	assert.Equal(expected, avcC)
+
+	// fragments, one per GOP
+	var audioSamples, videoSamples []fragmentSample
+	for i, write := range out.writes[1:] {
+		sequence, samples := parseFragment(t, write)
+		assert.Equal(uint32(i+1), sequence)
+		assert.True(samples[2][0].key, "fragment starts with a key frame")
+		assert.Equal(uint64(i*90000), samples[2][0].time)
+		audioSamples = append(audioSamples, samples[1]...)
+		videoSamples = append(videoSamples, samples[2]...)
+	}
+
+	// samples match the ones of the existing writers
+	reader, err := h264reader.NewReader(bytes.NewReader(annexB.Bytes()))
+	assert.NoError(err)
+	var nalus [][]byte
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			break
+		}
+		if nal.UnitType != h264reader.NalUnitTypeSPS && nal.UnitType != h264reader.NalUnitTypePPS {
+			nalus = append(nalus, nal.Data)
+		}
+	}
+	assert.Len(videoSamples, 30)
+	var sampleNALUs [][]byte
+	for i, s := range videoSamples {
+		assert.Equal(i%10 == 0, s.key)
+		assert.Equal(uint64(i*9000), s.time)
+		assert.Equal(uint32(9000), s.duration)
+		size := binary.BigEndian.Uint32(s.data)
+		assert.Equal(len(s.data), int(size)+4)
+		sampleNALUs = append(sampleNALUs, s.data[4:])
+	}
+	assert.Equal(nalus, sampleNALUs)
+
+	oggReader, _, err := oggreader.NewWith(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(err)
+	var oggFrames [][]byte
+	for {
+		payload, _, err := oggReader.ParseNextPage()
+		if err != nil {
+			break
+		}
+		if !bytes.HasPrefix(payload, []byte("OpusTags")) {
+			oggFrames = append(oggFrames, payload)
+		}
+	}
+	assert.Len(audioSamples, 150)
+	var audioFrames [][]byte
+	for i, s := range audioSamples {
+		assert.Equal(uint64(i*960), s.time, "audio starts with the video")
+		assert.Equal(uint32(960), s.duration)
+		audioFrames = append(audioFrames, s.data)
+	}
+	assert.Equal(oggFrames, audioFrames)
+}
+
+func TestFMP4Writer_AudioOnly(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "audio.mp4")
+	writer, err := New(path)
+	assert.NoError(err)
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus", Channels: 1})
+	assert.NoError(err)
+
+	// 2.5s, a fragment per second
+	for i := 0; i < 125; i++ {
+		assert.NoError(audio.WriteRTPAt(opusPacket(uint32(i*960), byte(i)), time.Duration(i)*20*time.Millisecond))
+	}
+	assert.NoError(writer.Close())
+	assert.Equal(errFileNotOpened, audio.WriteRTP(opusPacket(0, 0)))
+
+	b, err := os.ReadFile(path) //nolint:gosec
+	assert.NoError(err)
+	boxes := parseBoxes(t, b)
+	var types []string
+	for _, box := range boxes {
+		types = append(types, box.typ)
+	}
+	assert.Equal([]string{"ftyp", "moov", "moof", "mdat", "moof", "mdat", "moof", "mdat"}, types)
+	assert.Equal(byte(1), boxes[1].child("trak", "mdia", "minf", "stbl", "stsd", "Opus", "dOps").data[1])
+
+	// fragments of 1s, the last one ends with the file
+	position := len(boxes[0].data) + len(boxes[1].data) + 16
+	for i, time := range []uint64{0, 48000, 96000} {
+		size := len(boxes[2+i*2].data) + len(boxes[3+i*2].data) + 16
+		_, samples := parseFragment(t, b[position:position+size])
+		assert.Equal(time, samples[1][0].time)
+		assert.Len(samples[1], []int{50, 50, 25}[i])
+		position += size
+	}
+}
+
+func TestFMP4Writer_Errors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewWith(nil)
+	assert.Equal(errFileNotOpened, err)
+
+	writer, err := NewWith(io.Discard)
+	assert.NoError(err)
+	_, err = writer.AddTrack(TrackConfig{MimeType: "video/VP8"})
+	assert.Equal(errNoSuchCodec, err)
+
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus"})
+	assert.NoError(err)
+	assert.Equal(errInvalidNilPacket, audio.WriteRTP(nil))
+	assert.NoError(audio.WriteRTP(opusPacket(0, 1)))
+	_, err = writer.AddTrack(TrackConfig{MimeType: "video/H264"})
+	assert.Equal(errTracksStarted, err)
+	assert.NoError(writer.Close())
+}
+
+func TestSPSSize(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		sps           []byte
+		width, height int
+	}{
+		{"baseline", testSPS, 320, 240},
+		{
+			"high 1080p cropped",
+			[]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58},
+			1920, 1080,
+		},
+	} {
+		width, height, err := spsSize(test.sps)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.width, width, test.name)
+		assert.Equal(t, test.height, height, test.name)
+	}
+
+	_, _, err := spsSize(testSPS[:5])
+	assert.Equal(t, errShortSPS, err)
+}
+
+type segments struct {
+	init      []byte
+	fragments []FragmentInfo
+}
+
+func (s *segments) WriteInit(init []byte) error {
+	s.init = init
+	return nil
+}
+
+func (s *segments) WriteFragment(_ []byte, info FragmentInfo) error {
+	s.fragments = append(s.fragments, info)
+	return nil
+}
+
+func TestFMP4Writer_SegmentWriter(t *testing.T) {
+	assert := assert.New(t)
+	out := &segments{}
+	writer, err := NewWithSegmentWriter(out)
+	assert.NoError(err)
+	writer.SetFragmentDuration(300 * time.Millisecond)
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/H264"})
+	assert.NoError(err)
+
+	// 2 GOPs of 1s at 10fps
+	for i := 0; i < 20; i++ {
+		for _, packet := range h264Packets(uint32(i*9000), i%10 == 0, byte(i)) {
+			assert.NoError(video.WriteRTPAt(packet, time.Duration(i)*100*time.Millisecond))
+		}
+	}
+	assert.NoError(writer.Close())
+
+	assert.NotEmpty(out.init)
+	ms := time.Millisecond
+	assert.Equal([]FragmentInfo{
+		{Sequence: 1, Start: 0, Duration: 300 * ms, Independent: true},
+		{Sequence: 2, Start: 300 * ms, Duration: 300 * ms},
+		{Sequence: 3, Start: 600 * ms, Duration: 300 * ms},
+		{Sequence: 4, Start: 900 * ms, Duration: 100 * ms},
+		{Sequence: 5, Start: 1000 * ms, Duration: 300 * ms, Independent: true},
+		{Sequence: 6, Start: 1300 * ms, Duration: 300 * ms},
+		{Sequence: 7, Start: 1600 * ms, Duration: 300 * ms},
+		{Sequence: 8, Start: 1900 * ms, Duration: 100 * ms},
+	}, out.fragments)
+}
+
+func TestFMP4Writer_FragmentDurationBound(t *testing.T) {
+	assert := assert.New(t)
+	out := &segments{}
+	writer, err := NewWithSegmentWriter(out)
+	assert.NoError(err)
+	writer.SetFragmentDuration(250 * time.Millisecond)
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/H264"})
+	assert.NoError(err)
+
+	// 1 GOP of 1s at 10fps, fragments never reach past 250ms
+	for i := 0; i < 10; i++ {
+		for _, packet := range h264Packets(uint32(i*9000), i == 0, byte(i)) {
+			assert.NoError(video.WriteRTPAt(packet, time.Duration(i)*100*time.Millisecond))
+		}
+	}
+	assert.NoError(writer.Close())
+
+	ms := time.Millisecond
+	assert.Equal([]FragmentInfo{
+		{Sequence: 1, Start: 0, Duration: 200 * ms, Independent: true},
+		{Sequence: 2, Start: 200 * ms, Duration: 200 * ms},
+		{Sequence: 3, Start: 400 * ms, Duration: 200 * ms},
+		{Sequence: 4, Start: 600 * ms, Duration: 200 * ms},
+		{Sequence: 5, Start: 800 * ms, Duration: 200 * ms},
+	}, out.fragments)
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package fmp4writer
+
+import (
+	"errors"
+)
+
+var errShortSPS = errors.New("sps is too short")
+
+// bitReader reads the exp-Golomb coded fields of a RBSP
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) bit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errShortSPS
+	}
+	b := uint32(r.data[r.pos/8]>>(7-r.pos%8)) & 1
+	r.pos++
+	return b, nil
+}
+
+func (r *bitReader) bits(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errShortSPS
+		}
+	}
+	v, err := r.bits(zeros)
+	return 1<<zeros - 1 + v, err
+}
+
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if v&1 == 1 {
+		return int32(v/2 + 1), err
+	}
+	return -int32(v / 2), err
+}
+
+// rbsp removes the emulation prevention bytes of a NAL
+func rbsp(nalu []byte) []byte {
+	out := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// spsSize returns the picture size of a SPS NAL, header byte included
+func spsSize(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errShortSPS
+	}
+	r := &bitReader{data: rbsp(sps[4:])}
+	profile := sps[1]
+
+	chromaFormat := uint32(1)
+	// the first error is kept, fields read after it are 0
+	read := func(f func() (uint32, error)) uint32 {
+		v, e := f()
+		if e != nil && err == nil {
+			err = e
+		}
+		return v
+	}
+	bit := func() uint32 { return read(r.bit) }
+
+	read(r.ue) // seq_parameter_set_id
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = read(r.ue)
+		if chromaFormat == 3 {
+			bit() // separate_colour_plane_flag
+		}
+		read(r.ue) // bit_depth_luma_minus8
+		read(r.ue) // bit_depth_chroma_minus8
+		bit()      // qpprime_y_zero_transform_bypass_flag
+		if bit() == 1 {
+			// seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size && next != 0; j++ {
+					delta, e := r.se()
+					if e != nil {
+						return 0, 0, e
+					}
+					next = (last + delta + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	read(r.ue) // log2_max_frame_num_minus4
+	switch read(r.ue) {
+	case 0: // pic_order_cnt_type
+		read(r.ue) // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		bit() // delta_pic_order_always_zero_flag
+		if _, e := r.se(); e != nil {
+			return 0, 0, e
+		}
+		if _, e := r.se(); e != nil {
+			return 0, 0, e
+		}
+		cycle := read(r.ue)
+		for i := uint32(0); i < cycle; i++ {
+			if _, e := r.se(); e != nil {
+				return 0, 0, e
+			}
+		}
+	}
+	read(r.ue) // max_num_ref_frames
+	bit()      // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := read(r.ue) + 1
+	heightInMapUnits := read(r.ue) + 1
+	frameMbsOnly := bit()
+	if frameMbsOnly == 0 {
+		bit() // mb_adaptive_frame_field_flag
+	}
+	bit() // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if bit() == 1 {
+		cropLeft, cropRight = read(r.ue), read(r.ue)
+		cropTop, cropBottom = read(r.ue), read(r.ue)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cropX, cropY := uint32(1), 2-frameMbsOnly
+	switch chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+	width = int(widthInMbs*16 - cropX*(cropLeft+cropRight))
+	height = int((2-frameMbsOnly)*heightInMapUnits*16 - cropY*(cropTop+cropBottom))
+	return width, height, nil
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package webmwriter
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Matroska element IDs, https://www.matroska.org/technical/elements.html
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment      = 0x18538067
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekID       = 0x53AB
+	idSeekPosition = 0x53AC
+	idVoid         = 0xEC
+
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idMuxingApp      = 0x4D80
+	idWritingApp     = 0x5741
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idFlagLacing        = 0x9C
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idCodecDelay        = 0x56AA
+	idSeekPreRoll       = 0x56BB
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idCluster     = 0x1F43B675
+	idTimestamp   = 0xE7
+	idSimpleBlock = 0xA3
+
+	idCues               = 0x1C53BB6B
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+)
+
+// unknownSize marks an element whose size isn't known yet, it's encoded on 8
+// bytes so it can be replaced in place
+const unknownSize = 0x01FFFFFFFFFFFFFF
+
+func appendID(b []byte, id uint32) []byte {
+	switch {
+	case id >= 1<<24:
+		return append(b, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id >= 1<<16:
+		return append(b, byte(id>>16), byte(id>>8), byte(id))
+	case id >= 1<<8:
+		return append(b, byte(id>>8), byte(id))
+	}
+	return append(b, byte(id))
+}
+
+// appendSize encodes an element size as variable length integer on as few
+// bytes as possible
+func appendSize(b []byte, size uint64) []byte {
+	length := 1
+	for length < 8 && size >= 1<<(7*length)-1 {
+		length++
+	}
+	return appendSizeWidth(b, size, length)
+}
+
+// appendSizeWidth encodes an element size on exactly length bytes
+func appendSizeWidth(b []byte, size uint64, length int) []byte {
+	size |= 1 << (7 * length)
+	for i := length - 1; i >= 0; i-- {
+		b = append(b, byte(size>>(8*i)))
+	}
+	return b
+}
+
+func appendElement(b []byte, id uint32, data []byte) []byte {
+	b = appendID(b, id)
+	b = appendSize(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendUint(b []byte, id uint32, v uint64) []byte {
+	length := 1
+	for length < 8 && v >= 1<<(8*length) {
+		length++
+	}
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(v >> (8 * (length - 1 - i)))
+	}
+	return appendElement(b, id, data)
+}
+
+// appendUint64 encodes v on 8 bytes so it can be replaced in place
+func appendUint64(b []byte, id uint32, v uint64) []byte {
+	return appendElement(b, id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+func appendFloat(b []byte, id uint32, v float64) []byte {
+	return appendElement(b, id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func appendString(b []byte, id uint32, v string) []byte {
+	return appendElement(b, id, []byte(v))
+}
+
+// appendVoid pads with a Void element of exactly size bytes, at least 2
+func appendVoid(b []byte, size int) []byte {
+	b = appendID(b, idVoid)
+	// the size is encoded on 1 byte up to 126, else on 8
+	if size-2 < 0x7F {
+		b = appendSizeWidth(b, uint64(size-2), 1)
+		return append(b, make([]byte, size-2)...)
+	}
+	b = appendSizeWidth(b, uint64(size-9), 8)
+	return append(b, make([]byte, size-9)...)
+}
@@ -0,0 +1,689 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package webmwriter implements a WebM/Matroska media container writer
+// that muxes several tracks, e.g. the audio and video of a participant, into
+// one file
+package webmwriter
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+var (
+	errFileNotOpened    = errors.New("file not opened")
+	errInvalidNilPacket = errors.New("invalid nil packet")
+	errNoSuchCodec      = errors.New("no codec for this MimeType")
+	errTracksStarted    = errors.New("tracks can't be added after the first frame was written")
+)
+
+const (
+	mimeTypeVP8  = "video/VP8"
+	mimeTypeVP9  = "video/VP9"
+	mimeTypeH264 = "video/H264"
+	mimeTypeOpus = "audio/opus"
+
+	muxingApp = "pion-webmwriter"
+
+	// reserved for the SeekHead, rewritten on Close to point to the Cues
+	seekHeadSize = 96
+	// a video key frame starts a new cluster once the current one is this long
+	minClusterDuration = 1000
+	// longest cluster, block timestamps are relative to the cluster on 16 bits
+	maxClusterDuration = 5000
+	// frames buffered while waiting for the key frames of all video tracks
+	maxPendingFrames = 1000
+)
+
+// TrackConfig describes a track of the file
+type TrackConfig struct {
+	// video/VP8, video/VP9, video/H264 or audio/opus
+	MimeType string
+	// RTP clock rate, 90000 for video and 48000 for opus if 0
+	ClockRate uint32
+	// Video size, read from VP8 key frames if 0, else 640x480
+	Width, Height int
+	// Audio channels, 2 if 0
+	Channels int
+}
+
+// WebMWriter is used to take RTP packets of several tracks and write them to
+// a WebM file, or a Matroska file if a track is H264
+type WebMWriter struct {
+	mu       sync.Mutex
+	ioWriter io.Writer
+	now      func() time.Time
+	created  time.Time
+
+	tracks  []*Track
+	started bool
+	pending []frame
+	// pts of the file's time 0
+	startPTS time.Duration
+
+	// positions in the output
+	base               int64
+	written            int64
+	segmentSizeOffset  int64
+	segmentDataOffset  int64
+	seekHeadOffset     int64
+	durationOffset     int64
+	infoPosition       int64
+	tracksPosition     int64
+	lastTimestampMilli int64
+
+	cluster           []byte
+	clusterTime       int64
+	clusterOpen       bool
+	clusterCues       []cue
+	cues              []cue
+	seenVideoKeyFrame bool
+}
+
+// Track is a track of a WebMWriter, its packets may be written from another
+// goroutine than the other tracks'
+type Track struct {
+	writer *WebMWriter
+	number uint64
+	config TrackConfig
+
+	// RTP timing
+	seenPacket bool
+	lastTS     uint32
+	elapsedTS  int64
+	firstPTS   time.Duration
+
+	// frame being assembled
+	frame        []byte
+	frameTS      uint32
+	framePTS     time.Duration
+	frameKey     bool
+	inFrame      bool
+	seenKeyFrame bool
+	lastMilli    int64
+
+	h264     codecs.H264Packet
+	sps, pps []byte
+}
+
+type frame struct {
+	track *Track
+	data  []byte
+	pts   time.Duration
+	key   bool
+}
+
+type cue struct {
+	time     int64
+	track    uint64
+	position int64
+}
+
+// New builds a new WebM writer
+func New(fileName string) (*WebMWriter, error) {
+	f, err := os.Create(fileName) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	return NewWith(f)
+}
+
+// NewWith initialize a new WebM writer with an io.Writer output. Cues,
+// duration and segment size are only written if it's an io.WriteSeeker.
+func NewWith(out io.Writer) (*WebMWriter, error) {
+	if out == nil {
+		return nil, errFileNotOpened
+	}
+
+	w := &WebMWriter{
+		ioWriter: out,
+		now:      time.Now,
+	}
+	if ws, ok := out.(io.WriteSeeker); ok {
+		base, err := ws.Seek(0, io.SeekCurrent)
+		if err == nil {
+			w.base = base
+		}
+	}
+	return w, nil
+}
+
+// AddTrack adds a track, all tracks have to be added before the first packet
+// is written
+func (w *WebMWriter) AddTrack(config TrackConfig) (*Track, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ioWriter == nil {
+		return nil, errFileNotOpened
+	} else if w.started || len(w.pending) > 0 {
+		return nil, errTracksStarted
+	}
+
+	switch {
+	case strings.EqualFold(config.MimeType, mimeTypeVP8),
+		strings.EqualFold(config.MimeType, mimeTypeVP9),
+		strings.EqualFold(config.MimeType, mimeTypeH264):
+		if config.ClockRate == 0 {
+			config.ClockRate = 90000
+		}
+	case strings.EqualFold(config.MimeType, mimeTypeOpus):
+		if config.ClockRate == 0 {
+			config.ClockRate = 48000
+		}
+		if config.Channels == 0 {
+			config.Channels = 2
+		}
+	default:
+		return nil, errNoSuchCodec
+	}
+
+	t := &Track{writer: w, number: uint64(len(w.tracks) + 1), config: config, h264: codecs.H264Packet{IsAVC: true}}
+	w.tracks = append(w.tracks, t)
+	return t, nil
+}
+
+func (t *Track) is(mimeType string) bool {
+	return strings.EqualFold(t.config.MimeType, mimeType)
+}
+
+func (t *Track) isVideo() bool {
+	return !t.is(mimeTypeOpus)
+}
+
+// WriteRTP adds a packet of the track. The first packet of the track is
+// placed at the time it arrived since the writer was created, the following
+// ones by their RTP timestamps.
+func (t *Track) WriteRTP(packet *rtp.Packet) error {
+	if packet == nil {
+		return errInvalidNilPacket
+	}
+
+	t.writer.mu.Lock()
+	defer t.writer.mu.Unlock()
+
+	if !t.seenPacket {
+		if t.writer.created.IsZero() {
+			t.writer.created = t.writer.now()
+		}
+		t.firstPTS = t.writer.now().Sub(t.writer.created)
+	}
+	return t.writeRTP(packet, t.rtpPTS(packet))
+}
+
+// WriteRTPAt adds a packet of the track at the given presentation time, e.g.
+// from synchronizer.TrackSynchronizer.GetPTS to align the tracks of a
+// participant by their sender reports
+func (t *Track) WriteRTPAt(packet *rtp.Packet, pts time.Duration) error {
+	if packet == nil {
+		return errInvalidNilPacket
+	}
+
+	t.writer.mu.Lock()
+	defer t.writer.mu.Unlock()
+
+	t.rtpPTS(packet)
+	return t.writeRTP(packet, pts)
+}
+
+// rtpPTS returns the time of packet by its RTP timestamp, unwrapping the
+// timestamp
+func (t *Track) rtpPTS(packet *rtp.Packet) time.Duration {
+	if t.seenPacket {
+		t.elapsedTS += int64(int32(packet.Timestamp - t.lastTS))
+	}
+	t.seenPacket = true
+	t.lastTS = packet.Timestamp
+	return t.firstPTS + time.Duration(t.elapsedTS*int64(time.Second)/int64(t.config.ClockRate))
+}
+
+func (t *Track) writeRTP(packet *rtp.Packet, pts time.Duration) error {
+	if t.writer.ioWriter == nil {
+		return errFileNotOpened
+	} else if len(packet.Payload) == 0 {
+		return nil
+	}
+
+	// the marker of the previous frame was lost
+	if t.inFrame && packet.Timestamp != t.frameTS {
+		if err := t.endFrame(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case t.is(mimeTypeOpus):
+		return t.writer.writeFrame(frame{track: t, data: append([]byte{}, packet.Payload...), pts: pts, key: true})
+
+	case t.is(mimeTypeVP8):
+		vp8Packet := codecs.VP8Packet{}
+		if _, err := vp8Packet.Unmarshal(packet.Payload); err != nil {
+			return err
+		}
+		if vp8Packet.S == 1 && vp8Packet.PID == 0 && !t.inFrame {
+			t.startFrame(packet, pts, len(vp8Packet.Payload) > 0 && vp8Packet.Payload[0]&0x01 == 0)
+		}
+		if !t.inFrame {
+			return nil
+		}
+		t.frame = append(t.frame, vp8Packet.Payload...)
+
+	case t.is(mimeTypeVP9):
+		vp9Packet := codecs.VP9Packet{}
+		if _, err := vp9Packet.Unmarshal(packet.Payload); err != nil {
+			return err
+		}
+		if vp9Packet.B && !t.inFrame {
+			t.startFrame(packet, pts, !vp9Packet.P)
+		}
+		if !t.inFrame {
+			return nil
+		}
+		t.frame = append(t.frame, vp9Packet.Payload...)
+
+	case t.is(mimeTypeH264):
+		nalus, err := t.h264.Unmarshal(packet.Payload)
+		if err != nil {
+			return err
+		}
+		if !t.inFrame {
+			t.startFrame(packet, pts, false)
+		}
+		t.frame = append(t.frame, nalus...)
+	}
+
+	if packet.Marker {
+		return t.endFrame()
+	}
+	return nil
+}
+
+func (t *Track) startFrame(packet *rtp.Packet, pts time.Duration, key bool) {
+	t.inFrame = true
+	t.frame = nil
+	t.frameTS = packet.Timestamp
+	t.framePTS = pts
+	t.frameKey = key
+}
+
+func (t *Track) endFrame() error {
+	data, key := t.frame, t.frameKey
+	t.inFrame = false
+	t.frame = nil
+	if len(data) == 0 {
+		return nil
+	}
+
+	if t.is(mimeTypeH264) {
+		key = t.scanH264(data)
+	}
+	if !t.seenKeyFrame {
+		if !key {
+			// key frame not seen yet, discarding frame
+			return nil
+		}
+		t.seenKeyFrame = true
+		if t.is(mimeTypeVP8) && t.config.Width == 0 && len(data) >= 10 {
+			// 3 bytes frame tag, 3 bytes start code, 14 bits width and height
+			t.config.Width = int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff)
+			t.config.Height = int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff)
+		}
+	}
+	return t.writer.writeFrame(frame{track: t, data: data, pts: t.framePTS, key: key})
+}
+
+// scanH264 keeps the parameter sets of an AVC frame for the codec private
+// data and reports if it's an IDR frame
+func (t *Track) scanH264(data []byte) bool {
+	key := false
+	for len(data) > 4 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size == 0 || size > len(data)-4 {
+			break
+		}
+		nalu := data[4 : 4+size]
+		switch nalu[0] & 0x1f {
+		case 5:
+			key = true
+		case 7:
+			t.sps = append([]byte{}, nalu...)
+		case 8:
+			t.pps = append([]byte{}, nalu...)
+		}
+		data = data[4+size:]
+	}
+	return key && t.sps != nil && t.pps != nil
+}
+
+// writeFrame writes f once the file started, the file starts when all video
+// tracks had a key frame so the audio doesn't start before the picture
+func (w *WebMWriter) writeFrame(f frame) error {
+	if w.started {
+		return w.writeBlock(f)
+	}
+
+	if f.track.isVideo() {
+		w.seenVideoKeyFrame = true
+	} else if !w.seenVideoKeyFrame && w.hasVideo() {
+		return nil
+	}
+	w.pending = append(w.pending, f)
+
+	for _, t := range w.tracks {
+		if t.isVideo() && !t.seenKeyFrame && len(w.pending) < maxPendingFrames {
+			return nil
+		}
+	}
+	return w.start()
+}
+
+func (w *WebMWriter) hasVideo() bool {
+	for _, t := range w.tracks {
+		if t.isVideo() {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WebMWriter) start() error {
+	w.started = true
+	sort.SliceStable(w.pending, func(i, j int) bool { return w.pending[i].pts < w.pending[j].pts })
+	if len(w.pending) > 0 {
+		w.startPTS = w.pending[0].pts
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	pending := w.pending
+	w.pending = nil
+	for _, f := range pending {
+		if err := w.writeBlock(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WebMWriter) write(b []byte) error {
+	n, err := w.ioWriter.Write(b)
+	w.written += int64(n)
+	return err
+}
+
+func (w *WebMWriter) docType() string {
+	for _, t := range w.tracks {
+		if t.is(mimeTypeH264) {
+			return "matroska"
+		}
+	}
+	return "webm"
+}
+
+func (w *WebMWriter) writeHeader() error {
+	var ebml []byte
+	ebml = appendUint(ebml, idEBMLVersion, 1)
+	ebml = appendUint(ebml, idEBMLReadVersion, 1)
+	ebml = appendUint(ebml, idEBMLMaxIDLength, 4)
+	ebml = appendUint(ebml, idEBMLMaxSizeLength, 8)
+	ebml = appendString(ebml, idDocType, w.docType())
+	ebml = appendUint(ebml, idDocTypeVersion, 4)
+	ebml = appendUint(ebml, idDocTypeReadVersion, 2)
+	header := appendElement(nil, idEBML, ebml)
+
+	// segment size is written on Close
+	header = appendID(header, idSegment)
+	w.segmentSizeOffset = w.written + int64(len(header))
+	header = appendSizeWidth(header, unknownSize, 8)
+	w.segmentDataOffset = w.written + int64(len(header))
+
+	w.seekHeadOffset = w.written + int64(len(header))
+	w.infoPosition = seekHeadSize
+	header = append(header, w.seekHead(nil)...)
+
+	// duration is written on Close
+	var info []byte
+	info = appendUint(info, idTimestampScale, uint64(time.Millisecond))
+	durationOffset := len(info) + 3
+	info = appendFloat(info, idDuration, 0)
+	info = appendString(info, idMuxingApp, muxingApp)
+	info = appendString(info, idWritingApp, muxingApp)
+	infoHeader := appendElement(nil, idInfo, info)
+	w.durationOffset = w.written + int64(len(header)) + int64(len(infoHeader)-len(info)) + int64(durationOffset)
+	header = append(header, infoHeader...)
+
+	w.tracksPosition = w.infoPosition + int64(len(infoHeader))
+	var tracks []byte
+	for _, t := range w.tracks {
+		tracks = appendElement(tracks, idTrackEntry, t.entry())
+	}
+	header = appendElement(header, idTracks, tracks)
+
+	return w.write(header)
+}
+
+// seekHead returns the SeekHead padded to seekHeadSize, pointing to the Cues
+// if cues is set
+func (w *WebMWriter) seekHead(cues *int64) []byte {
+	seek := func(b []byte, id uint32, position int64) []byte {
+		var entry []byte
+		entry = appendElement(entry, idSeekID, appendID(nil, id))
+		entry = appendUint64(entry, idSeekPosition, uint64(position))
+		return appendElement(b, idSeek, entry)
+	}
+
+	var entries []byte
+	entries = seek(entries, idInfo, w.infoPosition)
+	entries = seek(entries, idTracks, w.tracksPosition)
+	if cues != nil {
+		entries = seek(entries, idCues, *cues)
+	}
+	b := appendElement(nil, idSeekHead, entries)
+	return appendVoid(b, seekHeadSize-len(b))
+}
+
+func (t *Track) entry() []byte {
+	var entry []byte
+	entry = appendUint(entry, idTrackNumber, t.number)
+	entry = appendUint(entry, idTrackUID, t.number)
+	entry = appendUint(entry, idFlagLacing, 0)
+
+	if !t.isVideo() {
+		entry = appendUint(entry, idTrackType, 2)
+		entry = appendString(entry, idCodecID, "A_OPUS")
+		entry = appendElement(entry, idCodecPrivate, opusHead(t.config.Channels))
+		entry = appendUint(entry, idCodecDelay, 0)
+		entry = appendUint(entry, idSeekPreRoll, uint64(80*time.Millisecond))
+
+		var audio []byte
+		audio = appendFloat(audio, idSamplingFrequency, 48000)
+		audio = appendUint(audio, idChannels, uint64(t.config.Channels))
+		return appendElement(entry, idAudio, audio)
+	}
+
+	entry = appendUint(entry, idTrackType, 1)
+	switch {
+	case t.is(mimeTypeVP8):
+		entry = appendString(entry, idCodecID, "V_VP8")
+	case t.is(mimeTypeVP9):
+		entry = appendString(entry, idCodecID, "V_VP9")
+	case t.is(mimeTypeH264):
+		entry = appendString(entry, idCodecID, "V_MPEG4/ISO/AVC")
+		if t.sps != nil && t.pps != nil {
+			entry = appendElement(entry, idCodecPrivate, avcDecoderConfig(t.sps, t.pps))
+		}
+	}
+
+	width, height := t.config.Width, t.config.Height
+	if width == 0 || height == 0 {
+		width, height = 640, 480
+	}
+	var video []byte
+	video = appendUint(video, idPixelWidth, uint64(width))
+	video = appendUint(video, idPixelHeight, uint64(height))
+	return appendElement(entry, idVideo, video)
+}
+
+// opusHead is the identification header of RFC 7845 without pre-skip
+func opusHead(channels int) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1                                     // Version
+	head[9] = uint8(channels)                       // Channel count
+	binary.LittleEndian.PutUint16(head[10:], 0)     // Pre-skip
+	binary.LittleEndian.PutUint32(head[12:], 48000) // Input sample rate
+	binary.LittleEndian.PutUint16(head[16:], 0)     // Output gain
+	head[18] = 0                                    // Channel mapping family
+	return head
+}
+
+// avcDecoderConfig is the AVCDecoderConfigurationRecord of ISO/IEC 14496-15
+func avcDecoderConfig(sps, pps []byte) []byte {
+	config := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1} // 4 byte NALU lengths, 1 SPS
+	config = binary.BigEndian.AppendUint16(config, uint16(len(sps)))
+	config = append(config, sps...)
+	config = append(config, 1) // 1 PPS
+	config = binary.BigEndian.AppendUint16(config, uint16(len(pps)))
+	return append(config, pps...)
+}
+
+func (w *WebMWriter) writeBlock(f frame) error {
+	t := f.track
+	timestamp := (f.pts - w.startPTS).Milliseconds()
+	if timestamp < t.lastMilli {
+		timestamp = t.lastMilli
+	}
+	t.lastMilli = timestamp
+	if timestamp > w.lastTimestampMilli {
+		w.lastTimestampMilli = timestamp
+	}
+
+	videoKey := f.key && t.isVideo()
+	if !w.clusterOpen ||
+		(videoKey && timestamp-w.clusterTime >= minClusterDuration) ||
+		timestamp-w.clusterTime >= maxClusterDuration ||
+		timestamp-w.clusterTime < math.MinInt16 {
+		if err := w.flushCluster(); err != nil {
+			return err
+		}
+		w.clusterOpen = true
+		w.clusterTime = timestamp
+		w.cluster = appendUint(nil, idTimestamp, uint64(timestamp))
+	}
+	if videoKey || (!w.hasVideo() && len(w.clusterCues) == 0) {
+		w.clusterCues = append(w.clusterCues, cue{time: timestamp, track: t.number})
+	}
+
+	block := appendSize(nil, t.number)
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(timestamp-w.clusterTime)))
+	if f.key {
+		block = append(block, 0x80)
+	} else {
+		block = append(block, 0x00)
+	}
+	block = append(block, f.data...)
+	w.cluster = appendElement(w.cluster, idSimpleBlock, block)
+	return nil
+}
+
+func (w *WebMWriter) flushCluster() error {
+	if !w.clusterOpen {
+		return nil
+	}
+	position := w.written - w.segmentDataOffset
+	for _, c := range w.clusterCues {
+		c.position = position
+		w.cues = append(w.cues, c)
+	}
+	w.clusterCues = nil
+	w.clusterOpen = false
+	cluster := w.cluster
+	w.cluster = nil
+	return w.write(appendElement(nil, idCluster, cluster))
+}
+
+// Close finishes the file: writes the last cluster and the cues, and if the
+// output is seekable the duration and segment size
+func (w *WebMWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ioWriter == nil {
+		// Returns no error as it may be convenient to call
+		// Close() multiple times
+		return nil
+	}
+
+	defer func() {
+		w.ioWriter = nil
+	}()
+
+	if !w.started {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+
+	var cuesPosition *int64
+	if len(w.cues) > 0 {
+		position := w.written - w.segmentDataOffset
+		cuesPosition = &position
+
+		var cues []byte
+		for _, c := range w.cues {
+			var positions []byte
+			positions = appendUint(positions, idCueTrack, c.track)
+			positions = appendUint(positions, idCueClusterPosition, uint64(c.position))
+
+			var point []byte
+			point = appendUint(point, idCueTime, uint64(c.time))
+			point = appendElement(point, idCueTrackPositions, positions)
+			cues = appendElement(cues, idCuePoint, point)
+		}
+		if err := w.write(appendElement(nil, idCues, cues)); err != nil {
+			return err
+		}
+	}
+
+	if ws, ok := w.ioWriter.(io.WriteSeeker); ok {
+		patches := []struct {
+			offset int64
+			data   []byte
+		}{
+			{w.segmentSizeOffset, appendSizeWidth(nil, uint64(w.written-w.segmentDataOffset), 8)},
+			{w.durationOffset, binary.BigEndian.AppendUint64(nil, math.Float64bits(float64(w.lastTimestampMilli)))},
+			{w.seekHeadOffset, w.seekHead(cuesPosition)},
+		}
+		for _, patch := range patches {
+			if _, err := ws.Seek(w.base+patch.offset, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := ws.Write(patch.data); err != nil {
+				return err
+			}
+		}
+		if _, err := ws.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := w.ioWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package webmwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/stretchr/testify/assert"
+)
+
+// element is a parsed EBML element, children are set for master elements
+type element struct {
+	id       uint32
+	offset   int
+	data     []byte
+	children []*element
+}
+
+var masterElements = map[uint32]bool{
+	idEBML: true, idSegment: true, idSeekHead: true, idSeek: true, idInfo: true,
+	idTracks: true, idTrackEntry: true, idVideo: true, idAudio: true,
+	idCluster: true, idCues: true, idCuePoint: true, idCueTrackPositions: true,
+}
+
+func readVint(b []byte, keepMarker bool) (uint64, int) {
+	length := 1
+	for length <= 8 && b[0]&(0x80>>(length-1)) == 0 {
+		length++
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xff >> length)
+	}
+	for i := 1; i < length; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, length
+}
+
+func parseElements(t *testing.T, b []byte, offset int) []*element {
+	var elements []*element
+	for pos := 0; pos < len(b); {
+		id, idLength := readVint(b[pos:], true)
+		size, sizeLength := readVint(b[pos+idLength:], false)
+		start := pos + idLength + sizeLength
+		if size == 1<<(7*sizeLength)-1 {
+			size = uint64(len(b) - start)
+		}
+		if !assert.LessOrEqual(t, start+int(size), len(b), "element %x overflows", id) {
+			return elements
+		}
+		e := &element{id: uint32(id), offset: offset + pos, data: b[start : start+int(size)]}
+		if masterElements[e.id] {
+			e.children = parseElements(t, e.data, offset+start)
+		}
+		elements = append(elements, e)
+		pos = start + int(size)
+	}
+	return elements
+}
+
+func (e *element) child(id uint32) *element {
+	for _, c := range e.children {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (e *element) all(id uint32) []*element {
+	var elements []*element
+	for _, c := range e.children {
+		if c.id == id {
+			elements = append(elements, c)
+		}
+	}
+	return elements
+}
+
+func (e *element) uint() uint64 {
+	var v uint64
+	for _, b := range e.data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+type block struct {
+	track uint64
+	time  int64
+	key   bool
+	data  []byte
+}
+
+// parseFile checks the layout of a written file and returns its segment and
+// blocks
+func parseFile(t *testing.T, b []byte, docType string) (*element, []block) {
+	elements := parseElements(t, b, 0)
+	if !assert.Len(t, elements, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, docType, string(elements[0].child(idDocType).data))
+	segment := elements[1]
+	assert.Equal(t, uint32(idSegment), segment.id)
+	segmentStart := len(b) - len(segment.data)
+
+	// the seek head points to the top level elements, the cues entry is
+	// only set if the output was seekable
+	for _, seek := range segment.child(idSeekHead).all(idSeek) {
+		id, _ := readVint(seek.child(idSeekID).data, true)
+		position := int(seek.child(idSeekPosition).uint())
+		if position == 0 {
+			continue
+		}
+		found, _ := readVint(b[segmentStart+position:], true)
+		assert.Equal(t, id, found)
+	}
+
+	var blocks []block
+	for _, cluster := range segment.all(idCluster) {
+		clusterTime := int64(cluster.child(idTimestamp).uint())
+		for _, simpleBlock := range cluster.all(idSimpleBlock) {
+			track, n := readVint(simpleBlock.data, false)
+			blocks = append(blocks, block{
+				track: track,
+				time:  clusterTime + int64(int16(binary.BigEndian.Uint16(simpleBlock.data[n:]))),
+				key:   simpleBlock.data[n+2]&0x80 != 0,
+				data:  simpleBlock.data[n+3:],
+			})
+		}
+	}
+	return segment, blocks
+}
+
+func blocksOf(blocks []block, track uint64) [][]byte {
+	var frames [][]byte
+	for _, b := range blocks {
+		if b.track == track {
+			frames = append(frames, b.data)
+		}
+	}
+	return frames
+}
+
+// vp8Packets returns the packets of a frame split in two, key frames carry
+// the frame size
+func vp8Packets(seq uint16, ts uint32, key bool, n byte) []*rtp.Packet {
+	frame := []byte{0x01, n, n, n, n, n, n, n, n, n}
+	if key {
+		frame = []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00, n}
+	}
+	half := len(frame) / 2
+	return []*rtp.Packet{
+		{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts}, Payload: append([]byte{0x10}, frame[:half]...)},
+		{Header: rtp.Header{SequenceNumber: seq + 1, Timestamp: ts, Marker: true}, Payload: append([]byte{0x00}, frame[half:]...)},
+	}
+}
+
+func opusPacket(seq uint16, ts uint32, n byte) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: ts}, Payload: []byte{0xfc, n, n, n}}
+}
+
+func TestWebMWriter_VP8OpusRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "participant.webm")
+	writer, err := New(path)
+	assert.NoError(err)
+	now := time.Unix(0, 0)
+	writer.now = func() time.Time { return now }
+
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/vp8"})
+	assert.NoError(err)
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus", Channels: 2})
+	assert.NoError(err)
+
+	ivf := &bytes.Buffer{}
+	ivfWriter, err := ivfwriter.NewWith(ivf)
+	assert.NoError(err)
+	ogg := &bytes.Buffer{}
+	oggWriter, err := oggwriter.NewWith(ogg, 48000, 2)
+	assert.NoError(err)
+
+	// audio before the first video key frame is dropped
+	assert.NoError(audio.WriteRTP(opusPacket(1, 960, 0xee)))
+	now = now.Add(20 * time.Millisecond)
+
+	// 6s at 10fps, a key frame every 2s, and 20ms audio frames
+	for i := 0; i < 60; i++ {
+		for _, packet := range vp8Packets(uint16(i*2), uint32(i*9000), i%20 == 0, byte(i)) {
+			assert.NoError(video.WriteRTP(packet))
+			assert.NoError(ivfWriter.WriteRTP(packet))
+		}
+		for j := 0; j < 5; j++ {
+			packet := opusPacket(uint16(2+i*5+j), uint32(1920+(i*5+j)*960), byte(i))
+			assert.NoError(audio.WriteRTP(packet))
+			assert.NoError(oggWriter.WriteRTP(packet))
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	assert.NoError(writer.Close())
+	assert.NoError(writer.Close(), "closing twice")
+
+	b, err := os.ReadFile(path) //nolint:gosec
+	assert.NoError(err)
+	segment, blocks := parseFile(t, b, "webm")
+
+	// frames match the ones of the existing writers
+	ivfReader, _, err := ivfreader.NewWith(bytes.NewReader(ivf.Bytes()))
+	assert.NoError(err)
+	var ivfFrames [][]byte
+	for {
+		frame, _, err := ivfReader.ParseNextFrame()
+		if err != nil {
+			break
+		}
+		ivfFrames = append(ivfFrames, frame)
+	}
+	assert.Len(ivfFrames, 60)
+	assert.Equal(ivfFrames, blocksOf(blocks, 1))
+
+	oggReader, _, err := oggreader.NewWith(bytes.NewReader(ogg.Bytes()))
+	assert.NoError(err)
+	var oggFrames [][]byte
+	for {
+		payload, _, err := oggReader.ParseNextPage()
+		if err != nil {
+			break
+		}
+		if bytes.HasPrefix(payload, []byte("OpusTags")) {
+			continue
+		}
+		oggFrames = append(oggFrames, payload)
+	}
+	assert.Len(oggFrames, 300)
+	assert.Equal(oggFrames, blocksOf(blocks, 2))
+
+	// audio and video start together, timestamps follow RTP
+	assert.Equal(block{track: 1, time: 0, key: true, data: ivfFrames[0]}, blocks[0])
+	for _, b := range blocks {
+		if b.track == 2 && bytes.Equal(b.data, []byte{0xfc, 30, 30, 30}) {
+			assert.Equal(int64(3000), b.time)
+			break
+		}
+	}
+
+	// video size read from the key frame
+	tracks := segment.child(idTracks).all(idTrackEntry)
+	assert.Len(tracks, 2)
+	assert.Equal("V_VP8", string(tracks[0].child(idCodecID).data))
+	assert.Equal(uint64(320), tracks[0].child(idVideo).child(idPixelWidth).uint())
+	assert.Equal(uint64(240), tracks[0].child(idVideo).child(idPixelHeight).uint())
+	assert.Equal("A_OPUS", string(tracks[1].child(idCodecID).data))
+	assert.Equal("OpusHead", string(tracks[1].child(idCodecPrivate).data[:8]))
+
+	// key frames start clusters and are cued, duration is set
+	assert.Len(segment.all(idCluster), 3)
+	cues := segment.child(idCues).all(idCuePoint)
+	assert.Len(cues, 3)
+	for i, cue := range cues {
+		assert.Equal(uint64(i*2000), cue.child(idCueTime).uint())
+		position := cue.child(idCueTrackPositions).child(idCueClusterPosition).uint()
+		id, _ := readVint(b[len(b)-len(segment.data)+int(position):], true)
+		assert.Equal(uint64(idCluster), id)
+	}
+	duration := math.Float64frombits(binary.BigEndian.Uint64(segment.child(idInfo).child(idDuration).data))
+	assert.InDelta(5980, duration, 20)
+}
+
+func TestWebMWriter_H264RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	out := &bytes.Buffer{}
+	writer, err := NewWith(out)
+	assert.NoError(err)
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/H264", Width: 1280, Height: 720})
+	assert.NoError(err)
+
+	annexB := &bytes.Buffer{}
+	h264Writer := h264writer.NewWith(annexB)
+
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	stapA := []byte{0x78}
+	for _, nalu := range [][]byte{sps, pps} {
+		stapA = binary.BigEndian.AppendUint16(stapA, uint16(len(nalu)))
+		stapA = append(stapA, nalu...)
+	}
+	packets := []*rtp.Packet{
+		// non IDR frame before the key frame is dropped
+		{Header: rtp.Header{Timestamp: 0, Marker: true}, Payload: []byte{0x41, 0x9a, 0x00}},
+		{Header: rtp.Header{Timestamp: 3000}, Payload: stapA},
+		// IDR fragmented in FU-A
+		{Header: rtp.Header{Timestamp: 3000}, Payload: []byte{0x7c, 0x85, 0x88, 0x84}},
+		{Header: rtp.Header{Timestamp: 3000, Marker: true}, Payload: []byte{0x7c, 0x45, 0x21, 0x00}},
+		{Header: rtp.Header{Timestamp: 6000, Marker: true}, Payload: []byte{0x41, 0x9a, 0x02}},
+	}
+	for _, packet := range packets {
+		assert.NoError(video.WriteRTP(packet))
+		assert.NoError(h264Writer.WriteRTP(packet))
+	}
+	assert.NoError(writer.Close())
+
+	// not seekable, the segment size stays unknown and cues end the file
+	segment, blocks := parseFile(t, out.Bytes(), "matroska")
+	assert.Equal(len(out.Bytes())-len(segment.data)-8, bytes.Index(out.Bytes(), []byte{0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}))
+	assert.NotNil(segment.child(idCues))
+
+	reader, err := h264reader.NewReader(bytes.NewReader(annexB.Bytes()))
+	assert.NoError(err)
+	var nalus [][]byte
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			break
+		}
+		nalus = append(nalus, nal.Data)
+	}
+
+	var avcNALUs [][]byte
+	for _, b := range blocks {
+		for data := b.data; len(data) > 4; {
+			size := binary.BigEndian.Uint32(data)
+			avcNALUs = append(avcNALUs, data[4:4+size])
+			data = data[4+size:]
+		}
+	}
+	assert.Equal(nalus[len(nalus)-len(avcNALUs):], avcNALUs)
+	assert.Len(blocks, 2)
+	assert.True(blocks[0].key)
+	assert.False(blocks[1].key)
+	assert.Equal(int64(33), blocks[1].time)
+
+	entry := segment.child(idTracks).child(idTrackEntry)
+	assert.Equal("V_MPEG4/ISO/AVC", string(entry.child(idCodecID).data))
+	config := entry.child(idCodecPrivate).data
+	assert.Equal([]byte{1, 0x42, 0xc0, 0x1f, 0xff, 0xe1}, config[:6])
+	assert.Equal(sps, config[8:8+len(sps)])
+	assert.Equal(pps, config[11+len(sps):])
+}
+
+func TestWebMWriter_WriteRTPAt(t *testing.T) {
+	assert := assert.New(t)
+	out := &bytes.Buffer{}
+	writer, err := NewWith(out)
+	assert.NoError(err)
+	video, err := writer.AddTrack(TrackConfig{MimeType: "video/VP8"})
+	assert.NoError(err)
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus"})
+	assert.NoError(err)
+
+	// pts of a synchronizer, the audio started 500ms before the video
+	for _, packet := range vp8Packets(1, 1234, true, 1) {
+		assert.NoError(video.WriteRTPAt(packet, 2*time.Second))
+	}
+	assert.NoError(audio.WriteRTPAt(opusPacket(1, 5000, 1), 1500*time.Millisecond))
+	assert.NoError(audio.WriteRTPAt(opusPacket(2, 5960, 2), 2500*time.Millisecond))
+	assert.NoError(writer.Close())
+
+	_, blocks := parseFile(t, out.Bytes(), "webm")
+	assert.Len(blocks, 3)
+	assert.Equal(int64(0), blocks[0].time)
+	assert.Equal(uint64(2), blocks[1].track)
+	assert.Equal(int64(500), blocks[2].time)
+}
+
+func TestWebMWriter_Errors(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewWith(nil)
+	assert.Equal(errFileNotOpened, err)
+
+	writer, err := NewWith(io.Discard)
+	assert.NoError(err)
+	_, err = writer.AddTrack(TrackConfig{MimeType: "video/H265"})
+	assert.Equal(errNoSuchCodec, err)
+
+	audio, err := writer.AddTrack(TrackConfig{MimeType: "audio/opus"})
+	assert.NoError(err)
+	assert.Equal(errInvalidNilPacket, audio.WriteRTP(nil))
+	assert.NoError(audio.WriteRTP(opusPacket(1, 0, 1)))
+	_, err = writer.AddTrack(TrackConfig{MimeType: "video/VP8"})
+	assert.Equal(errTracksStarted, err)
+
+	assert.NoError(writer.Close())
+	assert.Equal(errFileNotOpened, audio.WriteRTP(opusPacket(2, 960, 2)))
+}
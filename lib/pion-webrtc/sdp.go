@@ -358,6 +358,9 @@ func populateLocalCandidates(sessionDescription *SessionDescription, i *ICEGathe
 			return sessionDescription
 		}
 	}
+	if i.api.settingEngine.candidates.ZitiOnly {
+		scrubZitiOnlySDP(parsed)
+	}
 
 	sdp, err := parsed.Marshal()
 	if err != nil {
@@ -371,6 +374,45 @@ func populateLocalCandidates(sessionDescription *SessionDescription, i *ICEGathe
 	}
 }
 
+// scrubZitiOnlySDP removes candidates other than relay from all media
+// sections, including ones added to the description by the application.
+func scrubZitiOnlySDP(parsed *sdp.SessionDescription) {
+	for _, m := range parsed.MediaDescriptions {
+		attributes := m.Attributes[:0]
+		for _, a := range m.Attributes {
+			if a.Key == "candidate" {
+				value, ok := scrubZitiOnlyCandidateValue(a.Value)
+				if !ok {
+					continue
+				}
+				a.Value = value
+			}
+			attributes = append(attributes, a)
+		}
+		m.Attributes = attributes
+	}
+}
+
+func scrubZitiOnlyCandidateValue(value string) (string, bool) {
+	c, err := ice.UnmarshalCandidate(value)
+	if err != nil {
+		return "", false
+	}
+	candidate, err := newICECandidateFromICE(c)
+	if err != nil {
+		return "", false
+	}
+	scrubbed := scrubZitiOnlyCandidates([]ICECandidate{candidate})
+	if len(scrubbed) == 0 {
+		return "", false
+	}
+	scrubbedICE, err := scrubbed[0].toICE()
+	if err != nil {
+		return "", false
+	}
+	return scrubbedICE.Marshal(), true
+}
+
 func addSenderSDP(
 	mediaSection mediaSection,
 	isPlanB bool,
@@ -699,3 +699,37 @@ func TestRtpExtensionsFromMediaDescription(t *testing.T) {
 	assert.Equal(t, extensions[sdp.ABSSendTimeURI], 1)
 	assert.Equal(t, extensions[sdp.SDESMidURI], 3)
 }
+
+func TestScrubZitiOnlySDP(t *testing.T) {
+	parsed := &sdp.SessionDescription{
+		MediaDescriptions: []*sdp.MediaDescription{
+			{
+				Attributes: []sdp.Attribute{
+					{Key: "mid", Value: "0"},
+					{Key: "candidate", Value: "1 1 udp 2130706431 192.168.1.10 50000 typ host"},
+					{Key: "candidate", Value: "2 1 udp 1694498815 27.1.1.1 50001 typ srflx raddr 192.168.1.10 rport 50000"},
+					{Key: "candidate", Value: "3 1 udp 16777215 1.2.3.4 60000 typ relay raddr 27.1.1.1 rport 50001"},
+					{Key: "candidate", Value: "garbage"},
+					{Key: "end-of-candidates"},
+				},
+			},
+			{
+				Attributes: []sdp.Attribute{
+					{Key: "mid", Value: "1"},
+					{Key: "candidate", Value: "4 1 tcp 1671430143 192.168.1.10 9 typ host tcptype active"},
+				},
+			},
+		},
+	}
+
+	scrubZitiOnlySDP(parsed)
+
+	assert.Equal(t, []sdp.Attribute{
+		{Key: "mid", Value: "0"},
+		{Key: "candidate", Value: "3 1 udp 16777215 1.2.3.4 60000 typ relay"},
+		{Key: "end-of-candidates"},
+	}, parsed.MediaDescriptions[0].Attributes)
+	assert.Equal(t, []sdp.Attribute{
+		{Key: "mid", Value: "1"},
+	}, parsed.MediaDescriptions[1].Attributes)
+}
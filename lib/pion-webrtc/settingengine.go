@@ -56,6 +56,7 @@ type SettingEngine struct {
 		UsernameFragment         string
 		Password                 string
 		IncludeLoopbackCandidate bool
+		ZitiOnly                 bool
 	}
 	replayProtection struct {
 		DTLS  *uint
@@ -274,6 +275,16 @@ func (e *SettingEngine) SetNet(net transport.Net) {
 	e.net = net
 }
 
+// SetZitiOnly restricts ICE to relay candidates gathered over ziti services.
+// No host, srflx or mDNS candidates are gathered, and anything but relay
+// candidates is scrubbed from local descriptions and OnICECandidate, with the
+// related address of relay candidates zeroed. Nothing about the underlay is
+// signalled. Without SetNet a ziti only stdnet.Net on the default openziti
+// runtime is used.
+func (e *SettingEngine) SetZitiOnly(zitiOnly bool) {
+	e.candidates.ZitiOnly = zitiOnly
+}
+
 // SetICEMulticastDNSMode controls if pion/ice queries and generates mDNS ICE Candidates
 func (e *SettingEngine) SetICEMulticastDNSMode(multicastDNSMode ice.MulticastDNSMode) {
 	e.candidates.MulticastDNSMode = multicastDNSMode
@@ -52,7 +52,7 @@ func NewClient(netConn net.Conn, u *url.URL, requestHeader http.Header, readBufS
 // It is safe to call Dialer's methods concurrently.
 type Dialer struct {
 	// NetDial specifies the dial function for creating TCP connections. If
-	// NetDial is nil, net.Dialer DialContext is used.
+	// NetDial is nil, the connection is dialed over ziti, see Ziti.
 	NetDial func(network, addr string) (net.Conn, error)
 
 	// NetDialContext specifies the dial function for creating TCP connections. If
@@ -109,6 +109,10 @@ type Dialer struct {
 	// If Jar is nil, cookies are not sent in requests and ignored
 	// in responses.
 	Jar http.CookieJar
+
+	// Ziti dials connections over ziti if none of the NetDial functions is
+	// set. If Ziti is nil, ZitiTransport is used.
+	Ziti *ZitiDialer
 }
 
 // Dial creates a new client connection by calling DialContext with a background context.
@@ -245,6 +249,8 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 	}
 
 	var netDial netDialerFunc
+	tlsClientConfig := d.TLSClientConfig
+	skipTLS := false
 	switch {
 	case u.Scheme == "https" && d.NetDialTLSContext != nil:
 		netDial = d.NetDialTLSContext
@@ -254,8 +260,14 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		netDial = func(ctx context.Context, net, addr string) (net.Conn, error) {
 			return d.NetDial(net, addr)
 		}
+	case d.Ziti != nil:
+		netDial = d.Ziti.dial
+		if d.Ziti.TLSClientConfig != nil {
+			tlsClientConfig = d.Ziti.TLSClientConfig
+		}
+		skipTLS = d.Ziti.SkipTLS
 	default:
-		netDial = ZitiTransport.DialContext
+		netDial = zitiTransportDial
 	}
 
 	// If needed, wrap the dial function to set the connection deadline.
@@ -317,10 +329,10 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 		}
 	}()
 
-	if u.Scheme == "https" && d.NetDialTLSContext == nil {
+	if u.Scheme == "https" && d.NetDialTLSContext == nil && !skipTLS {
 		// If NetDialTLSContext is set, assume that the TLS handshake has already been done
 
-		cfg := cloneTLSConfig(d.TLSClientConfig)
+		cfg := cloneTLSConfig(tlsClientConfig)
 		if cfg.ServerName == "" {
 			cfg.ServerName = hostNoPort
 		}
@@ -354,8 +366,8 @@ func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader h
 
 	resp, err := http.ReadResponse(conn.br, req)
 	if err != nil {
-		if d.TLSClientConfig != nil {
-			for _, proto := range d.TLSClientConfig.NextProtos {
+		if tlsClientConfig != nil {
+			for _, proto := range tlsClientConfig.NextProtos {
 				if proto != "http/1.1" {
 					return nil, nil, fmt.Errorf(
 						"websocket: protocol %q was given but is not supported;"+
@@ -1,5 +1,66 @@
 package websocket
 
-import "net/http"
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+)
 
+// ZitiTransport is used to dial when a Dialer has neither a NetDial function
+// nor a Ziti dialer. It is set by openziti.InitCon.
+//
+// Deprecated: Set Dialer.Ziti instead.
 var ZitiTransport *http.Transport
+
+// ErrZitiNotConfigured is returned by Dial when the Dialer has no dial
+// function, no Ziti dialer and ZitiTransport is not set.
+var ErrZitiNotConfigured = errors.New("websocket: no ziti dialer configured")
+
+// ZitiDialer dials the connections of a Dialer over a ziti context.
+type ZitiDialer struct {
+	// DialContext dials addr over ziti, e.g. openziti.Runtime.DialContext
+	// which picks the service from the routing of the runtime.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Service overrides the service picked by DialContext. If set, every
+	// address is dialed on this service with DialService.
+	Service string
+
+	// DialService dials addr on the named service. Required if Service is
+	// set.
+	DialService func(ctx context.Context, service, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig is used for wss URLs instead of
+	// Dialer.TLSClientConfig, e.g. to verify the server against a CA that
+	// only signs certificates of ziti services.
+	TLSClientConfig *tls.Config
+
+	// SkipTLS sends wss requests without TLS. Ziti connections are already
+	// encrypted end to end, the hosting side has to serve plain HTTP.
+	SkipTLS bool
+}
+
+func (z *ZitiDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if z.Service != "" {
+		if z.DialService == nil {
+			return nil, ErrZitiNotConfigured
+		}
+		return z.DialService(ctx, z.Service, network, addr)
+	}
+	if z.DialContext == nil {
+		return nil, ErrZitiNotConfigured
+	}
+	return z.DialContext(ctx, network, addr)
+}
+
+// zitiTransportDial dials over ZitiTransport, failing gracefully if it is
+// not set.
+func zitiTransportDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	t := ZitiTransport
+	if t == nil || t.DialContext == nil {
+		return nil, ErrZitiNotConfigured
+	}
+	return t.DialContext(ctx, network, addr)
+}
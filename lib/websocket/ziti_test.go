@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Dialers without a dial function go over ZitiTransport, dial the test
+	// servers directly.
+	ZitiTransport = &http.Transport{DialContext: (&net.Dialer{}).DialContext}
+	os.Exit(m.Run())
+}
+
+func TestZitiDialerService(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	var dialed []string
+	d := cstDialer
+	d.Ziti = &ZitiDialer{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Fatal("DialContext called with a service override")
+			return nil, nil
+		},
+		Service: "livekit",
+		DialService: func(ctx context.Context, service, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, service+" "+network+" "+addr)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+
+	want := "livekit tcp " + s.Server.Listener.Addr().String()
+	if len(dialed) != 1 || dialed[0] != want {
+		t.Fatalf("dialed %v, want %q", dialed, want)
+	}
+}
+
+func TestZitiDialerTLS(t *testing.T) {
+	s := newTLSServer(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.Ziti = &ZitiDialer{
+		DialContext:     (&net.Dialer{}).DialContext,
+		TLSClientConfig: &tls.Config{RootCAs: rootCAs(t, s.Server)},
+	}
+	ws, _, err := d.Dial(s.URL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
+func TestZitiDialerSkipTLS(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.Ziti = &ZitiDialer{
+		DialContext: (&net.Dialer{}).DialContext,
+		SkipTLS:     true,
+	}
+	ws, _, err := d.Dial(strings.Replace(s.URL, "ws://", "wss://", 1), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer ws.Close()
+	sendRecv(t, ws)
+}
+
+func TestZitiNotConfigured(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	d := cstDialer
+	d.Ziti = &ZitiDialer{Service: "livekit"}
+	if _, _, err := d.Dial(s.URL, nil); !errors.Is(err, ErrZitiNotConfigured) {
+		t.Fatalf("Dial without DialService returned %v, want ErrZitiNotConfigured", err)
+	}
+
+	transport := ZitiTransport
+	ZitiTransport = nil
+	defer func() { ZitiTransport = transport }()
+	if _, _, err := cstDialer.Dial(s.URL, nil); !errors.Is(err, ErrZitiNotConfigured) {
+		t.Fatalf("Dial without ZitiTransport returned %v, want ErrZitiNotConfigured", err)
+	}
+}
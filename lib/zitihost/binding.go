@@ -0,0 +1,125 @@
+package zitihost
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+var ErrNotAllowed = errors.New("destination not allowed by binding")
+
+// Binding hosts one ziti service and says where its connections go.
+// Fields follow the host.v1 config type, so the json of a host config
+// unmarshals into a Binding.
+type Binding struct {
+	Service string `json:"-"`
+	// Local host connections are proxied to
+	Address string `json:"address"`
+	// Fixed port, ignored with ForwardPort
+	Port int `json:"port,omitempty"`
+	// tcp or udp, ignored with ForwardProtocol
+	Protocol string `json:"protocol,omitempty"`
+	// Take protocol/port from the dial(dst_protocol/dst_port app data)
+	ForwardProtocol   bool        `json:"forwardProtocol,omitempty"`
+	ForwardPort       bool        `json:"forwardPort,omitempty"`
+	AllowedProtocols  []string    `json:"allowedProtocols,omitempty"`
+	AllowedPortRanges []PortRange `json:"allowedPortRanges,omitempty"`
+}
+
+type PortRange struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// Parses a host.v1 config for service
+func ParseHostConfig(service string, data []byte) (Binding, error) {
+	b := Binding{}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Binding{}, err
+	}
+	b.Service = service
+	if err := b.Validate(); err != nil {
+		return Binding{}, err
+	}
+	return b, nil
+}
+
+func (b Binding) Validate() error {
+	if b.Service == "" {
+		return errors.New("binding without service")
+	}
+	if b.Address == "" {
+		return fmt.Errorf("binding %s: no address", b.Service)
+	}
+	if !b.ForwardProtocol && !validProtocol(b.Protocol) {
+		return fmt.Errorf("binding %s: unknown protocol %q", b.Service, b.Protocol)
+	}
+	if b.ForwardProtocol && len(b.AllowedProtocols) == 0 {
+		return fmt.Errorf("binding %s: forwardProtocol without allowedProtocols", b.Service)
+	}
+	for _, p := range b.AllowedProtocols {
+		if !validProtocol(p) {
+			return fmt.Errorf("binding %s: unknown protocol %q", b.Service, p)
+		}
+	}
+	if !b.ForwardPort && (b.Port < 1 || b.Port > 65535) {
+		return fmt.Errorf("binding %s: bad port %d", b.Service, b.Port)
+	}
+	if b.ForwardPort && len(b.AllowedPortRanges) == 0 {
+		return fmt.Errorf("binding %s: forwardPort without allowedPortRanges", b.Service)
+	}
+	return nil
+}
+
+// Returns network and address a connection is proxied to. appData is the
+// app data of the dial, needed for forwarded protocols and ports.
+func (b Binding) Target(appData []byte) (string, string, error) {
+	dst := map[string]string{}
+	if (b.ForwardProtocol || b.ForwardPort) && len(appData) > 0 {
+		if err := json.Unmarshal(appData, &dst); err != nil {
+			return "", "", fmt.Errorf("bad app data: %w", err)
+		}
+	}
+
+	protocol := b.Protocol
+	if b.ForwardProtocol {
+		protocol = dst["dst_protocol"]
+		if !contains(b.AllowedProtocols, protocol) {
+			return "", "", fmt.Errorf("%w: protocol %q", ErrNotAllowed, protocol)
+		}
+	}
+
+	port := b.Port
+	if b.ForwardPort {
+		p, err := strconv.Atoi(dst["dst_port"])
+		if err != nil || !b.portAllowed(p) {
+			return "", "", fmt.Errorf("%w: port %q", ErrNotAllowed, dst["dst_port"])
+		}
+		port = p
+	}
+	return protocol, net.JoinHostPort(b.Address, strconv.Itoa(port)), nil
+}
+
+func (b Binding) portAllowed(port int) bool {
+	for _, r := range b.AllowedPortRanges {
+		if port >= r.Low && port <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+func validProtocol(protocol string) bool {
+	return protocol == "tcp" || protocol == "udp"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
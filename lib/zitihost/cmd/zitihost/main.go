@@ -0,0 +1,79 @@
+// Sidecar hosting a LiveKit server on the ziti overlay, without a tunneler
+// and without published ports.
+//
+//	ZITI_IDENTITY         identity file without .json(default livekit-host)
+//	ZITI_SERVICE_LIVEKIT  signalling service
+//	ZITI_SERVICE_TURN     TURN service
+//	LIVEKIT_ADDRESS       host of the LiveKit server(default 127.0.0.1)
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ziti-livekit-example/lib/openziti"
+	"github.com/ziti-livekit-example/lib/zitihost"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	identity := getenv("ZITI_IDENTITY", "livekit-host")
+	err := openziti.InitCon(identity)
+	if err != nil {
+		return err
+	}
+	r := openziti.Default()
+	defer r.Close()
+
+	renewer := openziti.NewCertRenewer(openziti.CertRenewerOptions{
+		Name:    filepath.Base(identity),
+		Store:   openziti.FileIdentityStore{Dir: filepath.Dir(identity)},
+		Runtime: r,
+	})
+	err = renewer.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer renewer.Close()
+
+	host, err := zitihost.NewHost(zitihost.Options{
+		Runtime: r,
+		Bindings: zitihost.LiveKitBindings(zitihost.LiveKitOptions{
+			Address:       os.Getenv("LIVEKIT_ADDRESS"),
+			SignalService: os.Getenv("ZITI_SERVICE_LIVEKIT"),
+			TurnService:   os.Getenv("ZITI_SERVICE_TURN"),
+		}),
+	})
+	if err != nil {
+		return err
+	}
+	err = host.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+
+	<-ctx.Done()
+	return nil
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
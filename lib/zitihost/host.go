@@ -0,0 +1,344 @@
+// Package zitihost hosts local servers on ziti services, the bind side
+// counterpart of lib/openziti. A process using it needs no tunneler and no
+// published ports to be reachable over the overlay.
+package zitihost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/pion/transport/v2/stdnet"
+	"github.com/ziti-livekit-example/lib/openziti"
+)
+
+var ErrClosed = errors.New("ziti host closed")
+
+type Options struct {
+	// Runtime whose current context binds the services, listeners follow
+	// the context when the identity certificate is renewed
+	Runtime  *openziti.Runtime
+	Bindings []Binding
+	// Terminator instance name, the hosting identity's name if empty.
+	// Dialers pick this host with it, see openziti.ServiceRoute.Identity
+	Identity string
+	// Terminators per service, 3 if 0
+	MaxTerminators int
+	// Timeout dialing local targets, 5s if 0
+	DialTimeout time.Duration
+	// Wait before binding a service again after its listener failed, 5s if 0
+	RetryInterval time.Duration
+}
+
+// Host binds ziti services and proxies every accepted connection to the
+// local target of its binding
+type Host struct {
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[string]net.Listener
+	conns     map[net.Conn]string
+}
+
+func NewHost(opts Options) (*Host, error) {
+	if opts.Runtime == nil {
+		return nil, openziti.ErrNoRuntime
+	}
+	if len(opts.Bindings) == 0 {
+		return nil, errors.New("no bindings")
+	}
+	seen := map[string]bool{}
+	for _, b := range opts.Bindings {
+		if err := b.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[b.Service] {
+			return nil, fmt.Errorf("service %s bound twice", b.Service)
+		}
+		seen[b.Service] = true
+	}
+	return &Host{
+		opts:      opts,
+		listeners: map[string]net.Listener{},
+		conns:     map[net.Conn]string{},
+	}, nil
+}
+
+// Binds every service, fails if one can't be bound. Services stay bound
+// until ctx is done or Close is called, a listener that fails is bound again.
+func (h *Host) Start(ctx context.Context) error {
+	h.mu.Lock()
+	if h.closed || h.ctx != nil {
+		h.mu.Unlock()
+		return ErrClosed
+	}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.mu.Unlock()
+
+	listeners := make([]net.Listener, 0, len(h.opts.Bindings))
+	for _, b := range h.opts.Bindings {
+		l, err := h.listen(b)
+		if err != nil {
+			log.Print(err)
+			h.Close()
+			return err
+		}
+		if !h.setListener(b.Service, l) {
+			_ = l.Close()
+			return ErrClosed
+		}
+		listeners = append(listeners, l)
+	}
+
+	for i, b := range h.opts.Bindings {
+		h.wg.Add(1)
+		go h.serve(b, listeners[i])
+	}
+	go func() {
+		<-h.ctx.Done()
+		h.Close()
+	}()
+	return nil
+}
+
+// Unbinds the services and closes proxied connections
+func (h *Host) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	if h.cancel != nil {
+		h.cancel()
+	}
+	for _, l := range h.listeners {
+		_ = l.Close()
+	}
+	for conn := range h.conns {
+		_ = conn.Close()
+	}
+	h.mu.Unlock()
+
+	h.wg.Wait()
+}
+
+// Number of proxied connections per service
+func (h *Host) ActiveConns() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	active := map[string]int{}
+	for _, b := range h.opts.Bindings {
+		active[b.Service] = 0
+	}
+	for _, service := range h.conns {
+		active[service]++
+	}
+	return active
+}
+
+func (h *Host) listen(b Binding) (net.Listener, error) {
+	options := ziti.DefaultListenOptions()
+	if h.opts.MaxTerminators > 0 {
+		options.MaxTerminators = h.opts.MaxTerminators
+	}
+	if h.opts.Identity != "" {
+		options.Identity = h.opts.Identity
+	} else {
+		options.BindUsingEdgeIdentity = true
+	}
+
+	l, err := h.opts.Runtime.CurrentContext().ListenWithOptions(b.Service, options)
+	if err != nil {
+		return nil, fmt.Errorf("binding %s: %w", b.Service, err)
+	}
+	log.Printf("ziti host %s: bound", b.Service)
+	return l, nil
+}
+
+// Registers the current listener of a service, false once the host is closed
+func (h *Host) setListener(service string, l net.Listener) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.listeners[service] = l
+	return true
+}
+
+func (h *Host) serve(b Binding, l net.Listener) {
+	defer h.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			_ = l.Close()
+			if h.ctx.Err() != nil {
+				return
+			}
+			// e.g. the context was swapped after a renewal and closed
+			log.Printf("ziti host %s: %v, binding again", b.Service, err)
+			if l = h.rebind(b); l == nil {
+				return
+			}
+			continue
+		}
+
+		if !h.track(b.Service, conn) {
+			_ = conn.Close()
+			return
+		}
+		go h.proxy(b, conn)
+	}
+}
+
+func (h *Host) rebind(b Binding) net.Listener {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return nil
+		case <-time.After(h.retryInterval()):
+		}
+
+		l, err := h.listen(b)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if !h.setListener(b.Service, l) {
+			_ = l.Close()
+			return nil
+		}
+		return l
+	}
+}
+
+// Adds a proxied connection, Close waits for it. false once the host is closed
+func (h *Host) track(service string, conn net.Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.conns[conn] = service
+	h.wg.Add(1)
+	return true
+}
+
+func (h *Host) untrack(conn net.Conn) {
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	h.wg.Done()
+}
+
+func (h *Host) proxy(b Binding, conn net.Conn) {
+	defer h.untrack(conn)
+
+	var appData []byte
+	if c, ok := conn.(interface{ GetAppData() []byte }); ok {
+		appData = c.GetAppData()
+	}
+	network, addr, err := b.Target(appData)
+	if err != nil {
+		log.Printf("ziti host %s: %v", b.Service, err)
+		_ = conn.Close()
+		return
+	}
+
+	if network == "udp" {
+		err = h.serveUDP(b, conn, addr)
+	} else {
+		err = h.serveTCP(conn, addr)
+	}
+	if err != nil {
+		log.Printf("ziti host %s %s %s: %v", b.Service, network, addr, err)
+	}
+}
+
+func (h *Host) serveTCP(conn net.Conn, addr string) error {
+	dialer := &net.Dialer{Timeout: h.dialTimeout()}
+	target, err := dialer.DialContext(h.ctx, "tcp", addr)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return pipe(conn, target)
+}
+
+// Sends datagrams of conn to addr, unframing them if the service has the
+// datagram-framing attribute like ZitiPacketConn does on the dial side
+func (h *Host) serveUDP(b Binding, conn net.Conn, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if h.framing(b.Service) == stdnet.FramingLengthPrefix {
+		return stdnet.ServeFramedUDP(conn, udpAddr)
+	}
+
+	target, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return pipe(conn, target)
+}
+
+func (h *Host) framing(service string) stdnet.FramingMode {
+	svc, ok := h.opts.Runtime.CurrentContext().GetService(service)
+	if !ok || svc == nil || svc.RoleAttributes == nil {
+		return stdnet.FramingNone
+	}
+	return stdnet.FramingModeFromAttributes(*svc.RoleAttributes)
+}
+
+func (h *Host) dialTimeout() time.Duration {
+	if h.opts.DialTimeout > 0 {
+		return h.opts.DialTimeout
+	}
+	return 5 * time.Second
+}
+
+func (h *Host) retryInterval() time.Duration {
+	if h.opts.RetryInterval > 0 {
+		return h.opts.RetryInterval
+	}
+	return 5 * time.Second
+}
+
+// Copies between a and b until one side fails or closes, then closes both.
+// Every Read is one Write, so unframed datagrams keep their boundaries as
+// long as the fabric does.
+func pipe(a, b net.Conn) error {
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	errc := make(chan error, 2)
+	copyConn := func(dst, src net.Conn) {
+		_, err := io.CopyBuffer(dst, src, make([]byte, 1<<16))
+		errc <- err
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+
+	err := <-errc
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
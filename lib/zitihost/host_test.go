@@ -0,0 +1,284 @@
+package zitihost
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/edge"
+	"github.com/ziti-livekit-example/lib/openziti"
+)
+
+// fakeZitiContext binds services on in memory listeners, the test dials
+// them with dial
+type fakeZitiContext struct {
+	ziti.Context
+	attributes map[string][]string
+
+	mu        sync.Mutex
+	listeners map[string]*fakeListener
+	options   []ziti.ListenOptions
+}
+
+func (c *fakeZitiContext) ListenWithOptions(service string, options *ziti.ListenOptions) (edge.Listener, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := &fakeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	c.listeners[service] = l
+	c.options = append(c.options, *options)
+	return l, nil
+}
+
+func (c *fakeZitiContext) GetService(name string) (*rest_model.ServiceDetail, bool) {
+	attributes := rest_model.Attributes(c.attributes[name])
+	return &rest_model.ServiceDetail{Name: &name, RoleAttributes: &attributes}, true
+}
+
+func (c *fakeZitiContext) listener(service string) *fakeListener {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.listeners[service]
+}
+
+// dial connects to the listener of service like a dialing identity with
+// the given app data
+func (c *fakeZitiContext) dial(t *testing.T, service string, appData map[string]string) net.Conn {
+	data := []byte("{")
+	for k, v := range appData {
+		if len(data) > 1 {
+			data = append(data, ',')
+		}
+		data = append(data, strconv.Quote(k)+":"+strconv.Quote(v)...)
+	}
+	data = append(data, '}')
+
+	local, remote := net.Pipe()
+	select {
+	case c.listener(service).conns <- appDataConn{Conn: remote, appData: data}:
+	case <-time.After(time.Second):
+		t.Fatalf("%s not accepting", service)
+	}
+	return local
+}
+
+type appDataConn struct {
+	net.Conn
+	appData []byte
+}
+
+func (c appDataConn) GetAppData() []byte { return c.appData }
+
+type fakeListener struct {
+	edge.Listener
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *fakeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func TestParseHostConfig(t *testing.T) {
+	// turn host config of install.sh
+	b, err := ParseHostConfig("turn", []byte(`{
+  "forwardProtocol": true,
+  "forwardPort": true,
+  "address": "livekit-server",
+  "allowedPortRanges": [{"low": 3478, "high": 3478}, {"low": 30000, "high": 40000}],
+  "allowedProtocols": ["tcp", "udp"]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := []struct {
+		appData, network, addr string
+	}{
+		{`{"dst_protocol":"udp","dst_port":"3478"}`, "udp", "livekit-server:3478"},
+		{`{"dst_protocol":"tcp","dst_port":"31000"}`, "tcp", "livekit-server:31000"},
+		{`{"dst_protocol":"udp","dst_port":"5349"}`, "", ""},
+		{`{"dst_protocol":"sctp","dst_port":"3478"}`, "", ""},
+		{``, "", ""},
+	}
+	for _, target := range targets {
+		network, addr, err := b.Target([]byte(target.appData))
+		if target.network == "" {
+			if !errors.Is(err, ErrNotAllowed) {
+				t.Errorf("%s: expected ErrNotAllowed, got %v", target.appData, err)
+			}
+			continue
+		}
+		if err != nil || network != target.network || addr != target.addr {
+			t.Errorf("%s: unexpected target %s %s %v", target.appData, network, addr, err)
+		}
+	}
+
+	invalid := []string{
+		`{"address": "livekit", "port": 7880}`,
+		`{"address": "livekit", "protocol": "tcp"}`,
+		`{"protocol": "tcp", "port": 7880}`,
+		`{"address": "livekit", "forwardPort": true, "protocol": "tcp"}`,
+		`{"address": "livekit", "forwardProtocol": true, "port": 7880}`,
+	}
+	for _, data := range invalid {
+		if _, err = ParseHostConfig("livekit", []byte(data)); err == nil {
+			t.Errorf("%s: expected validation error", data)
+		}
+	}
+}
+
+func TestHostLiveKit(t *testing.T) {
+	signal := listenTCPEcho(t)
+	turn := listenUDPEcho(t)
+
+	zctx := &fakeZitiContext{
+		attributes: map[string][]string{"turn": {"datagram-framing"}},
+		listeners:  map[string]*fakeListener{},
+	}
+	r := openziti.NewRuntimeFromContext(zctx)
+	h, err := NewHost(Options{
+		Runtime: r,
+		Bindings: LiveKitBindings(LiveKitOptions{
+			SignalService: "livekit",
+			SignalPort:    signal.Addr().(*net.TCPAddr).Port,
+			TurnService:   "turn",
+			TurnPorts:     []PortRange{{Low: turn.LocalAddr().(*net.UDPAddr).Port, High: turn.LocalAddr().(*net.UDPAddr).Port}},
+		}),
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, options := range zctx.options {
+		if !options.BindUsingEdgeIdentity {
+			t.Fatal("expected terminators named after the hosting identity")
+		}
+	}
+
+	// signalling goes to the fixed port
+	conn := zctx.dial(t, "livekit", nil)
+	assertEcho(t, conn, []byte("GET /rtc HTTP/1.1\r\n\r\n"))
+	_ = conn.Close()
+
+	// turn datagrams are unframed, sent to the forwarded port and framed back
+	conn = zctx.dial(t, "turn", map[string]string{
+		"dst_protocol": "udp",
+		"dst_port":     strconv.Itoa(turn.LocalAddr().(*net.UDPAddr).Port),
+	})
+	frame := binary.BigEndian.AppendUint16(nil, 7)
+	assertEcho(t, conn, append(frame, "binding"...))
+	_ = conn.Close()
+
+	// ports outside the allowed ranges are refused
+	conn = zctx.dial(t, "turn", map[string]string{"dst_protocol": "udp", "dst_port": "9"})
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	// a failed listener is bound again, e.g. after the context was swapped
+	old := zctx.listener("livekit")
+	_ = old.Close()
+	waitFor(t, "livekit bound again", func() bool { return zctx.listener("livekit") != old })
+	conn = zctx.dial(t, "livekit", nil)
+	assertEcho(t, conn, []byte("ping"))
+
+	waitFor(t, "one active conn", func() bool {
+		active := h.ActiveConns()
+		return active["livekit"] == 1 && active["turn"] == 0
+	})
+	h.Close()
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected Close to close proxied connections")
+	}
+}
+
+func assertEcho(t *testing.T, conn net.Conn, data []byte) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(data) {
+		t.Fatalf("expected %q, got %q", data, buf)
+	}
+}
+
+func listenTCPEcho(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func listenUDPEcho(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,50 @@
+package zitihost
+
+type LiveKitOptions struct {
+	// LiveKit server host, 127.0.0.1 if empty
+	Address string
+	// Service for signalling(HTTP/WebSocket), e.g. $ZITI_SERVICE_LIVEKIT
+	SignalService string
+	// 7880 if 0
+	SignalPort int
+	// Service for TURN over udp, tcp and tls, e.g. $ZITI_SERVICE_TURN
+	TurnService string
+	// 3478, 5349 and the relay range 30000-40000 if empty
+	TurnPorts []PortRange
+}
+
+// Bindings hosting a LiveKit server the way the host configs of install.sh
+// do: signalling on a fixed port, TURN with protocol and port forwarded
+// from the dial
+func LiveKitBindings(opts LiveKitOptions) []Binding {
+	if opts.Address == "" {
+		opts.Address = "127.0.0.1"
+	}
+	if opts.SignalPort == 0 {
+		opts.SignalPort = 7880
+	}
+	if len(opts.TurnPorts) == 0 {
+		opts.TurnPorts = []PortRange{
+			{Low: 3478, High: 3478},
+			{Low: 5349, High: 5349},
+			{Low: 30000, High: 40000},
+		}
+	}
+
+	return []Binding{
+		{
+			Service:  opts.SignalService,
+			Address:  opts.Address,
+			Port:     opts.SignalPort,
+			Protocol: "tcp",
+		},
+		{
+			Service:           opts.TurnService,
+			Address:           opts.Address,
+			ForwardProtocol:   true,
+			ForwardPort:       true,
+			AllowedProtocols:  []string{"tcp", "udp"},
+			AllowedPortRanges: opts.TurnPorts,
+		},
+	}
+}
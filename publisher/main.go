@@ -3,38 +3,31 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"image"
-	"image/color"
 	"log"
-	"math/rand"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
 	"github.com/livekit/protocol/logger"
 	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/livekit/server-sdk-go/v2/pkg/appconfig"
+	"github.com/livekit/server-sdk-go/v2/pkg/mediasource"
+	"github.com/livekit/server-sdk-go/v2/pkg/supervisor"
 	"github.com/pion/mediadevices/pkg/codec/openh264"
 	"github.com/pion/mediadevices/pkg/prop"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
 	"github.com/sirupsen/logrus"
 	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 var (
-	roomClient        *lksdk.RoomServiceClient
-	room              *lksdk.Room
-	livekitEndpoint   string = "wss://livekit.ziti.example:7880"
-	livekitKey        string = "GAkDU6thrPsKgxl"
-	livekitSecret     string = "tZnZDeJGubl3tHJDPNvqfrQfmEkKcduQo0l23u9HY57"
-	width             int    = 1640
-	height            int    = 900
-	framerate         int    = 30
-	redTriangle       *image.RGBA
-	redTriangleBounds image.Rectangle
+	config     *appconfig.Config
+	roomClient *lksdk.RoomServiceClient
+	room       *lksdk.Room
+	source     mediasource.MediaSource
+	// published in the current room
+	tracks []mediasource.Track
 )
 
 func main() {
@@ -42,53 +35,53 @@ func main() {
 	logger.InitFromConfig(&logger.Config{Level: "debug"}, "ziti-livekit")
 	lksdk.SetLogger(logger.GetLogger())
 	logrus.StandardLogger().Level = logrus.DebugLevel
-	rand.Seed(time.Now().UnixNano())
 
-	for {
-		run()
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func run() {
-	err := openziti.InitCon("publisher")
+	// Defaults, overridden by config.yaml, the environment and flags
+	var err error
+	config, err = appconfig.Load("publisher", appconfig.Config{
+		Room:     "testroom",
+		Identity: "publisher",
+		Ziti:     appconfig.Ziti{Identity: "publisher"},
+		Video:    &appconfig.Video{Width: 1640, Height: 900, FrameRate: 30, Bitrate: 2000000, Source: "pattern", Simulcast: true},
+	}, "config.yaml", os.Args[1:])
 	if err != nil {
-		log.Print(err)
+		log.Fatal(err)
+	}
+	if config.PrintConfig {
+		if err = config.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
-	err = connectToLivekit()
+	// Raw video of the source is encoded with openh264, an encoder per
+	// simulcast layer
+	source, err = mediasource.Parse(config.Video.Source, mediasource.Options{
+		Width:     config.Video.Width,
+		Height:    config.Video.Height,
+		FrameRate: config.Video.FrameRate,
+		Encoder:   openh264Encoder{},
+		Bitrate:   config.Video.Bitrate,
+		Simulcast: config.Video.Simulcast,
+	})
 	if err != nil {
-		log.Print(err)
-		return
+		log.Fatal(err)
 	}
 
-	redTriangle = drawRedTriangle(width, height)
-	redTriangleBounds = getRedTriangleBounds(*redTriangle)
-
-	// Create a room
-	roomName := "testroom"
-
 	// Create livekit access token
 	canPublish := true
 	canSubscribe := false
 	canPublishData := true
 	canUpdateOwnMetadata := true
-	identy := "publisher"
 
 	grants := &auth.VideoGrant{
 		RoomJoin:             true,
-		Room:                 roomName,
+		Room:                 config.Room,
 		CanPublish:           &canPublish,
 		CanSubscribe:         &canSubscribe,
 		CanPublishData:       &canPublishData,
 		CanUpdateOwnMetadata: &canUpdateOwnMetadata,
 	}
-	token, err := createLivekitAccessToken(identy, grants)
-	if err != nil {
-		log.Print(err)
-		return
-	}
 
 	// Join room with token
 	roomCB := &lksdk.RoomCallback{
@@ -102,32 +95,51 @@ func run() {
 			log.Print("subscriber has left, waiting for him to come back...")
 		},
 	}
-	room = lksdk.NewRoom(roomCB)
 
-	// Join room
-	err = room.JoinWithToken(livekitEndpoint, token, lksdk.WithICETransportPolicy(webrtc.ICETransportPolicyRelay))
-	if err != nil {
-		log.Print(err)
-		return
+	// Tokens come from the token service if configured, else they're signed
+	// with the API secret
+	token := func() (string, error) {
+		return createLivekitAccessToken(config.Identity, grants)
 	}
-
-	err = setMetadata()
-	if err != nil {
-		log.Print(err)
-		return
+	if config.LiveKit.TokenService != "" {
+		tokens := lksdk.NewTokenClient(nil, config.LiveKit.TokenService, config.Room)
+		tokens.Start(context.Background())
+		defer tokens.Close()
+		token = tokens.Token
 	}
 
-	err = publishTrack()
+	// The supervisor sets up the ziti runtime, joins the room and rejoins
+	// with backoff whenever it's lost, until SIGINT/SIGTERM
+	s := supervisor.New(supervisor.Options{
+		Identity: config.Ziti.Identity,
+		URL:      config.LiveKit.URL,
+		Token:    token,
+		Callback: roomCB,
+		ConnectOptions: []lksdk.ConnectOption{
+			lksdk.WithICETransportPolicy(webrtc.ICETransportPolicyRelay),
+			lksdk.WithZitiOnly(),
+		},
+		Prepare: func(ctx context.Context, r *openziti.Runtime) error {
+			// the room service needs the API secret
+			if config.LiveKit.APISecret == "" {
+				return nil
+			}
+			return connectToLivekit()
+		},
+		OnJoined: func(ctx context.Context, joined *lksdk.Room) error {
+			room = joined
+			err := setMetadata()
+			if err != nil {
+				return err
+			}
+			return publishTracks()
+		},
+	})
+	err = s.RunUntilSignal()
+	_ = source.Close()
 	if err != nil {
-		log.Print(err)
-		return
+		log.Fatal(err)
 	}
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT)
-
-	<-sigChan
-	room.Disconnect()
 }
 
 // This will use zitified websocket connection to connect to livekit
@@ -135,9 +147,9 @@ func run() {
 // The zitification happens in forked websocket library inside lib
 func connectToLivekit() error {
 	roomClient = lksdk.NewRoomServiceClient(
-		livekitEndpoint,
-		livekitKey,
-		livekitSecret,
+		config.LiveKit.URL,
+		config.LiveKit.APIKey,
+		config.LiveKit.APISecret,
 	)
 
 	// To test that the host/keys are correct, do a test request
@@ -153,7 +165,7 @@ func connectToLivekit() error {
 
 func createLivekitAccessToken(identy string, grants *auth.VideoGrant) (string, error) {
 	// Generate a livekit token
-	at := auth.NewAccessToken(livekitKey, livekitSecret)
+	at := auth.NewAccessToken(config.LiveKit.APIKey, config.LiveKit.APISecret)
 
 	// Grant permissions
 	at.AddGrant(grants).
@@ -189,147 +201,56 @@ func setMetadata() error {
 	return nil
 }
 
-func drawRedTriangle(width int, height int) *image.RGBA {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	// Fill the background with white color
-	col := color.RGBA{uint8(rand.Intn(255)), uint8(rand.Intn(255)), uint8(rand.Intn(255)), uint8(rand.Intn(255))}
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			img.Set(x, y, col)
-		}
-	}
-
-	// Define the dimensions of the triangle
-	triangleHeight := height / 3 // Triangle height as a fraction of the image height
-	triangleBase := width / 2    // Triangle base as a fraction of the image width
-
-	// Calculate the triangle's coordinates to center it
-	triangleBaseY := height/2 + triangleHeight/2 // Bottom y-coordinate of the triangle
-	triangleTipY := height/2 - triangleHeight/2  // Top y-coordinate of the triangle
-	triangleBaseX1 := width/2 - triangleBase/2   // Left x-coordinate of the triangle base
-	triangleBaseX2 := width/2 + triangleBase/2   // Right x-coordinate of the triangle base
-	triangleTipX := width / 2                    // x-coordinate of the triangle tip
+// openh264Encoder encodes the raw video of the media source
+type openh264Encoder struct{}
 
-	// Define the red color for the triangle
-	red := color.RGBA{255, 0, 0, 255}
-
-	// Fill the triangle using a simple scanline algorithm
-	for y := triangleTipY; y <= triangleBaseY; y++ {
-		// Interpolate the x-coordinates of the left and right edges
-		t := float64(y-triangleTipY) / float64(triangleBaseY-triangleTipY)
-		leftX := int(float64(triangleTipX)*(1-t) + float64(triangleBaseX1)*t)
-		rightX := int(float64(triangleTipX)*(1-t) + float64(triangleBaseX2)*t)
-
-		// Draw horizontal line for the current scanline
-		for x := leftX; x <= rightX; x++ {
-			img.Set(x, y, red)
-		}
-	}
-	return img
-}
-
-func getRedTriangleBounds(img image.RGBA) image.Rectangle {
-	return img.Bounds()
+func (openh264Encoder) MimeType() string {
+	return webrtc.MimeTypeH264
 }
 
-// From this reader xh264 takes decorated screen captures
-type ScreenCaptureReader struct{}
-
-// Read() Returns a decorated image of the screenToCapture
-func (c ScreenCaptureReader) Read() (image.Image, func(), error) {
-	redTriangle = drawRedTriangle(width, height)
-	return redTriangle, func() {}, nil
-}
-
-func trackOnBind(track *lksdk.LocalTrack) error {
+func (openh264Encoder) Encode(frames mediasource.FrameReader, width, height int, frameRate float64, bitrate int) (mediasource.EncodedReader, error) {
 	// Create h264 params
 	params, err := openh264.NewParams()
 	if err != nil {
 		log.Print(err)
-		return err
+		return nil, err
 	}
 
 	// Configure params
-	params.BitRate = 2000000
+	params.BitRate = bitrate
 	params.EnableFrameSkip = false
 	params.UsageType = openh264.ScreenContentRealTime
 
-	// Set Media properties
-	buf := ScreenCaptureReader{}
-	bounds := redTriangleBounds
-	width := bounds.Max.X - bounds.Min.X
-	height := bounds.Max.Y - bounds.Min.Y
-	prop := prop.Media{
+	// build encoder
+	return params.BuildVideoEncoder(frames, prop.Media{
 		Video: prop.Video{
 			Width:     width,
 			Height:    height,
-			FrameRate: float32(framerate),
+			FrameRate: float32(frameRate),
 		},
-	}
-
-	// build encoder
-	enc, err := params.BuildVideoEncoder(buf, prop)
-	if err != nil {
-		log.Print(err)
-		return err
-	}
-	ticker := time.NewTicker(time.Second / time.Duration(framerate))
-
-	// Start the ticker
-	for range ticker.C {
-		select {
-		default:
-			// Get h264 encoded frame
-			b, _, err := enc.Read()
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-
-			log.Print(len(b))
-			// Send the frame trough track
-			duration := time.Second / time.Duration(framerate)
-			err = track.WriteSample(media.Sample{Data: b, Duration: duration}, &lksdk.SampleWriteOptions{})
-			if err != nil {
-				log.Print(err)
-				return err
-			}
-		}
+	})
+}
 
+func publishTracks() error {
+	// The tracks of the previous room, the source goes on with new ones
+	for _, track := range tracks {
+		_ = track.Close()
 	}
-	return nil
-}
 
-func publishTrack() error {
-	// Create a local track
-	track, err := lksdk.NewLocalTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264})
+	var err error
+	tracks, err = source.Tracks()
 	if err != nil {
 		log.Print(err)
 		return err
 	}
 
-	// On local track bind handler
-	track.OnBind(func() {
-		err := trackOnBind(track)
+	// Publish local tracks, simulcast tracks with their layers
+	for _, track := range tracks {
+		_, err = track.Publish(room.LocalParticipant)
 		if err != nil {
-			log.Print("Failed to bind track: ", err)
+			log.Print(err)
+			return err
 		}
-	})
-
-	// Options for local track publish
-	bwidth := redTriangleBounds.Max.X - redTriangleBounds.Min.X
-	bheight := redTriangleBounds.Max.Y - redTriangleBounds.Min.Y
-	options := &lksdk.TrackPublicationOptions{
-		VideoWidth:  bwidth,
-		VideoHeight: bheight,
-	}
-
-	// Publish local track
-	_, err = room.LocalParticipant.PublishTrack(track, options)
-	if err != nil {
-		log.Print(err)
-		return err
 	}
 	return nil
 }
@@ -5,92 +5,77 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/livekit/protocol/auth"
 	"github.com/livekit/protocol/livekit"
 	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/livekit/server-sdk-go/v2/pkg/appconfig"
+	"github.com/livekit/server-sdk-go/v2/pkg/hls"
 	"github.com/livekit/server-sdk-go/v2/pkg/samplebuilder"
+	"github.com/livekit/server-sdk-go/v2/pkg/supervisor"
+	"github.com/livekit/server-sdk-go/v2/pkg/synchronizer"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
-	"github.com/pion/webrtc/v3/pkg/media/h264writer"
-	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
-	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v3/pkg/media/webmwriter"
 	"github.com/ziti-livekit-example/lib/openziti"
 )
 
 var (
-	roomClient      *lksdk.RoomServiceClient
-	livekitEndpoint string = "wss://livekit.ziti.example:7880"
-	livekitKey      string = "GAkDU6thrPsKgxl"
-	livekitSecret   string = "tZnZDeJGubl3tHJDPNvqfrQfmEkKcduQo0l23u9HY57"
+	config     *appconfig.Config
+	roomClient *lksdk.RoomServiceClient
 )
 
 func main() {
 	// logger.InitFromConfig(&logger.Config{Level: "debug"}, "ziti-livekit")
 	// lksdk.SetLogger(logger.GetLogger())
 	// logrus.StandardLogger().Level = logrus.DebugLevel
-
-	for {
-		run()
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func run() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	err := openziti.InitCon("subscriber")
-	if err != nil {
-		log.Print(err)
-		return
-	}
 
-	err = connectToLivekit()
+	// Defaults, overridden by config.yaml, the environment and flags
+	var err error
+	config, err = appconfig.Load("subscriber", appconfig.Config{
+		Room:     "testroom",
+		Identity: "subscriber",
+		Ziti:     appconfig.Ziti{Identity: "subscriber"},
+		HLS:      &appconfig.HLS{},
+	}, "config.yaml", os.Args[1:])
 	if err != nil {
-		log.Print(err)
+		log.Fatal(err)
+	}
+	if config.PrintConfig {
+		if err = config.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
 	// Create a room
-	roomName := "testroom"
-	config := &livekit.CreateRoomRequest{
-		Name:            roomName,
+	createRoom := &livekit.CreateRoomRequest{
+		Name:            config.Room,
 		EmptyTimeout:    1222 * 60, // 10 minutes
 		MaxParticipants: 20,
 	}
 
-	_, err = roomClient.CreateRoom(context.Background(), config)
-	if err != nil {
-		log.Print(err)
-		return
-	}
-	log.Printf("Room %s created", roomName)
-
 	// Create livekit access token
 	canPublish := false
 	canSubscribe := true
 	canPublishData := true
 	canUpdateOwnMetadata := false
-	identy := "subscriber"
 
 	grants := &auth.VideoGrant{
 		RoomJoin:             true,
-		Room:                 roomName,
+		Room:                 config.Room,
 		CanPublish:           &canPublish,
 		CanSubscribe:         &canSubscribe,
 		CanPublishData:       &canPublishData,
 		CanUpdateOwnMetadata: &canUpdateOwnMetadata,
 	}
-	token, err := createLivekitAccessToken(identy, grants)
-	if err != nil {
-		log.Print(err)
-		return
-	}
 
 	// Join room with token
 	roomCB := &lksdk.RoomCallback{
@@ -105,32 +90,72 @@ func run() {
 			log.Print("publisher has left, waiting for him to come back...")
 		},
 	}
-	room := lksdk.NewRoom(roomCB)
 
-	// Join room
-	err = room.JoinWithToken(livekitEndpoint, token, lksdk.WithICETransportPolicy(webrtc.ICETransportPolicyRelay))
-	if err != nil {
-		log.Print(err)
-		return
+	// Tokens come from the token service if configured, else they're signed
+	// with the API secret
+	token := func() (string, error) {
+		return createLivekitAccessToken(config.Identity, grants)
+	}
+	if config.LiveKit.TokenService != "" {
+		tokens := lksdk.NewTokenClient(nil, config.LiveKit.TokenService, config.Room)
+		tokens.Start(context.Background())
+		defer tokens.Close()
+		token = tokens.Token
 	}
-	log.Print("Join successfull.")
-
-	log.Print("conn state ", room.ConnectionState())
-	log.Printf("remote participants %+v", room.GetRemoteParticipants())
 
-	for _, p := range room.GetRemoteParticipants() {
-		log.Print("identity of participant: ", p.Identity())
+	// The supervisor sets up the ziti runtime, joins the room and rejoins
+	// with backoff whenever it's lost, until SIGINT/SIGTERM
+	s := supervisor.New(supervisor.Options{
+		Identity: config.Ziti.Identity,
+		URL:      config.LiveKit.URL,
+		Token:    token,
+		Callback: roomCB,
+		ConnectOptions: []lksdk.ConnectOption{
+			lksdk.WithICETransportPolicy(webrtc.ICETransportPolicyRelay),
+			lksdk.WithZitiOnly(),
+		},
+		Prepare: func(ctx context.Context, r *openziti.Runtime) error {
+			// the room service needs the API secret, without it the room is
+			// created when joining
+			if config.LiveKit.APISecret == "" {
+				return nil
+			}
+			err := connectToLivekit()
+			if err != nil {
+				return err
+			}
+
+			_, err = roomClient.CreateRoom(ctx, createRoom)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			log.Printf("Room %s created", config.Room)
+			return nil
+		},
+		OnJoined: func(ctx context.Context, room *lksdk.Room) error {
+			log.Print("Join successfull.")
 
-		if p.Identity() == "publisher" {
-			log.Print("publisher tracks: ", p.TrackPublications())
-		}
-	}
+			log.Print("conn state ", room.ConnectionState())
+			log.Printf("remote participants %+v", room.GetRemoteParticipants())
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT)
+			for _, p := range room.GetRemoteParticipants() {
+				log.Print("identity of participant: ", p.Identity())
 
-	<-sigChan
-	room.Disconnect()
+				if p.Identity() == "publisher" {
+					log.Print("publisher tracks: ", p.TrackPublications())
+				}
+			}
+			return nil
+		},
+	})
+	if config.HLS.Service != "" {
+		go serveHLS(config.HLS.Service)
+	}
+	err = s.RunUntilSignal()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 // This will use zitified websocket connection to connect to livekit
@@ -138,9 +163,9 @@ func run() {
 // The zitification happens in forked websocket library inside lib
 func connectToLivekit() error {
 	roomClient = lksdk.NewRoomServiceClient(
-		livekitEndpoint,
-		livekitKey,
-		livekitSecret,
+		config.LiveKit.URL,
+		config.LiveKit.APIKey,
+		config.LiveKit.APISecret,
 	)
 
 	// To test that the host/keys are correct, do a test request
@@ -156,7 +181,7 @@ func connectToLivekit() error {
 
 func createLivekitAccessToken(identy string, grants *auth.VideoGrant) (string, error) {
 	// Generate a livekit token
-	at := auth.NewAccessToken(livekitKey, livekitSecret)
+	at := auth.NewAccessToken(config.LiveKit.APIKey, config.LiveKit.APISecret)
 
 	// Grant permissions
 	at.AddGrant(grants).
@@ -177,77 +202,265 @@ func onDataReceived(data []byte) {
 }
 
 func onTrackSubscribed(track *webrtc.TrackRemote, publication *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
-	tracks := fmt.Sprintf("%s-%s", rp.Identity(), track.ID())
-	log.Print("new track ", tracks)
-	NewTrackWriter(track, rp.WritePLI, tracks)
+	log.Printf("new track %s-%s", rp.Identity(), track.ID())
+	recordingFor(rp).addTrack(track)
 }
 
 const (
-	maxVideoLate = 1000 // nearly 2s for fhd video
-	maxAudioLate = 200  // 4s for audio
+	// how long a recording waits for the rest of the participant's tracks
+	recordingGrace = 2 * time.Second
+)
+
+var (
+	recordingsMu sync.Mutex
+	recordings   = map[string]*recording{}
+)
+
+// recording muxes the tracks of one participant into
+// <identity>-<start time>.webm, or .mkv if there is a h264 track, or into an
+// HLS stream if configured. Tracks are collected until all
+// publications of the participant are subscribed or recordingGrace passed,
+// tracks subscribed later aren't recorded.
+type recording struct {
+	mu      sync.Mutex
+	rp      *lksdk.RemoteParticipant
+	tracks  []*webrtc.TrackRemote
+	timer   *time.Timer
+	started bool
+}
+
+func recordingFor(rp *lksdk.RemoteParticipant) *recording {
+	recordingsMu.Lock()
+	defer recordingsMu.Unlock()
+	r := recordings[rp.Identity()]
+	if r == nil || r.rp != rp {
+		r = &recording{rp: rp}
+		recordings[rp.Identity()] = r
+	}
+	return r
+}
+
+func (r *recording) addTrack(track *webrtc.TrackRemote) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		log.Printf("recording of %s already started, track %s is not recorded", r.rp.Identity(), track.ID())
+		return
+	}
+	r.tracks = append(r.tracks, track)
+	if len(r.tracks) >= len(r.rp.TrackPublications()) {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		r.start()
+		return
+	}
+	if r.timer == nil {
+		r.timer = time.AfterFunc(recordingGrace, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.start()
+		})
+	}
+}
+
+func (r *recording) start() {
+	if r.started {
+		return
+	}
+	r.started = true
+	if config.HLS.Enabled() {
+		r.startHLS()
+		return
+	}
+
+	fileName := fmt.Sprintf("%s-%s.webm", r.rp.Identity(), time.Now().Format("20060102-150405"))
+	for _, track := range r.tracks {
+		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
+			fileName = strings.TrimSuffix(fileName, ".webm") + ".mkv"
+		}
+	}
+	writer, err := webmwriter.New(fileName)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// a/v alignment comes from the synchronizer, the file starts with the
+	// first key frame so ask for one
+	avSync := synchronizer.NewSynchronizer(nil)
+	var writers []*TrackWriter
+	for _, track := range r.tracks {
+		t, err := NewTrackWriter(track, r.rp.WritePLI, writer, avSync.AddTrack(track, r.rp.Identity()))
+		if err != nil {
+			log.Printf("track %s is not recorded: %v", track.ID(), err)
+			continue
+		}
+		writers = append(writers, t)
+	}
+	log.Printf("recording %d tracks of %s to %s", len(writers), r.rp.Identity(), fileName)
+
+	done := make(chan struct{}, len(writers))
+	for _, t := range writers {
+		go func(t *TrackWriter) {
+			t.start()
+			done <- struct{}{}
+		}(t)
+	}
+	go func() {
+		for range writers {
+			<-done
+		}
+		if err := writer.Close(); err != nil {
+			log.Print(err)
+		}
+		log.Printf("recording of %s finished", r.rp.Identity())
+	}()
+}
+
+var (
+	streamsMu sync.Mutex
+	streams   = map[string]*hls.Segmenter{}
 )
 
+// startHLS streams the tracks to <hls dir>/<identity>, or to memory, and
+// serves them at /<identity>/index.m3u8. A rejoining participant replaces
+// its stream.
+func (r *recording) startHLS() {
+	var storage hls.Storage = hls.NewMemoryStorage()
+	if config.HLS.Dir != "" {
+		dir, err := hls.NewDirStorage(filepath.Join(config.HLS.Dir, r.rp.Identity()))
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		storage = dir
+	}
+	seg, err := hls.New(hls.Options{Storage: storage, LowLatency: config.HLS.LowLatency})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	added := 0
+	for _, track := range r.tracks {
+		if err := seg.AddTrack(track, r.rp.WritePLI); err != nil {
+			log.Printf("track %s is not streamed: %v", track.ID(), err)
+			continue
+		}
+		added++
+	}
+	if added == 0 {
+		_ = seg.Close()
+		return
+	}
+	log.Printf("streaming %d tracks of %s at /%s/%s", added, r.rp.Identity(), r.rp.Identity(), hls.PlaylistName)
+
+	streamsMu.Lock()
+	streams[r.rp.Identity()] = seg
+	streamsMu.Unlock()
+	seg.Start()
+}
+
+// serveHLS serves the streams on the ziti service, binding again whenever the
+// listener fails, e.g. after the identity certificate was renewed
+func serveHLS(service string) {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+		streamsMu.Lock()
+		seg := streams[identity]
+		streamsMu.Unlock()
+		if seg == nil {
+			http.NotFound(w, req)
+			return
+		}
+		http.StripPrefix("/"+identity, seg.Handler()).ServeHTTP(w, req)
+	})}
+
+	for {
+		// the runtime is set up by the supervisor
+		if r := openziti.Default(); r != nil {
+			l, err := r.CurrentContext().Listen(service)
+			if err != nil {
+				log.Print(err)
+			} else {
+				log.Printf("hls bound to %s", service)
+				if err = server.Serve(l); err != nil {
+					log.Print(err)
+				}
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
 type TrackWriter struct {
 	sb     *samplebuilder.SampleBuilder
-	writer media.Writer
+	writer *webmwriter.Track
+	sync   *synchronizer.TrackSynchronizer
 	track  *webrtc.TrackRemote
 }
 
-func NewTrackWriter(track *webrtc.TrackRemote, pliWriter lksdk.PLIWriter, fileName string) (*TrackWriter, error) {
-	var (
-		sb     *samplebuilder.SampleBuilder
-		writer media.Writer
-		err    error
-	)
-	log.Print("-------", track.Codec().MimeType)
+func NewTrackWriter(track *webrtc.TrackRemote, pliWriter lksdk.PLIWriter, writer *webmwriter.WebMWriter, trackSync *synchronizer.TrackSynchronizer) (*TrackWriter, error) {
+	var sb *samplebuilder.SampleBuilder
+	onDropped := samplebuilder.WithPacketDroppedHandler(func() {
+		pliWriter(track.SSRC())
+	})
 	switch {
-	case strings.EqualFold(track.Codec().MimeType, "video/vp9"):
-		log.Print("vp999999999")
-		sb = samplebuilder.New(maxVideoLate, &codecs.VP9Packet{}, track.Codec().ClockRate, samplebuilder.WithPacketDroppedHandler(func() {
-			pliWriter(track.SSRC())
-		}))
-		// ivfwriter use frame count as PTS, that might cause video played in a incorrect framerate(fast or slow)
-		writer, err = ivfwriter.New(fileName + ".ivf")
-
-	case strings.EqualFold(track.Codec().MimeType, "video/h264"):
-		sb = samplebuilder.New(maxVideoLate, &codecs.H264Packet{}, track.Codec().ClockRate, samplebuilder.WithPacketDroppedHandler(func() {
-			pliWriter(track.SSRC())
-		}))
-		writer, err = h264writer.New(fileName + ".h264")
-
-	case strings.EqualFold(track.Codec().MimeType, "audio/opus"):
-		sb = samplebuilder.New(maxAudioLate, &codecs.OpusPacket{}, track.Codec().ClockRate)
-		writer, err = oggwriter.New(fileName+".ogg", 48000, track.Codec().Channels)
-
+	case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeVP8):
+		sb = samplebuilder.New(samplebuilder.MaxVideoLate, &codecs.VP8Packet{}, track.Codec().ClockRate, onDropped)
+	case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeVP9):
+		sb = samplebuilder.New(samplebuilder.MaxVideoLate, &codecs.VP9Packet{}, track.Codec().ClockRate, onDropped)
+	case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264):
+		sb = samplebuilder.New(samplebuilder.MaxVideoLate, &codecs.H264Packet{}, track.Codec().ClockRate, onDropped)
+	case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus):
+		sb = samplebuilder.New(samplebuilder.MaxAudioLate, &codecs.OpusPacket{}, track.Codec().ClockRate)
 	default:
 		return nil, errors.New("unsupported codec type")
 	}
 
+	trackWriter, err := writer.AddTrack(webmwriter.TrackConfig{
+		MimeType:  track.Codec().MimeType,
+		ClockRate: track.Codec().ClockRate,
+		Channels:  int(track.Codec().Channels),
+	})
 	if err != nil {
 		return nil, err
 	}
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		pliWriter(track.SSRC())
+	}
 
-	t := &TrackWriter{
+	return &TrackWriter{
 		sb:     sb,
-		writer: writer,
+		writer: trackWriter,
+		sync:   trackSync,
 		track:  track,
-	}
-	go t.start()
-	return t, nil
+	}, nil
 }
 
 func (t *TrackWriter) start() {
-	defer t.writer.Close()
+	initialized := false
 	for {
 		pkt, _, err := t.track.ReadRTP()
 		if err != nil {
 			break
 		}
+		if !initialized {
+			t.sync.Initialize(pkt)
+			initialized = true
+		}
 		t.sb.Push(pkt)
 
 		for _, p := range t.sb.PopPackets() {
-			// t.writer.WriteRTP(p)
-			log.Print(len(p.Payload))
+			pts, err := t.sync.GetPTS(p)
+			if err != nil {
+				continue
+			}
+			if err = t.writer.WriteRTPAt(p, pts); err != nil {
+				log.Print(err)
+				return
+			}
 		}
 	}
 }